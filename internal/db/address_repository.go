@@ -0,0 +1,256 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"ipam/internal/lifecycle"
+	"ipam/internal/models"
+	"ipam/internal/netutil"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrAddressExists is returned when the address already has a record in the same VRF
+var ErrAddressExists = errors.New("this address already has a record in this VRF")
+
+// addressSelect JOINs with vrfs, device_interfaces and devices to get display names
+const addressSelect = `
+	SELECT a.id, COALESCE(a.vrf_id, 1), COALESCE(f.name, ''), a.address, a.status, COALESCE(a.dns_name, ''),
+		COALESCE(a.owner, ''), COALESCE(a.purpose, ''), COALESCE(a.description, ''), COALESCE(a.interface_id, 0),
+		COALESCE(d.id, 0), COALESCE(d.hostname, ''), COALESCE(i.label, ''), a.created_at, a.updated_at
+	FROM ip_addresses a
+	LEFT JOIN vrfs f ON a.vrf_id = f.id
+	LEFT JOIN device_interfaces i ON a.interface_id = i.id
+	LEFT JOIN devices d ON i.device_id = d.id`
+
+func scanAddress(row interface{ Scan(...interface{}) error }, a *models.IPAddress) error {
+	return row.Scan(&a.ID, &a.VRFID, &a.VRFName, &a.Address, &a.Status, &a.DNSName, &a.Owner, &a.Purpose,
+		&a.Description, &a.InterfaceID, &a.DeviceID, &a.Hostname, &a.InterfaceLabel, &a.CreatedAt, &a.UpdatedAt)
+}
+
+// GetAllIPAddresses retrieves all standalone address records ordered by VRF, then address
+func GetAllIPAddresses() ([]models.IPAddress, error) {
+	rows, err := DB.Query(addressSelect)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var addresses []models.IPAddress
+	for rows.Next() {
+		var a models.IPAddress
+		if err := scanAddress(rows, &a); err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Addresses are text, so order them numerically in Go
+	sort.SliceStable(addresses, func(i, j int) bool {
+		if addresses[i].VRFID != addresses[j].VRFID {
+			return addresses[i].VRFID < addresses[j].VRFID
+		}
+		a, errA := netutil.ParseAddr(addresses[i].Address)
+		b, errB := netutil.ParseAddr(addresses[j].Address)
+		if errA != nil || errB != nil {
+			return addresses[i].Address < addresses[j].Address
+		}
+		return a.Compare(b) < 0
+	})
+	return addresses, nil
+}
+
+// GetIPAddress retrieves a single address record by ID
+func GetIPAddress(id int) (models.IPAddress, error) {
+	var a models.IPAddress
+	err := scanAddress(DB.QueryRow(addressSelect+" WHERE a.id = ?", id), &a)
+	return a, err
+}
+
+// AddIPAddress adds a new address record. When InterfaceID is set, the address is
+// assigned to that interface in the same transaction; actor is recorded in the audit log
+// for the change of its device.
+func AddIPAddress(a models.IPAddress, actor string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkIPAddress(tx, &a); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	result, err := tx.Exec(`INSERT INTO ip_addresses (vrf_id, address, status, dns_name, owner, purpose, description, interface_id, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		a.VRFID, a.Address, a.Status, a.DNSName, a.Owner, a.Purpose, a.Description, a.InterfaceID, now, now)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	a.ID = int(id)
+	if err := assignToInterface(tx, a, actor); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdateIPAddress updates an address record, attaching it to (or detaching it from) an interface,
+// like AddIPAddress
+func UpdateIPAddress(a models.IPAddress, actor string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkIPAddress(tx, &a); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE ip_addresses SET vrf_id=?, address=?, status=?, dns_name=?, owner=?, purpose=?, description=?,
+		interface_id=?, updated_at=? WHERE id=?`,
+		a.VRFID, a.Address, a.Status, a.DNSName, a.Owner, a.Purpose, a.Description, a.InterfaceID, time.Now(), a.ID)
+	if err != nil {
+		return err
+	}
+	if err := assignToInterface(tx, a, actor); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteIPAddress deletes an address record. An attached interface keeps its address.
+func DeleteIPAddress(id int) error {
+	_, err := DB.Exec("DELETE FROM ip_addresses WHERE id=?", id)
+	return err
+}
+
+// checkIPAddress canonicalizes the record and makes sure the address is neither recorded twice
+// in the VRF nor used by an interface other than the one it is attached to
+func checkIPAddress(tx *sql.Tx, a *models.IPAddress) error {
+	addr, err := netutil.CanonicalAddr(a.Address)
+	if err != nil {
+		return err
+	}
+	a.Address = addr
+	if a.VRFID, err = normalizeVRFID(tx, a.VRFID); err != nil {
+		return err
+	}
+	a.DNSName = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(a.DNSName)), ".")
+	if a.Status == "" {
+		a.Status = "Reserved"
+	}
+
+	var count int
+	err = tx.QueryRow("SELECT COUNT(*) FROM ip_addresses WHERE vrf_id = ? AND address = ? AND id != ?",
+		a.VRFID, a.Address, a.ID).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrAddressExists
+	}
+
+	if a.InterfaceID != 0 {
+		var vrfID int
+		var status string
+		err := tx.QueryRow(`SELECT COALESCE(i.vrf_id, 1), d.status FROM device_interfaces i JOIN devices d ON i.device_id = d.id
+			WHERE i.id = ? AND d.deleted_at IS NULL`, a.InterfaceID).Scan(&vrfID, &status)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("interface %d not found", a.InterfaceID)
+		} else if err != nil {
+			return err
+		}
+		if vrfID != a.VRFID {
+			return errors.New("the interface belongs to another VRF")
+		}
+		if status == lifecycle.Retired {
+			return ErrDeviceRetired
+		}
+	}
+
+	// Devices in the trash have their addresses freed
+	var hostname string
+	err = tx.QueryRow(`SELECT d.hostname FROM device_interfaces i JOIN devices d ON i.device_id = d.id
+		WHERE i.vrf_id = ? AND i.ip_address = ? AND i.id != ? AND d.deleted_at IS NULL LIMIT 1`, a.VRFID, a.Address, a.InterfaceID).Scan(&hostname)
+	if err == nil {
+		return fmt.Errorf("%s is already assigned to %s", a.Address, hostname)
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+	return nil
+}
+
+// assignToInterface puts the address of an attached record on its interface, recording the
+// change of its device in the audit log. A record attached to the interface before is detached,
+// since the interface no longer carries its address.
+func assignToInterface(tx *sql.Tx, a models.IPAddress, actor string) error {
+	if a.InterfaceID == 0 {
+		return nil
+	}
+	var deviceID int
+	if err := tx.QueryRow("SELECT device_id FROM device_interfaces WHERE id = ?", a.InterfaceID).Scan(&deviceID); err != nil {
+		return err
+	}
+	before, err := deviceSnapshot(tx, deviceID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE ip_addresses SET interface_id = 0 WHERE interface_id = ? AND id != ?", a.InterfaceID, a.ID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE device_interfaces SET ip_address=? WHERE id=?", a.Address, a.InterfaceID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE devices SET updated_at=?, version=version+1 WHERE id=?", time.Now(), deviceID); err != nil {
+		return err
+	}
+	return auditDevice(tx, deviceID, AuditUpdate, actor, before)
+}
+
+// attachedAddressRecords returns the IDs of the address records attached to a device's interfaces
+func attachedAddressRecords(tx *sql.Tx, deviceID int) ([]int, error) {
+	rows, err := tx.Query(`SELECT id FROM ip_addresses WHERE interface_id IN
+		(SELECT id FROM device_interfaces WHERE device_id = ?)`, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// relinkAddressRecords re-attaches records to whichever interface of the device now carries
+// their address, or detaches them when the address is gone
+func relinkAddressRecords(tx *sql.Tx, deviceID int, recordIDs []int) error {
+	for _, id := range recordIDs {
+		_, err := tx.Exec(`UPDATE ip_addresses SET interface_id = COALESCE((SELECT i.id FROM device_interfaces i
+			WHERE i.device_id = ? AND i.ip_address = ip_addresses.address AND i.vrf_id = ip_addresses.vrf_id LIMIT 1), 0)
+			WHERE id = ?`, deviceID, id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"ipam/internal/lifecycle"
+	"ipam/internal/models"
+	"testing"
+)
+
+func TestAttachIPAddress(t *testing.T) {
+	openTestDB(t)
+
+	device := models.Device{Hostname: "web01", Status: lifecycle.Active, UHeight: 1,
+		Interfaces: []models.DeviceInterface{{IPAddress: "10.0.0.5", Label: "eth0"}}}
+	if err := AddDevice(device, "alice"); err != nil {
+		t.Fatalf("AddDevice: %v", err)
+	}
+	iface := lastID(t, "device_interfaces")
+
+	// Each step attaches a record to the interface, which then carries its address alone
+	tests := []struct {
+		name    string
+		attach  func() error
+		address string
+		record  int // ID of the record expected on the interface
+	}{
+		{
+			name:    "first record",
+			attach:  func() error { return AddIPAddress(models.IPAddress{Address: "10.0.0.9", InterfaceID: iface}, "bob") },
+			address: "10.0.0.9",
+			record:  1,
+		},
+		{
+			name:    "second record takes over",
+			attach:  func() error { return AddIPAddress(models.IPAddress{Address: "10.0.0.10", InterfaceID: iface}, "bob") },
+			address: "10.0.0.10",
+			record:  2,
+		},
+		{
+			name: "first record moved back",
+			attach: func() error {
+				a, err := GetIPAddress(1)
+				if err != nil {
+					return err
+				}
+				a.InterfaceID = iface
+				return UpdateIPAddress(a, "bob")
+			},
+			address: "10.0.0.9",
+			record:  1,
+		},
+	}
+	for _, tt := range tests {
+		if err := tt.attach(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		stored, err := GetDevice(1)
+		if err != nil {
+			t.Fatalf("%s: GetDevice: %v", tt.name, err)
+		}
+		if got := stored.Interfaces[0].IPAddress; got != tt.address {
+			t.Errorf("%s: interface address = %s, want %s", tt.name, got, tt.address)
+		}
+		records, err := GetAllIPAddresses()
+		if err != nil {
+			t.Fatalf("%s: GetAllIPAddresses: %v", tt.name, err)
+		}
+		for _, a := range records {
+			attached := a.ID == tt.record
+			if (a.InterfaceID == iface) != attached {
+				t.Errorf("%s: record %s has interface %d, want attached %v", tt.name, a.Address, a.InterfaceID, attached)
+			}
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"ipam/internal/lifecycle"
+	"ipam/internal/models"
+	"ipam/internal/netutil"
+	"net/netip"
+	"time"
+)
+
+// ErrNoFreeAddress is returned when a prefix has no assignable address left
+var ErrNoFreeAddress = errors.New("no free address available in prefix")
+
+// AllocateNextIP finds the first free address in cidr and assigns it to a new interface
+// on the given device, all inside a single transaction. Network and broadcast addresses,
+// subnet gateways, DHCP pools, infrastructure ranges and addresses already present on an
+// interface of the same VRF are skipped.
+// The interface's DeviceID, VRFID, MACAddress and Label are used; its IPAddress is ignored.
+// version is the device version the caller last read, 0 to allocate whatever the device is now;
+// ErrStale is returned when the device was changed since. actor is recorded in the audit log.
+func AllocateNextIP(cidr string, iface models.DeviceInterface, version int, actor string) (models.DeviceInterface, error) {
+	prefix, err := netutil.ParsePrefix(cidr)
+	if err != nil {
+		return iface, err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return iface, err
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.QueryRow("SELECT status FROM devices WHERE id = ? AND deleted_at IS NULL", iface.DeviceID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return iface, fmt.Errorf("device %d not found", iface.DeviceID)
+	} else if err != nil {
+		return iface, err
+	}
+	if status == lifecycle.Retired {
+		return iface, ErrDeviceRetired
+	}
+	if version != 0 {
+		if err := checkVersion(tx, "devices", iface.DeviceID, version); err != nil {
+			return iface, err
+		}
+	}
+	before, err := deviceSnapshot(tx, iface.DeviceID)
+	if err != nil {
+		return iface, err
+	}
+
+	if iface.VRFID, err = normalizeVRFID(tx, iface.VRFID); err != nil {
+		return iface, err
+	}
+
+	taken, err := takenAddresses(tx, iface.VRFID)
+	if err != nil {
+		return iface, err
+	}
+
+	skip, err := dynamicRanges(tx, iface.VRFID)
+	if err != nil {
+		return iface, err
+	}
+
+	addr, err := firstFreeAddress(prefix, taken, skip)
+	if err != nil {
+		return iface, err
+	}
+	iface.IPAddress = addr.String()
+
+	id, err := insertInterface(tx, int64(iface.DeviceID), iface)
+	if err != nil {
+		return iface, err
+	}
+	iface.ID = int(id)
+
+	if _, err := tx.Exec("UPDATE devices SET updated_at=?, version=version+1 WHERE id=?", time.Now(), iface.DeviceID); err != nil {
+		return iface, err
+	}
+	if err := auditDevice(tx, iface.DeviceID, AuditUpdate, actor, before); err != nil {
+		return iface, err
+	}
+
+	return iface, tx.Commit()
+}
+
+// takenAddresses returns every address of a VRF that must not be handed out:
+// interface addresses, standalone address records and subnet gateways
+func takenAddresses(tx *sql.Tx, vrfID int) (map[netip.Addr]bool, error) {
+	taken := make(map[netip.Addr]bool)
+
+	collect := func(query string) error {
+		rows, err := tx.Query(query, vrfID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var raw sql.NullString
+			if err := rows.Scan(&raw); err != nil {
+				return err
+			}
+			if addr, err := netutil.ParseAddr(raw.String); err == nil {
+				taken[addr] = true
+			}
+		}
+		return rows.Err()
+	}
+
+	if err := collect(`SELECT ip_address FROM device_interfaces
+		WHERE vrf_id = ? AND device_id IN (SELECT id FROM devices WHERE deleted_at IS NULL)`); err != nil {
+		return nil, err
+	}
+	if err := collect("SELECT address FROM ip_addresses WHERE vrf_id = ?"); err != nil {
+		return nil, err
+	}
+	if err := collect("SELECT gateway FROM subnets WHERE gateway != '' AND vrf_id = ?"); err != nil {
+		return nil, err
+	}
+	return taken, nil
+}
+
+// addrSpan is an inclusive run of addresses
+type addrSpan struct {
+	start, end netip.Addr
+}
+
+// contains reports whether addr lies inside the span
+func (s addrSpan) contains(addr netip.Addr) bool {
+	return addr.Compare(s.start) >= 0 && addr.Compare(s.end) <= 0
+}
+
+// overlaps reports whether two spans share at least one address
+func (s addrSpan) overlaps(o addrSpan) bool {
+	return s.start.Compare(o.end) <= 0 && o.start.Compare(s.end) <= 0
+}
+
+// dynamicRanges returns the IP ranges of a VRF that static allocation must not hand out from
+// (everything except "Static" ranges)
+func dynamicRanges(tx *sql.Tx, vrfID int) ([]addrSpan, error) {
+	rows, err := tx.Query(`SELECT r.start_ip, r.end_ip FROM ip_ranges r
+		JOIN subnets s ON r.subnet_id = s.id
+		WHERE s.vrf_id = ? AND r.range_type != 'Static'`, vrfID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var spans []addrSpan
+	for rows.Next() {
+		var start, end string
+		if err := rows.Scan(&start, &end); err != nil {
+			return nil, err
+		}
+		s, err1 := netutil.ParseAddr(start)
+		e, err2 := netutil.ParseAddr(end)
+		if err1 == nil && err2 == nil {
+			spans = append(spans, addrSpan{s, e})
+		}
+	}
+	return spans, rows.Err()
+}
+
+// firstFreeAddress walks the host range of prefix and returns the first address that is
+// not in taken and not inside one of the skipped spans
+func firstFreeAddress(prefix netip.Prefix, taken map[netip.Addr]bool, skip []addrSpan) (netip.Addr, error) {
+	first, last := netutil.HostBounds(prefix)
+	addr := first
+	for addr.IsValid() && addr.Compare(last) <= 0 {
+		skipped := false
+		for _, span := range skip {
+			if span.contains(addr) {
+				// Jump over the whole span instead of walking it address by address
+				addr = span.end.Next()
+				skipped = true
+				break
+			}
+		}
+		if skipped {
+			continue
+		}
+		if !taken[addr] {
+			return addr, nil
+		}
+		addr = addr.Next()
+	}
+	return netip.Addr{}, ErrNoFreeAddress
+}
+
+// AvailableChildPrefixes lists up to limit free prefixes of the given length inside parent.
+// A block is free when it does not overlap any subnet defined inside parent in the same VRF.
+// Nothing is recorded; this is the dry-run counterpart of CarveSubnet.
+func AvailableChildPrefixes(vrfID int, parent string, bits, limit int) ([]netip.Prefix, error) {
+	prefix, err := netutil.ParsePrefix(parent)
+	if err != nil {
+		return nil, err
+	}
+	if vrfID == 0 {
+		vrfID = GlobalVRFID
+	}
+	children, err := childPrefixes(DB, vrfID, prefix)
+	if err != nil {
+		return nil, err
+	}
+	return netutil.FreeChildPrefixes(prefix, bits, children, limit), nil
+}
+
+// CarveSubnet records the first free prefix of the given length inside parent as a new subnet
+// in the VRF of s. The CIDR of s is ignored; its other fields are stored on the new subnet.
+func CarveSubnet(parent string, bits int, s models.Subnet) (models.Subnet, error) {
+	prefix, err := netutil.ParsePrefix(parent)
+	if err != nil {
+		return s, err
+	}
+	if bits <= prefix.Bits() || bits > prefix.Addr().BitLen() {
+		return s, fmt.Errorf("prefix length /%d cannot be carved out of %s", bits, prefix)
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return s, err
+	}
+	defer tx.Rollback()
+
+	if s.VRFID, err = normalizeVRFID(tx, s.VRFID); err != nil {
+		return s, err
+	}
+	children, err := childPrefixes(tx, s.VRFID, prefix)
+	if err != nil {
+		return s, err
+	}
+	free := netutil.FreeChildPrefixes(prefix, bits, children, 1)
+	if len(free) == 0 {
+		return s, fmt.Errorf("no free /%d left in %s", bits, prefix)
+	}
+
+	s.CIDR = free[0].String()
+	s.Gateway = ""
+	s.CreatedAt = time.Now()
+	if s.Status == "" {
+		s.Status = "Active"
+	}
+	result, err := tx.Exec("INSERT INTO subnets (cidr, name, description, gateway, status, vrf_id, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		s.CIDR, s.Name, s.Description, s.Gateway, s.Status, s.VRFID, s.CreatedAt)
+	if err != nil {
+		return s, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return s, err
+	}
+	s.ID = int(id)
+
+	return s, tx.Commit()
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// childPrefixes returns every subnet of a VRF strictly inside parent
+func childPrefixes(q queryer, vrfID int, parent netip.Prefix) ([]netip.Prefix, error) {
+	rows, err := q.Query("SELECT cidr FROM subnets WHERE vrf_id = ?", vrfID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var children []netip.Prefix
+	for rows.Next() {
+		var cidr string
+		if err := rows.Scan(&cidr); err != nil {
+			return nil, err
+		}
+		p, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			continue
+		}
+		if p.Bits() > parent.Bits() && parent.Contains(p.Addr()) {
+			children = append(children, p)
+		}
+	}
+	return children, rows.Err()
+}
@@ -0,0 +1,214 @@
+package db
+
+import (
+	"errors"
+	"ipam/internal/lifecycle"
+	"ipam/internal/models"
+	"net/netip"
+	"testing"
+)
+
+func span(start, end string) addrSpan {
+	return addrSpan{netip.MustParseAddr(start), netip.MustParseAddr(end)}
+}
+
+func TestFirstFreeAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		prefix  string
+		taken   []string
+		skip    []addrSpan
+		want    string
+		wantErr bool
+	}{
+		{name: "empty subnet", prefix: "10.0.0.0/24", want: "10.0.0.1"},
+		{name: "taken addresses", prefix: "10.0.0.0/24", taken: []string{"10.0.0.1", "10.0.0.2"}, want: "10.0.0.3"},
+		{name: "skipped span", prefix: "10.0.0.0/24", skip: []addrSpan{span("10.0.0.1", "10.0.0.99")}, want: "10.0.0.100"},
+		{
+			name:   "span then taken",
+			prefix: "10.0.0.0/24", taken: []string{"10.0.0.100"},
+			skip: []addrSpan{span("10.0.0.1", "10.0.0.99")},
+			want: "10.0.0.101",
+		},
+		{
+			name:   "adjacent spans",
+			prefix: "10.0.0.0/24",
+			skip:   []addrSpan{span("10.0.0.50", "10.0.0.99"), span("10.0.0.1", "10.0.0.49")},
+			want:   "10.0.0.100",
+		},
+		{name: "span outside the subnet", prefix: "10.0.0.0/24", skip: []addrSpan{span("10.0.1.1", "10.0.1.9")}, want: "10.0.0.1"},
+		{name: "full", prefix: "10.0.0.0/30", taken: []string{"10.0.0.1", "10.0.0.2"}, wantErr: true},
+		{name: "span to the end", prefix: "10.0.0.0/24", skip: []addrSpan{span("10.0.0.0", "10.0.0.255")}, wantErr: true},
+		{name: "IPv6", prefix: "2001:db8::/64", taken: []string{"2001:db8::1"}, want: "2001:db8::2"},
+		{name: "IPv6 span", prefix: "2001:db8::/64", skip: []addrSpan{span("2001:db8::1", "2001:db8::ff")}, want: "2001:db8::100"},
+		{name: "span from before the subnet", prefix: "10.0.0.0/24", skip: []addrSpan{span("9.255.255.0", "10.0.0.5")}, want: "10.0.0.6"},
+		{name: "/31 has both addresses", prefix: "10.0.0.0/31", taken: []string{"10.0.0.0"}, want: "10.0.0.1"},
+		{name: "/31 full", prefix: "10.0.0.0/31", taken: []string{"10.0.0.0", "10.0.0.1"}, wantErr: true},
+		{name: "/32", prefix: "10.0.0.5/32", want: "10.0.0.5"},
+		{name: "/32 taken", prefix: "10.0.0.5/32", taken: []string{"10.0.0.5"}, wantErr: true},
+		{name: "IPv6 /127", prefix: "2001:db8::/127", taken: []string{"2001:db8::"}, want: "2001:db8::1"},
+		{name: "IPv6 /128", prefix: "2001:db8::7/128", want: "2001:db8::7"},
+	}
+	for _, tt := range tests {
+		taken := make(map[netip.Addr]bool)
+		for _, a := range tt.taken {
+			taken[netip.MustParseAddr(a)] = true
+		}
+		got, err := firstFreeAddress(netip.MustParsePrefix(tt.prefix), taken, tt.skip)
+		if tt.wantErr {
+			if err != ErrNoFreeAddress {
+				t.Errorf("%s: got %v, %v, want ErrNoFreeAddress", tt.name, got, err)
+			}
+			continue
+		}
+		if err != nil || got.String() != tt.want {
+			t.Errorf("%s: got %v, %v, want %s", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestAddrSpanOverlaps(t *testing.T) {
+	tests := []struct {
+		a, b addrSpan
+		want bool
+	}{
+		{span("10.0.0.10", "10.0.0.20"), span("10.0.0.30", "10.0.0.40"), false},
+		{span("10.0.0.10", "10.0.0.20"), span("10.0.0.21", "10.0.0.40"), false},
+		{span("10.0.0.10", "10.0.0.20"), span("10.0.0.20", "10.0.0.40"), true},
+		{span("10.0.0.10", "10.0.0.20"), span("10.0.0.12", "10.0.0.15"), true},
+		{span("10.0.0.12", "10.0.0.15"), span("10.0.0.10", "10.0.0.20"), true},
+		{span("10.0.0.10", "10.0.0.10"), span("10.0.0.10", "10.0.0.10"), true},
+		{span("2001:db8::10", "2001:db8::20"), span("2001:db8::1", "2001:db8::f"), false},
+	}
+	for _, tt := range tests {
+		if got := tt.a.overlaps(tt.b); got != tt.want {
+			t.Errorf("%v overlaps %v = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+		if got := tt.b.overlaps(tt.a); got != tt.want {
+			t.Errorf("%v overlaps %v = %v, want %v", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestAllocateNextIP(t *testing.T) {
+	openTestDB(t)
+
+	// In the global VRF, 10.0.0.1 is on web01, 10.0.0.2 is recorded, 10.0.0.3 is the gateway and
+	// 10.0.0.4–10.0.0.9 is a DHCP pool. The lab VRF is empty.
+	device := models.Device{Hostname: "web01", Status: lifecycle.Active, UHeight: 1,
+		Interfaces: []models.DeviceInterface{{IPAddress: "10.0.0.1", Label: "eth0"}}}
+	if err := AddDevice(device, "alice"); err != nil {
+		t.Fatalf("AddDevice: %v", err)
+	}
+	if err := AddIPAddress(models.IPAddress{Address: "10.0.0.2"}, "alice"); err != nil {
+		t.Fatalf("AddIPAddress: %v", err)
+	}
+	if err := AddSubnet(models.Subnet{CIDR: "10.0.0.0/24", Gateway: "10.0.0.3", Status: "Active"}); err != nil {
+		t.Fatalf("AddSubnet: %v", err)
+	}
+	if err := AddRange(models.IPRange{SubnetID: lastID(t, "subnets"), StartIP: "10.0.0.4", EndIP: "10.0.0.9", Type: "DHCP"}); err != nil {
+		t.Fatalf("AddRange: %v", err)
+	}
+	if err := AddVRF(models.VRF{Name: "lab"}); err != nil {
+		t.Fatalf("AddVRF: %v", err)
+	}
+	lab := lastID(t, "vrfs")
+
+	tests := []struct {
+		name    string
+		cidr    string
+		vrfID   int
+		version int
+		want    string
+		wantErr error
+	}{
+		{name: "first free", cidr: "10.0.0.0/24", want: "10.0.0.10"},
+		{name: "next one", cidr: "10.0.0.0/24", want: "10.0.0.11"},
+		{name: "other VRF", cidr: "10.0.0.0/24", vrfID: lab, want: "10.0.0.1"},
+		{name: "current version", cidr: "10.0.0.0/24", version: 4, want: "10.0.0.12"},
+		{name: "stale version", cidr: "10.0.0.0/24", version: 1, wantErr: ErrStale},
+		{name: "full", cidr: "10.0.0.1/32", wantErr: ErrNoFreeAddress},
+		{name: "IPv6", cidr: "2001:db8::/64", want: "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		iface := models.DeviceInterface{DeviceID: 1, VRFID: tt.vrfID, Label: tt.name}
+		got, err := AllocateNextIP(tt.cidr, iface, tt.version, "bob")
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s: got %v, want %v", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got.IPAddress != tt.want || got.ID == 0 {
+			t.Errorf("%s: got %+v, %v, want a new interface with %s", tt.name, got, err, tt.want)
+		}
+	}
+
+	d, err := GetDevice(1)
+	if err != nil {
+		t.Fatalf("GetDevice: %v", err)
+	}
+	if len(d.Interfaces) != 6 {
+		t.Errorf("web01 has %d interfaces, want 6", len(d.Interfaces))
+	}
+}
+
+func TestCarveSubnet(t *testing.T) {
+	openTestDB(t)
+
+	// 10.1.0.0/26 is taken in the global VRF only
+	if err := AddSubnet(models.Subnet{CIDR: "10.1.0.0/26"}); err != nil {
+		t.Fatalf("AddSubnet: %v", err)
+	}
+	if err := AddVRF(models.VRF{Name: "lab"}); err != nil {
+		t.Fatalf("AddVRF: %v", err)
+	}
+	lab := lastID(t, "vrfs")
+
+	tests := []struct {
+		name    string
+		parent  string
+		bits    int
+		vrfID   int
+		want    string
+		wantErr bool
+	}{
+		{name: "after existing", parent: "10.1.0.0/24", bits: 26, want: "10.1.0.64/26"},
+		{name: "smaller fills gap", parent: "10.1.0.0/24", bits: 27, want: "10.1.0.128/27"},
+		{name: "larger aligned", parent: "10.1.0.0/24", bits: 25, wantErr: true},
+		{name: "other VRF", parent: "10.1.0.0/24", bits: 26, vrfID: lab, want: "10.1.0.0/26"},
+		{name: "remaining /26", parent: "10.1.0.0/24", bits: 26, want: "10.1.0.192/26"},
+		{name: "full", parent: "10.1.0.0/24", bits: 26, wantErr: true},
+		{name: "length of parent", parent: "10.1.0.0/24", bits: 24, wantErr: true},
+		{name: "too long", parent: "10.1.0.0/24", bits: 33, wantErr: true},
+		{name: "IPv6", parent: "2001:db8::/48", bits: 64, want: "2001:db8::/64"},
+		{name: "bad parent", parent: "10.1.0.0", bits: 26, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := CarveSubnet(tt.parent, tt.bits, models.Subnet{Name: tt.name, VRFID: tt.vrfID})
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: carved %s, want an error", tt.name, got.CIDR)
+			}
+			continue
+		}
+		if err != nil || got.CIDR != tt.want || got.ID == 0 {
+			t.Errorf("%s: got %q (id %d), %v, want %q", tt.name, got.CIDR, got.ID, err, tt.want)
+		}
+	}
+
+	free, err := AvailableChildPrefixes(0, "10.1.0.0/24", 27, 10)
+	if err != nil {
+		t.Fatalf("AvailableChildPrefixes: %v", err)
+	}
+	if len(free) != 1 || free[0].String() != "10.1.0.160/27" {
+		t.Errorf("free /27s in the global VRF = %v, want [10.1.0.160/27]", free)
+	}
+	free, err = AvailableChildPrefixes(lab, "10.1.0.0/24", 26, 2)
+	if err != nil {
+		t.Fatalf("AvailableChildPrefixes: %v", err)
+	}
+	if len(free) != 2 || free[0].String() != "10.1.0.64/26" || free[1].String() != "10.1.0.128/26" {
+		t.Errorf("first two free /26s in lab = %v, want [10.1.0.64/26 10.1.0.128/26]", free)
+	}
+}
@@ -0,0 +1,405 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"ipam/internal/models"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Audited object types
+const (
+	AuditDevice = "device"
+	AuditRack   = "rack"
+)
+
+// Audit actions
+const (
+	AuditBaseline = "baseline" // State of an object when the audit log was started
+	AuditCreate   = "create"
+	AuditUpdate   = "update"
+	AuditDelete   = "delete" // Moved to the trash
+	AuditRestore  = "restore"
+	AuditPurge    = "purge" // Deleted for good
+)
+
+// AuditActions lists the audit actions in the order an object goes through them
+var AuditActions = []string{AuditBaseline, AuditCreate, AuditUpdate, AuditDelete, AuditRestore, AuditPurge}
+
+// SystemActor is the actor of the changes the application makes by itself, e.g. releasing expired reservations
+const SystemActor = "system"
+
+// auditField is one field of an object as shown in the audit log
+type auditField struct {
+	name, value string
+}
+
+// deviceFields lists the audited fields of a device, in form order
+func deviceFields(d models.Device) []auditField {
+	model := strings.TrimSpace(d.ManufacturerName + " " + d.ModelName)
+	var expires string
+	if d.ExpiresAt != nil {
+		expires = d.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	ifaces := make([]string, len(d.Interfaces))
+	for i, iface := range d.Interfaces {
+		ifaces[i] = interfaceSummary(iface)
+	}
+	fields := []auditField{
+		{"hostname", d.Hostname},
+		{"device_type", d.DeviceType},
+		{"model", model},
+		{"rack", d.RackName},
+		{"position", d.Units()},
+		{"u_height", strconv.Itoa(d.UHeight)},
+		{"status", d.Status},
+		{"tenant", d.TenantName},
+		{"description", d.Description},
+		{"expires_at", expires},
+		{"interfaces", strings.Join(ifaces, "; ")},
+		{"tags", d.Tags.String()},
+	}
+	return append(fields, customFields(d.CustomFields)...)
+}
+
+// interfaceSummary describes an interface in one line, e.g. "eth0 10.0.0.5 aa:bb:cc:dd:ee:ff (Lab)"
+func interfaceSummary(iface models.DeviceInterface) string {
+	parts := []string{iface.Label, iface.IPAddress, iface.MACAddress}
+	if iface.VRFName != "" && iface.VRFID != GlobalVRFID {
+		parts = append(parts, "("+iface.VRFName+")")
+	}
+	return strings.Join(strings.Fields(strings.Join(parts, " ")), " ")
+}
+
+// rackFields lists the audited fields of a rack, in form order
+func rackFields(r models.Rack) []auditField {
+	fields := []auditField{
+		{"name", r.Name},
+		{"site", r.SiteName},
+		{"room", r.RoomName},
+		{"height", strconv.Itoa(r.Height)},
+		{"status", r.Status},
+		{"tenant", r.TenantName},
+		{"tags", r.Tags.String()},
+	}
+	return append(fields, customFields(r.CustomFields)...)
+}
+
+// customFields lists custom field values as audited fields, by field name
+func customFields(values map[string]string) []auditField {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fields := make([]auditField, len(names))
+	for i, name := range names {
+		fields[i] = auditField{"custom." + name, values[name]}
+	}
+	return fields
+}
+
+// diffFields compares the fields of an object before and after a change. Either side may be nil,
+// for an object that did not exist yet or no longer exists.
+func diffFields(before, after []auditField) []models.FieldChange {
+	var changes []models.FieldChange
+	seen := make(map[string]bool)
+	add := func(name, b, a string) {
+		if b != a {
+			changes = append(changes, models.FieldChange{Field: name, Before: b, After: a})
+		}
+	}
+	for _, f := range after {
+		seen[f.name] = true
+		add(f.name, fieldValue(before, f.name), f.value)
+	}
+	for _, f := range before {
+		if !seen[f.name] {
+			add(f.name, f.value, "")
+		}
+	}
+	return changes
+}
+
+func fieldValue(fields []auditField, name string) string {
+	for _, f := range fields {
+		if f.name == name {
+			return f.value
+		}
+	}
+	return ""
+}
+
+// writeAudit appends an entry to the audit log. snapshot is the object after the change,
+// nil once it is gone. Updates that change nothing are not recorded.
+func writeAudit(tx *sql.Tx, objectType string, objectID int, name, action, actor string, changes []models.FieldChange, snapshot interface{}) error {
+	if action == AuditUpdate && len(changes) == 0 {
+		return nil
+	}
+	changesJSON, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	var snapshotJSON []byte
+	if snapshot != nil {
+		if snapshotJSON, err = json.Marshal(snapshot); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`INSERT INTO audit_log (object_type, object_id, object_name, action, actor, changes, snapshot, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, objectType, objectID, name, action, actor, string(changesJSON), string(snapshotJSON), time.Now().UTC())
+	return err
+}
+
+// deviceSnapshot reads a device as it is inside tx, whether or not it is in the trash
+func deviceSnapshot(tx *sql.Tx, id int) (*models.Device, error) {
+	d, err := loadDevice(tx, id, "")
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// auditDevice records a change of a device. before is the device as it was, nil when it is
+// created or its baseline is recorded; the device is read again for its new state unless it was purged.
+func auditDevice(tx *sql.Tx, id int, action, actor string, before *models.Device) error {
+	var after *models.Device
+	if action != AuditPurge {
+		var err error
+		if after, err = deviceSnapshot(tx, id); err != nil {
+			return err
+		}
+	}
+
+	var beforeFields, afterFields []auditField
+	name := ""
+	if before != nil {
+		beforeFields, name = deviceFields(*before), before.Hostname
+	}
+	if after != nil {
+		afterFields, name = deviceFields(*after), after.Hostname
+	}
+	var changes []models.FieldChange
+	if action != AuditDelete && action != AuditRestore {
+		changes = diffFields(beforeFields, afterFields)
+	}
+	if after == nil {
+		return writeAudit(tx, AuditDevice, id, name, action, actor, changes, nil)
+	}
+	return writeAudit(tx, AuditDevice, id, name, action, actor, changes, after)
+}
+
+// rackSnapshot reads a rack as it is inside tx, whether or not it is in the trash
+func rackSnapshot(tx *sql.Tx, id int) (*models.Rack, error) {
+	r, err := loadRack(tx, id, "")
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// auditRack records a change of a rack, like auditDevice
+func auditRack(tx *sql.Tx, id int, action, actor string, before *models.Rack) error {
+	var after *models.Rack
+	if action != AuditPurge {
+		var err error
+		if after, err = rackSnapshot(tx, id); err != nil {
+			return err
+		}
+	}
+
+	var beforeFields, afterFields []auditField
+	name := ""
+	if before != nil {
+		beforeFields, name = rackFields(*before), before.Name
+	}
+	if after != nil {
+		afterFields, name = rackFields(*after), after.Name
+	}
+	var changes []models.FieldChange
+	if action != AuditDelete && action != AuditRestore {
+		changes = diffFields(beforeFields, afterFields)
+	}
+	if after == nil {
+		return writeAudit(tx, AuditRack, id, name, action, actor, changes, nil)
+	}
+	return writeAudit(tx, AuditRack, id, name, action, actor, changes, after)
+}
+
+// Changes to many devices or racks at once, e.g. deleting the tenant they belong to, are audited
+// in three steps inside their transaction: snapshot the objects concerned, change them, then
+// record an update of each. Objects in the trash are left out: an update entry would bring them
+// back into the past inventory, and their restore records the state they come back in.
+
+// snapshotDevices reads the devices outside the trash whose ID is selected by idQuery, ahead of
+// a change to all of them
+func snapshotDevices(tx *sql.Tx, idQuery string, args ...interface{}) ([]*models.Device, error) {
+	ids, err := queryIDs(tx, "SELECT id FROM devices WHERE deleted_at IS NULL AND id IN ("+idQuery+") ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]*models.Device, len(ids))
+	for i, id := range ids {
+		if devices[i], err = deviceSnapshot(tx, id); err != nil {
+			return nil, err
+		}
+	}
+	return devices, nil
+}
+
+// auditDeviceUpdates records an update of each device read by snapshotDevices
+func auditDeviceUpdates(tx *sql.Tx, before []*models.Device, actor string) error {
+	for _, d := range before {
+		if err := auditDevice(tx, d.ID, AuditUpdate, actor, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshotRacks reads the racks outside the trash whose ID is selected by idQuery, like snapshotDevices
+func snapshotRacks(tx *sql.Tx, idQuery string, args ...interface{}) ([]*models.Rack, error) {
+	ids, err := queryIDs(tx, "SELECT id FROM racks WHERE deleted_at IS NULL AND id IN ("+idQuery+") ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	racks := make([]*models.Rack, len(ids))
+	for i, id := range ids {
+		if racks[i], err = rackSnapshot(tx, id); err != nil {
+			return nil, err
+		}
+	}
+	return racks, nil
+}
+
+// auditRackUpdates records an update of each rack read by snapshotRacks
+func auditRackUpdates(tx *sql.Tx, before []*models.Rack, actor string) error {
+	for _, r := range before {
+		if err := auditRack(tx, r.ID, AuditUpdate, actor, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryIDs runs a query returning one ID per row
+func queryIDs(tx *sql.Tx, query string, args ...interface{}) ([]int, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// AuditFilter narrows down the audit log. Zero values match everything.
+type AuditFilter struct {
+	ObjectType string
+	ObjectID   int
+	Action     string
+	Actor      string
+	Query      string    // Part of the object name
+	Since      time.Time // Changes at or after
+	Until      time.Time // Changes before
+	BeforeID   int       // Entries older than this one, to page through the log
+	Limit      int
+}
+
+// GetAuditLog retrieves the audit log entries matching the filter, newest first
+func GetAuditLog(f AuditFilter) ([]models.AuditEntry, error) {
+	query := `SELECT id, object_type, object_id, COALESCE(object_name, ''), action, COALESCE(actor, ''), COALESCE(changes, ''), created_at
+		FROM audit_log WHERE 1 = 1`
+	var args []interface{}
+	if f.ObjectType != "" {
+		query += " AND object_type = ?"
+		args = append(args, f.ObjectType)
+	}
+	if f.ObjectID != 0 {
+		query += " AND object_id = ?"
+		args = append(args, f.ObjectID)
+	}
+	if f.Action != "" {
+		query += " AND action = ?"
+		args = append(args, f.Action)
+	}
+	if f.Actor != "" {
+		query += " AND actor = ?"
+		args = append(args, f.Actor)
+	}
+	if f.Query != "" {
+		query += " AND object_name LIKE ?"
+		args = append(args, "%"+f.Query+"%")
+	}
+	if !f.Since.IsZero() {
+		query += " AND created_at >= ?"
+		args = append(args, f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		query += " AND created_at < ?"
+		args = append(args, f.Until.UTC())
+	}
+	if f.BeforeID != 0 {
+		query += " AND id < ?"
+		args = append(args, f.BeforeID)
+	}
+	query += " ORDER BY id DESC"
+	if f.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, f.Limit)
+	}
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.AuditEntry
+	for rows.Next() {
+		var e models.AuditEntry
+		var changes string
+		if err := rows.Scan(&e.ID, &e.ObjectType, &e.ObjectID, &e.ObjectName, &e.Action, &e.Actor, &changes, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if changes != "" {
+			if err := json.Unmarshal([]byte(changes), &e.Changes); err != nil {
+				return nil, fmt.Errorf("audit entry %d: %w", e.ID, err)
+			}
+		}
+		e.CreatedAt = e.CreatedAt.Local()
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// GetAuditActors lists everyone who appears in the audit log, by name
+func GetAuditActors() ([]string, error) {
+	rows, err := DB.Query("SELECT DISTINCT actor FROM audit_log WHERE COALESCE(actor, '') != '' ORDER BY actor")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var actors []string
+	for rows.Next() {
+		var actor string
+		if err := rows.Scan(&actor); err != nil {
+			return nil, err
+		}
+		actors = append(actors, actor)
+	}
+	return actors, rows.Err()
+}
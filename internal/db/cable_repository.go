@@ -0,0 +1,173 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"ipam/internal/models"
+	"time"
+)
+
+var (
+	// ErrInterfaceCabled is returned when connecting an interface that already has a cable
+	ErrInterfaceCabled = errors.New("interface already has a cable")
+	// ErrInterfaceNotFound is returned when a cable end is not an existing interface
+	ErrInterfaceNotFound = errors.New("interface does not exist")
+)
+
+const cableSelect = `SELECT c.id, c.a_interface_id, c.b_interface_id, COALESCE(c.cable_type, ''), COALESCE(c.color, ''),
+	COALESCE(c.length, 0), COALESCE(c.label, ''), c.created_at,
+	COALESCE(ad.id, 0), COALESCE(ad.hostname, ''), COALESCE(ai.label, ''), COALESCE(ai.ip_address, ''),
+	COALESCE(bd.id, 0), COALESCE(bd.hostname, ''), COALESCE(bi.label, ''), COALESCE(bi.ip_address, '')
+	FROM cables c
+	LEFT JOIN device_interfaces ai ON ai.id = c.a_interface_id
+	LEFT JOIN devices ad ON ad.id = ai.device_id
+	LEFT JOIN device_interfaces bi ON bi.id = c.b_interface_id
+	LEFT JOIN devices bd ON bd.id = bi.device_id`
+
+func scanCable(row interface{ Scan(...interface{}) error }, c *models.Cable) error {
+	return row.Scan(&c.ID, &c.AInterfaceID, &c.BInterfaceID, &c.Type, &c.Color, &c.Length, &c.Label, &c.CreatedAt,
+		&c.A.DeviceID, &c.A.Hostname, &c.A.Label, &c.A.IPAddress,
+		&c.B.DeviceID, &c.B.Hostname, &c.B.Label, &c.B.IPAddress)
+}
+
+// GetAllCables retrieves all cables with the interfaces on both ends, ordered by the A end
+func GetAllCables() ([]models.Cable, error) {
+	rows, err := DB.Query(cableSelect + " ORDER BY ad.hostname, ai.label, c.id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cables []models.Cable
+	for rows.Next() {
+		var c models.Cable
+		if err := scanCable(rows, &c); err != nil {
+			return nil, err
+		}
+		cables = append(cables, c)
+	}
+	return cables, rows.Err()
+}
+
+// GetCable retrieves a single cable by ID
+func GetCable(id int) (models.Cable, error) {
+	var c models.Cable
+	err := scanCable(DB.QueryRow(cableSelect+" WHERE c.id = ?", id), &c)
+	return c, err
+}
+
+// checkCableEnds makes sure both ends of a cable are existing interfaces without another cable
+func checkCableEnds(tx *sql.Tx, c models.Cable) error {
+	if c.AInterfaceID == c.BInterfaceID {
+		return errors.New("a cable needs two different interfaces")
+	}
+	for _, ifaceID := range []int{c.AInterfaceID, c.BInterfaceID} {
+		var end models.CableEnd
+		// Interfaces of devices in the trash cannot be cabled
+		err := tx.QueryRow(`SELECT d.id, d.hostname, i.label, i.ip_address FROM device_interfaces i
+			JOIN devices d ON d.id = i.device_id WHERE i.id = ? AND d.deleted_at IS NULL`, ifaceID).
+			Scan(&end.DeviceID, &end.Hostname, &end.Label, &end.IPAddress)
+		if err == sql.ErrNoRows {
+			return ErrInterfaceNotFound
+		} else if err != nil {
+			return err
+		}
+
+		var count int
+		err = tx.QueryRow("SELECT COUNT(*) FROM cables WHERE (a_interface_id = ? OR b_interface_id = ?) AND id != ?",
+			ifaceID, ifaceID, c.ID).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("%s: %w", end, ErrInterfaceCabled)
+		}
+	}
+	return nil
+}
+
+// AddCable connects two interfaces. It returns an error wrapping ErrInterfaceCabled when one of
+// them already has a cable.
+func AddCable(c models.Cable) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkCableEnds(tx, c); err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO cables (a_interface_id, b_interface_id, cable_type, color, length, label, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		c.AInterfaceID, c.BInterfaceID, c.Type, c.Color, c.Length, c.Label, time.Now())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateCable changes the ends and the properties of a cable
+func UpdateCable(c models.Cable) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkCableEnds(tx, c); err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE cables SET a_interface_id=?, b_interface_id=?, cable_type=?, color=?, length=?, label=? WHERE id=?",
+		c.AInterfaceID, c.BInterfaceID, c.Type, c.Color, c.Length, c.Label, c.ID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteCable removes a cable
+func DeleteCable(id int) error {
+	_, err := DB.Exec("DELETE FROM cables WHERE id = ?", id)
+	return err
+}
+
+// cablePeers returns the cables plugged into the interfaces of a device, keyed by interface ID
+func cablePeers(q queryer, deviceID int) (map[int]*models.CablePeer, error) {
+	rows, err := q.Query(`SELECT c.id, i.id, o.id, d.id, d.hostname, o.label, o.ip_address,
+		COALESCE(c.cable_type, ''), COALESCE(c.color, ''), COALESCE(c.label, '')
+		FROM cables c
+		JOIN device_interfaces i ON i.id IN (c.a_interface_id, c.b_interface_id)
+		JOIN device_interfaces o ON o.id = CASE WHEN i.id = c.a_interface_id THEN c.b_interface_id ELSE c.a_interface_id END
+		JOIN devices d ON d.id = o.device_id
+		WHERE i.device_id = ?`, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	peers := make(map[int]*models.CablePeer)
+	for rows.Next() {
+		var ifaceID int
+		p := &models.CablePeer{}
+		if err := rows.Scan(&p.CableID, &ifaceID, &p.InterfaceID, &p.End.DeviceID, &p.End.Hostname, &p.End.Label,
+			&p.End.IPAddress, &p.Type, &p.Color, &p.Label); err != nil {
+			return nil, err
+		}
+		peers[ifaceID] = p
+	}
+	return peers, rows.Err()
+}
+
+// deleteInterfaceCables removes the cables plugged into an interface
+func deleteInterfaceCables(tx *sql.Tx, interfaceID int) error {
+	_, err := tx.Exec("DELETE FROM cables WHERE a_interface_id = ? OR b_interface_id = ?", interfaceID, interfaceID)
+	return err
+}
+
+// deleteDeviceCables removes the cables plugged into the interfaces of a device
+func deleteDeviceCables(tx *sql.Tx, deviceID int) error {
+	_, err := tx.Exec(`DELETE FROM cables WHERE a_interface_id IN (SELECT id FROM device_interfaces WHERE device_id = ?)
+		OR b_interface_id IN (SELECT id FROM device_interfaces WHERE device_id = ?)`, deviceID, deviceID)
+	return err
+}
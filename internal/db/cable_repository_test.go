@@ -0,0 +1,69 @@
+package db
+
+import (
+	"errors"
+	"ipam/internal/lifecycle"
+	"ipam/internal/models"
+	"testing"
+)
+
+func TestAddCable(t *testing.T) {
+	openTestDB(t)
+	f := newInterfaceFixture(t)
+
+	// db01 is in the trash
+	db01 := models.Device{Hostname: "db01", Status: lifecycle.Active, UHeight: 1,
+		Interfaces: []models.DeviceInterface{{IPAddress: "10.0.2.1", Label: "eth0"}}}
+	if err := AddDevice(db01, "alice"); err != nil {
+		t.Fatalf("AddDevice: %v", err)
+	}
+	trashed := lastID(t, "device_interfaces")
+	if err := DeleteDevice(lastID(t, "devices"), "alice"); err != nil {
+		t.Fatalf("DeleteDevice: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		a, b    int
+		wantErr error
+		anyErr  bool
+	}{
+		{name: "A end cabled", a: f.eth1, b: f.eth0, wantErr: ErrInterfaceCabled},
+		{name: "B end cabled", a: f.eth0, b: f.other, wantErr: ErrInterfaceCabled},
+		{name: "same interface", a: f.eth0, b: f.eth0, anyErr: true},
+		{name: "unknown interface", a: f.eth0, b: 99, wantErr: ErrInterfaceNotFound},
+		{name: "device in the trash", a: f.eth0, b: trashed, wantErr: ErrInterfaceNotFound},
+	}
+	for _, tt := range tests {
+		err := AddCable(models.Cable{AInterfaceID: tt.a, BInterfaceID: tt.b, Type: "Cat6"})
+		switch {
+		case tt.anyErr:
+			if err == nil {
+				t.Errorf("%s: AddCable succeeded, want an error", tt.name)
+			}
+		case !errors.Is(err, tt.wantErr):
+			t.Errorf("%s: AddCable = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	// The cable of the fixture can be edited without its own ends counting as cabled,
+	// and moving its A end frees the old one
+	c, err := GetCable(1)
+	if err != nil {
+		t.Fatalf("GetCable: %v", err)
+	}
+	c.AInterfaceID, c.Label = f.eth0, "C-001"
+	if err := UpdateCable(c); err != nil {
+		t.Fatalf("UpdateCable: %v", err)
+	}
+	web01, err := GetDevice(f.device)
+	if err != nil {
+		t.Fatalf("GetDevice: %v", err)
+	}
+	for _, iface := range web01.Interfaces {
+		cabled := iface.Peer != nil && iface.Peer.InterfaceID == f.other && iface.Peer.End.Hostname == "web02"
+		if cabled != (iface.ID == f.eth0) {
+			t.Errorf("%s peer = %+v, want only eth0 cabled to web02", iface.Label, iface.Peer)
+		}
+	}
+}
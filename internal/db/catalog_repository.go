@@ -0,0 +1,200 @@
+package db
+
+import (
+	"errors"
+	"ipam/internal/models"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrManufacturerExists is returned when a manufacturer name is already taken (names are case-insensitive)
+	ErrManufacturerExists = errors.New("a manufacturer with this name already exists")
+	// ErrDeviceModelExists is returned when the manufacturer already has a model with this name
+	ErrDeviceModelExists = errors.New("this manufacturer already has a model with this name")
+	// ErrManufacturerInUse is returned when deleting a manufacturer that still has models
+	ErrManufacturerInUse = errors.New("the manufacturer still has models; delete them first")
+)
+
+// GetAllManufacturers retrieves all manufacturers ordered by name
+func GetAllManufacturers() ([]models.Manufacturer, error) {
+	rows, err := DB.Query("SELECT id, name, created_at FROM manufacturers ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var manufacturers []models.Manufacturer
+	for rows.Next() {
+		var m models.Manufacturer
+		if err := rows.Scan(&m.ID, &m.Name, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		manufacturers = append(manufacturers, m)
+	}
+	return manufacturers, rows.Err()
+}
+
+// GetManufacturer retrieves a single manufacturer by ID
+func GetManufacturer(id int) (models.Manufacturer, error) {
+	var m models.Manufacturer
+	err := DB.QueryRow("SELECT id, name, created_at FROM manufacturers WHERE id = ?", id).Scan(&m.ID, &m.Name, &m.CreatedAt)
+	return m, err
+}
+
+// AddManufacturer adds a new manufacturer
+func AddManufacturer(m models.Manufacturer) error {
+	m.Name = normalizeName(m.Name)
+	taken, err := countsAny(DB, "SELECT COUNT(*) FROM manufacturers WHERE name = ? COLLATE NOCASE", m.Name)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrManufacturerExists
+	}
+
+	_, err = DB.Exec("INSERT INTO manufacturers (name, created_at) VALUES (?, ?)", m.Name, time.Now())
+	return err
+}
+
+// UpdateManufacturer renames a manufacturer
+func UpdateManufacturer(m models.Manufacturer) error {
+	m.Name = normalizeName(m.Name)
+	taken, err := countsAny(DB, "SELECT COUNT(*) FROM manufacturers WHERE name = ? COLLATE NOCASE AND id != ?", m.Name, m.ID)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrManufacturerExists
+	}
+
+	_, err = DB.Exec("UPDATE manufacturers SET name=? WHERE id=?", m.Name, m.ID)
+	return err
+}
+
+// DeleteManufacturer deletes a manufacturer without models
+func DeleteManufacturer(id int) error {
+	inUse, err := countsAny(DB, "SELECT COUNT(*) FROM device_models WHERE manufacturer_id = ?", id)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		return ErrManufacturerInUse
+	}
+
+	_, err = DB.Exec("DELETE FROM manufacturers WHERE id = ?", id)
+	return err
+}
+
+const deviceModelSelect = `SELECT m.id, m.manufacturer_id, COALESCE(v.name, ''), m.name, COALESCE(m.part_number, ''),
+	COALESCE(m.u_height, 1), COALESCE(m.interface_template, ''), m.created_at
+	FROM device_models m LEFT JOIN manufacturers v ON v.id = m.manufacturer_id`
+
+func scanDeviceModel(row interface{ Scan(...interface{}) error }, m *models.DeviceModel) error {
+	var template string
+	if err := row.Scan(&m.ID, &m.ManufacturerID, &m.ManufacturerName, &m.Name, &m.PartNumber, &m.UHeight, &template, &m.CreatedAt); err != nil {
+		return err
+	}
+	m.Interfaces = nil
+	if template != "" {
+		m.Interfaces = strings.Split(template, "\n")
+	}
+	return nil
+}
+
+// GetAllDeviceModels retrieves all models ordered by manufacturer and name
+func GetAllDeviceModels() ([]models.DeviceModel, error) {
+	rows, err := DB.Query(deviceModelSelect + " ORDER BY v.name, m.name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deviceModels []models.DeviceModel
+	for rows.Next() {
+		var m models.DeviceModel
+		if err := scanDeviceModel(rows, &m); err != nil {
+			return nil, err
+		}
+		deviceModels = append(deviceModels, m)
+	}
+	return deviceModels, rows.Err()
+}
+
+// GetDeviceModel retrieves a single model by ID
+func GetDeviceModel(id int) (models.DeviceModel, error) {
+	var m models.DeviceModel
+	err := scanDeviceModel(DB.QueryRow(deviceModelSelect+" WHERE m.id = ?", id), &m)
+	return m, err
+}
+
+// interfaceTemplate stores the interface labels of a model one per line, skipping blank and repeated ones
+func interfaceTemplate(labels []string) string {
+	var kept []string
+	seen := make(map[string]bool)
+	for _, label := range labels {
+		if label = strings.TrimSpace(label); label != "" && !seen[label] {
+			seen[label] = true
+			kept = append(kept, label)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
+// AddDeviceModel adds a new model to the catalog
+func AddDeviceModel(m models.DeviceModel) error {
+	m.Name = normalizeName(m.Name)
+	taken, err := countsAny(DB, "SELECT COUNT(*) FROM device_models WHERE manufacturer_id = ? AND name = ? COLLATE NOCASE", m.ManufacturerID, m.Name)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrDeviceModelExists
+	}
+
+	_, err = DB.Exec("INSERT INTO device_models (manufacturer_id, name, part_number, u_height, interface_template, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		m.ManufacturerID, m.Name, m.PartNumber, m.UHeight, interfaceTemplate(m.Interfaces), time.Now())
+	return err
+}
+
+// UpdateDeviceModel updates a model. Devices created from it earlier keep their interfaces and height.
+func UpdateDeviceModel(m models.DeviceModel) error {
+	m.Name = normalizeName(m.Name)
+	taken, err := countsAny(DB, "SELECT COUNT(*) FROM device_models WHERE manufacturer_id = ? AND name = ? COLLATE NOCASE AND id != ?",
+		m.ManufacturerID, m.Name, m.ID)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrDeviceModelExists
+	}
+
+	_, err = DB.Exec("UPDATE device_models SET manufacturer_id=?, name=?, part_number=?, u_height=?, interface_template=? WHERE id=?",
+		m.ManufacturerID, m.Name, m.PartNumber, m.UHeight, interfaceTemplate(m.Interfaces), m.ID)
+	return err
+}
+
+// DeleteDeviceModel deletes a model. Its devices are kept without a model; actor is recorded
+// in the audit log for each of them.
+func DeleteDeviceModel(id int, actor string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	devices, err := snapshotDevices(tx, "SELECT id FROM devices WHERE model_id = ?", id)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE devices SET model_id = 0, version = version + 1 WHERE model_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM device_models WHERE id = ?", id); err != nil {
+		return err
+	}
+	if err := auditDeviceUpdates(tx, devices, actor); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,64 @@
+package db
+
+import (
+	"errors"
+	"ipam/internal/lifecycle"
+	"ipam/internal/models"
+	"slices"
+	"testing"
+)
+
+func TestDeviceCatalog(t *testing.T) {
+	openTestDB(t)
+
+	manufacturers := []struct {
+		name    string
+		wantErr error
+	}{
+		{name: "Dell"},
+		{name: "Juniper  Networks"},
+		{name: " dell ", wantErr: ErrManufacturerExists},
+		{name: "juniper networks", wantErr: ErrManufacturerExists},
+	}
+	for _, tt := range manufacturers {
+		if err := AddManufacturer(models.Manufacturer{Name: tt.name}); !errors.Is(err, tt.wantErr) {
+			t.Errorf("AddManufacturer(%q) = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	deviceModels := []struct {
+		model   models.DeviceModel
+		wantErr error
+	}{
+		{model: models.DeviceModel{ManufacturerID: 1, Name: "R640", UHeight: 1, Interfaces: []string{" eth0", "eth1", "", "eth0", "ipmi"}}},
+		{model: models.DeviceModel{ManufacturerID: 2, Name: "R640", UHeight: 1}},
+		{model: models.DeviceModel{ManufacturerID: 1, Name: "r640", UHeight: 2}, wantErr: ErrDeviceModelExists},
+	}
+	for _, tt := range deviceModels {
+		if err := AddDeviceModel(tt.model); !errors.Is(err, tt.wantErr) {
+			t.Errorf("AddDeviceModel(%d, %q) = %v, want %v", tt.model.ManufacturerID, tt.model.Name, err, tt.wantErr)
+		}
+	}
+	m, err := GetDeviceModel(1)
+	if err != nil || m.ManufacturerName != "Dell" || !slices.Equal(m.Interfaces, []string{"eth0", "eth1", "ipmi"}) {
+		t.Errorf("model = %+v, %v, want Dell R640 with eth0, eth1 and ipmi", m, err)
+	}
+
+	// A manufacturer with models is kept; deleting a model keeps its devices without a model
+	if err := DeleteManufacturer(1); !errors.Is(err, ErrManufacturerInUse) {
+		t.Errorf("DeleteManufacturer with models = %v, want %v", err, ErrManufacturerInUse)
+	}
+	if err := AddDevice(models.Device{Hostname: "web01", Status: lifecycle.Active, UHeight: 1, ModelID: 1}, "alice"); err != nil {
+		t.Fatalf("AddDevice: %v", err)
+	}
+	if err := DeleteDeviceModel(1, "bob"); err != nil {
+		t.Fatalf("DeleteDeviceModel: %v", err)
+	}
+	d, err := GetDevice(1)
+	if err != nil || d.ModelID != 0 || d.Version != 2 {
+		t.Errorf("web01 model %d at version %d, %v, want no model at version 2", d.ModelID, d.Version, err)
+	}
+	if err := DeleteManufacturer(1); err != nil {
+		t.Errorf("DeleteManufacturer without models = %v", err)
+	}
+}
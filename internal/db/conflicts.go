@@ -0,0 +1,167 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"ipam/internal/models"
+	"strings"
+)
+
+// Conflict is an address of a device being saved that is already in use in the same VRF
+type Conflict struct {
+	Kind     string // "IP" or "MAC"
+	Value    string
+	VRFName  string
+	DeviceID int    // Device already using the value (0 for a device not saved yet)
+	Hostname string // Name of that device, or a description of the address record
+	Record   bool   // Held by a standalone address record rather than a device
+}
+
+func (c Conflict) String() string {
+	if c.Record {
+		return fmt.Sprintf("%s %s is held by address record %s", c.Kind, c.Value, c.Hostname)
+	}
+	return fmt.Sprintf("%s %s is already used by %s", c.Kind, c.Value, c.Hostname)
+}
+
+// ConflictError is returned by AddDevice and UpdateDevice when addresses of the device clash
+// with other devices, unless the device is saved with AllowDuplicates
+type ConflictError struct {
+	Conflicts []Conflict
+}
+
+func (e *ConflictError) Error() string {
+	msgs := make([]string, len(e.Conflicts))
+	for i, c := range e.Conflicts {
+		msgs[i] = c.String()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// normalizeMAC makes MAC addresses comparable regardless of case and separator
+func normalizeMAC(mac string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(mac)), "-", ":")
+}
+
+// checkConflicts looks for interface IPs and MACs of device d that are already used in the same
+// VRF by another device. IPs also clash with a second interface of d itself and with address
+// records that are not attached to d. Addresses d already had before the save are not checked
+// again, so an accepted duplicate does not block later edits.
+func checkConflicts(tx *sql.Tx, d models.Device) error {
+	// Addresses the device already had were either checked or accepted before
+	current := make(map[string]bool)
+	rows, err := tx.Query("SELECT COALESCE(vrf_id, 1), ip_address, mac_address FROM device_interfaces WHERE device_id = ?", d.ID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var vrfID int
+		var ip, mac string
+		if err := rows.Scan(&vrfID, &ip, &mac); err != nil {
+			rows.Close()
+			return err
+		}
+		current[fmt.Sprintf("%d/%s", vrfID, ip)] = true
+		current[fmt.Sprintf("%d/%s", vrfID, normalizeMAC(mac))] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return findConflicts(tx, d, current)
+}
+
+// findConflicts checks the interfaces of device d for conflicts, skipping the addresses in
+// current (keyed "vrf/ip" and "vrf/mac")
+func findConflicts(tx *sql.Tx, d models.Device, current map[string]bool) error {
+	var conflicts []Conflict
+	seenIPs := make(map[string]bool)
+	for _, iface := range d.Interfaces {
+		var vrfName string
+		if err := tx.QueryRow("SELECT name FROM vrfs WHERE id = ?", iface.VRFID).Scan(&vrfName); err != nil {
+			return err
+		}
+
+		// Interfaces of retired devices have no address to conflict with
+		ipKey := fmt.Sprintf("%d/%s", iface.VRFID, iface.IPAddress)
+		if iface.IPAddress != "" && seenIPs[ipKey] {
+			conflicts = append(conflicts, Conflict{Kind: "IP", Value: iface.IPAddress, VRFName: vrfName, DeviceID: d.ID, Hostname: "another interface of this device"})
+		}
+		seenIPs[ipKey] = true
+		if iface.IPAddress != "" && !current[ipKey] {
+			found, err := ipConflicts(tx, d.ID, iface)
+			if err != nil {
+				return err
+			}
+			conflicts = append(conflicts, withVRF(found, vrfName)...)
+		}
+
+		// Interfaces of one device may share a MAC (e.g. VLAN sub-interfaces), so only other devices count
+		mac := normalizeMAC(iface.MACAddress)
+		if mac != "" && !current[fmt.Sprintf("%d/%s", iface.VRFID, mac)] {
+			found, err := macConflicts(tx, d.ID, iface)
+			if err != nil {
+				return err
+			}
+			conflicts = append(conflicts, withVRF(found, vrfName)...)
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return &ConflictError{Conflicts: conflicts}
+	}
+	return nil
+}
+
+// ipConflicts finds another device, or an address record not attached to the device, using the IP
+func ipConflicts(tx *sql.Tx, deviceID int, iface models.DeviceInterface) ([]Conflict, error) {
+	var conflicts []Conflict
+
+	c := Conflict{Kind: "IP", Value: iface.IPAddress}
+	err := tx.QueryRow(`SELECT d.id, d.hostname FROM device_interfaces i JOIN devices d ON i.device_id = d.id
+		WHERE i.vrf_id = ? AND i.ip_address = ? AND i.device_id != ? AND d.deleted_at IS NULL LIMIT 1`,
+		iface.VRFID, iface.IPAddress, deviceID).Scan(&c.DeviceID, &c.Hostname)
+	if err == nil {
+		conflicts = append(conflicts, c)
+	} else if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	// Records attached to one of the device's interfaces follow the device, so only others count
+	var purpose, dnsName string
+	err = tx.QueryRow(`SELECT COALESCE(purpose, ''), COALESCE(dns_name, '') FROM ip_addresses
+		WHERE vrf_id = ? AND address = ? AND interface_id NOT IN (SELECT id FROM device_interfaces WHERE device_id = ?)
+		LIMIT 1`, iface.VRFID, iface.IPAddress, deviceID).Scan(&purpose, &dnsName)
+	if err == nil {
+		label := strings.TrimSpace(dnsName + " " + purpose)
+		if label == "" {
+			label = "(no name)"
+		}
+		conflicts = append(conflicts, Conflict{Kind: "IP", Value: iface.IPAddress, Hostname: label, Record: true})
+	} else if err != sql.ErrNoRows {
+		return nil, err
+	}
+	return conflicts, nil
+}
+
+// macConflicts finds another device using the MAC of the interface in the same VRF
+func macConflicts(tx *sql.Tx, deviceID int, iface models.DeviceInterface) ([]Conflict, error) {
+	c := Conflict{Kind: "MAC", Value: iface.MACAddress}
+	err := tx.QueryRow(`SELECT d.id, d.hostname FROM device_interfaces i JOIN devices d ON i.device_id = d.id
+		WHERE i.vrf_id = ? AND LOWER(REPLACE(TRIM(i.mac_address), '-', ':')) = ? AND i.device_id != ? AND d.deleted_at IS NULL
+		LIMIT 1`,
+		iface.VRFID, normalizeMAC(iface.MACAddress), deviceID).Scan(&c.DeviceID, &c.Hostname)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return []Conflict{c}, nil
+}
+
+func withVRF(conflicts []Conflict, vrfName string) []Conflict {
+	for i := range conflicts {
+		conflicts[i].VRFName = vrfName
+	}
+	return conflicts
+}
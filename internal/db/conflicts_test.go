@@ -0,0 +1,95 @@
+package db
+
+import (
+	"errors"
+	"ipam/internal/lifecycle"
+	"ipam/internal/models"
+	"reflect"
+	"testing"
+)
+
+func TestDeviceConflicts(t *testing.T) {
+	openTestDB(t)
+
+	// web01 holds 10.0.0.1 and aa:bb:cc:00:00:01, old01 in the trash held 10.0.0.9,
+	// and the address record "printer" holds 10.0.0.2
+	web01 := models.Device{Hostname: "web01", Status: lifecycle.Active, UHeight: 1,
+		Interfaces: []models.DeviceInterface{{IPAddress: "10.0.0.1", MACAddress: "aa:bb:cc:00:00:01"}}}
+	old01 := models.Device{Hostname: "old01", Status: lifecycle.Active, UHeight: 1,
+		Interfaces: []models.DeviceInterface{{IPAddress: "10.0.0.9", MACAddress: "aa:bb:cc:00:00:09"}}}
+	for _, d := range []models.Device{web01, old01} {
+		if err := AddDevice(d, "alice"); err != nil {
+			t.Fatalf("AddDevice(%s): %v", d.Hostname, err)
+		}
+	}
+	if err := DeleteDevice(2, "alice"); err != nil {
+		t.Fatalf("DeleteDevice: %v", err)
+	}
+	if err := AddIPAddress(models.IPAddress{Address: "10.0.0.2", DNSName: "printer"}, "alice"); err != nil {
+		t.Fatalf("AddIPAddress: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		interfaces []models.DeviceInterface
+		allow      bool
+		want       []string // Conflict.String() of each expected conflict
+	}{
+		{name: "free", interfaces: []models.DeviceInterface{{IPAddress: "10.0.0.3"}}},
+		{
+			name:       "IP of another device",
+			interfaces: []models.DeviceInterface{{IPAddress: "10.0.0.1"}},
+			want:       []string{"IP 10.0.0.1 is already used by web01"},
+		},
+		{
+			name:       "MAC in another spelling",
+			interfaces: []models.DeviceInterface{{IPAddress: "10.0.0.4", MACAddress: "AA-BB-CC-00-00-01"}},
+			want:       []string{"MAC aa:bb:cc:00:00:01 is already used by web01"},
+		},
+		{
+			name:       "address record",
+			interfaces: []models.DeviceInterface{{IPAddress: "10.0.0.2"}},
+			want:       []string{"IP 10.0.0.2 is held by address record printer"},
+		},
+		{
+			name:       "same IP twice",
+			interfaces: []models.DeviceInterface{{IPAddress: "10.0.0.5"}, {IPAddress: "10.0.0.5"}},
+			want:       []string{"IP 10.0.0.5 is already used by another interface of this device"},
+		},
+		{
+			name:       "shared MAC on one device",
+			interfaces: []models.DeviceInterface{{IPAddress: "10.0.0.6", MACAddress: "aa:bb:cc:00:00:06"}, {IPAddress: "10.0.0.7", MACAddress: "aa:bb:cc:00:00:06"}},
+		},
+		{name: "device in the trash", interfaces: []models.DeviceInterface{{IPAddress: "10.0.0.9", MACAddress: "aa:bb:cc:00:00:09"}}},
+		{name: "allowed duplicate", interfaces: []models.DeviceInterface{{IPAddress: "10.0.0.1"}}, allow: true},
+	}
+	for _, tt := range tests {
+		d := models.Device{Hostname: "new", Status: lifecycle.Active, UHeight: 1, Interfaces: tt.interfaces, AllowDuplicates: tt.allow}
+		err := AddDevice(d, "alice")
+
+		var got []string
+		var conflictErr *ConflictError
+		if errors.As(err, &conflictErr) {
+			for _, c := range conflictErr.Conflicts {
+				got = append(got, c.String())
+			}
+		} else if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: conflicts = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// The accepted duplicate does not block later edits of the device that holds it
+	id := lastID(t, "devices")
+	d, err := GetDevice(id)
+	if err != nil {
+		t.Fatalf("GetDevice: %v", err)
+	}
+	d.Hostname = "renamed"
+	if err := UpdateDevice(d, "bob"); err != nil {
+		t.Errorf("UpdateDevice with an accepted duplicate: %v", err)
+	}
+}
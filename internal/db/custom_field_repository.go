@@ -0,0 +1,230 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"ipam/internal/models"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// CustomFieldObjectTypes are the object types that can have custom fields
+var CustomFieldObjectTypes = []string{TagDevice, TagRack, TagSubnet}
+
+// CustomFieldTypes are the kinds of value a custom field can hold
+var CustomFieldTypes = []string{"text", "integer", "boolean", "date", "select", "url"}
+
+var customFieldNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,39}$`)
+
+// ErrCustomFieldExists is returned when a custom field name is already used for the object type
+var ErrCustomFieldExists = errors.New("a custom field with this name already exists for this object type")
+
+const customFieldSelect = `SELECT id, object_type, name, label, field_type, COALESCE(options, ''), required, position, created_at
+	FROM custom_fields`
+
+func scanCustomField(row interface{ Scan(...interface{}) error }, f *models.CustomField) error {
+	var options string
+	if err := row.Scan(&f.ID, &f.ObjectType, &f.Name, &f.Label, &f.Type, &options, &f.Required, &f.Position, &f.CreatedAt); err != nil {
+		return err
+	}
+	f.Options = nil
+	if options != "" {
+		f.Options = strings.Split(options, "\n")
+	}
+	return nil
+}
+
+// GetCustomFields retrieves the custom fields of an object type in display order,
+// or of every object type when objectType is empty
+func GetCustomFields(objectType string) ([]models.CustomField, error) {
+	rows, err := DB.Query(customFieldSelect+" WHERE ? = '' OR object_type = ? ORDER BY object_type, position, id", objectType, objectType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fields []models.CustomField
+	for rows.Next() {
+		var f models.CustomField
+		if err := scanCustomField(rows, &f); err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+	}
+	return fields, rows.Err()
+}
+
+// GetCustomField retrieves a single custom field by ID
+func GetCustomField(id int) (models.CustomField, error) {
+	var f models.CustomField
+	err := scanCustomField(DB.QueryRow(customFieldSelect+" WHERE id = ?", id), &f)
+	return f, err
+}
+
+// checkCustomField validates the definition of a custom field
+func checkCustomField(f *models.CustomField) error {
+	f.Label = strings.TrimSpace(f.Label)
+	if f.Label == "" {
+		return fmt.Errorf("label is required")
+	}
+	if !slices.Contains(CustomFieldObjectTypes, f.ObjectType) {
+		return fmt.Errorf("unknown object type %q", f.ObjectType)
+	}
+	if !slices.Contains(CustomFieldTypes, f.Type) {
+		return fmt.Errorf("unknown field type %q", f.Type)
+	}
+	if !customFieldNamePattern.MatchString(f.Name) {
+		return fmt.Errorf("name %q must start with a letter and contain only lowercase letters, digits and _ (40 at most)", f.Name)
+	}
+
+	var options []string
+	for _, o := range f.Options {
+		if o = strings.TrimSpace(o); o != "" && !slices.Contains(options, o) {
+			options = append(options, o)
+		}
+	}
+	f.Options = options
+	if f.Type == "select" && len(f.Options) == 0 {
+		return fmt.Errorf("a select field needs at least one option")
+	}
+	if f.Type != "select" {
+		f.Options = nil
+	}
+	return nil
+}
+
+// AddCustomField defines a new custom field
+func AddCustomField(f models.CustomField) error {
+	if err := checkCustomField(&f); err != nil {
+		return err
+	}
+
+	var count int
+	if err := DB.QueryRow("SELECT COUNT(*) FROM custom_fields WHERE object_type = ? AND name = ?", f.ObjectType, f.Name).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrCustomFieldExists
+	}
+
+	_, err := DB.Exec("INSERT INTO custom_fields (object_type, name, label, field_type, options, required, position, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		f.ObjectType, f.Name, f.Label, f.Type, strings.Join(f.Options, "\n"), f.Required, f.Position, time.Now())
+	return err
+}
+
+// UpdateCustomField changes the label, options, required flag and position of a custom field.
+// The object type, name and type are fixed once created, since stored values depend on them.
+func UpdateCustomField(f models.CustomField) error {
+	existing, err := GetCustomField(f.ID)
+	if err != nil {
+		return err
+	}
+	f.ObjectType, f.Name, f.Type = existing.ObjectType, existing.Name, existing.Type
+	if err := checkCustomField(&f); err != nil {
+		return err
+	}
+
+	_, err = DB.Exec("UPDATE custom_fields SET label=?, options=?, required=?, position=? WHERE id=?",
+		f.Label, strings.Join(f.Options, "\n"), f.Required, f.Position, f.ID)
+	return err
+}
+
+// DeleteCustomField deletes a custom field and every value stored for it
+func DeleteCustomField(id int, actor string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	const holders = `SELECT v.object_id FROM custom_field_values v JOIN custom_fields f ON f.id = v.field_id
+		WHERE f.id = ? AND f.object_type = ?`
+	devices, err := snapshotDevices(tx, holders, id, TagDevice)
+	if err != nil {
+		return err
+	}
+	racks, err := snapshotRacks(tx, holders, id, TagRack)
+	if err != nil {
+		return err
+	}
+	if err := bumpVersions(tx, "devices", holders, id, TagDevice); err != nil {
+		return err
+	}
+	if err := bumpVersions(tx, "racks", holders, id, TagRack); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM custom_field_values WHERE field_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM custom_fields WHERE id = ?", id); err != nil {
+		return err
+	}
+
+	if err := auditDeviceUpdates(tx, devices, actor); err != nil {
+		return err
+	}
+	if err := auditRackUpdates(tx, racks, actor); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// setCustomFieldValues replaces the custom field values of an object. Values of fields not
+// defined for the object type and empty values are not stored.
+func setCustomFieldValues(tx *sql.Tx, objectType string, objectID int64, values map[string]string) error {
+	if err := deleteCustomFieldValues(tx, objectType, int(objectID)); err != nil {
+		return err
+	}
+
+	for name, value := range values {
+		if value == "" {
+			continue
+		}
+		_, err := tx.Exec(`INSERT INTO custom_field_values (field_id, object_id, value)
+			SELECT id, ?, ? FROM custom_fields WHERE object_type = ? AND name = ?`, objectID, value, objectType, name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteCustomFieldValues removes the custom field values of an object
+func deleteCustomFieldValues(tx *sql.Tx, objectType string, objectID int) error {
+	_, err := tx.Exec("DELETE FROM custom_field_values WHERE object_id = ? AND field_id IN (SELECT id FROM custom_fields WHERE object_type = ?)",
+		objectID, objectType)
+	return err
+}
+
+// customFieldValues returns the custom field values of every object of a type, keyed by
+// object ID and then field name. extraWhere narrows down the objects, e.g. "v.object_id = ?".
+func customFieldValues(q queryer, objectType, extraWhere string, args ...interface{}) (map[int]map[string]string, error) {
+	query := `SELECT v.object_id, f.name, v.value FROM custom_field_values v
+		JOIN custom_fields f ON f.id = v.field_id WHERE f.object_type = ?`
+	if extraWhere != "" {
+		query += " AND " + extraWhere
+	}
+	rows, err := q.Query(query, append([]interface{}{objectType}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := make(map[int]map[string]string)
+	for rows.Next() {
+		var objectID int
+		var name, value string
+		if err := rows.Scan(&objectID, &name, &value); err != nil {
+			return nil, err
+		}
+		if values[objectID] == nil {
+			values[objectID] = make(map[string]string)
+		}
+		values[objectID][name] = value
+	}
+	return values, rows.Err()
+}
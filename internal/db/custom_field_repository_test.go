@@ -0,0 +1,83 @@
+package db
+
+import (
+	"errors"
+	"ipam/internal/lifecycle"
+	"ipam/internal/models"
+	"slices"
+	"testing"
+)
+
+func TestAddCustomField(t *testing.T) {
+	openTestDB(t)
+
+	tests := []struct {
+		name    string
+		field   models.CustomField
+		wantErr error
+		anyErr  bool
+	}{
+		{name: "text", field: models.CustomField{ObjectType: TagDevice, Name: "asset_tag", Label: "Asset Tag", Type: "text"}},
+		{name: "same name on racks", field: models.CustomField{ObjectType: TagRack, Name: "asset_tag", Label: "Asset Tag", Type: "text"}},
+		{name: "same name on devices", field: models.CustomField{ObjectType: TagDevice, Name: "asset_tag", Label: "Tag", Type: "integer"}, wantErr: ErrCustomFieldExists},
+		{name: "select", field: models.CustomField{ObjectType: TagDevice, Name: "tier", Label: "Tier", Type: "select", Options: []string{" gold ", "", "silver", "gold"}}},
+		{name: "select without options", field: models.CustomField{ObjectType: TagDevice, Name: "zone", Label: "Zone", Type: "select", Options: []string{" "}}, anyErr: true},
+		{name: "no label", field: models.CustomField{ObjectType: TagDevice, Name: "owner", Label: " ", Type: "text"}, anyErr: true},
+		{name: "upper case name", field: models.CustomField{ObjectType: TagDevice, Name: "Owner", Label: "Owner", Type: "text"}, anyErr: true},
+		{name: "name starting with a digit", field: models.CustomField{ObjectType: TagDevice, Name: "1owner", Label: "Owner", Type: "text"}, anyErr: true},
+		{name: "unknown type", field: models.CustomField{ObjectType: TagDevice, Name: "owner", Label: "Owner", Type: "color"}, anyErr: true},
+		{name: "unknown object type", field: models.CustomField{ObjectType: "vlan", Name: "owner", Label: "Owner", Type: "text"}, anyErr: true},
+	}
+	for _, tt := range tests {
+		err := AddCustomField(tt.field)
+		switch {
+		case tt.anyErr:
+			if err == nil {
+				t.Errorf("%s: AddCustomField succeeded, want an error", tt.name)
+			}
+		case !errors.Is(err, tt.wantErr):
+			t.Errorf("%s: AddCustomField = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	fields, err := GetCustomFields(TagDevice)
+	if err != nil {
+		t.Fatalf("GetCustomFields: %v", err)
+	}
+	for _, f := range fields {
+		if f.Name == "tier" && !slices.Equal(f.Options, []string{"gold", "silver"}) {
+			t.Errorf("tier options = %q, want [gold silver]", f.Options)
+		}
+	}
+}
+
+func TestCustomFieldValues(t *testing.T) {
+	openTestDB(t)
+
+	if err := AddCustomField(models.CustomField{ObjectType: TagDevice, Name: "asset_tag", Label: "Asset Tag", Type: "text"}); err != nil {
+		t.Fatalf("AddCustomField: %v", err)
+	}
+	field := lastID(t, "custom_fields")
+	d := models.Device{Hostname: "web01", Status: lifecycle.Active, UHeight: 1,
+		CustomFields: map[string]string{"asset_tag": "A-1", "undefined": "x", "empty": ""}}
+	if err := AddDevice(d, "alice"); err != nil {
+		t.Fatalf("AddDevice: %v", err)
+	}
+
+	got, err := GetDevice(1)
+	if err != nil {
+		t.Fatalf("GetDevice: %v", err)
+	}
+	if len(got.CustomFields) != 1 || got.CustomFields["asset_tag"] != "A-1" {
+		t.Errorf("custom fields = %v, want only asset_tag A-1", got.CustomFields)
+	}
+
+	// Deleting the field drops its values and moves the devices holding them to a new version
+	if err := DeleteCustomField(field, "bob"); err != nil {
+		t.Fatalf("DeleteCustomField: %v", err)
+	}
+	got, err = GetDevice(1)
+	if err != nil || len(got.CustomFields) != 0 || got.Version != 2 {
+		t.Errorf("after delete: custom fields %v at version %d, %v, want none at version 2", got.CustomFields, got.Version, err)
+	}
+}
@@ -3,6 +3,7 @@ package db
 import (
 	"database/sql"
 	"log"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -16,7 +17,9 @@ var (
 func InitDB(filepath string) {
 	DBPath = filepath
 	var err error
-	DB, err = sql.Open("sqlite3", filepath)
+	// _txlock=immediate takes the write lock when a transaction begins, so read-then-write
+	// sequences (e.g. next available IP allocation) cannot interleave between connections.
+	DB, err = sql.Open("sqlite3", "file:"+filepath+"?_txlock=immediate&_busy_timeout=5000")
 	if err != nil {
 		log.Fatalf("Error opening database: %v", err)
 	}
@@ -91,6 +94,364 @@ func createTables() {
 		log.Fatalf("Error creating device_interfaces table: %v", err)
 	}
 
+	createSubnetsTable := `CREATE TABLE IF NOT EXISTS subnets (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		cidr TEXT NOT NULL,
+		name TEXT,
+		description TEXT,
+		gateway TEXT,
+		status TEXT DEFAULT 'Active',
+		created_at DATETIME
+	);`
+
+	if _, err := DB.Exec(createSubnetsTable); err != nil {
+		log.Fatalf("Error creating subnets table: %v", err)
+	}
+
+	createVLANsTable := `CREATE TABLE IF NOT EXISTS vlans (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		vid INTEGER NOT NULL,
+		name TEXT,
+		vlan_group TEXT DEFAULT '',
+		description TEXT,
+		created_at DATETIME
+	);`
+
+	if _, err := DB.Exec(createVLANsTable); err != nil {
+		log.Fatalf("Error creating vlans table: %v", err)
+	}
+
+	// Tagged VLAN membership of interfaces (many-to-many)
+	createInterfaceVLANsTable := `CREATE TABLE IF NOT EXISTS interface_vlans (
+		interface_id INTEGER NOT NULL,
+		vlan_id INTEGER NOT NULL,
+		PRIMARY KEY (interface_id, vlan_id)
+	);`
+
+	if _, err := DB.Exec(createInterfaceVLANsTable); err != nil {
+		log.Fatalf("Error creating interface_vlans table: %v", err)
+	}
+
+	// Migrations for VLAN columns (errors ignored if the columns already exist)
+	DB.Exec("ALTER TABLE subnets ADD COLUMN vlan_id INTEGER DEFAULT 0")
+	DB.Exec("ALTER TABLE device_interfaces ADD COLUMN vlan_mode TEXT DEFAULT ''")
+	DB.Exec("ALTER TABLE device_interfaces ADD COLUMN untagged_vlan_id INTEGER DEFAULT 0")
+
+	createVRFsTable := `CREATE TABLE IF NOT EXISTS vrfs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE,
+		rd TEXT DEFAULT '',
+		description TEXT,
+		created_at DATETIME
+	);`
+
+	if _, err := DB.Exec(createVRFsTable); err != nil {
+		log.Fatalf("Error creating vrfs table: %v", err)
+	}
+
+	// Every database has the global VRF; existing subnets and interfaces default to it
+	_, err = DB.Exec("INSERT OR IGNORE INTO vrfs (id, name, rd, description, created_at) VALUES (?, 'Global', '', 'Default routing domain', ?)", GlobalVRFID, time.Now())
+	if err != nil {
+		log.Fatalf("Error creating global VRF: %v", err)
+	}
+	DB.Exec("ALTER TABLE subnets ADD COLUMN vrf_id INTEGER DEFAULT 1")
+	DB.Exec("ALTER TABLE device_interfaces ADD COLUMN vrf_id INTEGER DEFAULT 1")
+
+	createRangesTable := `CREATE TABLE IF NOT EXISTS ip_ranges (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		subnet_id INTEGER NOT NULL,
+		start_ip TEXT NOT NULL,
+		end_ip TEXT NOT NULL,
+		range_type TEXT DEFAULT 'DHCP',
+		description TEXT,
+		created_at DATETIME
+	);`
+
+	if _, err := DB.Exec(createRangesTable); err != nil {
+		log.Fatalf("Error creating ip_ranges table: %v", err)
+	}
+
+	createAddressesTable := `CREATE TABLE IF NOT EXISTS ip_addresses (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		vrf_id INTEGER DEFAULT 1,
+		address TEXT NOT NULL,
+		status TEXT DEFAULT 'Reserved',
+		dns_name TEXT,
+		owner TEXT,
+		purpose TEXT,
+		description TEXT,
+		interface_id INTEGER DEFAULT 0,
+		created_at DATETIME,
+		updated_at DATETIME
+	);`
+
+	if _, err := DB.Exec(createAddressesTable); err != nil {
+		log.Fatalf("Error creating ip_addresses table: %v", err)
+	}
+
+	// Reservations may expire; NULL means the device is kept until someone removes it
+	DB.Exec("ALTER TABLE devices ADD COLUMN expires_at DATETIME")
+
+	// Rack elevation: lowest unit, height in units and rack face of mounted devices
+	DB.Exec("ALTER TABLE devices ADD COLUMN position INTEGER DEFAULT 0")
+	DB.Exec("ALTER TABLE devices ADD COLUMN u_height INTEGER DEFAULT 1")
+	DB.Exec("ALTER TABLE devices ADD COLUMN face TEXT DEFAULT ''")
+
+	createReservationEventsTable := `CREATE TABLE IF NOT EXISTS reservation_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		device_id INTEGER,
+		hostname TEXT,
+		addresses TEXT,
+		action TEXT NOT NULL,
+		expires_at DATETIME,
+		created_at DATETIME
+	);`
+
+	if _, err := DB.Exec(createReservationEventsTable); err != nil {
+		log.Fatalf("Error creating reservation_events table: %v", err)
+	}
+
+	createTagsTable := `CREATE TABLE IF NOT EXISTS tags (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE,
+		color TEXT,
+		created_at DATETIME
+	);`
+
+	if _, err := DB.Exec(createTagsTable); err != nil {
+		log.Fatalf("Error creating tags table: %v", err)
+	}
+
+	// Tag links for devices, racks, interfaces and subnets (many-to-many)
+	createObjectTagsTable := `CREATE TABLE IF NOT EXISTS object_tags (
+		tag_id INTEGER NOT NULL,
+		object_type TEXT NOT NULL,
+		object_id INTEGER NOT NULL,
+		PRIMARY KEY (tag_id, object_type, object_id)
+	);`
+
+	if _, err := DB.Exec(createObjectTagsTable); err != nil {
+		log.Fatalf("Error creating object_tags table: %v", err)
+	}
+
+	// Admin-defined extra fields per object type (device, rack, subnet)
+	createCustomFieldsTable := `CREATE TABLE IF NOT EXISTS custom_fields (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		object_type TEXT NOT NULL,
+		name TEXT NOT NULL,
+		label TEXT NOT NULL,
+		field_type TEXT NOT NULL,
+		options TEXT,
+		required BOOLEAN DEFAULT 0,
+		position INTEGER DEFAULT 0,
+		created_at DATETIME,
+		UNIQUE (object_type, name)
+	);`
+
+	if _, err := DB.Exec(createCustomFieldsTable); err != nil {
+		log.Fatalf("Error creating custom_fields table: %v", err)
+	}
+
+	createCustomFieldValuesTable := `CREATE TABLE IF NOT EXISTS custom_field_values (
+		field_id INTEGER NOT NULL,
+		object_id INTEGER NOT NULL,
+		value TEXT NOT NULL,
+		PRIMARY KEY (field_id, object_id)
+	);`
+
+	if _, err := DB.Exec(createCustomFieldValuesTable); err != nil {
+		log.Fatalf("Error creating custom_field_values table: %v", err)
+	}
+
+	// Location hierarchy above racks: region > site > room
+	createRegionsTable := `CREATE TABLE IF NOT EXISTS regions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+		description TEXT,
+		created_at DATETIME
+	);`
+
+	if _, err := DB.Exec(createRegionsTable); err != nil {
+		log.Fatalf("Error creating regions table: %v", err)
+	}
+
+	createSitesTable := `CREATE TABLE IF NOT EXISTS sites (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+		region_id INTEGER DEFAULT 0,
+		description TEXT,
+		created_at DATETIME
+	);`
+
+	if _, err := DB.Exec(createSitesTable); err != nil {
+		log.Fatalf("Error creating sites table: %v", err)
+	}
+
+	createRoomsTable := `CREATE TABLE IF NOT EXISTS rooms (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		site_id INTEGER NOT NULL,
+		name TEXT NOT NULL COLLATE NOCASE,
+		description TEXT,
+		created_at DATETIME,
+		UNIQUE (site_id, name)
+	);`
+
+	if _, err := DB.Exec(createRoomsTable); err != nil {
+		log.Fatalf("Error creating rooms table: %v", err)
+	}
+
+	DB.Exec("ALTER TABLE racks ADD COLUMN site_id INTEGER DEFAULT 0")
+	DB.Exec("ALTER TABLE racks ADD COLUMN room_id INTEGER DEFAULT 0")
+
+	// Racks used to have a free text location; turn each distinct one into a site
+	if err := migrateRackLocations(); err != nil {
+		log.Fatalf("Error migrating rack locations to sites: %v", err)
+	}
+
+	// Each interface takes at most one cable, on either end
+	createCablesTable := `CREATE TABLE IF NOT EXISTS cables (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		a_interface_id INTEGER NOT NULL UNIQUE,
+		b_interface_id INTEGER NOT NULL UNIQUE,
+		cable_type TEXT,
+		color TEXT,
+		length REAL DEFAULT 0,
+		label TEXT,
+		created_at DATETIME,
+		CHECK (a_interface_id != b_interface_id)
+	);`
+
+	if _, err := DB.Exec(createCablesTable); err != nil {
+		log.Fatalf("Error creating cables table: %v", err)
+	}
+
+	createManufacturersTable := `CREATE TABLE IF NOT EXISTS manufacturers (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+		created_at DATETIME
+	);`
+
+	if _, err := DB.Exec(createManufacturersTable); err != nil {
+		log.Fatalf("Error creating manufacturers table: %v", err)
+	}
+
+	// interface_template holds the labels of the interfaces of new devices, one per line
+	createDeviceModelsTable := `CREATE TABLE IF NOT EXISTS device_models (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		manufacturer_id INTEGER NOT NULL,
+		name TEXT NOT NULL COLLATE NOCASE,
+		part_number TEXT,
+		u_height INTEGER DEFAULT 1,
+		interface_template TEXT,
+		created_at DATETIME,
+		UNIQUE (manufacturer_id, name)
+	);`
+
+	if _, err := DB.Exec(createDeviceModelsTable); err != nil {
+		log.Fatalf("Error creating device_models table: %v", err)
+	}
+
+	DB.Exec("ALTER TABLE devices ADD COLUMN model_id INTEGER DEFAULT 0")
+
+	// Tenants own devices, racks and interfaces; groups are optional
+	createTenantGroupsTable := `CREATE TABLE IF NOT EXISTS tenant_groups (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+		description TEXT,
+		created_at DATETIME
+	);`
+
+	if _, err := DB.Exec(createTenantGroupsTable); err != nil {
+		log.Fatalf("Error creating tenant_groups table: %v", err)
+	}
+
+	createTenantsTable := `CREATE TABLE IF NOT EXISTS tenants (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+		group_id INTEGER DEFAULT 0,
+		description TEXT,
+		created_at DATETIME
+	);`
+
+	if _, err := DB.Exec(createTenantsTable); err != nil {
+		log.Fatalf("Error creating tenants table: %v", err)
+	}
+
+	DB.Exec("ALTER TABLE devices ADD COLUMN tenant_id INTEGER DEFAULT 0")
+	DB.Exec("ALTER TABLE racks ADD COLUMN tenant_id INTEGER DEFAULT 0")
+	DB.Exec("ALTER TABLE device_interfaces ADD COLUMN tenant_id INTEGER DEFAULT 0")
+
+	// Lifecycle: every change of device state, with the addresses released on retirement
+	createDeviceStatusEventsTable := `CREATE TABLE IF NOT EXISTS device_status_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		device_id INTEGER NOT NULL,
+		from_status TEXT,
+		to_status TEXT NOT NULL,
+		released_addresses TEXT,
+		changed_at DATETIME
+	);`
+
+	if _, err := DB.Exec(createDeviceStatusEventsTable); err != nil {
+		log.Fatalf("Error creating device_status_events table: %v", err)
+	}
+
+	// Statuses from before the lifecycle
+	DB.Exec("UPDATE devices SET status = 'Active' WHERE status = 'Online'")
+	DB.Exec("UPDATE devices SET status = 'Planned' WHERE status = 'Reserved'")
+
+	createSettingsTable := `CREATE TABLE IF NOT EXISTS settings (
+		key TEXT PRIMARY KEY,
+		value TEXT
+	);`
+
+	if _, err := DB.Exec(createSettingsTable); err != nil {
+		log.Fatalf("Error creating settings table: %v", err)
+	}
+	if err := loadStatusColors(); err != nil {
+		log.Fatalf("Error loading status colors: %v", err)
+	}
+
+	// Trash: deleted devices and racks are kept until purged
+	DB.Exec("ALTER TABLE devices ADD COLUMN deleted_at DATETIME")
+	DB.Exec("ALTER TABLE devices ADD COLUMN deleted_by TEXT")
+	DB.Exec("ALTER TABLE racks ADD COLUMN deleted_at DATETIME")
+	DB.Exec("ALTER TABLE racks ADD COLUMN deleted_by TEXT")
+
+	// Optimistic concurrency: every change of a device or rack bumps its version
+	DB.Exec("ALTER TABLE devices ADD COLUMN version INTEGER NOT NULL DEFAULT 1")
+	DB.Exec("ALTER TABLE racks ADD COLUMN version INTEGER NOT NULL DEFAULT 1")
+
+	// Audit log: every change of a device or rack, with the object as it was after the change.
+	// Times are stored in UTC so they compare as text.
+	createAuditLogTable := `CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		object_type TEXT NOT NULL,
+		object_id INTEGER NOT NULL,
+		object_name TEXT,
+		action TEXT NOT NULL,
+		actor TEXT,
+		changes TEXT,
+		snapshot TEXT,
+		created_at DATETIME NOT NULL
+	);`
+
+	if _, err := DB.Exec(createAuditLogTable); err != nil {
+		log.Fatalf("Error creating audit_log table: %v", err)
+	}
+	DB.Exec("CREATE INDEX IF NOT EXISTS idx_audit_log_object ON audit_log (object_type, object_id)")
+	// The log is append-only
+	for _, trigger := range []string{
+		"CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log BEGIN SELECT RAISE(ABORT, 'the audit log is append-only'); END",
+		"CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log BEGIN SELECT RAISE(ABORT, 'the audit log is append-only'); END",
+	} {
+		if _, err := DB.Exec(trigger); err != nil {
+			log.Fatalf("Error creating audit_log trigger: %v", err)
+		}
+	}
+	if err := recordAuditBaseline(); err != nil {
+		log.Fatalf("Error recording the audit log baseline: %v", err)
+	}
+
 	// Migration: Check if old columns exist in 'devices' (ip_address) and migrate data if needed.
 	// For simplicity in this iteration, we will leave the old schema in place if it exists,
 	// but the application will rely on the new table.
@@ -0,0 +1,26 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// openTestDB points DB at a fresh database for the duration of the test
+func openTestDB(t *testing.T) {
+	t.Helper()
+	InitDB(filepath.Join(t.TempDir(), "ipam.db"))
+	t.Cleanup(func() {
+		CloseDB()
+		DB = nil
+	})
+}
+
+// lastID returns the ID of the row last added to table
+func lastID(t *testing.T, table string) int {
+	t.Helper()
+	var id int
+	if err := DB.QueryRow("SELECT COALESCE(MAX(id), 0) FROM " + table).Scan(&id); err != nil {
+		t.Fatalf("last %s ID: %v", table, err)
+	}
+	return id
+}
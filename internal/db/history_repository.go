@@ -0,0 +1,224 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"ipam/internal/models"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The inventory of the past is rebuilt from the snapshots of the audit log: an object as of a
+// time is its snapshot in the latest entry up to then, unless that entry moved it to the trash.
+
+// recordAuditBaseline records the state of the devices and racks that have no history yet,
+// i.e. created before the audit log, so that the past inventory includes them from now on
+func recordAuditBaseline() error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"devices", "racks"} {
+		objectType := AuditDevice
+		if table == "racks" {
+			objectType = AuditRack
+		}
+		ids, err := unaudited(tx, table, objectType)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if objectType == AuditDevice {
+				err = auditDevice(tx, id, AuditBaseline, SystemActor, nil)
+			} else {
+				err = auditRack(tx, id, AuditBaseline, SystemActor, nil)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// unaudited lists the rows of table (devices or racks) outside the trash without audit entries
+func unaudited(tx *sql.Tx, table, objectType string) ([]int, error) {
+	rows, err := tx.Query("SELECT id FROM "+table+` WHERE deleted_at IS NULL
+		AND id NOT IN (SELECT object_id FROM audit_log WHERE object_type = ?) ORDER BY id`, objectType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// HistoryStart returns when the audit log starts, the earliest time the inventory can be rebuilt for.
+// It is the zero time while the log is empty.
+func HistoryStart() (time.Time, error) {
+	var start time.Time
+	err := DB.QueryRow("SELECT created_at FROM audit_log ORDER BY id LIMIT 1").Scan(&start)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return start.Local(), err
+}
+
+// snapshotsAsOf returns the snapshot of every object of a type that existed outside the trash at t
+func snapshotsAsOf(objectType string, t time.Time) ([]string, error) {
+	rows, err := DB.Query(`SELECT COALESCE(a.snapshot, '') FROM audit_log a
+		JOIN (SELECT MAX(id) AS id FROM audit_log WHERE object_type = ? AND created_at <= ? GROUP BY object_id) latest
+		ON a.id = latest.id
+		WHERE a.action NOT IN (?, ?) ORDER BY a.object_id`, objectType, t.UTC(), AuditDelete, AuditPurge)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snapshots []string
+	for rows.Next() {
+		var snapshot string
+		if err := rows.Scan(&snapshot); err != nil {
+			return nil, err
+		}
+		if snapshot != "" {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	return snapshots, rows.Err()
+}
+
+// GetInventoryAsOf rebuilds the devices and racks as they were at t, ordered like GetAllDevices
+// and GetAllRacks. Devices in a rack that was in the trash at t show as not racked.
+func GetInventoryAsOf(t time.Time) ([]models.Device, []models.Rack, error) {
+	rackSnapshots, err := snapshotsAsOf(AuditRack, t)
+	if err != nil {
+		return nil, nil, err
+	}
+	racks := make([]models.Rack, len(rackSnapshots))
+	racked := make(map[int]bool)
+	for i, snapshot := range rackSnapshots {
+		if err := json.Unmarshal([]byte(snapshot), &racks[i]); err != nil {
+			return nil, nil, fmt.Errorf("rack snapshot: %w", err)
+		}
+		racked[racks[i].ID] = true
+	}
+	sort.SliceStable(racks, func(i, j int) bool {
+		a, b := racks[i], racks[j]
+		if (a.SiteName == "") != (b.SiteName == "") {
+			return b.SiteName == ""
+		}
+		if a.SiteName != b.SiteName {
+			return a.SiteName < b.SiteName
+		}
+		return a.Name < b.Name
+	})
+
+	deviceSnapshots, err := snapshotsAsOf(AuditDevice, t)
+	if err != nil {
+		return nil, nil, err
+	}
+	devices := make([]models.Device, len(deviceSnapshots))
+	for i, snapshot := range deviceSnapshots {
+		d := &devices[i]
+		if err := json.Unmarshal([]byte(snapshot), d); err != nil {
+			return nil, nil, fmt.Errorf("device snapshot: %w", err)
+		}
+		if d.RackID != 0 && !racked[d.RackID] {
+			d.RackID, d.RackName, d.Position, d.Face = 0, "", 0, ""
+		}
+	}
+	sort.SliceStable(devices, func(i, j int) bool {
+		return devices[i].UpdatedAt.After(devices[j].UpdatedAt)
+	})
+	return devices, racks, nil
+}
+
+// GetAddressHistory lists who held an IP address over time, oldest first, from the device
+// changes in the audit log
+func GetAddressHistory(ip string) ([]models.AddressAssignment, error) {
+	// Only the devices that held the address at some point are replayed
+	needle, err := json.Marshal(ip)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := DB.Query(`SELECT object_id, action, COALESCE(snapshot, ''), created_at FROM audit_log
+		WHERE object_type = ? AND object_id IN
+			(SELECT object_id FROM audit_log WHERE object_type = ? AND snapshot LIKE ? ESCAPE '\')
+		ORDER BY id`, AuditDevice, AuditDevice, `%"ip_address":`+escapeLike(string(needle))+`%`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var assignments []models.AddressAssignment
+	// Index in assignments of the open assignment of each device and VRF
+	open := make(map[[2]int]int)
+	for rows.Next() {
+		var id int
+		var action, snapshot string
+		var at time.Time
+		if err := rows.Scan(&id, &action, &snapshot, &at); err != nil {
+			return nil, err
+		}
+		at = at.Local()
+
+		var d models.Device
+		if action != AuditDelete && action != AuditPurge && snapshot != "" {
+			if err := json.Unmarshal([]byte(snapshot), &d); err != nil {
+				return nil, fmt.Errorf("device snapshot: %w", err)
+			}
+		}
+		held := make(map[[2]int]models.DeviceInterface)
+		for _, iface := range d.Interfaces {
+			if iface.IPAddress == ip {
+				held[[2]int{id, iface.VRFID}] = iface
+			}
+		}
+
+		for key, i := range open {
+			if key[0] != id {
+				continue
+			}
+			if iface, ok := held[key]; ok {
+				assignments[i].Hostname, assignments[i].Label = d.Hostname, iface.Label
+				continue
+			}
+			until := at
+			assignments[i].Until = &until
+			delete(open, key)
+		}
+		for key, iface := range held {
+			if _, ok := open[key]; ok {
+				continue
+			}
+			open[key] = len(assignments)
+			assignments = append(assignments, models.AddressAssignment{
+				IPAddress: ip,
+				DeviceID:  id,
+				Hostname:  d.Hostname,
+				Label:     iface.Label,
+				VRFName:   iface.VRFName,
+				From:      at,
+			})
+		}
+	}
+	return assignments, rows.Err()
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern, for ESCAPE '\'
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
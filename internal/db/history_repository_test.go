@@ -0,0 +1,94 @@
+package db
+
+import (
+	"ipam/internal/lifecycle"
+	"ipam/internal/models"
+	"testing"
+	"time"
+)
+
+func TestGetAddressHistory(t *testing.T) {
+	openTestDB(t)
+
+	device := models.Device{
+		Hostname:   "web01",
+		Status:     lifecycle.Active,
+		UHeight:    1,
+		Interfaces: []models.DeviceInterface{{IPAddress: "10.0.0.5", Label: "eth0"}},
+	}
+	if err := AddDevice(device, "alice"); err != nil {
+		t.Fatalf("AddDevice: %v", err)
+	}
+	devices, err := GetAllDevices()
+	if err != nil || len(devices) != 1 || len(devices[0].Interfaces) != 1 {
+		t.Fatalf("GetAllDevices = %v, %v, want one device with one interface", devices, err)
+	}
+	iface := devices[0].Interfaces[0]
+
+	// Attaching a record moves the interface from its old address to the record's
+	record := models.IPAddress{Address: "10.0.0.9", Status: "Active", InterfaceID: iface.ID}
+	if err := AddIPAddress(record, "bob"); err != nil {
+		t.Fatalf("AddIPAddress: %v", err)
+	}
+
+	tests := []struct {
+		ip       string
+		held     bool
+		released bool
+	}{
+		{ip: "10.0.0.5", held: true, released: true},
+		{ip: "10.0.0.9", held: true},
+		{ip: "10.0.0.10"},
+	}
+	for _, tt := range tests {
+		got, err := GetAddressHistory(tt.ip)
+		if err != nil {
+			t.Errorf("GetAddressHistory(%s): %v", tt.ip, err)
+			continue
+		}
+		if !tt.held {
+			if len(got) != 0 {
+				t.Errorf("GetAddressHistory(%s) = %v, want no assignments", tt.ip, got)
+			}
+			continue
+		}
+		if len(got) != 1 {
+			t.Errorf("GetAddressHistory(%s) = %v, want one assignment", tt.ip, got)
+			continue
+		}
+		a := got[0]
+		if a.DeviceID != iface.DeviceID || a.Hostname != "web01" || a.Label != "eth0" {
+			t.Errorf("GetAddressHistory(%s) = %+v, want web01 eth0", tt.ip, a)
+		}
+		if (a.Until != nil) != tt.released {
+			t.Errorf("GetAddressHistory(%s) until = %v, want released %v", tt.ip, a.Until, tt.released)
+		}
+	}
+}
+
+func TestGetInventoryAsOfAfterTenantDelete(t *testing.T) {
+	openTestDB(t)
+
+	if err := AddTenant(models.Tenant{Name: "acme"}); err != nil {
+		t.Fatalf("AddTenant: %v", err)
+	}
+	tenants, err := GetAllTenants()
+	if err != nil || len(tenants) != 1 {
+		t.Fatalf("GetAllTenants = %v, %v, want one tenant", tenants, err)
+	}
+	device := models.Device{Hostname: "web01", Status: lifecycle.Active, UHeight: 1, TenantID: tenants[0].ID}
+	if err := AddDevice(device, "alice"); err != nil {
+		t.Fatalf("AddDevice: %v", err)
+	}
+	if err := DeleteTenant(tenants[0].ID, "bob"); err != nil {
+		t.Fatalf("DeleteTenant: %v", err)
+	}
+
+	devices, _, err := GetInventoryAsOf(time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("GetInventoryAsOf: %v", err)
+	}
+	if len(devices) != 1 || devices[0].TenantID != 0 || devices[0].TenantName != "" {
+		t.Errorf("GetInventoryAsOf = %+v, want web01 without a tenant", devices)
+	}
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"ipam/internal/lifecycle"
+	"ipam/internal/models"
+	"time"
+)
+
+// ErrDeviceRetired is returned when giving an address to a retired device
+var ErrDeviceRetired = errors.New("retired devices cannot hold addresses")
+
+// deviceStatus returns the lifecycle state a device is stored with
+func deviceStatus(tx *sql.Tx, deviceID int) (string, error) {
+	var status string
+	err := tx.QueryRow("SELECT status FROM devices WHERE id = ?", deviceID).Scan(&status)
+	return status, err
+}
+
+// GetDeviceStatus returns the lifecycle state a device is stored with
+func GetDeviceStatus(deviceID int) (string, error) {
+	var status string
+	err := DB.QueryRow("SELECT status FROM devices WHERE id = ?", deviceID).Scan(&status)
+	return status, err
+}
+
+// recordStatusChange records a device moving between two states
+func recordStatusChange(tx *sql.Tx, deviceID int, from, to, released string) error {
+	_, err := tx.Exec("INSERT INTO device_status_events (device_id, from_status, to_status, released_addresses, changed_at) VALUES (?, ?, ?, ?, ?)",
+		deviceID, from, to, released, time.Now())
+	return err
+}
+
+// releaseAddresses frees the addresses of a device being retired. Its interfaces are kept with
+// their MAC, label and cables but lose their IP address, and the address records attached to
+// them become standalone. It returns the released addresses.
+func releaseAddresses(tx *sql.Tx, deviceID int) (string, error) {
+	addresses, err := deviceAddresses(tx, deviceID)
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.Exec("UPDATE ip_addresses SET interface_id = 0 WHERE interface_id IN (SELECT id FROM device_interfaces WHERE device_id = ?)", deviceID)
+	if err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec("UPDATE device_interfaces SET ip_address = '' WHERE device_id = ?", deviceID); err != nil {
+		return "", err
+	}
+	return addresses, nil
+}
+
+// GetStatusHistory retrieves the lifecycle changes of a device, newest first
+func GetStatusHistory(deviceID int) ([]models.StatusEvent, error) {
+	rows, err := DB.Query(`SELECT id, device_id, COALESCE(from_status, ''), to_status, COALESCE(released_addresses, ''), changed_at
+		FROM device_status_events WHERE device_id = ? ORDER BY id DESC`, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.StatusEvent
+	for rows.Next() {
+		var e models.StatusEvent
+		if err := rows.Scan(&e.ID, &e.DeviceID, &e.From, &e.To, &e.ReleasedAddresses, &e.ChangedAt); err != nil {
+			return nil, err
+		}
+		e.ChangedAt = e.ChangedAt.Local()
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// checkTransition makes sure a device may move from its stored state to the one it is saved with.
+// It returns the stored state.
+func checkTransition(tx *sql.Tx, d models.Device) (string, error) {
+	from, err := deviceStatus(tx, d.ID)
+	if err != nil {
+		return "", err
+	}
+	if !lifecycle.CanTransition(from, d.Status) {
+		return from, &lifecycle.TransitionError{From: from, To: d.Status}
+	}
+	return from, nil
+}
@@ -0,0 +1,431 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"ipam/internal/models"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrRegionExists is returned when a region name is already taken (names are case-insensitive)
+	ErrRegionExists = errors.New("a region with this name already exists")
+	// ErrSiteExists is returned when a site name is already taken (names are case-insensitive)
+	ErrSiteExists = errors.New("a site with this name already exists")
+	// ErrRoomExists is returned when the site already has a room with this name
+	ErrRoomExists = errors.New("this site already has a room with this name")
+	// ErrRoomNotFound is returned when placing a rack in a room that does not exist
+	ErrRoomNotFound = errors.New("the selected room does not exist")
+)
+
+// normalizeName trims a name of a location or catalog entry and collapses inner whitespace, e.g. " DC  1 " becomes "DC 1"
+func normalizeName(name string) string {
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// countsAny reports whether a COUNT(*) query counts any row, e.g. another row already using a name
+func countsAny(q queryer, query string, args ...interface{}) (bool, error) {
+	var count int
+	if err := q.QueryRow(query, args...).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// GetAllRegions retrieves all regions ordered by name
+func GetAllRegions() ([]models.Region, error) {
+	rows, err := DB.Query("SELECT id, name, COALESCE(description, ''), created_at FROM regions ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var regions []models.Region
+	for rows.Next() {
+		var g models.Region
+		if err := rows.Scan(&g.ID, &g.Name, &g.Description, &g.CreatedAt); err != nil {
+			return nil, err
+		}
+		regions = append(regions, g)
+	}
+	return regions, rows.Err()
+}
+
+// GetRegion retrieves a single region by ID
+func GetRegion(id int) (models.Region, error) {
+	var g models.Region
+	err := DB.QueryRow("SELECT id, name, COALESCE(description, ''), created_at FROM regions WHERE id = ?", id).
+		Scan(&g.ID, &g.Name, &g.Description, &g.CreatedAt)
+	return g, err
+}
+
+// AddRegion adds a new region
+func AddRegion(g models.Region) error {
+	g.Name = normalizeName(g.Name)
+	taken, err := countsAny(DB, "SELECT COUNT(*) FROM regions WHERE name = ? COLLATE NOCASE", g.Name)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrRegionExists
+	}
+
+	_, err = DB.Exec("INSERT INTO regions (name, description, created_at) VALUES (?, ?, ?)", g.Name, g.Description, time.Now())
+	return err
+}
+
+// UpdateRegion updates an existing region
+func UpdateRegion(g models.Region) error {
+	g.Name = normalizeName(g.Name)
+	taken, err := countsAny(DB, "SELECT COUNT(*) FROM regions WHERE name = ? COLLATE NOCASE AND id != ?", g.Name, g.ID)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrRegionExists
+	}
+
+	_, err = DB.Exec("UPDATE regions SET name=?, description=? WHERE id=?", g.Name, g.Description, g.ID)
+	return err
+}
+
+// DeleteRegion deletes a region. Its sites are kept, outside of any region.
+func DeleteRegion(id int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE sites SET region_id = 0 WHERE region_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM regions WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const siteSelect = `SELECT s.id, s.name, COALESCE(s.region_id, 0), COALESCE(g.name, ''), COALESCE(s.description, ''), s.created_at
+	FROM sites s LEFT JOIN regions g ON g.id = s.region_id`
+
+func scanSite(row interface{ Scan(...interface{}) error }, s *models.Site) error {
+	return row.Scan(&s.ID, &s.Name, &s.RegionID, &s.RegionName, &s.Description, &s.CreatedAt)
+}
+
+// GetAllSites retrieves all sites ordered by region and name. Sites outside of a region come first.
+func GetAllSites() ([]models.Site, error) {
+	rows, err := DB.Query(siteSelect + " ORDER BY COALESCE(g.name, ''), s.name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sites []models.Site
+	for rows.Next() {
+		var s models.Site
+		if err := scanSite(rows, &s); err != nil {
+			return nil, err
+		}
+		sites = append(sites, s)
+	}
+	return sites, rows.Err()
+}
+
+// GetSite retrieves a single site by ID
+func GetSite(id int) (models.Site, error) {
+	var s models.Site
+	err := scanSite(DB.QueryRow(siteSelect+" WHERE s.id = ?", id), &s)
+	return s, err
+}
+
+// AddSite adds a new site
+func AddSite(s models.Site) error {
+	s.Name = normalizeName(s.Name)
+	taken, err := countsAny(DB, "SELECT COUNT(*) FROM sites WHERE name = ? COLLATE NOCASE", s.Name)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrSiteExists
+	}
+
+	_, err = DB.Exec("INSERT INTO sites (name, region_id, description, created_at) VALUES (?, ?, ?, ?)",
+		s.Name, s.RegionID, s.Description, time.Now())
+	return err
+}
+
+// UpdateSite updates an existing site
+func UpdateSite(s models.Site) error {
+	s.Name = normalizeName(s.Name)
+	taken, err := countsAny(DB, "SELECT COUNT(*) FROM sites WHERE name = ? COLLATE NOCASE AND id != ?", s.Name, s.ID)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrSiteExists
+	}
+
+	_, err = DB.Exec("UPDATE sites SET name=?, region_id=?, description=? WHERE id=?", s.Name, s.RegionID, s.Description, s.ID)
+	return err
+}
+
+// DeleteSite deletes a site and its rooms. Its racks are kept, outside of any site.
+// actor is recorded in the audit log for each rack changed.
+func DeleteSite(id int, actor string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	racks, err := snapshotRacks(tx, "SELECT id FROM racks WHERE site_id = ?", id)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE racks SET site_id = 0, room_id = 0, version = version + 1 WHERE site_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM rooms WHERE site_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM sites WHERE id = ?", id); err != nil {
+		return err
+	}
+	if err := auditRackUpdates(tx, racks, actor); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MergeSite moves the racks and rooms of a site into another one and deletes it, for cleaning up
+// duplicates such as "DC1" and "Datacenter 1". Rooms whose name already exists in the target
+// site are merged as well. actor is recorded in the audit log for each rack moved.
+func MergeSite(fromID, intoID int, actor string) error {
+	if fromID == intoID {
+		return errors.New("cannot merge a site into itself")
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM sites WHERE id IN (?, ?)", fromID, intoID).Scan(&exists); err != nil {
+		return err
+	}
+	if exists != 2 {
+		return sql.ErrNoRows
+	}
+	racks, err := snapshotRacks(tx, "SELECT id FROM racks WHERE site_id = ?", fromID)
+	if err != nil {
+		return err
+	}
+
+	// Rooms with the same name in both sites become one room of the target site. The versions
+	// of the racks are bumped once below, when they move to the target site.
+	_, err = tx.Exec(`UPDATE racks SET room_id = (SELECT t.id FROM rooms t JOIN rooms f ON f.name = t.name COLLATE NOCASE
+			WHERE f.id = racks.room_id AND t.site_id = ?)
+		WHERE site_id = ? AND room_id IN (SELECT f.id FROM rooms f JOIN rooms t ON t.name = f.name COLLATE NOCASE
+			WHERE f.site_id = ? AND t.site_id = ?)`, intoID, fromID, fromID, intoID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM rooms WHERE site_id = ? AND name IN (SELECT name FROM rooms WHERE site_id = ?)`, fromID, intoID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE rooms SET site_id = ? WHERE site_id = ?", intoID, fromID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE racks SET site_id = ?, version = version + 1 WHERE site_id = ?", intoID, fromID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM sites WHERE id = ?", fromID); err != nil {
+		return err
+	}
+	if err := auditRackUpdates(tx, racks, actor); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const roomSelect = `SELECT rm.id, rm.site_id, COALESCE(s.name, ''), rm.name, COALESCE(rm.description, ''), rm.created_at
+	FROM rooms rm LEFT JOIN sites s ON s.id = rm.site_id`
+
+func scanRoom(row interface{ Scan(...interface{}) error }, rm *models.Room) error {
+	return row.Scan(&rm.ID, &rm.SiteID, &rm.SiteName, &rm.Name, &rm.Description, &rm.CreatedAt)
+}
+
+// GetAllRooms retrieves all rooms ordered by site and name
+func GetAllRooms() ([]models.Room, error) {
+	rows, err := DB.Query(roomSelect + " ORDER BY s.name, rm.name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rooms []models.Room
+	for rows.Next() {
+		var rm models.Room
+		if err := scanRoom(rows, &rm); err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, rm)
+	}
+	return rooms, rows.Err()
+}
+
+// GetRoom retrieves a single room by ID
+func GetRoom(id int) (models.Room, error) {
+	var rm models.Room
+	err := scanRoom(DB.QueryRow(roomSelect+" WHERE rm.id = ?", id), &rm)
+	return rm, err
+}
+
+// AddRoom adds a new room to a site
+func AddRoom(rm models.Room) error {
+	rm.Name = normalizeName(rm.Name)
+	taken, err := countsAny(DB, "SELECT COUNT(*) FROM rooms WHERE site_id = ? AND name = ? COLLATE NOCASE", rm.SiteID, rm.Name)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrRoomExists
+	}
+
+	_, err = DB.Exec("INSERT INTO rooms (site_id, name, description, created_at) VALUES (?, ?, ?, ?)",
+		rm.SiteID, rm.Name, rm.Description, time.Now())
+	return err
+}
+
+// UpdateRoom updates an existing room. Moving it to another site moves its racks along;
+// actor is recorded in the audit log for each rack changed.
+func UpdateRoom(rm models.Room, actor string) error {
+	rm.Name = normalizeName(rm.Name)
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	taken, err := countsAny(tx, "SELECT COUNT(*) FROM rooms WHERE site_id = ? AND name = ? COLLATE NOCASE AND id != ?", rm.SiteID, rm.Name, rm.ID)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrRoomExists
+	}
+
+	racks, err := snapshotRacks(tx, "SELECT id FROM racks WHERE room_id = ?", rm.ID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE rooms SET site_id=?, name=?, description=? WHERE id=?", rm.SiteID, rm.Name, rm.Description, rm.ID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE racks SET site_id = ?, version = version + 1 WHERE room_id = ?", rm.SiteID, rm.ID); err != nil {
+		return err
+	}
+	if err := auditRackUpdates(tx, racks, actor); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteRoom deletes a room. Its racks stay in the site; actor is recorded in the audit log
+// for each of them.
+func DeleteRoom(id int, actor string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	racks, err := snapshotRacks(tx, "SELECT id FROM racks WHERE room_id = ?", id)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE racks SET room_id = 0, version = version + 1 WHERE room_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM rooms WHERE id = ?", id); err != nil {
+		return err
+	}
+	if err := auditRackUpdates(tx, racks, actor); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// placeRack makes the site of a rack agree with its room: a rack in a room is in the room's site
+func placeRack(tx *sql.Tx, r *models.Rack) error {
+	if r.RoomID == 0 {
+		return nil
+	}
+	err := tx.QueryRow("SELECT site_id FROM rooms WHERE id = ?", r.RoomID).Scan(&r.SiteID)
+	if err == sql.ErrNoRows {
+		return ErrRoomNotFound
+	}
+	return err
+}
+
+// migrateRackLocations turns the free text locations of racks not placed in a site yet into sites.
+// Locations differing only in case or spacing ("DC1", "dc1 ") end up in the same site. The text is
+// cleared once migrated, so a rack later taken out of its site is not put back on the next start.
+func migrateRackLocations() error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Racks are taken in ID order, so the spelling of the oldest rack names the site
+	rows, err := tx.Query("SELECT id, location FROM racks WHERE COALESCE(site_id, 0) = 0 AND TRIM(COALESCE(location, '')) != '' ORDER BY id")
+	if err != nil {
+		return err
+	}
+	type rackLocation struct {
+		rackID int
+		name   string
+	}
+	var locations []rackLocation
+	for rows.Next() {
+		var l rackLocation
+		if err := rows.Scan(&l.rackID, &l.name); err != nil {
+			rows.Close()
+			return err
+		}
+		l.name = normalizeName(l.name)
+		locations = append(locations, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, l := range locations {
+		var siteID int64
+		err := tx.QueryRow("SELECT id FROM sites WHERE name = ? COLLATE NOCASE", l.name).Scan(&siteID)
+		if err == sql.ErrNoRows {
+			result, err := tx.Exec("INSERT INTO sites (name, region_id, description, created_at) VALUES (?, 0, '', ?)", l.name, now)
+			if err != nil {
+				return err
+			}
+			if siteID, err = result.LastInsertId(); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec("UPDATE racks SET site_id = ?, location = '' WHERE id = ?", siteID, l.rackID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,115 @@
+package db
+
+import (
+	"errors"
+	"ipam/internal/models"
+	"testing"
+	"time"
+)
+
+func TestMigrateRackLocations(t *testing.T) {
+	openTestDB(t)
+
+	// Legacy racks with free text locations, as stored before sites existed
+	for _, r := range []struct{ name, location string }{
+		{"R1", "DC1"}, {"R2", " dc1 "}, {"R3", "Data  Center 2"}, {"R4", ""}, {"R5", "data center 2"},
+	} {
+		if _, err := DB.Exec("INSERT INTO racks (name, location, height, created_at) VALUES (?, ?, 42, ?)", r.name, r.location, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := migrateRackLocations(); err != nil {
+		t.Fatalf("migrateRackLocations: %v", err)
+	}
+	// Running it again finds nothing left to migrate
+	if err := migrateRackLocations(); err != nil {
+		t.Fatalf("migrateRackLocations again: %v", err)
+	}
+
+	sites, err := GetAllSites()
+	if err != nil || len(sites) != 2 {
+		t.Fatalf("sites = %+v, %v, want two", sites, err)
+	}
+	tests := []struct {
+		rack     int
+		wantSite string
+	}{
+		{rack: 1, wantSite: "DC1"},
+		{rack: 2, wantSite: "DC1"},
+		{rack: 3, wantSite: "Data Center 2"},
+		{rack: 4, wantSite: ""},
+		{rack: 5, wantSite: "Data Center 2"},
+	}
+	for _, tt := range tests {
+		r, err := GetRack(tt.rack)
+		if err != nil || r.SiteName != tt.wantSite {
+			t.Errorf("rack %d is in site %q, %v, want %q", tt.rack, r.SiteName, err, tt.wantSite)
+		}
+	}
+}
+
+func TestMergeSite(t *testing.T) {
+	openTestDB(t)
+
+	// DC1 (1) has Hall A; Datacenter 1 (2) has hall a and Hall B, each with a rack
+	for _, name := range []string{"DC1", "Datacenter 1", "Other"} {
+		if err := AddSite(models.Site{Name: name}); err != nil {
+			t.Fatalf("AddSite(%s): %v", name, err)
+		}
+	}
+	for _, rm := range []models.Room{{SiteID: 1, Name: "Hall A"}, {SiteID: 2, Name: "hall a"}, {SiteID: 2, Name: "Hall B"}} {
+		if err := AddRoom(rm); err != nil {
+			t.Fatalf("AddRoom(%s): %v", rm.Name, err)
+		}
+	}
+	for _, r := range []models.Rack{
+		{Name: "R1", Height: 42, RoomID: 1}, {Name: "R2", Height: 42, RoomID: 2}, {Name: "R3", Height: 42, RoomID: 3},
+		{Name: "R4", Height: 42, SiteID: 2},
+	} {
+		if err := AddRack(r, "alice"); err != nil {
+			t.Fatalf("AddRack(%s): %v", r.Name, err)
+		}
+	}
+
+	errs := []struct {
+		name     string
+		from, to int
+		wantErr  bool
+	}{
+		{name: "into itself", from: 2, to: 2, wantErr: true},
+		{name: "unknown site", from: 2, to: 9, wantErr: true},
+		{name: "duplicate", from: 2, to: 1},
+		{name: "already merged", from: 2, to: 1, wantErr: true},
+	}
+	for _, tt := range errs {
+		if err := MergeSite(tt.from, tt.to, "bob"); (err != nil) != tt.wantErr {
+			t.Errorf("%s: MergeSite = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	tests := []struct {
+		rack, wantRoom, wantVersion int
+	}{
+		{rack: 1, wantRoom: 1, wantVersion: 1},
+		{rack: 2, wantRoom: 1, wantVersion: 2},
+		{rack: 3, wantRoom: 3, wantVersion: 2},
+		{rack: 4, wantRoom: 0, wantVersion: 2},
+	}
+	for _, tt := range tests {
+		r, err := GetRack(tt.rack)
+		if err != nil || r.SiteID != 1 || r.RoomID != tt.wantRoom || r.Version != tt.wantVersion {
+			t.Errorf("rack %s: site %d, room %d, version %d, %v, want site 1, room %d, version %d",
+				r.Name, r.SiteID, r.RoomID, r.Version, err, tt.wantRoom, tt.wantVersion)
+		}
+	}
+	rooms, err := GetAllRooms()
+	if err != nil || len(rooms) != 2 {
+		t.Errorf("rooms = %+v, %v, want Hall A and Hall B", rooms, err)
+	}
+	if _, err := GetSite(2); err == nil {
+		t.Errorf("merged site still exists")
+	}
+	if err := AddSite(models.Site{Name: " dc1"}); !errors.Is(err, ErrSiteExists) {
+		t.Errorf("AddSite of a name differing in case = %v, want %v", err, ErrSiteExists)
+	}
+}
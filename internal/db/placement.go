@@ -0,0 +1,104 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"ipam/internal/models"
+)
+
+// ErrRackInTrash is returned when a device is put in a rack that is in the trash. Devices that
+// were in the rack when it was deleted stay in it, and come back with it when it is restored.
+var ErrRackInTrash = errors.New("the rack is in the trash")
+
+// PlacementError is returned when a device does not fit where it is mounted in its rack:
+// it overlaps another device on the same face, or it sticks out of the rack.
+// Devices on opposite faces may share units, e.g. a patch panel behind a switch.
+type PlacementError struct {
+	Units    string // Units the device would occupy, e.g. "U10–U11 front"
+	Hostname string // Device already in those units, "" when the rack is too short
+	Height   int    // Height of the rack, when the device sticks out of it
+}
+
+func (e *PlacementError) Error() string {
+	if e.Hostname != "" {
+		return fmt.Sprintf("%s is already taken by %s", e.Units, e.Hostname)
+	}
+	return fmt.Sprintf("%s does not fit in a %dU rack", e.Units, e.Height)
+}
+
+// checkPlacement makes sure a device fits where it is mounted. Devices without a rack or a
+// position are not mounted; their position and face are cleared. It returns ErrRackInTrash when
+// the rack is in the trash, unless the device was already in it.
+func checkPlacement(tx *sql.Tx, d *models.Device) error {
+	if d.UHeight < 1 {
+		d.UHeight = 1
+	}
+	if d.RackID == 0 || d.Position <= 0 {
+		d.Position, d.Face = 0, ""
+		return nil
+	}
+	if d.Face != "rear" {
+		d.Face = "front"
+	}
+
+	var height int
+	var trashed bool
+	err := tx.QueryRow("SELECT height, deleted_at IS NOT NULL FROM racks WHERE id = ?", d.RackID).Scan(&height, &trashed)
+	if err == sql.ErrNoRows {
+		// The rack is gone; keep the device but unmount it
+		d.Position, d.Face = 0, ""
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if trashed {
+		var rackID int
+		err := tx.QueryRow("SELECT COALESCE(rack_id, 0) FROM devices WHERE id = ?", d.ID).Scan(&rackID)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if rackID != d.RackID {
+			return ErrRackInTrash
+		}
+	}
+	if d.TopUnit() > height {
+		return &PlacementError{Units: d.Units(), Height: height}
+	}
+
+	var hostname string
+	err = tx.QueryRow(`SELECT hostname FROM devices
+		WHERE rack_id = ? AND face = ? AND id != ? AND position > 0 AND deleted_at IS NULL
+			AND position <= ? AND position + COALESCE(u_height, 1) - 1 >= ?
+		ORDER BY position LIMIT 1`,
+		d.RackID, d.Face, d.ID, d.TopUnit(), d.Position).Scan(&hostname)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return &PlacementError{Units: d.Units(), Hostname: hostname}
+}
+
+// checkRackHeight makes sure the devices mounted in a rack still fit when its height changes.
+// Racks in the trash cannot be changed; sql.ErrNoRows is returned for them.
+func checkRackHeight(tx *sql.Tx, r models.Rack) error {
+	var trashed bool
+	if err := tx.QueryRow("SELECT deleted_at IS NOT NULL FROM racks WHERE id = ?", r.ID).Scan(&trashed); err != nil {
+		return err
+	}
+	if trashed {
+		return sql.ErrNoRows
+	}
+
+	var d models.Device
+	err := tx.QueryRow(`SELECT hostname, position, COALESCE(u_height, 1), face FROM devices
+		WHERE rack_id = ? AND position > 0 AND position + COALESCE(u_height, 1) - 1 > ? AND deleted_at IS NULL
+		ORDER BY position + u_height DESC LIMIT 1`, r.ID, r.Height).Scan(&d.Hostname, &d.Position, &d.UHeight, &d.Face)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return &PlacementError{Units: d.Hostname + " in " + d.Units(), Height: r.Height}
+}
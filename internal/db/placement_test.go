@@ -0,0 +1,128 @@
+package db
+
+import (
+	"errors"
+	"ipam/internal/lifecycle"
+	"ipam/internal/models"
+	"testing"
+)
+
+func TestCheckPlacement(t *testing.T) {
+	openTestDB(t)
+
+	// R1 holds sw1 in U10–U11 front; R2 is in the trash with old1 still in U1
+	for _, r := range []models.Rack{{Name: "R1", Height: 42}, {Name: "R2", Height: 10}} {
+		if err := AddRack(r, "alice"); err != nil {
+			t.Fatalf("AddRack(%s): %v", r.Name, err)
+		}
+	}
+	devices := []models.Device{
+		{Hostname: "sw1", Status: lifecycle.Active, RackID: 1, Position: 10, UHeight: 2, Face: "front"},
+		{Hostname: "old1", Status: lifecycle.Active, RackID: 2, Position: 1, UHeight: 1, Face: "front"},
+	}
+	for _, d := range devices {
+		if err := AddDevice(d, "alice"); err != nil {
+			t.Fatalf("AddDevice(%s): %v", d.Hostname, err)
+		}
+	}
+	if err := DeleteRack(2, "alice"); err != nil {
+		t.Fatalf("DeleteRack: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		device    models.Device
+		taken     bool // Whether another device is in the way
+		tooShort  bool
+		wantErr   error
+		wantUnits string
+	}{
+		{name: "free units", device: models.Device{RackID: 1, Position: 12, UHeight: 2, Face: "front"}, wantUnits: "U12–U13 front"},
+		{name: "overlaps the bottom", device: models.Device{RackID: 1, Position: 9, UHeight: 2, Face: "front"}, taken: true},
+		{name: "overlaps the top", device: models.Device{RackID: 1, Position: 11, UHeight: 1, Face: "front"}, taken: true},
+		{name: "opposite face", device: models.Device{RackID: 1, Position: 10, UHeight: 2, Face: "rear"}, wantUnits: "U10–U11 rear"},
+		{name: "face defaults to front", device: models.Device{RackID: 1, Position: 10, UHeight: 1}, taken: true},
+		{name: "its own units", device: models.Device{ID: 1, RackID: 1, Position: 10, UHeight: 2, Face: "front"}, wantUnits: "U10–U11 front"},
+		{name: "sticks out", device: models.Device{RackID: 1, Position: 42, UHeight: 2, Face: "front"}, tooShort: true},
+		{name: "not mounted", device: models.Device{RackID: 1, Face: "rear"}},
+		{name: "rack gone", device: models.Device{RackID: 9, Position: 1, Face: "front"}},
+		{name: "into a rack in the trash", device: models.Device{RackID: 2, Position: 5, Face: "front"}, wantErr: ErrRackInTrash},
+		{name: "moved into a rack in the trash", device: models.Device{ID: 1, RackID: 2, Position: 5, Face: "front"}, wantErr: ErrRackInTrash},
+		{name: "already in the rack in the trash", device: models.Device{ID: 2, RackID: 2, Position: 2, Face: "front"}, wantUnits: "U2 front"},
+	}
+	for _, tt := range tests {
+		tx, err := DB.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := tt.device
+		err = checkPlacement(tx, &d)
+		tx.Rollback()
+
+		var placementErr *PlacementError
+		switch {
+		case tt.wantErr != nil:
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s: got %v, want %v", tt.name, err, tt.wantErr)
+			}
+		case tt.taken || tt.tooShort:
+			if !errors.As(err, &placementErr) || (placementErr.Hostname != "") != tt.taken {
+				t.Errorf("%s: got %v, want a placement error (taken %v)", tt.name, err, tt.taken)
+			}
+		case err != nil:
+			t.Errorf("%s: got %v, want no error", tt.name, err)
+		case d.Units() != tt.wantUnits:
+			t.Errorf("%s: placed in %q, want %q", tt.name, d.Units(), tt.wantUnits)
+		}
+	}
+}
+
+func TestCheckRackHeight(t *testing.T) {
+	openTestDB(t)
+
+	// sw1 sits in U30–U31 of R1; old1 in U40 is in the trash and does not count
+	if err := AddRack(models.Rack{Name: "R1", Height: 42}, "alice"); err != nil {
+		t.Fatalf("AddRack: %v", err)
+	}
+	devices := []models.Device{
+		{Hostname: "sw1", Status: lifecycle.Active, RackID: 1, Position: 30, UHeight: 2, Face: "rear"},
+		{Hostname: "old1", Status: lifecycle.Active, RackID: 1, Position: 40, UHeight: 1, Face: "front"},
+	}
+	for _, d := range devices {
+		if err := AddDevice(d, "alice"); err != nil {
+			t.Fatalf("AddDevice(%s): %v", d.Hostname, err)
+		}
+	}
+	if err := DeleteDevice(2, "alice"); err != nil {
+		t.Fatalf("DeleteDevice: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		height    int
+		wantUnits string // Units of the placement error, "" when the height fits
+	}{
+		{name: "taller", height: 48},
+		{name: "just fits", height: 31},
+		{name: "cuts the top unit", height: 30, wantUnits: "sw1 in U30–U31 rear"},
+		{name: "below the device", height: 12, wantUnits: "sw1 in U30–U31 rear"},
+	}
+	for _, tt := range tests {
+		tx, err := DB.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = checkRackHeight(tx, models.Rack{ID: 1, Height: tt.height})
+		tx.Rollback()
+
+		var placementErr *PlacementError
+		switch {
+		case tt.wantUnits == "":
+			if err != nil {
+				t.Errorf("%s: got %v, want no error", tt.name, err)
+			}
+		case !errors.As(err, &placementErr) || placementErr.Units != tt.wantUnits:
+			t.Errorf("%s: got %v, want a placement error for %s", tt.name, err, tt.wantUnits)
+		}
+	}
+}
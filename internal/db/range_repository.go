@@ -0,0 +1,164 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"ipam/internal/models"
+	"ipam/internal/netutil"
+	"sort"
+	"time"
+)
+
+// ErrRangeOverlap is returned when an IP range overlaps another range in the same VRF
+var ErrRangeOverlap = errors.New("IP range overlaps an existing range")
+
+// RangeTypes lists the supported kinds of IP range
+var RangeTypes = []string{"DHCP", "Static", "Infrastructure"}
+
+// rangeSelect JOINs with subnets to get the prefix and VRF of each range
+const rangeSelect = `
+	SELECT r.id, r.subnet_id, COALESCE(s.cidr, ''), COALESCE(s.vrf_id, 1), r.start_ip, r.end_ip, r.range_type,
+		r.description, r.created_at
+	FROM ip_ranges r
+	LEFT JOIN subnets s ON r.subnet_id = s.id`
+
+func scanRange(row interface{ Scan(...interface{}) error }, r *models.IPRange) error {
+	return row.Scan(&r.ID, &r.SubnetID, &r.SubnetCIDR, &r.VRFID, &r.StartIP, &r.EndIP, &r.Type, &r.Description, &r.CreatedAt)
+}
+
+// GetAllRanges retrieves all IP ranges ordered by subnet, then start address
+func GetAllRanges() ([]models.IPRange, error) {
+	return queryRanges("")
+}
+
+// GetSubnetRanges retrieves the IP ranges of a single subnet
+func GetSubnetRanges(subnetID int) ([]models.IPRange, error) {
+	return queryRanges(" WHERE r.subnet_id = ?", subnetID)
+}
+
+func queryRanges(where string, args ...interface{}) ([]models.IPRange, error) {
+	rows, err := DB.Query(rangeSelect+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ranges []models.IPRange
+	for rows.Next() {
+		var r models.IPRange
+		if err := scanRange(rows, &r); err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Addresses are text, so order them numerically in Go
+	sortRanges(ranges)
+	return ranges, nil
+}
+
+// GetRange retrieves a single IP range by ID
+func GetRange(id int) (models.IPRange, error) {
+	var r models.IPRange
+	err := scanRange(DB.QueryRow(rangeSelect+" WHERE r.id = ?", id), &r)
+	return r, err
+}
+
+// AddRange adds a new IP range. Both ends must lie inside the subnet and the range
+// must not overlap any other range of the same VRF.
+func AddRange(r models.IPRange) error {
+	if err := checkRange(&r); err != nil {
+		return err
+	}
+	_, err := DB.Exec("INSERT INTO ip_ranges (subnet_id, start_ip, end_ip, range_type, description, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		r.SubnetID, r.StartIP, r.EndIP, r.Type, r.Description, time.Now())
+	return err
+}
+
+// UpdateRange updates an existing IP range
+func UpdateRange(r models.IPRange) error {
+	if err := checkRange(&r); err != nil {
+		return err
+	}
+	_, err := DB.Exec("UPDATE ip_ranges SET subnet_id=?, start_ip=?, end_ip=?, range_type=?, description=? WHERE id=?",
+		r.SubnetID, r.StartIP, r.EndIP, r.Type, r.Description, r.ID)
+	return err
+}
+
+// DeleteRange deletes an IP range
+func DeleteRange(id int) error {
+	_, err := DB.Exec("DELETE FROM ip_ranges WHERE id=?", id)
+	return err
+}
+
+// checkRange canonicalizes the addresses of r and validates it against its subnet and
+// the other ranges of the VRF
+func checkRange(r *models.IPRange) error {
+	subnet, err := GetSubnet(r.SubnetID)
+	if err != nil {
+		return fmt.Errorf("subnet %d not found", r.SubnetID)
+	}
+	prefix, err := netutil.ParsePrefix(subnet.CIDR)
+	if err != nil {
+		return err
+	}
+
+	start, err := netutil.ParseAddr(r.StartIP)
+	if err != nil {
+		return err
+	}
+	end, err := netutil.ParseAddr(r.EndIP)
+	if err != nil {
+		return err
+	}
+	if !prefix.Contains(start) || !prefix.Contains(end) {
+		return fmt.Errorf("range %s-%s is not inside %s", start, end, prefix)
+	}
+	if start.Compare(end) > 0 {
+		return fmt.Errorf("range start %s is after its end %s", start, end)
+	}
+	r.StartIP, r.EndIP = start.String(), end.String()
+	r.VRFID = subnet.VRFID
+
+	validType := false
+	for _, t := range RangeTypes {
+		validType = validType || r.Type == t
+	}
+	if !validType {
+		return fmt.Errorf("unknown range type %q", r.Type)
+	}
+
+	others, err := queryRanges(" WHERE s.vrf_id = ? AND r.id != ?", subnet.VRFID, r.ID)
+	if err != nil {
+		return err
+	}
+	for _, o := range others {
+		oStart, err1 := netutil.ParseAddr(o.StartIP)
+		oEnd, err2 := netutil.ParseAddr(o.EndIP)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		if (addrSpan{start, end}).overlaps(addrSpan{oStart, oEnd}) {
+			return fmt.Errorf("%w: %s-%s (%s)", ErrRangeOverlap, o.StartIP, o.EndIP, o.Type)
+		}
+	}
+	return nil
+}
+
+// sortRanges orders ranges by VRF, then start address
+func sortRanges(ranges []models.IPRange) {
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].VRFID != ranges[j].VRFID {
+			return ranges[i].VRFID < ranges[j].VRFID
+		}
+		a, errA := netutil.ParseAddr(ranges[i].StartIP)
+		b, errB := netutil.ParseAddr(ranges[j].StartIP)
+		if errA != nil || errB != nil {
+			return ranges[i].StartIP < ranges[j].StartIP
+		}
+		return a.Compare(b) < 0
+	})
+}
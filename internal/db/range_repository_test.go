@@ -0,0 +1,79 @@
+package db
+
+import (
+	"errors"
+	"ipam/internal/models"
+	"testing"
+)
+
+func TestAddRange(t *testing.T) {
+	openTestDB(t)
+
+	// Subnet 1 is 10.0.0.0/24 and subnet 3 the same prefix in the lab VRF;
+	// subnet 2 is 10.0.0.0/25, nested in subnet 1, and 2001:db8::/64 is subnet 4
+	if err := AddVRF(models.VRF{Name: "lab"}); err != nil {
+		t.Fatalf("AddVRF: %v", err)
+	}
+	lab := lastID(t, "vrfs")
+	for _, s := range []models.Subnet{
+		{CIDR: "10.0.0.0/24"}, {CIDR: "10.0.0.0/25"}, {CIDR: "10.0.0.0/24", VRFID: lab}, {CIDR: "2001:db8::/64"},
+	} {
+		if err := AddSubnet(s); err != nil {
+			t.Fatalf("AddSubnet(%s): %v", s.CIDR, err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		r       models.IPRange
+		wantErr error
+		anyErr  bool
+	}{
+		{name: "DHCP pool", r: models.IPRange{SubnetID: 1, StartIP: "10.0.0.100", EndIP: "10.0.0.199", Type: "DHCP"}},
+		{name: "single address", r: models.IPRange{SubnetID: 1, StartIP: "10.0.0.1", EndIP: "10.0.0.1", Type: "Infrastructure"}},
+		{name: "overlaps the start", r: models.IPRange{SubnetID: 1, StartIP: "10.0.0.50", EndIP: "10.0.0.100", Type: "Static"}, wantErr: ErrRangeOverlap},
+		{name: "inside another", r: models.IPRange{SubnetID: 1, StartIP: "10.0.0.120", EndIP: "10.0.0.130", Type: "Static"}, wantErr: ErrRangeOverlap},
+		{name: "overlaps from a nested subnet", r: models.IPRange{SubnetID: 2, StartIP: "10.0.0.1", EndIP: "10.0.0.10", Type: "Static"}, wantErr: ErrRangeOverlap},
+		{name: "adjacent", r: models.IPRange{SubnetID: 1, StartIP: "10.0.0.200", EndIP: "10.0.0.254", Type: "Static"}},
+		{name: "same addresses in another VRF", r: models.IPRange{SubnetID: 3, StartIP: "10.0.0.100", EndIP: "10.0.0.199", Type: "DHCP"}},
+		{name: "IPv6", r: models.IPRange{SubnetID: 4, StartIP: "2001:DB8::100", EndIP: "2001:db8::1ff", Type: "DHCP"}},
+		{name: "outside the subnet", r: models.IPRange{SubnetID: 2, StartIP: "10.0.0.120", EndIP: "10.0.0.130", Type: "Static"}, anyErr: true},
+		{name: "start after end", r: models.IPRange{SubnetID: 1, StartIP: "10.0.0.20", EndIP: "10.0.0.10", Type: "Static"}, anyErr: true},
+		{name: "unknown type", r: models.IPRange{SubnetID: 1, StartIP: "10.0.0.20", EndIP: "10.0.0.30", Type: "Pool"}, anyErr: true},
+		{name: "unknown subnet", r: models.IPRange{SubnetID: 9, StartIP: "10.0.0.20", EndIP: "10.0.0.30", Type: "Static"}, anyErr: true},
+	}
+	for _, tt := range tests {
+		err := AddRange(tt.r)
+		switch {
+		case tt.anyErr:
+			if err == nil {
+				t.Errorf("%s: AddRange succeeded, want an error", tt.name)
+			}
+		case !errors.Is(err, tt.wantErr):
+			t.Errorf("%s: AddRange = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	r, err := GetRange(lastID(t, "ip_ranges"))
+	if err != nil || r.StartIP != "2001:db8::100" || r.EndIP != "2001:db8::1ff" {
+		t.Errorf("IPv6 range = %+v, %v, want it stored in canonical form", r, err)
+	}
+
+	// A range can be edited in place without overlapping itself, and its subnet cannot shrink past it
+	r, err = GetRange(1)
+	if err != nil {
+		t.Fatalf("GetRange: %v", err)
+	}
+	r.EndIP = "10.0.0.150"
+	if err := UpdateRange(r); err != nil {
+		t.Errorf("UpdateRange: %v", err)
+	}
+	s, err := GetSubnet(1)
+	if err != nil {
+		t.Fatalf("GetSubnet: %v", err)
+	}
+	s.CIDR = "10.0.0.0/26"
+	if err := UpdateSubnet(s); err == nil || errors.Is(err, ErrSubnetExists) {
+		t.Errorf("UpdateSubnet shrinking past its ranges = %v, want a range error", err)
+	}
+}
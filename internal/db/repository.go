@@ -1,86 +1,232 @@
 package db
 
 import (
+	"database/sql"
+	"fmt"
+	"ipam/internal/lifecycle"
 	"ipam/internal/models"
+	"ipam/internal/netutil"
 	"log"
+	"net"
+	"strings"
 	"time"
 )
 
-// GetAllRacks retrieves all racks
+const rackSelect = `SELECT r.id, r.name, COALESCE(r.site_id, 0), COALESCE(s.name, ''), COALESCE(g.name, ''),
+	COALESCE(r.room_id, 0), COALESCE(rm.name, ''), r.height, r.status, COALESCE(r.tenant_id, 0), COALESCE(t.name, ''), r.created_at,
+	r.version, r.deleted_at, COALESCE(r.deleted_by, '')
+	FROM racks r
+	LEFT JOIN sites s ON s.id = r.site_id
+	LEFT JOIN regions g ON g.id = s.region_id
+	LEFT JOIN rooms rm ON rm.id = r.room_id
+	LEFT JOIN tenants t ON t.id = r.tenant_id`
+
+func scanRack(row interface{ Scan(...interface{}) error }, r *models.Rack) error {
+	return row.Scan(&r.ID, &r.Name, &r.SiteID, &r.SiteName, &r.RegionName, &r.RoomID, &r.RoomName, &r.Height, &r.Status,
+		&r.TenantID, &r.TenantName, &r.CreatedAt, &r.Version, &r.DeletedAt, &r.DeletedBy)
+}
+
+// GetAllRacks retrieves all racks with their site and tags, grouped by site. Racks outside of a site come last.
+// Racks in the trash are left out.
 func GetAllRacks() ([]models.Rack, error) {
-	rows, err := DB.Query("SELECT id, name, location, height, status, created_at FROM racks ORDER BY name")
+	rows, err := DB.Query(rackSelect + " WHERE r.deleted_at IS NULL ORDER BY s.name IS NULL, s.name, r.name")
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	tags, err := objectTags(DB, TagRack, "")
+	if err != nil {
+		return nil, err
+	}
+	custom, err := customFieldValues(DB, TagRack, "")
+	if err != nil {
+		return nil, err
+	}
+
 	var racks []models.Rack
 	for rows.Next() {
 		var r models.Rack
-		if err := rows.Scan(&r.ID, &r.Name, &r.Location, &r.Height, &r.Status, &r.CreatedAt); err != nil {
+		if err := scanRack(rows, &r); err != nil {
 			return nil, err
 		}
+		r.Tags = tags[r.ID]
+		r.CustomFields = custom[r.ID]
 		racks = append(racks, r)
 	}
 	return racks, nil
 }
 
-// AddRack adds a new rack
-func AddRack(r models.Rack) error {
+// AddRack adds a new rack. actor is recorded in the audit log.
+func AddRack(r models.Rack, actor string) error {
 	status := r.Status
 	if status == "" {
 		status = "Online"
 	}
-	_, err := DB.Exec("INSERT INTO racks (name, location, height, status, created_at) VALUES (?, ?, ?, ?, ?)",
-		r.Name, r.Location, r.Height, status, time.Now())
-	return err
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := placeRack(tx, &r); err != nil {
+		return err
+	}
+	result, err := tx.Exec("INSERT INTO racks (name, site_id, room_id, height, status, tenant_id, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		r.Name, r.SiteID, r.RoomID, r.Height, status, r.TenantID, time.Now())
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if err := setObjectTags(tx, TagRack, id, r.Tags); err != nil {
+		return err
+	}
+	if err := setCustomFieldValues(tx, TagRack, id, r.CustomFields); err != nil {
+		return err
+	}
+	if err := auditRack(tx, int(id), AuditCreate, actor, nil); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 // GetRack retrieves a single rack by ID
 func GetRack(id int) (models.Rack, error) {
+	return loadRack(DB, id, " AND r.deleted_at IS NULL")
+}
+
+// loadRack reads a rack with its tags and custom fields; extraWhere narrows down which racks qualify
+func loadRack(q queryer, id int, extraWhere string) (models.Rack, error) {
 	var r models.Rack
-	err := DB.QueryRow("SELECT id, name, location, height, status, created_at FROM racks WHERE id = ?", id).
-		Scan(&r.ID, &r.Name, &r.Location, &r.Height, &r.Status, &r.CreatedAt)
+	if err := scanRack(q.QueryRow(rackSelect+" WHERE r.id = ?"+extraWhere, id), &r); err != nil {
+		return r, err
+	}
+
+	tags, err := objectTags(q, TagRack, "o.object_id = ?", id)
+	if err != nil {
+		return r, err
+	}
+	r.Tags = tags[id]
+
+	custom, err := customFieldValues(q, TagRack, "v.object_id = ?", id)
+	r.CustomFields = custom[id]
 	return r, err
 }
 
-// UpdateRack updates an existing rack
-func UpdateRack(r models.Rack) error {
-	_, err := DB.Exec("UPDATE racks SET name=?, location=?, height=?, status=? WHERE id=?",
-		r.Name, r.Location, r.Height, r.Status, r.ID)
-	return err
+// UpdateRack updates an existing rack. It returns a *PlacementError when the rack would become
+// too short for the devices mounted in it, ErrStale when the rack was changed since r.Version
+// or r.Version is not set, and sql.ErrNoRows when the rack is gone or in the trash.
+func UpdateRack(r models.Rack, actor string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkVersion(tx, "racks", r.ID, r.Version); err != nil {
+		return err
+	}
+	before, err := rackSnapshot(tx, r.ID)
+	if err != nil {
+		return err
+	}
+
+	if err := placeRack(tx, &r); err != nil {
+		return err
+	}
+	if err := checkRackHeight(tx, r); err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE racks SET name=?, site_id=?, room_id=?, height=?, status=?, tenant_id=?, version=version+1 WHERE id=?",
+		r.Name, r.SiteID, r.RoomID, r.Height, r.Status, r.TenantID, r.ID)
+	if err != nil {
+		return err
+	}
+	if err := setObjectTags(tx, TagRack, int64(r.ID), r.Tags); err != nil {
+		return err
+	}
+	if err := setCustomFieldValues(tx, TagRack, int64(r.ID), r.CustomFields); err != nil {
+		return err
+	}
+	if err := auditRack(tx, r.ID, AuditUpdate, actor, before); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-// DeleteRack deletes a rack
-func DeleteRack(id int) error {
-	// Optional: Handle devices associated with this rack (Set rack_id = 0 or CASCADE)
-	// For now, let's set them to 0 (Unassigned) manually or rely on DB constraints if set (ON DELETE SET NULL)
-	// Our CREATE TABLE had: FOREIGN KEY(rack_id) REFERENCES racks(id) ON DELETE SET NULL
-	// So just deleting the rack should work fine.
+// DeleteRack moves a rack to the trash. Its devices read as not racked but keep their place,
+// so they are back in the rack when it is restored. It returns sql.ErrNoRows when there is no
+// such rack outside the trash.
+func DeleteRack(id int, actor string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	_, err := DB.Exec("DELETE FROM racks WHERE id=?", id)
-	return err
+	result, err := tx.Exec("UPDATE racks SET deleted_at = ?, deleted_by = ?, version = version + 1 WHERE id = ? AND deleted_at IS NULL", time.Now(), actor, id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	if err := auditRack(tx, id, AuditDelete, actor, nil); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// deviceSelect reads a device with the names of its rack, model and tenant.
+// Devices whose rack is in the trash (or gone) read as not racked; the rack is kept for a restore.
+const deviceSelect = `SELECT d.id, d.hostname, d.device_type, COALESCE(d.model_id, 0), COALESCE(m.name, ''), COALESCE(v.name, ''),
+	COALESCE(r.id, 0), COALESCE(r.name, ''), CASE WHEN r.id IS NULL THEN 0 ELSE COALESCE(d.position, 0) END,
+	COALESCE(d.u_height, 1), CASE WHEN r.id IS NULL THEN '' ELSE COALESCE(d.face, '') END, d.status,
+	COALESCE(d.tenant_id, 0), COALESCE(t.name, ''), d.description, d.expires_at, d.updated_at, d.version,
+	d.deleted_at, COALESCE(d.deleted_by, '')
+	FROM devices d
+	LEFT JOIN racks r ON d.rack_id = r.id AND r.deleted_at IS NULL
+	LEFT JOIN device_models m ON m.id = d.model_id
+	LEFT JOIN manufacturers v ON v.id = m.manufacturer_id
+	LEFT JOIN tenants t ON t.id = d.tenant_id`
+
+func scanDevice(row interface{ Scan(...interface{}) error }, d *models.Device) error {
+	return row.Scan(&d.ID, &d.Hostname, &d.DeviceType, &d.ModelID, &d.ModelName, &d.ManufacturerName, &d.RackID, &d.RackName,
+		&d.Position, &d.UHeight, &d.Face, &d.Status, &d.TenantID, &d.TenantName, &d.Description, &d.ExpiresAt, &d.UpdatedAt,
+		&d.Version, &d.DeletedAt, &d.DeletedBy)
 }
 
 // GetAllDevices retrieves all devices and their interfaces
 // JOINs with racks table to get rack name
 func GetAllDevices() ([]models.Device, error) {
-	query := `
-		SELECT d.id, d.hostname, d.device_type, d.rack_id, COALESCE(r.name, '') as rack_name, d.status, d.description, d.updated_at 
-		FROM devices d 
-		LEFT JOIN racks r ON d.rack_id = r.id 
-		ORDER BY d.updated_at DESC`
-
-	rows, err := DB.Query(query)
+	rows, err := DB.Query(deviceSelect + " WHERE d.deleted_at IS NULL ORDER BY d.updated_at DESC")
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	tags, err := objectTags(DB, TagDevice, "")
+	if err != nil {
+		return nil, err
+	}
+	custom, err := customFieldValues(DB, TagDevice, "")
+	if err != nil {
+		return nil, err
+	}
+
 	var devices []models.Device
 	for rows.Next() {
 		var d models.Device
-		if err := rows.Scan(&d.ID, &d.Hostname, &d.DeviceType, &d.RackID, &d.RackName, &d.Status, &d.Description, &d.UpdatedAt); err != nil {
+		if err := scanDevice(rows, &d); err != nil {
 			return nil, err
 		}
 
@@ -91,15 +237,28 @@ func GetAllDevices() ([]models.Device, error) {
 			continue
 		}
 		d.Interfaces = ifaces
+		d.Tags = tags[d.ID]
+		d.CustomFields = custom[d.ID]
+		localExpiry(&d)
 
 		devices = append(devices, d)
 	}
 	return devices, nil
 }
 
-// GetDeviceInterfaces retrieves interfaces for a specific device ID
+// GetDeviceInterfaces retrieves interfaces for a specific device ID, including VLAN membership, tags and cables
+// JOINs with vrfs table to get the VRF name
 func GetDeviceInterfaces(deviceID int) ([]models.DeviceInterface, error) {
-	rows, err := DB.Query("SELECT id, device_id, ip_address, mac_address, label FROM device_interfaces WHERE device_id = ?", deviceID)
+	return deviceInterfaces(DB, deviceID)
+}
+
+func deviceInterfaces(q queryer, deviceID int) ([]models.DeviceInterface, error) {
+	rows, err := q.Query(`SELECT i.id, i.device_id, i.ip_address, i.mac_address, i.label, COALESCE(i.vlan_mode, ''),
+		COALESCE(i.untagged_vlan_id, 0), COALESCE(i.vrf_id, 1), COALESCE(f.name, ''), COALESCE(i.tenant_id, 0), COALESCE(t.name, '')
+		FROM device_interfaces i
+		LEFT JOIN vrfs f ON i.vrf_id = f.id
+		LEFT JOIN tenants t ON t.id = i.tenant_id
+		WHERE i.device_id = ?`, deviceID)
 	if err != nil {
 		return nil, err
 	}
@@ -108,42 +267,278 @@ func GetDeviceInterfaces(deviceID int) ([]models.DeviceInterface, error) {
 	var ifaces []models.DeviceInterface
 	for rows.Next() {
 		var i models.DeviceInterface
-		if err := rows.Scan(&i.ID, &i.DeviceID, &i.IPAddress, &i.MACAddress, &i.Label); err != nil {
+		if err := rows.Scan(&i.ID, &i.DeviceID, &i.IPAddress, &i.MACAddress, &i.Label, &i.VLANMode, &i.UntaggedVLANID,
+			&i.VRFID, &i.VRFName, &i.TenantID, &i.TenantName); err != nil {
 			return nil, err
 		}
 		ifaces = append(ifaces, i)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	tagged, err := taggedVLANs(q, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := objectTags(q, TagInterface, "o.object_id IN (SELECT id FROM device_interfaces WHERE device_id = ?)", deviceID)
+	if err != nil {
+		return nil, err
+	}
+	peers, err := cablePeers(q, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range ifaces {
+		ifaces[i].TaggedVLANIDs = tagged[ifaces[i].ID]
+		ifaces[i].Tags = tags[ifaces[i].ID]
+		ifaces[i].Peer = peers[ifaces[i].ID]
+	}
 	return ifaces, nil
 }
 
+// taggedVLANs returns the tagged VLAN IDs of every interface of a device, keyed by interface ID
+func taggedVLANs(q queryer, deviceID int) (map[int][]int, error) {
+	rows, err := q.Query(`SELECT iv.interface_id, iv.vlan_id FROM interface_vlans iv
+		JOIN device_interfaces di ON di.id = iv.interface_id
+		WHERE di.device_id = ? ORDER BY iv.vlan_id`, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tagged := make(map[int][]int)
+	for rows.Next() {
+		var ifaceID, vlanID int
+		if err := rows.Scan(&ifaceID, &vlanID); err != nil {
+			return nil, err
+		}
+		tagged[ifaceID] = append(tagged[ifaceID], vlanID)
+	}
+	return tagged, rows.Err()
+}
+
+// insertInterface inserts an interface with its tagged VLAN membership and tags, returning the new interface ID
+func insertInterface(tx *sql.Tx, deviceID int64, iface models.DeviceInterface) (int64, error) {
+	result, err := tx.Exec("INSERT INTO device_interfaces (device_id, ip_address, mac_address, label, vlan_mode, untagged_vlan_id, vrf_id, tenant_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		deviceID, iface.IPAddress, iface.MACAddress, iface.Label, iface.VLANMode, iface.UntaggedVLANID, iface.VRFID, iface.TenantID)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	if iface.VLANMode == "tagged" {
+		for _, vlanID := range iface.TaggedVLANIDs {
+			if _, err := tx.Exec("INSERT OR IGNORE INTO interface_vlans (interface_id, vlan_id) VALUES (?, ?)", id, vlanID); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if err := setObjectTags(tx, TagInterface, id, iface.Tags); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// syncInterfaces brings the stored interfaces of a device in line with the submitted ones.
+// Submitted interfaces with the ID of one of its interfaces update it in place, so it keeps its ID
+// and cables; the others are inserted, and stored interfaces that were not submitted are removed.
+func syncInterfaces(tx *sql.Tx, deviceID int, ifaces []models.DeviceInterface) error {
+	rows, err := tx.Query("SELECT id FROM device_interfaces WHERE device_id = ?", deviceID)
+	if err != nil {
+		return err
+	}
+	stored := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		stored[id] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	kept := make(map[int]bool)
+	for _, iface := range ifaces {
+		// IDs of other devices, or one submitted twice, get a new interface
+		if !stored[iface.ID] || kept[iface.ID] {
+			if _, err := insertInterface(tx, int64(deviceID), iface); err != nil {
+				return err
+			}
+			continue
+		}
+		kept[iface.ID] = true
+		if err := updateInterface(tx, iface); err != nil {
+			return err
+		}
+	}
+
+	for id := range stored {
+		if kept[id] {
+			continue
+		}
+		if err := deleteInterface(tx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateInterface saves an interface in place, replacing its tagged VLAN membership and tags
+func updateInterface(tx *sql.Tx, iface models.DeviceInterface) error {
+	_, err := tx.Exec("UPDATE device_interfaces SET ip_address=?, mac_address=?, label=?, vlan_mode=?, untagged_vlan_id=?, vrf_id=?, tenant_id=? WHERE id=?",
+		iface.IPAddress, iface.MACAddress, iface.Label, iface.VLANMode, iface.UntaggedVLANID, iface.VRFID, iface.TenantID, iface.ID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM interface_vlans WHERE interface_id = ?", iface.ID); err != nil {
+		return err
+	}
+	if iface.VLANMode == "tagged" {
+		for _, vlanID := range iface.TaggedVLANIDs {
+			if _, err := tx.Exec("INSERT OR IGNORE INTO interface_vlans (interface_id, vlan_id) VALUES (?, ?)", iface.ID, vlanID); err != nil {
+				return err
+			}
+		}
+	}
+	return setObjectTags(tx, TagInterface, int64(iface.ID), iface.Tags)
+}
+
+// deleteInterface removes one interface with its VLAN membership, tags and cables. Address
+// records attached to it become standalone.
+func deleteInterface(tx *sql.Tx, id int) error {
+	if err := deleteInterfaceCables(tx, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE ip_addresses SET interface_id = 0 WHERE interface_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM interface_vlans WHERE interface_id = ?", id); err != nil {
+		return err
+	}
+	if err := deleteObjectTags(tx, TagInterface, id); err != nil {
+		return err
+	}
+	_, err := tx.Exec("DELETE FROM device_interfaces WHERE id = ?", id)
+	return err
+}
+
+// deleteDeviceInterfaces removes all interfaces of a device along with their VLAN membership and tags
+func deleteDeviceInterfaces(tx *sql.Tx, deviceID int) error {
+	_, err := tx.Exec("DELETE FROM interface_vlans WHERE interface_id IN (SELECT id FROM device_interfaces WHERE device_id = ?)", deviceID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM object_tags WHERE object_type = ? AND object_id IN (SELECT id FROM device_interfaces WHERE device_id = ?)",
+		TagInterface, deviceID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM device_interfaces WHERE device_id=?", deviceID)
+	return err
+}
+
 // GetDevice retrieves a single device by ID with its interfaces
 func GetDevice(id int) (models.Device, error) {
+	return loadDevice(DB, id, " AND d.deleted_at IS NULL")
+}
+
+// loadDevice reads a device with its tags, custom fields and interfaces; extraWhere narrows down
+// which devices qualify
+func loadDevice(q queryer, id int, extraWhere string) (models.Device, error) {
 	var d models.Device
-	query := `
-		SELECT d.id, d.hostname, d.device_type, d.rack_id, COALESCE(r.name, '') as rack_name, d.status, d.description, d.updated_at 
-		FROM devices d 
-		LEFT JOIN racks r ON d.rack_id = r.id 
-		WHERE d.id = ?`
+	if err := scanDevice(q.QueryRow(deviceSelect+" WHERE d.id = ?"+extraWhere, id), &d); err != nil {
+		return d, err
+	}
+	localExpiry(&d)
+
+	tags, err := objectTags(q, TagDevice, "o.object_id = ?", id)
+	if err != nil {
+		return d, err
+	}
+	d.Tags = tags[id]
 
-	err := DB.QueryRow(query, id).
-		Scan(&d.ID, &d.Hostname, &d.DeviceType, &d.RackID, &d.RackName, &d.Status, &d.Description, &d.UpdatedAt)
+	custom, err := customFieldValues(q, TagDevice, "v.object_id = ?", id)
 	if err != nil {
 		return d, err
 	}
+	d.CustomFields = custom[id]
 
-	d.Interfaces, err = GetDeviceInterfaces(d.ID)
+	d.Interfaces, err = deviceInterfaces(q, d.ID)
 	return d, err
 }
 
-// AddDevice adds a new device and its interfaces
-func AddDevice(d models.Device) error {
+// normalizeInterfaces validates interface IP addresses (IPv4 or IPv6), MACs and VRFs and returns
+// a copy with every address in canonical form (MACs as "aa:bb:cc:dd:ee:ff").
+// Interfaces without a VRF join the global VRF. Interfaces of retired devices have no IP address.
+func normalizeInterfaces(ifaces []models.DeviceInterface) ([]models.DeviceInterface, error) {
+	normalized := make([]models.DeviceInterface, len(ifaces))
+	for i, iface := range ifaces {
+		var err error
+		if iface.IPAddress != "" {
+			if iface.IPAddress, err = netutil.CanonicalAddr(iface.IPAddress); err != nil {
+				return nil, err
+			}
+		}
+		if iface.MACAddress = strings.TrimSpace(iface.MACAddress); iface.MACAddress != "" {
+			hw, err := net.ParseMAC(iface.MACAddress)
+			if err != nil {
+				return nil, fmt.Errorf("invalid MAC address %q", iface.MACAddress)
+			}
+			iface.MACAddress = hw.String()
+		}
+		if iface.VRFID, err = normalizeVRFID(DB, iface.VRFID); err != nil {
+			return nil, err
+		}
+		normalized[i] = iface
+	}
+	return normalized, nil
+}
+
+// localExpiry shows a reservation expiry in local time, as entered in the device form
+func localExpiry(d *models.Device) {
+	if d.ExpiresAt != nil {
+		t := d.ExpiresAt.Local()
+		d.ExpiresAt = &t
+	}
+}
+
+// AddDevice adds a new device and its interfaces.
+// It returns a *ConflictError when an IP or MAC is already in use, unless d.AllowDuplicates is set,
+// and a *PlacementError when the device does not fit where it is mounted in its rack.
+func AddDevice(d models.Device, actor string) error {
+	var err error
+	if d.Interfaces, err = normalizeInterfaces(d.Interfaces); err != nil {
+		return err
+	}
+
 	tx, err := DB.Begin()
 	if err != nil {
 		return err
 	}
 
-	result, err := tx.Exec("INSERT INTO devices (hostname, device_type, rack_id, status, description, updated_at) VALUES (?, ?, ?, ?, ?, ?)",
-		d.Hostname, d.DeviceType, d.RackID, d.Status, d.Description, time.Now())
+	if !d.AllowDuplicates {
+		if err := checkConflicts(tx, d); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := checkPlacement(tx, &d); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	result, err := tx.Exec(`INSERT INTO devices (hostname, device_type, model_id, rack_id, position, u_height, face, status, tenant_id, description, expires_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		d.Hostname, d.DeviceType, d.ModelID, d.RackID, d.Position, d.UHeight, d.Face, d.Status, d.TenantID, d.Description, reservationExpiry(d), time.Now())
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -154,71 +549,200 @@ func AddDevice(d models.Device) error {
 		tx.Rollback()
 		return err
 	}
+	if err := recordStatusChange(tx, int(id), "", d.Status, ""); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := setObjectTags(tx, TagDevice, id, d.Tags); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := setCustomFieldValues(tx, TagDevice, id, d.CustomFields); err != nil {
+		tx.Rollback()
+		return err
+	}
 
 	for _, iface := range d.Interfaces {
-		_, err := tx.Exec("INSERT INTO device_interfaces (device_id, ip_address, mac_address, label) VALUES (?, ?, ?, ?)",
-			id, iface.IPAddress, iface.MACAddress, iface.Label)
-		if err != nil {
+		if _, err := insertInterface(tx, id, iface); err != nil {
 			tx.Rollback()
 			return err
 		}
 	}
 
+	if err := auditDevice(tx, int(id), AuditCreate, actor, nil); err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	return tx.Commit()
 }
 
-// UpdateDevice updates an existing device and replaces its interfaces
-func UpdateDevice(d models.Device) error {
+// UpdateDevice updates an existing device and its interfaces. Interfaces keep their ID, cables and
+// attached address records when they are saved with it; see syncInterfaces.
+// It returns a *ConflictError when an IP or MAC is already in use, unless d.AllowDuplicates is set,
+// a *PlacementError when the device does not fit where it is mounted in its rack, and a
+// *lifecycle.TransitionError when the device may not move to its new state, and ErrStale when
+// the device was changed since d.Version or d.Version is not set.
+// Retired devices hold no addresses: retiring a device releases them, and its interfaces are kept
+// without an IP address. actor is recorded in the audit log.
+func UpdateDevice(d models.Device, actor string) error {
+	var err error
+	if d.Status == lifecycle.Retired {
+		for i := range d.Interfaces {
+			d.Interfaces[i].IPAddress = ""
+		}
+	}
+	if d.Interfaces, err = normalizeInterfaces(d.Interfaces); err != nil {
+		return err
+	}
+
 	tx, err := DB.Begin()
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.Exec("UPDATE devices SET hostname=?, device_type=?, rack_id=?, status=?, description=?, updated_at=? WHERE id=?",
-		d.Hostname, d.DeviceType, d.RackID, d.Status, d.Description, time.Now(), d.ID)
+	if err := checkVersion(tx, "devices", d.ID, d.Version); err != nil {
+		tx.Rollback()
+		return err
+	}
+	from, err := checkTransition(tx, d)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	before, err := deviceSnapshot(tx, d.ID)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	// Remove old interfaces
-	_, err = tx.Exec("DELETE FROM device_interfaces WHERE device_id=?", d.ID)
+	if !d.AllowDuplicates {
+		if err := checkConflicts(tx, d); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := checkPlacement(tx, &d); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE devices SET hostname=?, device_type=?, model_id=?, rack_id=?, position=?, u_height=?, face=?, status=?, tenant_id=?, description=?, expires_at=?, updated_at=?, version=version+1 WHERE id=?",
+		d.Hostname, d.DeviceType, d.ModelID, d.RackID, d.Position, d.UHeight, d.Face, d.Status, d.TenantID, d.Description, reservationExpiry(d), time.Now(), d.ID)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	// Insert new interfaces
-	for _, iface := range d.Interfaces {
-		_, err := tx.Exec("INSERT INTO device_interfaces (device_id, ip_address, mac_address, label) VALUES (?, ?, ?, ?)",
-			d.ID, iface.IPAddress, iface.MACAddress, iface.Label)
-		if err != nil {
+	if err := setObjectTags(tx, TagDevice, int64(d.ID), d.Tags); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := setCustomFieldValues(tx, TagDevice, int64(d.ID), d.CustomFields); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if from != d.Status {
+		released := ""
+		if d.Status == lifecycle.Retired {
+			if released, err = releaseAddresses(tx, d.ID); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if err := recordStatusChange(tx, d.ID, from, d.Status, released); err != nil {
 			tx.Rollback()
 			return err
 		}
 	}
 
+	// Remember which address records are attached, to re-attach them where their address is now
+	records, err := attachedAddressRecords(tx, d.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := syncInterfaces(tx, d.ID, d.Interfaces); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := relinkAddressRecords(tx, d.ID, records); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := auditDevice(tx, d.ID, AuditUpdate, actor, before); err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	return tx.Commit()
 }
 
-// DeleteDevice deletes a device and its interfaces (manual cascade)
-func DeleteDevice(id int) error {
+// DeleteDevice moves a device to the trash. It keeps its interfaces and rack position for a
+// restore, but its addresses are free for other devices in the meantime. Its cables are
+// removed and attached address records become standalone again, as they involve other objects.
+// It returns sql.ErrNoRows when there is no such device outside the trash.
+func DeleteDevice(id int, actor string) error {
 	tx, err := DB.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
+
+	if err := trashDevice(tx, id, actor); err != nil {
+		return err
+	}
 
-	_, err = tx.Exec("DELETE FROM device_interfaces WHERE device_id=?", id)
+	return tx.Commit()
+}
+
+// trashDevice moves a device to the trash and records it in the audit log, see DeleteDevice
+func trashDevice(tx *sql.Tx, id int, actor string) error {
+	result, err := tx.Exec("UPDATE devices SET deleted_at = ?, deleted_by = ?, version = version + 1 WHERE id = ? AND deleted_at IS NULL", time.Now(), actor, id)
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	if err := detachDevice(tx, id); err != nil {
+		return err
+	}
+	return auditDevice(tx, id, AuditDelete, actor, nil)
+}
 
-	_, err = tx.Exec("DELETE FROM devices WHERE id=?", id)
+// detachDevice removes the cables of a device and frees the address records attached to it
+func detachDevice(tx *sql.Tx, id int) error {
+	// Address records attached to the device become standalone again
+	_, err := tx.Exec("UPDATE ip_addresses SET interface_id = 0 WHERE interface_id IN (SELECT id FROM device_interfaces WHERE device_id = ?)", id)
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
+	return deleteDeviceCables(tx, id)
+}
 
-	return tx.Commit()
+// deleteDevice deletes a device and its interfaces for good (manual cascade)
+func deleteDevice(tx *sql.Tx, id int) error {
+	if err := detachDevice(tx, id); err != nil {
+		return err
+	}
+	if err := deleteDeviceInterfaces(tx, id); err != nil {
+		return err
+	}
+	if err := deleteObjectTags(tx, TagDevice, id); err != nil {
+		return err
+	}
+	if err := deleteCustomFieldValues(tx, TagDevice, id); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec("DELETE FROM devices WHERE id=?", id)
+	return err
 }
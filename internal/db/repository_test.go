@@ -0,0 +1,196 @@
+package db
+
+import (
+	"ipam/internal/lifecycle"
+	"ipam/internal/models"
+	"testing"
+)
+
+// interfaceFixture holds the IDs of web01 with eth0 and eth1 and of web02 with eth0, with a cable
+// from web01 eth1 to web02 eth0
+type interfaceFixture struct {
+	device, eth0, eth1 int
+	otherDevice, other int
+}
+
+func newInterfaceFixture(t *testing.T) interfaceFixture {
+	t.Helper()
+	devices := []models.Device{
+		{Hostname: "web01", Status: lifecycle.Active, UHeight: 1, Interfaces: []models.DeviceInterface{
+			{IPAddress: "10.0.0.1", Label: "eth0"}, {IPAddress: "10.0.0.2", Label: "eth1"},
+		}},
+		{Hostname: "web02", Status: lifecycle.Active, UHeight: 1, Interfaces: []models.DeviceInterface{
+			{IPAddress: "10.0.1.1", Label: "eth0"},
+		}},
+	}
+	for _, d := range devices {
+		if err := AddDevice(d, "alice"); err != nil {
+			t.Fatalf("AddDevice(%s): %v", d.Hostname, err)
+		}
+	}
+
+	stored, err := GetAllDevices()
+	if err != nil {
+		t.Fatalf("GetAllDevices: %v", err)
+	}
+	var f interfaceFixture
+	for _, d := range stored {
+		switch d.Hostname {
+		case "web01":
+			f.device, f.eth0, f.eth1 = d.ID, d.Interfaces[0].ID, d.Interfaces[1].ID
+		case "web02":
+			f.otherDevice, f.other = d.ID, d.Interfaces[0].ID
+		}
+	}
+	if err := AddCable(models.Cable{AInterfaceID: f.eth1, BInterfaceID: f.other, Type: "Cat6"}); err != nil {
+		t.Fatalf("AddCable: %v", err)
+	}
+	return f
+}
+
+func TestSyncInterfaces(t *testing.T) {
+	const added = -1 // Expected ID of an interface inserted by syncInterfaces
+
+	tests := []struct {
+		name      string
+		submitted func(f interfaceFixture) []models.DeviceInterface
+		want      func(f interfaceFixture) map[string]int // Label to ID of the interfaces stored after
+		cabled    bool                                    // Whether the cable of eth1 is still there
+	}{
+		{
+			name: "unchanged",
+			submitted: func(f interfaceFixture) []models.DeviceInterface {
+				return []models.DeviceInterface{{ID: f.eth0, Label: "eth0"}, {ID: f.eth1, Label: "eth1"}}
+			},
+			want:   func(f interfaceFixture) map[string]int { return map[string]int{"eth0": f.eth0, "eth1": f.eth1} },
+			cabled: true,
+		},
+		{
+			name: "renamed in place",
+			submitted: func(f interfaceFixture) []models.DeviceInterface {
+				return []models.DeviceInterface{{ID: f.eth0, Label: "eth0"}, {ID: f.eth1, Label: "uplink"}}
+			},
+			want:   func(f interfaceFixture) map[string]int { return map[string]int{"eth0": f.eth0, "uplink": f.eth1} },
+			cabled: true,
+		},
+		{
+			name: "removed",
+			submitted: func(f interfaceFixture) []models.DeviceInterface {
+				return []models.DeviceInterface{{ID: f.eth0, Label: "eth0"}}
+			},
+			want: func(f interfaceFixture) map[string]int { return map[string]int{"eth0": f.eth0} },
+		},
+		{
+			name: "added",
+			submitted: func(f interfaceFixture) []models.DeviceInterface {
+				return []models.DeviceInterface{{ID: f.eth0, Label: "eth0"}, {ID: f.eth1, Label: "eth1"}, {Label: "eth2"}}
+			},
+			want: func(f interfaceFixture) map[string]int {
+				return map[string]int{"eth0": f.eth0, "eth1": f.eth1, "eth2": added}
+			},
+			cabled: true,
+		},
+		{
+			name: "ID of another device",
+			submitted: func(f interfaceFixture) []models.DeviceInterface {
+				return []models.DeviceInterface{{ID: f.eth0, Label: "eth0"}, {ID: f.eth1, Label: "eth1"}, {ID: f.other, Label: "eth2"}}
+			},
+			want: func(f interfaceFixture) map[string]int {
+				return map[string]int{"eth0": f.eth0, "eth1": f.eth1, "eth2": added}
+			},
+			cabled: true,
+		},
+		{
+			name: "ID submitted twice",
+			submitted: func(f interfaceFixture) []models.DeviceInterface {
+				return []models.DeviceInterface{{ID: f.eth0, Label: "eth0"}, {ID: f.eth0, Label: "eth1"}}
+			},
+			want: func(f interfaceFixture) map[string]int { return map[string]int{"eth0": f.eth0, "eth1": added} },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestDB(t)
+			f := newInterfaceFixture(t)
+
+			tx, err := DB.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := syncInterfaces(tx, f.device, tt.submitted(f)); err != nil {
+				tx.Rollback()
+				t.Fatalf("syncInterfaces: %v", err)
+			}
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+
+			device, err := GetDevice(f.device)
+			if err != nil {
+				t.Fatalf("GetDevice: %v", err)
+			}
+			want := tt.want(f)
+			if len(device.Interfaces) != len(want) {
+				t.Errorf("got interfaces %+v, want %v", device.Interfaces, want)
+			}
+			for _, iface := range device.Interfaces {
+				id, ok := want[iface.Label]
+				switch {
+				case !ok:
+					t.Errorf("got unexpected interface %s", iface.Label)
+				case id == added && (iface.ID == f.eth0 || iface.ID == f.eth1 || iface.ID == f.other):
+					t.Errorf("interface %s took over ID %d, want a new one", iface.Label, iface.ID)
+				case id != added && iface.ID != id:
+					t.Errorf("interface %s has ID %d, want %d", iface.Label, iface.ID, id)
+				}
+			}
+
+			other, err := GetDevice(f.otherDevice)
+			if err != nil || len(other.Interfaces) != 1 || other.Interfaces[0].ID != f.other {
+				t.Errorf("web02 interfaces = %+v, %v, want its eth0 untouched", other.Interfaces, err)
+			}
+			cables, err := GetAllCables()
+			if err != nil {
+				t.Fatalf("GetAllCables: %v", err)
+			}
+			if (len(cables) == 1) != tt.cabled {
+				t.Errorf("got cables %+v, want cabled %v", cables, tt.cabled)
+			}
+		})
+	}
+}
+
+func TestNormalizeInterfaces(t *testing.T) {
+	openTestDB(t)
+
+	tests := []struct {
+		name    string
+		iface   models.DeviceInterface
+		wantIP  string
+		wantMAC string
+		wantErr bool
+	}{
+		{name: "IPv4", iface: models.DeviceInterface{IPAddress: " 10.0.0.1 "}, wantIP: "10.0.0.1"},
+		{name: "IPv6 upper case", iface: models.DeviceInterface{IPAddress: "2001:DB8::A"}, wantIP: "2001:db8::a"},
+		{name: "IPv6 zeros spelled out", iface: models.DeviceInterface{IPAddress: "2001:0db8:0000:0000:0000:0000:0000:0001"}, wantIP: "2001:db8::1"},
+		{name: "IPv4-mapped IPv6", iface: models.DeviceInterface{IPAddress: "::ffff:10.0.0.1"}, wantIP: "10.0.0.1"},
+		{name: "no address", iface: models.DeviceInterface{Label: "ge-0/0/1"}},
+		{name: "MAC with dashes", iface: models.DeviceInterface{MACAddress: "AA-BB-CC-00-00-01"}, wantMAC: "aa:bb:cc:00:00:01"},
+		{name: "invalid IP", iface: models.DeviceInterface{IPAddress: "10.0.0.256"}, wantErr: true},
+		{name: "IP with prefix length", iface: models.DeviceInterface{IPAddress: "2001:db8::1/64"}, wantErr: true},
+		{name: "invalid MAC", iface: models.DeviceInterface{MACAddress: "aa:bb:cc"}, wantErr: true},
+		{name: "unknown VRF", iface: models.DeviceInterface{IPAddress: "10.0.0.1", VRFID: 9}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := normalizeInterfaces([]models.DeviceInterface{tt.iface})
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: got %+v, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || got[0].IPAddress != tt.wantIP || got[0].MACAddress != tt.wantMAC || got[0].VRFID != GlobalVRFID {
+			t.Errorf("%s: got %+v, %v, want IP %q and MAC %q in the global VRF", tt.name, got, err, tt.wantIP, tt.wantMAC)
+		}
+	}
+}
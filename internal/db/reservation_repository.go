@@ -0,0 +1,189 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"ipam/internal/lifecycle"
+	"ipam/internal/models"
+	"log"
+	"strings"
+	"time"
+)
+
+// ErrNotReserved is returned when extending a device that is not a reservation
+var ErrNotReserved = errors.New("only planned devices can be extended")
+
+// ErrExpiryInPast is returned when a reservation would expire before now
+var ErrExpiryInPast = errors.New("the expiry must be in the future")
+
+// reservationExpiry returns the expiry to store for a device. Only reservations expire.
+func reservationExpiry(d models.Device) *time.Time {
+	if !lifecycle.IsReservation(d.Status) {
+		return nil
+	}
+	return d.ExpiresAt
+}
+
+// ExtendReservation moves the expiry of a reservation to until and records the extension.
+// version is the device version the caller last read, 0 to extend whatever the device is now;
+// ErrStale is returned when the device was changed since. actor is recorded in the audit log.
+func ExtendReservation(deviceID int, until time.Time, version int, actor string) error {
+	if !until.After(time.Now()) {
+		return ErrExpiryInPast
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var hostname, status string
+	err = tx.QueryRow("SELECT hostname, status FROM devices WHERE id = ? AND deleted_at IS NULL", deviceID).Scan(&hostname, &status)
+	if err != nil {
+		return err
+	}
+	if !lifecycle.IsReservation(status) {
+		return ErrNotReserved
+	}
+	if version != 0 {
+		if err := checkVersion(tx, "devices", deviceID, version); err != nil {
+			return err
+		}
+	}
+
+	addresses, err := deviceAddresses(tx, deviceID)
+	if err != nil {
+		return err
+	}
+	before, err := deviceSnapshot(tx, deviceID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if _, err := tx.Exec("UPDATE devices SET expires_at=?, updated_at=?, version=version+1 WHERE id=?", until, now, deviceID); err != nil {
+		return err
+	}
+	if err := auditDevice(tx, deviceID, AuditUpdate, actor, before); err != nil {
+		return err
+	}
+	if err := recordReservationEvent(tx, models.ReservationEvent{DeviceID: deviceID, Hostname: hostname,
+		Addresses: addresses, Action: "Extended", ExpiresAt: until, CreatedAt: now}); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ReleaseExpiredReservations moves every reservation whose expiry has passed to the trash,
+// freeing its addresses, and returns what was released. Each release is recorded as a
+// reservation event.
+func ReleaseExpiredReservations(now time.Time) ([]models.ReservationEvent, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Expiry times are compared in Go, since SQLite stores them as text with a zone offset
+	// Reservations in the trash are left to the trash purge
+	rows, err := tx.Query("SELECT id, hostname, expires_at FROM devices WHERE status = ? AND expires_at IS NOT NULL AND deleted_at IS NULL", lifecycle.Planned)
+	if err != nil {
+		return nil, err
+	}
+	var expired []models.ReservationEvent
+	for rows.Next() {
+		var e models.ReservationEvent
+		if err := rows.Scan(&e.DeviceID, &e.Hostname, &e.ExpiresAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if !e.ExpiresAt.After(now) {
+			expired = append(expired, e)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range expired {
+		e := &expired[i]
+		if e.Addresses, err = deviceAddresses(tx, e.DeviceID); err != nil {
+			return nil, err
+		}
+		e.Action = "Released"
+		e.CreatedAt = now
+		if err := recordReservationEvent(tx, *e); err != nil {
+			return nil, err
+		}
+		if err := trashDevice(tx, e.DeviceID, SystemActor); err != nil {
+			return nil, err
+		}
+	}
+
+	return expired, tx.Commit()
+}
+
+// GetReservationEvents retrieves the most recent reservation events, newest first
+func GetReservationEvents(limit int) ([]models.ReservationEvent, error) {
+	rows, err := DB.Query(`SELECT id, COALESCE(device_id, 0), COALESCE(hostname, ''), COALESCE(addresses, ''), action,
+		expires_at, created_at FROM reservation_events ORDER BY id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.ReservationEvent
+	for rows.Next() {
+		var e models.ReservationEvent
+		if err := rows.Scan(&e.ID, &e.DeviceID, &e.Hostname, &e.Addresses, &e.Action, &e.ExpiresAt, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.ExpiresAt = e.ExpiresAt.Local()
+		e.CreatedAt = e.CreatedAt.Local()
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// StartReservationReaper releases expired reservations now and then every interval, in the background
+func StartReservationReaper(interval time.Duration) {
+	go func() {
+		for {
+			released, err := ReleaseExpiredReservations(time.Now())
+			if err != nil {
+				log.Printf("Error releasing expired reservations: %v", err)
+			}
+			for _, e := range released {
+				log.Printf("Released expired reservation %q (%s), expired %s", e.Hostname, e.Addresses, e.ExpiresAt.Format(time.RFC3339))
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+func recordReservationEvent(tx *sql.Tx, e models.ReservationEvent) error {
+	_, err := tx.Exec("INSERT INTO reservation_events (device_id, hostname, addresses, action, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		e.DeviceID, e.Hostname, e.Addresses, e.Action, e.ExpiresAt, e.CreatedAt)
+	return err
+}
+
+// deviceAddresses lists the interface addresses of a device, comma separated
+func deviceAddresses(tx *sql.Tx, deviceID int) (string, error) {
+	rows, err := tx.Query("SELECT ip_address FROM device_interfaces WHERE device_id = ? AND ip_address != '' ORDER BY id", deviceID)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var addresses []string
+	for rows.Next() {
+		var addr string
+		if err := rows.Scan(&addr); err != nil {
+			return "", err
+		}
+		addresses = append(addresses, addr)
+	}
+	return strings.Join(addresses, ", "), rows.Err()
+}
@@ -0,0 +1,110 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"ipam/internal/lifecycle"
+	"ipam/internal/models"
+	"testing"
+	"time"
+)
+
+func TestExtendReservation(t *testing.T) {
+	openTestDB(t)
+
+	// res01 is a reservation (version 1), web01 is active
+	expires := time.Now().Add(time.Hour)
+	devices := []models.Device{
+		{Hostname: "res01", Status: lifecycle.Planned, UHeight: 1, ExpiresAt: &expires,
+			Interfaces: []models.DeviceInterface{{IPAddress: "10.0.0.1"}}},
+		{Hostname: "web01", Status: lifecycle.Active, UHeight: 1},
+	}
+	for _, d := range devices {
+		if err := AddDevice(d, "alice"); err != nil {
+			t.Fatalf("AddDevice(%s): %v", d.Hostname, err)
+		}
+	}
+
+	later := time.Now().Add(48 * time.Hour)
+	tests := []struct {
+		name    string
+		id      int
+		until   time.Time
+		version int
+		wantErr error
+	}{
+		{name: "in the past", id: 1, until: time.Now().Add(-time.Minute), wantErr: ErrExpiryInPast},
+		{name: "not a reservation", id: 2, until: later, wantErr: ErrNotReserved},
+		{name: "unknown device", id: 9, until: later, wantErr: sql.ErrNoRows},
+		{name: "stale version", id: 1, until: later, version: 7, wantErr: ErrStale},
+		{name: "current version", id: 1, until: later, version: 1},
+		{name: "any version", id: 1, until: later.Add(time.Hour)},
+	}
+	for _, tt := range tests {
+		if err := ExtendReservation(tt.id, tt.until, tt.version, "bob"); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: ExtendReservation = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	d, err := GetDevice(1)
+	if err != nil {
+		t.Fatalf("GetDevice: %v", err)
+	}
+	if d.ExpiresAt == nil || !d.ExpiresAt.Equal(later.Add(time.Hour)) || d.Version != 3 {
+		t.Errorf("res01 expires %v at version %d, want %v at version 3", d.ExpiresAt, d.Version, later.Add(time.Hour))
+	}
+	events, err := GetReservationEvents(10)
+	if err != nil || len(events) != 2 || events[0].Action != "Extended" || events[0].Addresses != "10.0.0.1" {
+		t.Errorf("reservation events = %+v, %v, want two extensions of 10.0.0.1", events, err)
+	}
+}
+
+func TestReleaseExpiredReservations(t *testing.T) {
+	openTestDB(t)
+
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Hour)
+	tests := []struct {
+		name     string
+		device   models.Device
+		released bool
+	}{
+		{name: "expired", device: models.Device{Status: lifecycle.Planned, ExpiresAt: &past}, released: true},
+		{name: "expires now", device: models.Device{Status: lifecycle.Planned, ExpiresAt: &now}, released: true},
+		{name: "expires later", device: models.Device{Status: lifecycle.Planned, ExpiresAt: &future}},
+		{name: "never expires", device: models.Device{Status: lifecycle.Planned}},
+		{name: "active keeps no expiry", device: models.Device{Status: lifecycle.Active, ExpiresAt: &past}},
+	}
+	for i, tt := range tests {
+		d := tt.device
+		d.Hostname, d.UHeight = tt.name, 1
+		d.Interfaces = []models.DeviceInterface{{IPAddress: fmt.Sprintf("10.0.0.%d", i+1)}}
+		if err := AddDevice(d, "alice"); err != nil {
+			t.Fatalf("AddDevice(%s): %v", tt.name, err)
+		}
+	}
+
+	released, err := ReleaseExpiredReservations(now)
+	if err != nil {
+		t.Fatalf("ReleaseExpiredReservations: %v", err)
+	}
+	gone := make(map[string]bool)
+	for _, e := range released {
+		gone[e.Hostname] = true
+		if e.Action != "Released" || e.Addresses == "" {
+			t.Errorf("released %+v, want a Released event with its addresses", e)
+		}
+	}
+	for i, tt := range tests {
+		_, err := GetDevice(i + 1)
+		if gone[tt.name] != tt.released || errors.Is(err, sql.ErrNoRows) != tt.released {
+			t.Errorf("%s: released %v (GetDevice: %v), want %v", tt.name, gone[tt.name], err, tt.released)
+		}
+	}
+
+	// Released reservations are in the trash, so running again releases nothing
+	if again, err := ReleaseExpiredReservations(now); err != nil || len(again) != 0 {
+		t.Errorf("second run released %+v, %v, want nothing", again, err)
+	}
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"database/sql"
+	"ipam/internal/lifecycle"
+	"strings"
+)
+
+// statusColorPrefix prefixes the settings keys of the state colors, e.g. "status_color.Active"
+const statusColorPrefix = "status_color."
+
+// GetSetting returns the value of a setting, "" when it was never set
+func GetSetting(key string) (string, error) {
+	var value string
+	err := DB.QueryRow("SELECT COALESCE(value, '') FROM settings WHERE key = ?", key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return value, err
+}
+
+// SetSetting stores the value of a setting
+func SetSetting(key, value string) error {
+	_, err := DB.Exec("INSERT INTO settings (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value", key, value)
+	return err
+}
+
+// loadStatusColors reads the configured state colors into the lifecycle package
+func loadStatusColors() error {
+	rows, err := DB.Query("SELECT key, value FROM settings WHERE key LIKE ?", statusColorPrefix+"%")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	colors := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return err
+		}
+		colors[strings.TrimPrefix(key, statusColorPrefix)] = value
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	lifecycle.SetColors(colors)
+	return nil
+}
+
+// SaveStatusColors stores the badge color of each state. Colors are expected to be valid
+// (see lifecycle.ValidColor).
+func SaveStatusColors(colors map[string]string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for state, color := range colors {
+		_, err := tx.Exec("INSERT INTO settings (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value",
+			statusColorPrefix+state, color)
+		if err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return loadStatusColors()
+}
@@ -0,0 +1,271 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"ipam/internal/models"
+	"ipam/internal/netutil"
+	"net/netip"
+	"sort"
+	"time"
+)
+
+// ErrSubnetExists is returned when a subnet with the same CIDR is already defined in the VRF
+var ErrSubnetExists = errors.New("a subnet with this CIDR already exists in this VRF")
+
+// subnetSelect JOINs with vlans and vrfs to get their names for display
+const subnetSelect = `
+	SELECT s.id, s.cidr, s.name, s.description, s.gateway, s.status, COALESCE(s.vlan_id, 0),
+		COALESCE(v.vid, 0), COALESCE(v.name, ''), COALESCE(s.vrf_id, 1), COALESCE(f.name, ''), s.created_at
+	FROM subnets s
+	LEFT JOIN vlans v ON s.vlan_id = v.id
+	LEFT JOIN vrfs f ON s.vrf_id = f.id`
+
+func scanSubnet(row interface{ Scan(...interface{}) error }, s *models.Subnet) error {
+	return row.Scan(&s.ID, &s.CIDR, &s.Name, &s.Description, &s.Gateway, &s.Status, &s.VLANID, &s.VLANVID, &s.VLANName,
+		&s.VRFID, &s.VRFName, &s.CreatedAt)
+}
+
+// GetAllSubnets retrieves all subnets ordered by VRF, then network address
+func GetAllSubnets() ([]models.Subnet, error) {
+	rows, err := DB.Query(subnetSelect)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags, err := objectTags(DB, TagSubnet, "")
+	if err != nil {
+		return nil, err
+	}
+	custom, err := customFieldValues(DB, TagSubnet, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var subnets []models.Subnet
+	for rows.Next() {
+		var s models.Subnet
+		if err := scanSubnet(rows, &s); err != nil {
+			return nil, err
+		}
+		s.Tags = tags[s.ID]
+		s.CustomFields = custom[s.ID]
+		subnets = append(subnets, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// SQL string ordering is not numeric ("10.0.10.0" < "10.0.2.0"), so sort in Go
+	sort.SliceStable(subnets, func(i, j int) bool {
+		if subnets[i].VRFID != subnets[j].VRFID {
+			return subnets[i].VRFID < subnets[j].VRFID
+		}
+		pi, erri := netip.ParsePrefix(subnets[i].CIDR)
+		pj, errj := netip.ParsePrefix(subnets[j].CIDR)
+		if erri != nil || errj != nil {
+			return subnets[i].CIDR < subnets[j].CIDR
+		}
+		return netutil.ComparePrefixes(pi, pj) < 0
+	})
+	assignSubnetParents(subnets)
+	return subnets, nil
+}
+
+// assignSubnetParents sets ParentID to the most specific other subnet of the same VRF
+// that contains each subnet
+func assignSubnetParents(subnets []models.Subnet) {
+	prefixes := make([]netip.Prefix, len(subnets))
+	for i, s := range subnets {
+		prefixes[i], _ = netip.ParsePrefix(s.CIDR)
+	}
+
+	for i := range subnets {
+		subnets[i].ParentID = 0
+		bestBits := -1
+		for j := range subnets {
+			if i == j || subnets[i].VRFID != subnets[j].VRFID || !prefixes[i].IsValid() || !prefixes[j].IsValid() {
+				continue
+			}
+			if prefixes[j].Bits() < prefixes[i].Bits() && prefixes[j].Contains(prefixes[i].Addr()) &&
+				prefixes[j].Bits() > bestBits {
+				bestBits = prefixes[j].Bits()
+				subnets[i].ParentID = subnets[j].ID
+			}
+		}
+	}
+}
+
+// GetSubnet retrieves a single subnet by ID
+func GetSubnet(id int) (models.Subnet, error) {
+	var s models.Subnet
+	if err := scanSubnet(DB.QueryRow(subnetSelect+" WHERE s.id = ?", id), &s); err != nil {
+		return s, err
+	}
+
+	tags, err := objectTags(DB, TagSubnet, "o.object_id = ?", id)
+	if err != nil {
+		return s, err
+	}
+	s.Tags = tags[id]
+
+	custom, err := customFieldValues(DB, TagSubnet, "v.object_id = ?", id)
+	s.CustomFields = custom[id]
+	return s, err
+}
+
+// AddSubnet adds a new subnet. The CIDR is stored in canonical form.
+func AddSubnet(s models.Subnet) error {
+	if err := normalizeSubnet(&s); err != nil {
+		return err
+	}
+	if err := checkSubnetUnique(s); err != nil {
+		return err
+	}
+	status := s.Status
+	if status == "" {
+		status = "Active"
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO subnets (cidr, name, description, gateway, status, vlan_id, vrf_id, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		s.CIDR, s.Name, s.Description, s.Gateway, status, s.VLANID, s.VRFID, time.Now())
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if err := setObjectTags(tx, TagSubnet, id, s.Tags); err != nil {
+		return err
+	}
+	if err := setCustomFieldValues(tx, TagSubnet, id, s.CustomFields); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdateSubnet updates an existing subnet
+func UpdateSubnet(s models.Subnet) error {
+	if err := normalizeSubnet(&s); err != nil {
+		return err
+	}
+	if err := checkSubnetUnique(s); err != nil {
+		return err
+	}
+	if err := checkRangesInside(s); err != nil {
+		return err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE subnets SET cidr=?, name=?, description=?, gateway=?, status=?, vlan_id=?, vrf_id=? WHERE id=?",
+		s.CIDR, s.Name, s.Description, s.Gateway, s.Status, s.VLANID, s.VRFID, s.ID)
+	if err != nil {
+		return err
+	}
+	if err := setObjectTags(tx, TagSubnet, int64(s.ID), s.Tags); err != nil {
+		return err
+	}
+	if err := setCustomFieldValues(tx, TagSubnet, int64(s.ID), s.CustomFields); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteSubnet deletes a subnet and its IP ranges. Devices and their addresses are not affected.
+func DeleteSubnet(id int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM ip_ranges WHERE subnet_id=?", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := deleteObjectTags(tx, TagSubnet, id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := deleteCustomFieldValues(tx, TagSubnet, id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM subnets WHERE id=?", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// normalizeSubnet canonicalizes the CIDR and VRF and checks that the gateway lies inside the prefix
+func normalizeSubnet(s *models.Subnet) error {
+	prefix, err := netutil.ParsePrefix(s.CIDR)
+	if err != nil {
+		return err
+	}
+	s.CIDR = prefix.String()
+
+	if s.VRFID, err = normalizeVRFID(DB, s.VRFID); err != nil {
+		return err
+	}
+
+	if s.Gateway != "" {
+		gw, err := netip.ParseAddr(s.Gateway)
+		if err != nil {
+			return fmt.Errorf("invalid gateway address %q", s.Gateway)
+		}
+		if !prefix.Contains(gw) {
+			return fmt.Errorf("gateway %s is not inside %s", gw, prefix)
+		}
+		s.Gateway = gw.String()
+	}
+	return nil
+}
+
+func checkSubnetUnique(s models.Subnet) error {
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM subnets WHERE cidr = ? AND vrf_id = ? AND id != ?", s.CIDR, s.VRFID, s.ID).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrSubnetExists
+	}
+	return nil
+}
+
+// checkRangesInside makes sure a resized subnet still holds all of its IP ranges
+func checkRangesInside(s models.Subnet) error {
+	prefix, err := netutil.ParsePrefix(s.CIDR)
+	if err != nil {
+		return err
+	}
+	ranges, err := GetSubnetRanges(s.ID)
+	if err != nil {
+		return err
+	}
+	for _, r := range ranges {
+		start, err1 := netutil.ParseAddr(r.StartIP)
+		end, err2 := netutil.ParseAddr(r.EndIP)
+		if err1 != nil || err2 != nil || !prefix.Contains(start) || !prefix.Contains(end) {
+			return fmt.Errorf("IP range %s-%s would fall outside %s", r.StartIP, r.EndIP, prefix)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"errors"
+	"ipam/internal/models"
+	"slices"
+	"testing"
+)
+
+func TestAddSubnet(t *testing.T) {
+	openTestDB(t)
+
+	tests := []struct {
+		name        string
+		subnet      models.Subnet
+		wantCIDR    string
+		wantGateway string
+		wantErr     bool
+	}{
+		{name: "plain", subnet: models.Subnet{CIDR: "10.0.2.0/24", Gateway: "10.0.2.1"}, wantCIDR: "10.0.2.0/24", wantGateway: "10.0.2.1"},
+		{name: "host bits are masked", subnet: models.Subnet{CIDR: "10.0.10.77/24"}, wantCIDR: "10.0.10.0/24"},
+		{name: "IPv6 is canonical", subnet: models.Subnet{CIDR: "2001:DB8:0::/64", Gateway: "2001:db8:0:0::1"}, wantCIDR: "2001:db8::/64", wantGateway: "2001:db8::1"},
+		{name: "duplicate", subnet: models.Subnet{CIDR: "10.0.2.0/24"}, wantErr: true},
+		{name: "gateway outside", subnet: models.Subnet{CIDR: "10.0.3.0/24", Gateway: "10.0.4.1"}, wantErr: true},
+		{name: "invalid gateway", subnet: models.Subnet{CIDR: "10.0.3.0/24", Gateway: "10.0.3"}, wantErr: true},
+		{name: "no prefix length", subnet: models.Subnet{CIDR: "10.0.3.0"}, wantErr: true},
+		{name: "unknown VRF", subnet: models.Subnet{CIDR: "10.0.3.0/24", VRFID: 9}, wantErr: true},
+	}
+	for _, tt := range tests {
+		err := AddSubnet(tt.subnet)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: AddSubnet succeeded, want an error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: AddSubnet = %v", tt.name, err)
+			continue
+		}
+		s, err := GetSubnet(lastID(t, "subnets"))
+		if err != nil || s.CIDR != tt.wantCIDR || s.Gateway != tt.wantGateway || s.Status != "Active" || s.VRFID != GlobalVRFID {
+			t.Errorf("%s: stored %+v, %v, want %s with gateway %q", tt.name, s, err, tt.wantCIDR, tt.wantGateway)
+		}
+	}
+	if err := AddSubnet(models.Subnet{CIDR: "10.0.10.0/24"}); !errors.Is(err, ErrSubnetExists) {
+		t.Errorf("AddSubnet of a masked duplicate = %v, want %v", err, ErrSubnetExists)
+	}
+
+	// Subnets are listed in numeric, not string, order
+	subnets, err := GetAllSubnets()
+	if err != nil {
+		t.Fatalf("GetAllSubnets: %v", err)
+	}
+	var got []string
+	for _, s := range subnets {
+		got = append(got, s.CIDR)
+	}
+	want := []string{"10.0.2.0/24", "10.0.10.0/24", "2001:db8::/64"}
+	if !slices.Equal(got, want) {
+		t.Errorf("GetAllSubnets = %v, want %v", got, want)
+	}
+}
+
+func TestAssignSubnetParents(t *testing.T) {
+	subnets := []models.Subnet{
+		{ID: 1, CIDR: "10.0.0.0/16", VRFID: 1},
+		{ID: 2, CIDR: "10.0.0.0/24", VRFID: 1},
+		{ID: 3, CIDR: "10.0.0.0/26", VRFID: 1},
+		{ID: 4, CIDR: "10.0.1.0/24", VRFID: 1},
+		{ID: 5, CIDR: "10.0.0.0/26", VRFID: 2},
+		{ID: 6, CIDR: "10.1.0.0/24", VRFID: 1},
+		{ID: 7, CIDR: "bad", VRFID: 1},
+	}
+	assignSubnetParents(subnets)
+
+	want := map[int]int{1: 0, 2: 1, 3: 2, 4: 1, 5: 0, 6: 0, 7: 0}
+	for _, s := range subnets {
+		if s.ParentID != want[s.ID] {
+			t.Errorf("parent of %s (VRF %d) = %d, want %d", s.CIDR, s.VRFID, s.ParentID, want[s.ID])
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"ipam/internal/models"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Object types that can carry tags
+const (
+	TagDevice    = "device"
+	TagRack      = "rack"
+	TagInterface = "interface"
+	TagSubnet    = "subnet"
+)
+
+// tagColors is the palette new tags pick their color from
+var tagColors = []string{"#0ea5e9", "#22c55e", "#eab308", "#f97316", "#ef4444", "#ec4899", "#a855f7", "#14b8a6"}
+
+var (
+	tagNamePattern  = regexp.MustCompile(`^[a-z0-9][a-z0-9._:/-]*$`)
+	tagColorPattern = regexp.MustCompile(`^#[0-9a-f]{6}$`)
+)
+
+// ErrTagExists is returned when renaming a tag to the name of another tag
+var ErrTagExists = errors.New("a tag with this name already exists")
+
+// NormalizeTagName lowercases a tag name and turns spaces into hyphens, e.g. "Customer X" becomes "customer-x"
+func NormalizeTagName(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(name)), "-")
+}
+
+// CheckTagName reports whether a normalized tag name is acceptable
+func CheckTagName(name string) error {
+	if len(name) > 50 {
+		return fmt.Errorf("tag %q is longer than 50 characters", name)
+	}
+	if !tagNamePattern.MatchString(name) {
+		return fmt.Errorf("tag %q may only contain letters, digits and . _ : / -", name)
+	}
+	return nil
+}
+
+// defaultTagColor picks a palette color from the name, so a tag keeps its color if recreated
+func defaultTagColor(name string) string {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return tagColors[h.Sum32()%uint32(len(tagColors))]
+}
+
+// GetAllTags retrieves all tags ordered by name, with the number of objects carrying each one
+func GetAllTags() ([]models.Tag, error) {
+	rows, err := DB.Query(`SELECT t.id, t.name, t.color, t.created_at, COUNT(o.tag_id)
+		FROM tags t LEFT JOIN object_tags o ON o.tag_id = t.id
+		GROUP BY t.id ORDER BY t.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []models.Tag
+	for rows.Next() {
+		var t models.Tag
+		if err := rows.Scan(&t.ID, &t.Name, &t.Color, &t.CreatedAt, &t.Count); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+// tagHolders selects the IDs of the objects of a type carrying a tag, for snapshotDevices,
+// snapshotRacks and bumpVersions
+const tagHolders = "SELECT object_id FROM object_tags WHERE tag_id = ? AND object_type = ?"
+
+// UpdateTag renames and recolors a tag. actor is recorded in the audit log for each device and
+// rack carrying it.
+func UpdateTag(t models.Tag, actor string) error {
+	t.Name = NormalizeTagName(t.Name)
+	if err := CheckTagName(t.Name); err != nil {
+		return err
+	}
+	t.Color = strings.ToLower(strings.TrimSpace(t.Color))
+	if !tagColorPattern.MatchString(t.Color) {
+		return fmt.Errorf("invalid color %q, expected e.g. #0ea5e9", t.Color)
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM tags WHERE name = ? AND id != ?", t.Name, t.ID).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrTagExists
+	}
+
+	devices, racks, err := snapshotTagHolders(tx, t.ID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE tags SET name=?, color=? WHERE id=?", t.Name, t.Color, t.ID); err != nil {
+		return err
+	}
+	if err := auditTagHolders(tx, devices, racks, actor); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteTag deletes a tag and removes it from everything carrying it. actor is recorded in the
+// audit log for each device and rack that carried it.
+func DeleteTag(id int, actor string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	devices, racks, err := snapshotTagHolders(tx, id)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM object_tags WHERE tag_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM tags WHERE id = ?", id); err != nil {
+		return err
+	}
+	if err := auditTagHolders(tx, devices, racks, actor); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// snapshotTagHolders reads the devices and racks carrying a tag ahead of a change to it, and
+// moves them to their next version
+func snapshotTagHolders(tx *sql.Tx, id int) ([]*models.Device, []*models.Rack, error) {
+	devices, err := snapshotDevices(tx, tagHolders, id, TagDevice)
+	if err != nil {
+		return nil, nil, err
+	}
+	racks, err := snapshotRacks(tx, tagHolders, id, TagRack)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := bumpVersions(tx, "devices", tagHolders, id, TagDevice); err != nil {
+		return nil, nil, err
+	}
+	if err := bumpVersions(tx, "racks", tagHolders, id, TagRack); err != nil {
+		return nil, nil, err
+	}
+	return devices, racks, nil
+}
+
+// auditTagHolders records an update of each device and rack read by snapshotTagHolders
+func auditTagHolders(tx *sql.Tx, devices []*models.Device, racks []*models.Rack, actor string) error {
+	if err := auditDeviceUpdates(tx, devices, actor); err != nil {
+		return err
+	}
+	return auditRackUpdates(tx, racks, actor)
+}
+
+// setObjectTags replaces the tags of an object, creating tags that do not exist yet
+func setObjectTags(tx *sql.Tx, objectType string, objectID int64, tags models.Tags) error {
+	if _, err := tx.Exec("DELETE FROM object_tags WHERE object_type = ? AND object_id = ?", objectType, objectID); err != nil {
+		return err
+	}
+
+	for _, t := range tags {
+		name := NormalizeTagName(t.Name)
+		if name == "" {
+			continue
+		}
+		if err := CheckTagName(name); err != nil {
+			return err
+		}
+
+		var id int64
+		err := tx.QueryRow("SELECT id FROM tags WHERE name = ?", name).Scan(&id)
+		if err == sql.ErrNoRows {
+			result, err := tx.Exec("INSERT INTO tags (name, color, created_at) VALUES (?, ?, ?)", name, defaultTagColor(name), time.Now())
+			if err != nil {
+				return err
+			}
+			if id, err = result.LastInsertId(); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("INSERT OR IGNORE INTO object_tags (tag_id, object_type, object_id) VALUES (?, ?, ?)", id, objectType, objectID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteObjectTags removes the tags of an object that is being deleted
+func deleteObjectTags(tx *sql.Tx, objectType string, objectID int) error {
+	_, err := tx.Exec("DELETE FROM object_tags WHERE object_type = ? AND object_id = ?", objectType, objectID)
+	return err
+}
+
+// objectTags returns the tags of every object of a type, keyed by object ID.
+// extraWhere narrows down the objects, e.g. to the interfaces of one device.
+func objectTags(q queryer, objectType, extraWhere string, args ...interface{}) (map[int]models.Tags, error) {
+	query := `SELECT o.object_id, t.id, t.name, t.color, t.created_at FROM object_tags o
+		JOIN tags t ON t.id = o.tag_id WHERE o.object_type = ?`
+	if extraWhere != "" {
+		query += " AND " + extraWhere
+	}
+	rows, err := q.Query(query+" ORDER BY t.name", append([]interface{}{objectType}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make(map[int]models.Tags)
+	for rows.Next() {
+		var objectID int
+		var t models.Tag
+		if err := rows.Scan(&objectID, &t.ID, &t.Name, &t.Color, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		tags[objectID] = append(tags[objectID], t)
+	}
+	return tags, rows.Err()
+}
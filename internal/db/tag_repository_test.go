@@ -0,0 +1,76 @@
+package db
+
+import (
+	"ipam/internal/lifecycle"
+	"ipam/internal/models"
+	"testing"
+)
+
+func TestTagChangesReachHolders(t *testing.T) {
+	openTestDB(t)
+
+	prod := models.Tags{{Name: "prod"}}
+	if err := AddDevice(models.Device{Hostname: "web01", Status: lifecycle.Active, UHeight: 1, Tags: prod}, "alice"); err != nil {
+		t.Fatalf("AddDevice: %v", err)
+	}
+	if err := AddRack(models.Rack{Name: "R1", Height: 42, Tags: prod}, "alice"); err != nil {
+		t.Fatalf("AddRack: %v", err)
+	}
+	tag := lastID(t, "tags")
+
+	// Each change moves the device and the rack to their next version; only those that change
+	// what they show are audited
+	tests := []struct {
+		name    string
+		change  func() error
+		tags    string
+		entries int // Update entries in the audit log so far
+	}{
+		{
+			name:    "renamed",
+			change:  func() error { return UpdateTag(models.Tag{ID: tag, Name: "production", Color: "#0ea5e9"}, "bob") },
+			tags:    "production",
+			entries: 2,
+		},
+		{
+			name:    "recolored",
+			change:  func() error { return UpdateTag(models.Tag{ID: tag, Name: "production", Color: "#f97316"}, "bob") },
+			tags:    "production",
+			entries: 2,
+		},
+		{
+			name:    "deleted",
+			change:  func() error { return DeleteTag(tag, "bob") },
+			tags:    "",
+			entries: 4,
+		},
+	}
+	for i, tt := range tests {
+		if err := tt.change(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		d, err := GetDevice(1)
+		if err != nil {
+			t.Fatalf("%s: GetDevice: %v", tt.name, err)
+		}
+		r, err := GetRack(1)
+		if err != nil {
+			t.Fatalf("%s: GetRack: %v", tt.name, err)
+		}
+		if d.Tags.String() != tt.tags || r.Tags.String() != tt.tags {
+			t.Errorf("%s: device tags %q, rack tags %q, want %q", tt.name, d.Tags, r.Tags, tt.tags)
+		}
+		if want := i + 2; d.Version != want || r.Version != want {
+			t.Errorf("%s: device version %d, rack version %d, want %d", tt.name, d.Version, r.Version, want)
+		}
+
+		var entries int
+		if err := DB.QueryRow("SELECT COUNT(*) FROM audit_log WHERE action = ?", AuditUpdate).Scan(&entries); err != nil {
+			t.Fatal(err)
+		}
+		if entries != tt.entries {
+			t.Errorf("%s: %d update entries, want %d", tt.name, entries, tt.entries)
+		}
+	}
+}
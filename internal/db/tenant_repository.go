@@ -0,0 +1,202 @@
+package db
+
+import (
+	"errors"
+	"ipam/internal/models"
+	"time"
+)
+
+var (
+	// ErrTenantGroupExists is returned when a tenant group name is already taken (names are case-insensitive)
+	ErrTenantGroupExists = errors.New("a tenant group with this name already exists")
+	// ErrTenantExists is returned when a tenant name is already taken (names are case-insensitive)
+	ErrTenantExists = errors.New("a tenant with this name already exists")
+)
+
+// Tenant groups
+
+// GetAllTenantGroups retrieves all tenant groups ordered by name
+func GetAllTenantGroups() ([]models.TenantGroup, error) {
+	rows, err := DB.Query("SELECT id, name, COALESCE(description, ''), created_at FROM tenant_groups ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []models.TenantGroup
+	for rows.Next() {
+		var g models.TenantGroup
+		if err := rows.Scan(&g.ID, &g.Name, &g.Description, &g.CreatedAt); err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
+
+// GetTenantGroup retrieves a single tenant group by ID
+func GetTenantGroup(id int) (models.TenantGroup, error) {
+	var g models.TenantGroup
+	err := DB.QueryRow("SELECT id, name, COALESCE(description, ''), created_at FROM tenant_groups WHERE id = ?", id).
+		Scan(&g.ID, &g.Name, &g.Description, &g.CreatedAt)
+	return g, err
+}
+
+// AddTenantGroup adds a new tenant group
+func AddTenantGroup(g models.TenantGroup) error {
+	g.Name = normalizeName(g.Name)
+	taken, err := countsAny(DB, "SELECT COUNT(*) FROM tenant_groups WHERE name = ? COLLATE NOCASE", g.Name)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrTenantGroupExists
+	}
+
+	_, err = DB.Exec("INSERT INTO tenant_groups (name, description, created_at) VALUES (?, ?, ?)", g.Name, g.Description, time.Now())
+	return err
+}
+
+// UpdateTenantGroup updates an existing tenant group
+func UpdateTenantGroup(g models.TenantGroup) error {
+	g.Name = normalizeName(g.Name)
+	taken, err := countsAny(DB, "SELECT COUNT(*) FROM tenant_groups WHERE name = ? COLLATE NOCASE AND id != ?", g.Name, g.ID)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrTenantGroupExists
+	}
+
+	_, err = DB.Exec("UPDATE tenant_groups SET name=?, description=? WHERE id=?", g.Name, g.Description, g.ID)
+	return err
+}
+
+// DeleteTenantGroup deletes a tenant group. Its tenants are kept without a group.
+func DeleteTenantGroup(id int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE tenants SET group_id = 0 WHERE group_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM tenant_groups WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Tenants
+
+const tenantSelect = `SELECT t.id, COALESCE(t.group_id, 0), COALESCE(g.name, ''), t.name, COALESCE(t.description, ''), t.created_at
+	FROM tenants t LEFT JOIN tenant_groups g ON g.id = t.group_id`
+
+func scanTenant(row interface{ Scan(...interface{}) error }, t *models.Tenant) error {
+	return row.Scan(&t.ID, &t.GroupID, &t.GroupName, &t.Name, &t.Description, &t.CreatedAt)
+}
+
+// GetAllTenants retrieves all tenants ordered by name
+func GetAllTenants() ([]models.Tenant, error) {
+	rows, err := DB.Query(tenantSelect + " ORDER BY t.name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tenants []models.Tenant
+	for rows.Next() {
+		var t models.Tenant
+		if err := scanTenant(rows, &t); err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, t)
+	}
+	return tenants, rows.Err()
+}
+
+// GetTenant retrieves a single tenant by ID
+func GetTenant(id int) (models.Tenant, error) {
+	var t models.Tenant
+	err := scanTenant(DB.QueryRow(tenantSelect+" WHERE t.id = ?", id), &t)
+	return t, err
+}
+
+// AddTenant adds a new tenant
+func AddTenant(t models.Tenant) error {
+	t.Name = normalizeName(t.Name)
+	taken, err := countsAny(DB, "SELECT COUNT(*) FROM tenants WHERE name = ? COLLATE NOCASE", t.Name)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrTenantExists
+	}
+
+	_, err = DB.Exec("INSERT INTO tenants (name, group_id, description, created_at) VALUES (?, ?, ?, ?)",
+		t.Name, t.GroupID, t.Description, time.Now())
+	return err
+}
+
+// UpdateTenant updates an existing tenant
+func UpdateTenant(t models.Tenant) error {
+	t.Name = normalizeName(t.Name)
+	taken, err := countsAny(DB, "SELECT COUNT(*) FROM tenants WHERE name = ? COLLATE NOCASE AND id != ?", t.Name, t.ID)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrTenantExists
+	}
+
+	_, err = DB.Exec("UPDATE tenants SET name=?, group_id=?, description=? WHERE id=?", t.Name, t.GroupID, t.Description, t.ID)
+	return err
+}
+
+// DeleteTenant deletes a tenant. Its devices, racks and interfaces are kept without a tenant.
+// actor is recorded in the audit log for each device and rack changed.
+func DeleteTenant(id int, actor string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	const deviceIDs = `SELECT id FROM devices WHERE tenant_id = ?
+		UNION SELECT device_id FROM device_interfaces WHERE tenant_id = ?`
+	const rackIDs = "SELECT id FROM racks WHERE tenant_id = ?"
+	devices, err := snapshotDevices(tx, deviceIDs, id, id)
+	if err != nil {
+		return err
+	}
+	racks, err := snapshotRacks(tx, rackIDs, id)
+	if err != nil {
+		return err
+	}
+	if err := bumpVersions(tx, "devices", deviceIDs, id, id); err != nil {
+		return err
+	}
+	if err := bumpVersions(tx, "racks", rackIDs, id); err != nil {
+		return err
+	}
+
+	for _, table := range []string{"devices", "racks", "device_interfaces"} {
+		// table is one of the constants above, never user input
+		if _, err := tx.Exec("UPDATE "+table+" SET tenant_id = 0 WHERE tenant_id = ?", id); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM tenants WHERE id = ?", id); err != nil {
+		return err
+	}
+
+	if err := auditDeviceUpdates(tx, devices, actor); err != nil {
+		return err
+	}
+	if err := auditRackUpdates(tx, racks, actor); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,44 @@
+package db
+
+import (
+	"errors"
+	"ipam/internal/models"
+	"testing"
+)
+
+func TestTenantNames(t *testing.T) {
+	openTestDB(t)
+
+	if err := AddTenantGroup(models.TenantGroup{Name: "Customers"}); err != nil {
+		t.Fatalf("AddTenantGroup: %v", err)
+	}
+	tests := []struct {
+		name    string
+		tenant  models.Tenant
+		wantErr error
+	}{
+		{name: "in a group", tenant: models.Tenant{Name: "Acme  Corp", GroupID: 1}},
+		{name: "without a group", tenant: models.Tenant{Name: "Internal"}},
+		{name: "name in another case", tenant: models.Tenant{Name: "acme corp"}, wantErr: ErrTenantExists},
+	}
+	for _, tt := range tests {
+		if err := AddTenant(tt.tenant); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: AddTenant = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+	if err := UpdateTenant(models.Tenant{ID: 2, Name: "ACME Corp"}); !errors.Is(err, ErrTenantExists) {
+		t.Errorf("UpdateTenant to a taken name = %v, want %v", err, ErrTenantExists)
+	}
+	if err := AddTenantGroup(models.TenantGroup{Name: " customers"}); !errors.Is(err, ErrTenantGroupExists) {
+		t.Errorf("AddTenantGroup of a taken name = %v, want %v", err, ErrTenantGroupExists)
+	}
+
+	// Deleting the group keeps its tenants without a group
+	if err := DeleteTenantGroup(1); err != nil {
+		t.Fatalf("DeleteTenantGroup: %v", err)
+	}
+	tenant, err := GetTenant(1)
+	if err != nil || tenant.Name != "Acme Corp" || tenant.GroupID != 0 {
+		t.Errorf("tenant = %+v, %v, want Acme Corp without a group", tenant, err)
+	}
+}
@@ -0,0 +1,326 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"ipam/internal/models"
+	"log"
+	"strconv"
+	"time"
+)
+
+// DefaultTrashRetentionDays is how long deleted devices and racks are kept until it is changed on the Trash page
+const DefaultTrashRetentionDays = 30
+
+// trashRetentionKey is the settings key of the trash retention, in days
+const trashRetentionKey = "trash_retention_days"
+
+// ErrNotInTrash is returned when restoring or purging an object that is not in the trash
+var ErrNotInTrash = errors.New("this object is not in the trash")
+
+// TrashRetention returns how many days deleted objects are kept before they are purged.
+// 0 keeps them until they are purged by hand.
+func TrashRetention() (int, error) {
+	value, err := GetSetting(trashRetentionKey)
+	if err != nil || value == "" {
+		return DefaultTrashRetentionDays, err
+	}
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 0 {
+		return DefaultTrashRetentionDays, nil
+	}
+	return days, nil
+}
+
+// SetTrashRetention sets how many days deleted objects are kept, 0 to keep them until purged by hand
+func SetTrashRetention(days int) error {
+	if days < 0 {
+		return fmt.Errorf("retention must be 0 or more days")
+	}
+	return SetSetting(trashRetentionKey, strconv.Itoa(days))
+}
+
+// GetDeletedDevices retrieves the devices in the trash, most recently deleted first
+func GetDeletedDevices() ([]models.Device, error) {
+	rows, err := DB.Query(deviceSelect + " WHERE d.deleted_at IS NOT NULL ORDER BY d.deleted_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var devices []models.Device
+	for rows.Next() {
+		var d models.Device
+		if err := scanDevice(rows, &d); err != nil {
+			return nil, err
+		}
+		devices = append(devices, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range devices {
+		if devices[i].Interfaces, err = GetDeviceInterfaces(devices[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return devices, nil
+}
+
+// GetDeletedRacks retrieves the racks in the trash, most recently deleted first
+func GetDeletedRacks() ([]models.Rack, error) {
+	rows, err := DB.Query(rackSelect + " WHERE r.deleted_at IS NOT NULL ORDER BY r.deleted_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var racks []models.Rack
+	for rows.Next() {
+		var r models.Rack
+		if err := scanRack(rows, &r); err != nil {
+			return nil, err
+		}
+		racks = append(racks, r)
+	}
+	return racks, rows.Err()
+}
+
+// RestoreDevice takes a device out of the trash with its interfaces and rack position.
+// It returns a *ConflictError when one of its addresses was given to another device meanwhile,
+// and a *PlacementError when its units were taken. A reservation that expired meanwhile comes
+// back without expiry, so it is not released again right away.
+func RestoreDevice(id int, actor string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := inTrash(tx, "devices", id); err != nil {
+		return err
+	}
+	var d models.Device
+	err = tx.QueryRow(`SELECT id, hostname, rack_id, COALESCE(position, 0), COALESCE(u_height, 1), COALESCE(face, ''), expires_at
+		FROM devices WHERE id = ?`, id).Scan(&d.ID, &d.Hostname, &d.RackID, &d.Position, &d.UHeight, &d.Face, &d.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	if d.Interfaces, err = deviceInterfaces(tx, id); err != nil {
+		return err
+	}
+
+	// Its addresses were free while it was in the trash, so all of them are checked
+	if err := findConflicts(tx, d, nil); err != nil {
+		return err
+	}
+	if err := checkPlacement(tx, &d); err != nil {
+		return err
+	}
+
+	before, err := deviceSnapshot(tx, id)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if d.ExpiresAt != nil && !d.ExpiresAt.After(now) {
+		d.ExpiresAt = nil
+	}
+	_, err = tx.Exec("UPDATE devices SET deleted_at = NULL, deleted_by = NULL, position = ?, face = ?, expires_at = ?, updated_at = ?, version = version + 1 WHERE id = ?",
+		d.Position, d.Face, d.ExpiresAt, now, id)
+	if err != nil {
+		return err
+	}
+	if err := auditDevice(tx, id, AuditRestore, actor, before); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RestoreRack takes a rack out of the trash. Devices that were in it are back in their units.
+func RestoreRack(id int, actor string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := inTrash(tx, "racks", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE racks SET deleted_at = NULL, deleted_by = NULL, version = version + 1 WHERE id = ?", id); err != nil {
+		return err
+	}
+	if err := auditRack(tx, id, AuditRestore, actor, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PurgeDevice deletes a device in the trash for good
+func PurgeDevice(id int, actor string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := inTrash(tx, "devices", id); err != nil {
+		return err
+	}
+	if err := purgeDevice(tx, id, actor); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// purgeDevice deletes a device for good and records it in the audit log
+func purgeDevice(tx *sql.Tx, id int, actor string) error {
+	before, err := deviceSnapshot(tx, id)
+	if err != nil {
+		return err
+	}
+	if err := deleteDevice(tx, id); err != nil {
+		return err
+	}
+	return auditDevice(tx, id, AuditPurge, actor, before)
+}
+
+// PurgeRack deletes a rack in the trash for good. Devices still assigned to it are unracked.
+func PurgeRack(id int, actor string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := inTrash(tx, "racks", id); err != nil {
+		return err
+	}
+	if err := purgeRack(tx, id, actor); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// purgeRack deletes a rack for good and records it in the audit log
+func purgeRack(tx *sql.Tx, id int, actor string) error {
+	before, err := rackSnapshot(tx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := deleteObjectTags(tx, TagRack, id); err != nil {
+		return err
+	}
+	if err := deleteCustomFieldValues(tx, TagRack, id); err != nil {
+		return err
+	}
+	devices, err := snapshotDevices(tx, "SELECT id FROM devices WHERE rack_id = ?", id)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE devices SET rack_id = 0, position = 0, face = '', version = version + 1 WHERE rack_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM racks WHERE id = ?", id); err != nil {
+		return err
+	}
+	if err := auditDeviceUpdates(tx, devices, actor); err != nil {
+		return err
+	}
+	return auditRack(tx, id, AuditPurge, actor, before)
+}
+
+// inTrash makes sure a row of table (devices or racks) is in the trash
+func inTrash(tx *sql.Tx, table string, id int) error {
+	var deleted bool
+	// table is one of the constants of the callers, never user input
+	err := tx.QueryRow("SELECT deleted_at IS NOT NULL FROM "+table+" WHERE id = ?", id).Scan(&deleted)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrNotInTrash
+	}
+	return nil
+}
+
+// PurgeExpiredTrash deletes for good the devices and racks deleted longer than the retention ago.
+// It returns how many objects were purged.
+func PurgeExpiredTrash(now time.Time) (int, error) {
+	days, err := TrashRetention()
+	if err != nil {
+		return 0, err
+	}
+	if days == 0 {
+		return 0, nil
+	}
+	cutoff := now.AddDate(0, 0, -days)
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	purged := 0
+	for _, table := range []string{"devices", "racks"} {
+		// Deletion times are compared in Go, since SQLite stores them as text with a zone offset
+		ids, err := deletedBefore(tx, table, cutoff)
+		if err != nil {
+			return 0, err
+		}
+		for _, id := range ids {
+			if table == "devices" {
+				err = purgeDevice(tx, id, SystemActor)
+			} else {
+				err = purgeRack(tx, id, SystemActor)
+			}
+			if err != nil {
+				return 0, err
+			}
+			purged++
+		}
+	}
+	return purged, tx.Commit()
+}
+
+// deletedBefore lists the rows of table (devices or racks) moved to the trash before cutoff
+func deletedBefore(tx *sql.Tx, table string, cutoff time.Time) ([]int, error) {
+	rows, err := tx.Query("SELECT id, deleted_at FROM " + table + " WHERE deleted_at IS NOT NULL")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		var deletedAt time.Time
+		if err := rows.Scan(&id, &deletedAt); err != nil {
+			return nil, err
+		}
+		if deletedAt.Before(cutoff) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, rows.Err()
+}
+
+// StartTrashPurger purges expired trash now and then every interval, in the background
+func StartTrashPurger(interval time.Duration) {
+	go func() {
+		for {
+			purged, err := PurgeExpiredTrash(time.Now())
+			if err != nil {
+				log.Printf("Error purging the trash: %v", err)
+			} else if purged > 0 {
+				log.Printf("Purged %d expired objects from the trash", purged)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
@@ -0,0 +1,62 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"ipam/internal/lifecycle"
+	"ipam/internal/models"
+	"testing"
+)
+
+func TestRestoreDevice(t *testing.T) {
+	openTestDB(t)
+
+	// web01 and web02 are in the trash; web03 took the address of web02 meanwhile
+	for _, d := range []models.Device{
+		{Hostname: "web01", Status: lifecycle.Active, UHeight: 1, Interfaces: []models.DeviceInterface{{IPAddress: "10.0.0.1"}}},
+		{Hostname: "web02", Status: lifecycle.Active, UHeight: 1, Interfaces: []models.DeviceInterface{{IPAddress: "10.0.0.2"}}},
+	} {
+		if err := AddDevice(d, "alice"); err != nil {
+			t.Fatalf("AddDevice(%s): %v", d.Hostname, err)
+		}
+	}
+	for _, id := range []int{1, 2} {
+		if err := DeleteDevice(id, "alice"); err != nil {
+			t.Fatalf("DeleteDevice(%d): %v", id, err)
+		}
+	}
+	web03 := models.Device{Hostname: "web03", Status: lifecycle.Active, UHeight: 1, Interfaces: []models.DeviceInterface{{IPAddress: "10.0.0.2"}}}
+	if err := AddDevice(web03, "alice"); err != nil {
+		t.Fatalf("AddDevice(web03): %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		id       int
+		conflict bool
+		wantErr  error
+	}{
+		{name: "in the trash", id: 1},
+		{name: "restored already", id: 1, wantErr: ErrNotInTrash},
+		{name: "not deleted", id: 3, wantErr: ErrNotInTrash},
+		{name: "unknown", id: 9, wantErr: sql.ErrNoRows},
+		{name: "address taken meanwhile", id: 2, conflict: true},
+	}
+	for _, tt := range tests {
+		err := RestoreDevice(tt.id, "bob")
+		var conflictErr *ConflictError
+		switch {
+		case tt.conflict:
+			if !errors.As(err, &conflictErr) {
+				t.Errorf("%s: got %v, want a conflict", tt.name, err)
+			}
+		case !errors.Is(err, tt.wantErr):
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	d, err := GetDevice(1)
+	if err != nil || len(d.Interfaces) != 1 || d.Interfaces[0].IPAddress != "10.0.0.1" {
+		t.Errorf("restored web01 = %+v, %v, want it back with 10.0.0.1", d, err)
+	}
+}
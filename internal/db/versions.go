@@ -0,0 +1,76 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"ipam/internal/models"
+)
+
+// ErrStale is returned when a device or rack was saved by someone else since the version a change
+// is based on was read
+var ErrStale = errors.New("it was changed by someone else since it was loaded")
+
+// checkVersion makes sure a row of table (devices or racks) is still at the version a change is
+// based on. No row is at version 0, so a change that does not say what it is based on is stale.
+func checkVersion(tx *sql.Tx, table string, id, version int) error {
+	var current int
+	// table is one of the constants of the callers, never user input
+	if err := tx.QueryRow("SELECT version FROM "+table+" WHERE id = ?", id).Scan(&current); err != nil {
+		return err
+	}
+	if current != version {
+		return ErrStale
+	}
+	return nil
+}
+
+// bumpVersions moves the rows of table (devices or racks) outside the trash whose ID is selected
+// by idQuery to their next version, for changes made to them through other tables
+func bumpVersions(tx *sql.Tx, table, idQuery string, args ...interface{}) error {
+	// table is one of the constants of the callers, never user input
+	_, err := tx.Exec("UPDATE "+table+" SET version = version + 1 WHERE deleted_at IS NULL AND id IN ("+idQuery+")", args...)
+	return err
+}
+
+// DiffDevice compares a device as it is saved with the version a user submitted, for showing both
+// when the submitted one is stale. Before is the saved value of each field, After the submitted one.
+func DiffDevice(saved, submitted models.Device) ([]models.FieldChange, error) {
+	if normalized, err := normalizeInterfaces(submitted.Interfaces); err == nil {
+		submitted.Interfaces = normalized
+	}
+	if err := deviceNames(&submitted); err != nil {
+		return nil, err
+	}
+	return diffFields(deviceFields(saved), deviceFields(submitted)), nil
+}
+
+// DiffRack compares a rack as it is saved with the version a user submitted, like DiffDevice
+func DiffRack(saved, submitted models.Rack) ([]models.FieldChange, error) {
+	err := DB.QueryRow(`SELECT COALESCE((SELECT name FROM sites WHERE id = ?), ''), COALESCE((SELECT name FROM rooms WHERE id = ?), ''),
+		COALESCE((SELECT name FROM tenants WHERE id = ?), '')`, submitted.SiteID, submitted.RoomID, submitted.TenantID).
+		Scan(&submitted.SiteName, &submitted.RoomName, &submitted.TenantName)
+	if err != nil {
+		return nil, err
+	}
+	return diffFields(rackFields(saved), rackFields(submitted)), nil
+}
+
+// deviceNames fills in the names a device form only submits the IDs of: rack, model, tenant and
+// the VRFs of the interfaces
+func deviceNames(d *models.Device) error {
+	err := DB.QueryRow(`SELECT COALESCE((SELECT name FROM racks WHERE id = ?), ''),
+		COALESCE((SELECT m.name FROM device_models m WHERE m.id = ?), ''),
+		COALESCE((SELECT v.name FROM device_models m JOIN manufacturers v ON v.id = m.manufacturer_id WHERE m.id = ?), ''),
+		COALESCE((SELECT name FROM tenants WHERE id = ?), '')`, d.RackID, d.ModelID, d.ModelID, d.TenantID).
+		Scan(&d.RackName, &d.ModelName, &d.ManufacturerName, &d.TenantName)
+	if err != nil {
+		return err
+	}
+	for i := range d.Interfaces {
+		err := DB.QueryRow("SELECT COALESCE((SELECT name FROM vrfs WHERE id = ?), '')", d.Interfaces[i].VRFID).Scan(&d.Interfaces[i].VRFName)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"ipam/internal/models"
+	"strings"
+	"time"
+)
+
+// ErrVLANExists is returned when the VID is already used within the same group
+var ErrVLANExists = errors.New("a VLAN with this VID already exists in this group")
+
+// GetAllVLANs retrieves all VLANs ordered by group then VID
+func GetAllVLANs() ([]models.VLAN, error) {
+	rows, err := DB.Query("SELECT id, vid, name, vlan_group, description, created_at FROM vlans ORDER BY vlan_group, vid")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var vlans []models.VLAN
+	for rows.Next() {
+		var v models.VLAN
+		if err := rows.Scan(&v.ID, &v.VID, &v.Name, &v.Group, &v.Description, &v.CreatedAt); err != nil {
+			return nil, err
+		}
+		vlans = append(vlans, v)
+	}
+	return vlans, rows.Err()
+}
+
+// GetVLAN retrieves a single VLAN by ID
+func GetVLAN(id int) (models.VLAN, error) {
+	var v models.VLAN
+	err := DB.QueryRow("SELECT id, vid, name, vlan_group, description, created_at FROM vlans WHERE id = ?", id).
+		Scan(&v.ID, &v.VID, &v.Name, &v.Group, &v.Description, &v.CreatedAt)
+	return v, err
+}
+
+// AddVLAN adds a new VLAN
+func AddVLAN(v models.VLAN) error {
+	if err := checkVLAN(v); err != nil {
+		return err
+	}
+	_, err := DB.Exec("INSERT INTO vlans (vid, name, vlan_group, description, created_at) VALUES (?, ?, ?, ?, ?)",
+		v.VID, v.Name, strings.TrimSpace(v.Group), v.Description, time.Now())
+	return err
+}
+
+// UpdateVLAN updates an existing VLAN
+func UpdateVLAN(v models.VLAN) error {
+	if err := checkVLAN(v); err != nil {
+		return err
+	}
+	_, err := DB.Exec("UPDATE vlans SET vid=?, name=?, vlan_group=?, description=? WHERE id=?",
+		v.VID, v.Name, strings.TrimSpace(v.Group), v.Description, v.ID)
+	return err
+}
+
+// DeleteVLAN deletes a VLAN and detaches it from subnets and interfaces. actor is recorded in
+// the audit log for each device whose interfaces carried it.
+func DeleteVLAN(id int, actor string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	const deviceIDs = `SELECT device_id FROM device_interfaces WHERE untagged_vlan_id = ?
+		UNION SELECT i.device_id FROM interface_vlans iv JOIN device_interfaces i ON i.id = iv.interface_id WHERE iv.vlan_id = ?`
+	devices, err := snapshotDevices(tx, deviceIDs, id, id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := bumpVersions(tx, "devices", deviceIDs, id, id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	statements := []string{
+		"UPDATE subnets SET vlan_id = 0 WHERE vlan_id = ?",
+		"UPDATE device_interfaces SET untagged_vlan_id = 0 WHERE untagged_vlan_id = ?",
+		"DELETE FROM interface_vlans WHERE vlan_id = ?",
+		"DELETE FROM vlans WHERE id = ?",
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt, id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := auditDeviceUpdates(tx, devices, actor); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func checkVLAN(v models.VLAN) error {
+	if v.VID < 1 || v.VID > 4094 {
+		return fmt.Errorf("VID must be between 1 and 4094, got %d", v.VID)
+	}
+
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM vlans WHERE vid = ? AND vlan_group = ? AND id != ?",
+		v.VID, strings.TrimSpace(v.Group), v.ID).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrVLANExists
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"errors"
+	"ipam/internal/lifecycle"
+	"ipam/internal/models"
+	"slices"
+	"testing"
+)
+
+func TestAddVLAN(t *testing.T) {
+	openTestDB(t)
+
+	tests := []struct {
+		name    string
+		vlan    models.VLAN
+		wantErr bool
+	}{
+		{name: "lowest VID", vlan: models.VLAN{VID: 1, Name: "default"}},
+		{name: "highest VID", vlan: models.VLAN{VID: 4094, Name: "top"}},
+		{name: "VID 0", vlan: models.VLAN{VID: 0}, wantErr: true},
+		{name: "VID 4095", vlan: models.VLAN{VID: 4095}, wantErr: true},
+		{name: "VID taken", vlan: models.VLAN{VID: 1, Name: "again"}, wantErr: true},
+		{name: "VID in another group", vlan: models.VLAN{VID: 1, Name: "lab", Group: "lab"}},
+		{name: "VID taken in that group", vlan: models.VLAN{VID: 1, Group: " lab "}, wantErr: true},
+	}
+	for _, tt := range tests {
+		err := AddVLAN(tt.vlan)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: AddVLAN = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	// A VLAN can keep its own VID when it is edited
+	v, err := GetVLAN(1)
+	if err != nil {
+		t.Fatalf("GetVLAN: %v", err)
+	}
+	v.Name = "renamed"
+	if err := UpdateVLAN(v); err != nil {
+		t.Errorf("UpdateVLAN with the same VID: %v", err)
+	}
+	v.VID = 4094
+	if err := UpdateVLAN(v); !errors.Is(err, ErrVLANExists) {
+		t.Errorf("UpdateVLAN to a taken VID = %v, want %v", err, ErrVLANExists)
+	}
+}
+
+func TestDeleteVLAN(t *testing.T) {
+	openTestDB(t)
+
+	// VLAN 10 is on a subnet, the access port of web01 and the trunk of sw01
+	for _, vid := range []int{10, 20} {
+		if err := AddVLAN(models.VLAN{VID: vid}); err != nil {
+			t.Fatalf("AddVLAN(%d): %v", vid, err)
+		}
+	}
+	if err := AddSubnet(models.Subnet{CIDR: "10.0.0.0/24", VLANID: 1}); err != nil {
+		t.Fatalf("AddSubnet: %v", err)
+	}
+	devices := []models.Device{
+		{Hostname: "web01", Status: lifecycle.Active, UHeight: 1, Interfaces: []models.DeviceInterface{
+			{IPAddress: "10.0.0.1", VLANMode: "access", UntaggedVLANID: 1}}},
+		{Hostname: "sw01", Status: lifecycle.Active, UHeight: 1, Interfaces: []models.DeviceInterface{
+			{Label: "ge-0/0/1", VLANMode: "tagged", UntaggedVLANID: 2, TaggedVLANIDs: []int{1, 2}}}},
+	}
+	for _, d := range devices {
+		if err := AddDevice(d, "alice"); err != nil {
+			t.Fatalf("AddDevice(%s): %v", d.Hostname, err)
+		}
+	}
+
+	if err := DeleteVLAN(1, "bob"); err != nil {
+		t.Fatalf("DeleteVLAN: %v", err)
+	}
+
+	s, err := GetSubnet(1)
+	if err != nil || s.VLANID != 0 {
+		t.Errorf("subnet VLAN = %d, %v, want 0", s.VLANID, err)
+	}
+	tests := []struct {
+		id          int
+		wantUntag   int
+		wantTagged  []int
+		wantVersion int
+	}{
+		{id: 1, wantUntag: 0, wantVersion: 2},
+		{id: 2, wantUntag: 2, wantTagged: []int{2}, wantVersion: 2},
+	}
+	for _, tt := range tests {
+		d, err := GetDevice(tt.id)
+		if err != nil {
+			t.Fatalf("GetDevice(%d): %v", tt.id, err)
+		}
+		iface := d.Interfaces[0]
+		if iface.UntaggedVLANID != tt.wantUntag || !slices.Equal(iface.TaggedVLANIDs, tt.wantTagged) {
+			t.Errorf("%s: untagged %d, tagged %v, want %d, %v", d.Hostname, iface.UntaggedVLANID, iface.TaggedVLANIDs, tt.wantUntag, tt.wantTagged)
+		}
+		if d.Version != tt.wantVersion {
+			t.Errorf("%s: version %d, want %d", d.Hostname, d.Version, tt.wantVersion)
+		}
+	}
+}
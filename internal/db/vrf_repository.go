@@ -0,0 +1,119 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"ipam/internal/models"
+	"strings"
+	"time"
+)
+
+// GlobalVRFID is the default routing domain. Data created before VRFs existed belongs to it.
+const GlobalVRFID = 1
+
+var (
+	// ErrVRFExists is returned when another VRF already uses the name
+	ErrVRFExists = errors.New("a VRF with this name already exists")
+	// ErrVRFInUse is returned when deleting a VRF that still holds subnets or addresses
+	ErrVRFInUse = errors.New("VRF still holds subnets, interface addresses or address records")
+)
+
+// GetAllVRFs retrieves all VRFs, the global VRF first
+func GetAllVRFs() ([]models.VRF, error) {
+	rows, err := DB.Query("SELECT id, name, rd, description, created_at FROM vrfs ORDER BY id != ?, name", GlobalVRFID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var vrfs []models.VRF
+	for rows.Next() {
+		var v models.VRF
+		if err := rows.Scan(&v.ID, &v.Name, &v.RD, &v.Description, &v.CreatedAt); err != nil {
+			return nil, err
+		}
+		vrfs = append(vrfs, v)
+	}
+	return vrfs, rows.Err()
+}
+
+// GetVRF retrieves a single VRF by ID
+func GetVRF(id int) (models.VRF, error) {
+	var v models.VRF
+	err := DB.QueryRow("SELECT id, name, rd, description, created_at FROM vrfs WHERE id = ?", id).
+		Scan(&v.ID, &v.Name, &v.RD, &v.Description, &v.CreatedAt)
+	return v, err
+}
+
+// AddVRF adds a new VRF
+func AddVRF(v models.VRF) error {
+	if err := checkVRF(&v); err != nil {
+		return err
+	}
+	_, err := DB.Exec("INSERT INTO vrfs (name, rd, description, created_at) VALUES (?, ?, ?, ?)",
+		v.Name, v.RD, v.Description, time.Now())
+	return err
+}
+
+// UpdateVRF updates an existing VRF
+func UpdateVRF(v models.VRF) error {
+	if err := checkVRF(&v); err != nil {
+		return err
+	}
+	_, err := DB.Exec("UPDATE vrfs SET name=?, rd=?, description=? WHERE id=?", v.Name, v.RD, v.Description, v.ID)
+	return err
+}
+
+// DeleteVRF deletes an empty VRF. The global VRF cannot be deleted.
+func DeleteVRF(id int) error {
+	if id == GlobalVRFID {
+		return errors.New("the global VRF cannot be deleted")
+	}
+
+	var count int
+	err := DB.QueryRow(`SELECT (SELECT COUNT(*) FROM subnets WHERE vrf_id = ?) +
+		(SELECT COUNT(*) FROM device_interfaces WHERE vrf_id = ?) +
+		(SELECT COUNT(*) FROM ip_addresses WHERE vrf_id = ?)`, id, id, id).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrVRFInUse
+	}
+
+	_, err = DB.Exec("DELETE FROM vrfs WHERE id=?", id)
+	return err
+}
+
+// normalizeVRFID maps the zero value to the global VRF and checks that the VRF exists
+func normalizeVRFID(q queryer, id int) (int, error) {
+	if id == 0 {
+		return GlobalVRFID, nil
+	}
+	var count int
+	if err := q.QueryRow("SELECT COUNT(*) FROM vrfs WHERE id = ?", id).Scan(&count); err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		return 0, fmt.Errorf("VRF %d not found", id)
+	}
+	return id, nil
+}
+
+func checkVRF(v *models.VRF) error {
+	v.Name = strings.TrimSpace(v.Name)
+	v.RD = strings.TrimSpace(v.RD)
+	if v.Name == "" {
+		return errors.New("VRF name is required")
+	}
+
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM vrfs WHERE name = ? COLLATE NOCASE AND id != ?", v.Name, v.ID).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrVRFExists
+	}
+	return nil
+}
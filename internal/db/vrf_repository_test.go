@@ -0,0 +1,116 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"ipam/internal/lifecycle"
+	"ipam/internal/models"
+	"testing"
+)
+
+func TestDeleteVRF(t *testing.T) {
+	openTestDB(t)
+
+	tests := []struct {
+		name    string
+		fill    func(vrfID int) error // Puts something in the VRF before it is deleted
+		wantErr error
+	}{
+		{name: "empty", fill: func(int) error { return nil }},
+		{
+			name: "subnet",
+			fill: func(vrfID int) error {
+				return AddSubnet(models.Subnet{CIDR: "10.0.0.0/24", Status: "Active", VRFID: vrfID})
+			},
+			wantErr: ErrVRFInUse,
+		},
+		{
+			name: "interface address",
+			fill: func(vrfID int) error {
+				return AddDevice(models.Device{Hostname: "web01", Status: lifecycle.Active, UHeight: 1,
+					Interfaces: []models.DeviceInterface{{IPAddress: "10.0.0.5", VRFID: vrfID}}}, "alice")
+			},
+			wantErr: ErrVRFInUse,
+		},
+		{
+			name: "address record",
+			fill: func(vrfID int) error {
+				return AddIPAddress(models.IPAddress{Address: "10.0.0.9", VRFID: vrfID}, "alice")
+			},
+			wantErr: ErrVRFInUse,
+		},
+	}
+	for i, tt := range tests {
+		if err := AddVRF(models.VRF{Name: fmt.Sprintf("lab%d", i)}); err != nil {
+			t.Fatalf("AddVRF: %v", err)
+		}
+		id := lastID(t, "vrfs")
+		if err := tt.fill(id); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if err := DeleteVRF(id); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: DeleteVRF = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	if err := DeleteVRF(GlobalVRFID); err == nil {
+		t.Errorf("DeleteVRF(global) succeeded, want an error")
+	}
+}
+
+func TestVRFScopedConflicts(t *testing.T) {
+	openTestDB(t)
+
+	// web01 and 10.0.0.0/24 are in the global VRF, and address record 10.0.0.2 is in blue
+	if err := AddVRF(models.VRF{Name: "blue"}); err != nil {
+		t.Fatalf("AddVRF: %v", err)
+	}
+	blue := lastID(t, "vrfs")
+	web01 := models.Device{Hostname: "web01", Status: lifecycle.Active, UHeight: 1,
+		Interfaces: []models.DeviceInterface{{IPAddress: "10.0.0.1", MACAddress: "aa:bb:cc:00:00:01"}}}
+	if err := AddDevice(web01, "alice"); err != nil {
+		t.Fatalf("AddDevice: %v", err)
+	}
+	if err := AddSubnet(models.Subnet{CIDR: "10.0.0.0/24"}); err != nil {
+		t.Fatalf("AddSubnet: %v", err)
+	}
+	if err := AddIPAddress(models.IPAddress{Address: "10.0.0.2", VRFID: blue}, "alice"); err != nil {
+		t.Fatalf("AddIPAddress: %v", err)
+	}
+
+	devices := []struct {
+		name     string
+		iface    models.DeviceInterface
+		conflict bool
+	}{
+		{name: "IP in the same VRF", iface: models.DeviceInterface{IPAddress: "10.0.0.1"}, conflict: true},
+		{name: "IP in another VRF", iface: models.DeviceInterface{IPAddress: "10.0.0.1", VRFID: blue}},
+		{name: "MAC in another VRF", iface: models.DeviceInterface{IPAddress: "10.0.0.3", MACAddress: "aa:bb:cc:00:00:01", VRFID: blue}},
+		{name: "record in another VRF", iface: models.DeviceInterface{IPAddress: "10.0.0.2"}},
+		{name: "record in the same VRF", iface: models.DeviceInterface{IPAddress: "10.0.0.2", VRFID: blue}, conflict: true},
+	}
+	for i, tt := range devices {
+		d := models.Device{Hostname: fmt.Sprintf("dev%d", i), Status: lifecycle.Active, UHeight: 1,
+			Interfaces: []models.DeviceInterface{tt.iface}}
+		err := AddDevice(d, "alice")
+		var conflictErr *ConflictError
+		if got := errors.As(err, &conflictErr); got != tt.conflict || (!got && err != nil) {
+			t.Errorf("%s: AddDevice = %v, want conflict %v", tt.name, err, tt.conflict)
+		}
+	}
+
+	subnets := []struct {
+		name    string
+		subnet  models.Subnet
+		wantErr error
+	}{
+		{name: "same CIDR in the same VRF", subnet: models.Subnet{CIDR: "10.0.0.0/24"}, wantErr: ErrSubnetExists},
+		{name: "same CIDR spelled differently", subnet: models.Subnet{CIDR: "10.0.0.7/24"}, wantErr: ErrSubnetExists},
+		{name: "same CIDR in another VRF", subnet: models.Subnet{CIDR: "10.0.0.0/24", VRFID: blue}},
+	}
+	for _, tt := range subnets {
+		if err := AddSubnet(tt.subnet); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: AddSubnet = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
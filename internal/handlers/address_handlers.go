@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"ipam/internal/db"
+	"ipam/internal/lifecycle"
+	"ipam/internal/models"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// InterfaceOption is an interface an address record can be attached to
+type InterfaceOption struct {
+	ID        int
+	VRFID     int
+	Hostname  string
+	Label     string
+	IPAddress string
+}
+
+// AddressFormData is the data rendered by address_form.html
+type AddressFormData struct {
+	Address    models.IPAddress
+	VRFs       []models.VRF
+	Interfaces []InterfaceOption
+	Error      string
+}
+
+func renderAddressForm(w http.ResponseWriter, a models.IPAddress, errMsg string) {
+	data := AddressFormData{Address: a, Error: errMsg}
+	var err error
+	if data.VRFs, err = db.GetAllVRFs(); err != nil {
+		log.Printf("Error fetching VRFs: %v", err)
+		http.Error(w, "Error loading form", http.StatusInternalServerError)
+		return
+	}
+	devices, err := db.GetAllDevices()
+	if err != nil {
+		log.Printf("Error fetching devices: %v", err)
+		http.Error(w, "Error loading form", http.StatusInternalServerError)
+		return
+	}
+	for _, d := range devices {
+		// Retired devices hold no addresses
+		if d.Status == lifecycle.Retired {
+			continue
+		}
+		for _, iface := range d.Interfaces {
+			data.Interfaces = append(data.Interfaces, InterfaceOption{
+				ID:        iface.ID,
+				VRFID:     iface.VRFID,
+				Hostname:  d.Hostname,
+				Label:     iface.Label,
+				IPAddress: iface.IPAddress,
+			})
+		}
+	}
+	if errMsg != "" {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	render(w, "address_form.html", data)
+}
+
+// AddressesHandler lists all standalone IP address records
+func AddressesHandler(w http.ResponseWriter, r *http.Request) {
+	addresses, err := db.GetAllIPAddresses()
+	if err != nil {
+		log.Printf("Error fetching IP addresses: %v", err)
+		http.Error(w, "Could not fetch IP addresses", http.StatusInternalServerError)
+		return
+	}
+
+	render(w, "addresses.html", addresses)
+}
+
+func AddAddressHandler(w http.ResponseWriter, r *http.Request) {
+	vrfID, _ := strconv.Atoi(r.URL.Query().Get("vrf"))
+	renderAddressForm(w, models.IPAddress{
+		Address: r.URL.Query().Get("ip"),
+		VRFID:   vrfID,
+		Status:  "Reserved",
+	}, "")
+}
+
+func CreateAddressHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/add-address", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	a := addressFromForm(r)
+	if err := db.AddIPAddress(a, requestActor(r)); err != nil {
+		log.Printf("Error adding IP address: %v", err)
+		renderAddressForm(w, a, err.Error())
+		return
+	}
+
+	http.Redirect(w, r, "/addresses", http.StatusSeeOther)
+}
+
+func EditAddressHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid address ID", http.StatusBadRequest)
+		return
+	}
+
+	a, err := db.GetIPAddress(id)
+	if err != nil {
+		http.Error(w, "IP address not found", http.StatusNotFound)
+		return
+	}
+
+	renderAddressForm(w, a, "")
+}
+
+func UpdateAddressHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/addresses", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid address ID", http.StatusBadRequest)
+		return
+	}
+
+	a := addressFromForm(r)
+	a.ID = id
+	if err := db.UpdateIPAddress(a, requestActor(r)); err != nil {
+		log.Printf("Error updating IP address: %v", err)
+		renderAddressForm(w, a, err.Error())
+		return
+	}
+
+	http.Redirect(w, r, "/addresses", http.StatusSeeOther)
+}
+
+func DeleteAddressHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/addresses", http.StatusSeeOther)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid address ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.DeleteIPAddress(id); err != nil {
+		log.Printf("Error deleting IP address: %v", err)
+		http.Error(w, "Error deleting IP address", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/addresses", http.StatusSeeOther)
+}
+
+func addressFromForm(r *http.Request) models.IPAddress {
+	vrfID, _ := strconv.Atoi(r.FormValue("vrf_id"))
+	interfaceID, _ := strconv.Atoi(r.FormValue("interface_id"))
+	return models.IPAddress{
+		VRFID:       vrfID,
+		Address:     strings.TrimSpace(r.FormValue("address")),
+		Status:      r.FormValue("status"),
+		DNSName:     strings.TrimSpace(r.FormValue("dns_name")),
+		Owner:       strings.TrimSpace(r.FormValue("owner")),
+		Purpose:     strings.TrimSpace(r.FormValue("purpose")),
+		Description: r.FormValue("description"),
+		InterfaceID: interfaceID,
+	}
+}
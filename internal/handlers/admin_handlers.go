@@ -1,28 +1,84 @@
 package handlers
 
 import (
-	"html/template"
+	"fmt"
 	"io"
 	"ipam/internal/db"
+	"ipam/internal/lifecycle"
+	"ipam/internal/models"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
+// SettingsData is the data rendered by settings.html
+type SettingsData struct {
+	CustomFields []models.CustomField
+	States       []StateSetting
+}
+
+// StateSetting is a device lifecycle state as shown in the settings
+type StateSetting struct {
+	State string
+	Color string
+	Next  []string // States a device may move to from this one
+}
+
+// stateSettings lists the lifecycle states with their color and transitions
+func stateSettings() []StateSetting {
+	var states []StateSetting
+	for _, s := range lifecycle.States {
+		states = append(states, StateSetting{State: s, Color: lifecycle.Color(s), Next: lifecycle.Next(s)[1:]})
+	}
+	return states
+}
+
 // SettingsHandler renders the settings page
 func SettingsHandler(w http.ResponseWriter, r *http.Request) {
-	tmpl, err := template.ParseFiles("templates/layout.html", "templates/settings.html")
+	fields, err := db.GetCustomFields("")
 	if err != nil {
-		log.Printf("Error parsing templates: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		log.Printf("Error fetching custom fields: %v", err)
+		http.Error(w, "Could not fetch custom fields", http.StatusInternalServerError)
 		return
 	}
 
-	err = tmpl.Execute(w, nil)
-	if err != nil {
-		log.Printf("Error executing template: %v", err)
+	render(w, "settings.html", SettingsData{CustomFields: fields, States: stateSettings()})
+}
+
+// UpdateStatusColorsHandler saves the badge colors of the device lifecycle states
+func UpdateStatusColorsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	colors := make(map[string]string)
+	for _, s := range lifecycle.States {
+		color := strings.ToLower(strings.TrimSpace(r.FormValue("color_" + s)))
+		if color == "" {
+			continue
+		}
+		if !lifecycle.ValidColor(color) {
+			http.Error(w, fmt.Sprintf("Invalid color %q for %s", color, s), http.StatusBadRequest)
+			return
+		}
+		colors[s] = color
 	}
+
+	if err := db.SaveStatusColors(colors); err != nil {
+		log.Printf("Error saving status colors: %v", err)
+		http.Error(w, "Error saving colors", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
 }
 
 // BackupDBHandler handles downloading the current database file
@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ipam/internal/db"
+	"ipam/internal/models"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// writeJSON encodes v as the JSON response body with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding JSON response: %v", err)
+	}
+}
+
+// decodeRequest fills dst from a JSON body, or from form/query values for other content types
+func decodeRequest(r *http.Request, dst interface{}, fromForm func()) error {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		return json.NewDecoder(r.Body).Decode(dst)
+	}
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	fromForm()
+	return nil
+}
+
+// versionETag returns the ETag of a device or rack at a version
+func versionETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// ifMatchVersion returns the device or rack version required by the If-Match header of a change,
+// 0 when there is none or it is "*"
+func ifMatchVersion(r *http.Request) (int, error) {
+	value := strings.TrimSpace(r.Header.Get("If-Match"))
+	if value == "" || value == "*" {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(strings.Trim(value, `"`))
+	if err != nil || version < 1 || !strings.HasPrefix(value, `"`) {
+		return 0, fmt.Errorf("If-Match must be the ETag of the object, e.g. %s", versionETag(3))
+	}
+	return version, nil
+}
+
+// setDeviceETag sets the ETag of a device as it is now, after a change
+func setDeviceETag(w http.ResponseWriter, id int) {
+	if d, err := db.GetDevice(id); err == nil {
+		w.Header().Set("ETag", versionETag(d.Version))
+	}
+}
+
+// DeviceAPIHandler returns one device with its interfaces. Its ETag is the device version, which
+// changes that depend on it send back in If-Match.
+//
+//	GET /api/device?id=3
+func DeviceAPIHandler(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Success bool           `json:"success"`
+		Device  *models.Device `json:"device,omitempty"`
+		Error   string         `json:"error,omitempty"`
+	}
+
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, response{Error: "Method not allowed"})
+		return
+	}
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, response{Error: "Invalid device ID"})
+		return
+	}
+
+	device, err := db.GetDevice(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusNotFound, response{Error: "Device not found"})
+		return
+	} else if err != nil {
+		log.Printf("Error fetching device %d: %v", id, err)
+		writeJSON(w, http.StatusInternalServerError, response{Error: "Could not fetch device"})
+		return
+	}
+
+	etag := versionETag(device.Version)
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(w, http.StatusOK, response{Success: true, Device: &device})
+}
+
+// RackAPIHandler returns one rack
+//
+//	GET /api/rack?id=1
+func RackAPIHandler(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Success bool         `json:"success"`
+		Rack    *models.Rack `json:"rack,omitempty"`
+		Error   string       `json:"error,omitempty"`
+	}
+
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, response{Error: "Method not allowed"})
+		return
+	}
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, response{Error: "Invalid rack ID"})
+		return
+	}
+
+	rack, err := db.GetRack(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusNotFound, response{Error: "Rack not found"})
+		return
+	} else if err != nil {
+		log.Printf("Error fetching rack %d: %v", id, err)
+		writeJSON(w, http.StatusInternalServerError, response{Error: "Could not fetch rack"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, response{Success: true, Rack: &rack})
+}
+
+type allocateIPRequest struct {
+	CIDR       string `json:"cidr"`
+	SubnetID   int    `json:"subnet_id"`
+	VRFID      int    `json:"vrf_id"`
+	DeviceID   int    `json:"device_id"`
+	MACAddress string `json:"mac_address"`
+	Label      string `json:"label"`
+}
+
+// AllocateIPHandler assigns the next available address of a prefix to a new interface
+// on a device. The prefix is given either as "cidr" (with an optional "vrf_id", global by
+// default) or as the ID of a defined subnet, whose VRF is then used. With an If-Match header,
+// nothing is allocated unless the device is still at that version (412 otherwise).
+//
+//	POST /api/allocate-ip  {"cidr": "10.0.1.0/24", "device_id": 3, "label": "LAN"}
+func AllocateIPHandler(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Success   bool                    `json:"success"`
+		IP        string                  `json:"ip,omitempty"`
+		Interface *models.DeviceInterface `json:"interface,omitempty"`
+		Error     string                  `json:"error,omitempty"`
+	}
+
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, response{Error: "Method not allowed"})
+		return
+	}
+
+	var req allocateIPRequest
+	err := decodeRequest(r, &req, func() {
+		req.CIDR = r.FormValue("cidr")
+		req.SubnetID, _ = strconv.Atoi(r.FormValue("subnet_id"))
+		req.VRFID, _ = strconv.Atoi(r.FormValue("vrf_id"))
+		req.DeviceID, _ = strconv.Atoi(r.FormValue("device_id"))
+		req.MACAddress = r.FormValue("mac_address")
+		req.Label = r.FormValue("label")
+	})
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, response{Error: "Invalid request body"})
+		return
+	}
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, response{Error: err.Error()})
+		return
+	}
+
+	if req.CIDR == "" && req.SubnetID != 0 {
+		subnet, err := db.GetSubnet(req.SubnetID)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, response{Error: "Subnet not found"})
+			return
+		}
+		req.CIDR = subnet.CIDR
+		req.VRFID = subnet.VRFID
+	}
+	if req.CIDR == "" || req.DeviceID == 0 {
+		writeJSON(w, http.StatusBadRequest, response{Error: "cidr (or subnet_id) and device_id are required"})
+		return
+	}
+
+	iface, err := db.AllocateNextIP(req.CIDR, models.DeviceInterface{
+		DeviceID:   req.DeviceID,
+		VRFID:      req.VRFID,
+		MACAddress: strings.TrimSpace(req.MACAddress),
+		Label:      strings.TrimSpace(req.Label),
+	}, version, requestActor(r))
+	if err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case errors.Is(err, db.ErrNoFreeAddress), errors.Is(err, db.ErrDeviceRetired):
+			status = http.StatusConflict
+		case errors.Is(err, db.ErrStale):
+			status = http.StatusPreconditionFailed
+			setDeviceETag(w, req.DeviceID)
+		}
+		log.Printf("Error allocating IP in %s: %v", req.CIDR, err)
+		writeJSON(w, status, response{Error: err.Error()})
+		return
+	}
+
+	setDeviceETag(w, req.DeviceID)
+	writeJSON(w, http.StatusCreated, response{Success: true, IP: iface.IPAddress, Interface: &iface})
+}
+
+type carveSubnetRequest struct {
+	CIDR         string `json:"cidr"`
+	ParentID     int    `json:"parent_id"`
+	VRFID        int    `json:"vrf_id"`
+	PrefixLength int    `json:"prefix_length"`
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	DryRun       bool   `json:"dry_run"`
+}
+
+// CarveSubnetAPIHandler carves the first free child prefix of the requested length out of a
+// parent prefix, in the parent's VRF (or "vrf_id" when the parent is given as "cidr").
+// With dry_run set, the free candidate blocks are listed and nothing is recorded.
+//
+//	POST /api/carve-subnet  {"cidr": "10.0.0.0/22", "prefix_length": 26, "name": "DMZ"}
+func CarveSubnetAPIHandler(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Success    bool           `json:"success"`
+		Subnet     *models.Subnet `json:"subnet,omitempty"`
+		Candidates []string       `json:"candidates,omitempty"`
+		Error      string         `json:"error,omitempty"`
+	}
+
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, response{Error: "Method not allowed"})
+		return
+	}
+
+	var req carveSubnetRequest
+	err := decodeRequest(r, &req, func() {
+		req.CIDR = r.FormValue("cidr")
+		req.ParentID, _ = strconv.Atoi(r.FormValue("parent_id"))
+		req.VRFID, _ = strconv.Atoi(r.FormValue("vrf_id"))
+		req.PrefixLength, _ = strconv.Atoi(r.FormValue("prefix_length"))
+		req.Name = r.FormValue("name")
+		req.Description = r.FormValue("description")
+		req.DryRun, _ = strconv.ParseBool(r.FormValue("dry_run"))
+	})
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, response{Error: "Invalid request body"})
+		return
+	}
+
+	if req.CIDR == "" && req.ParentID != 0 {
+		parent, err := db.GetSubnet(req.ParentID)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, response{Error: "Parent subnet not found"})
+			return
+		}
+		req.CIDR = parent.CIDR
+		req.VRFID = parent.VRFID
+	}
+	if req.CIDR == "" || req.PrefixLength == 0 {
+		writeJSON(w, http.StatusBadRequest, response{Error: "cidr (or parent_id) and prefix_length are required"})
+		return
+	}
+
+	if req.DryRun {
+		candidates, err := db.AvailableChildPrefixes(req.VRFID, req.CIDR, req.PrefixLength, maxCarveCandidates)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, response{Error: err.Error()})
+			return
+		}
+		resp := response{Success: true, Candidates: []string{}}
+		for _, c := range candidates {
+			resp.Candidates = append(resp.Candidates, c.String())
+		}
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	subnet, err := db.CarveSubnet(req.CIDR, req.PrefixLength, models.Subnet{
+		VRFID:       req.VRFID,
+		Name:        strings.TrimSpace(req.Name),
+		Description: req.Description,
+	})
+	if err != nil {
+		log.Printf("Error carving subnet out of %s: %v", req.CIDR, err)
+		writeJSON(w, http.StatusConflict, response{Error: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, response{Success: true, Subnet: &subnet})
+}
@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"fmt"
+	"ipam/internal/db"
+	"ipam/internal/models"
+	"ipam/internal/netutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// changeLogPageSize is how many entries the change log shows at a time
+const changeLogPageSize = 100
+
+// auditDateLayout is the format of the date filters of the change log
+const auditDateLayout = "2006-01-02"
+
+// asOfLayouts are the accepted formats of ?as_of=, in local time: a datetime-local input, with or
+// without seconds, or a date alone for midnight. RFC 3339 times are accepted too.
+var asOfLayouts = []string{"2006-01-02T15:04", "2006-01-02T15:04:05", "2006-01-02 15:04", auditDateLayout}
+
+// parseAsOf returns the past time in ?as_of=, nil when it is not set or not in the past
+func parseAsOf(r *http.Request) (*time.Time, error) {
+	value := strings.TrimSpace(r.URL.Query().Get("as_of"))
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	for _, layout := range asOfLayouts {
+		if err == nil {
+			break
+		}
+		t, err = time.ParseInLocation(layout, value, time.Local)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid as_of time %q, expected e.g. 2024-01-31T15:04", value)
+	}
+	if !t.Before(time.Now()) {
+		return nil, nil
+	}
+	return &t, nil
+}
+
+// inventory returns the devices and racks, as they are or, when asOf is set, as they were then
+func inventory(asOf *time.Time) ([]models.Device, []models.Rack, error) {
+	if asOf != nil {
+		return db.GetInventoryAsOf(*asOf)
+	}
+	devices, err := db.GetAllDevices()
+	if err != nil {
+		return nil, nil, err
+	}
+	racks, err := db.GetAllRacks()
+	if err != nil {
+		return nil, nil, err
+	}
+	return devices, racks, nil
+}
+
+// HistoryData is the data rendered by history.html
+type HistoryData struct {
+	ObjectType string
+	ObjectID   int
+	Name       string // Latest name of the object
+	EditURL    string // Edit page of the object, "" when it is in the trash or gone
+	Entries    []models.AuditEntry
+}
+
+// HistoryHandler shows the changes of one device or rack (?type=device&id=3)
+func HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	objectType := r.URL.Query().Get("type")
+	if objectType != db.AuditDevice && objectType != db.AuditRack {
+		http.Error(w, "Unknown object type", http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid "+objectType+" ID", http.StatusBadRequest)
+		return
+	}
+
+	data := HistoryData{ObjectType: objectType, ObjectID: id}
+	if data.Entries, err = db.GetAuditLog(db.AuditFilter{ObjectType: objectType, ObjectID: id}); err != nil {
+		log.Printf("Error fetching history: %v", err)
+		http.Error(w, "Could not fetch the history", http.StatusInternalServerError)
+		return
+	}
+
+	// Only objects that are not in the trash can be edited
+	if objectType == db.AuditDevice {
+		if d, err := db.GetDevice(id); err == nil {
+			data.Name, data.EditURL = d.Hostname, fmt.Sprintf("/edit?id=%d", id)
+		}
+	} else if rack, err := db.GetRack(id); err == nil {
+		data.Name, data.EditURL = rack.Name, fmt.Sprintf("/edit-rack?id=%d", id)
+	}
+	if data.Name == "" && len(data.Entries) > 0 {
+		data.Name = data.Entries[0].ObjectName
+	}
+	if data.Name == "" {
+		http.Error(w, strings.ToUpper(objectType[:1])+objectType[1:]+" not found", http.StatusNotFound)
+		return
+	}
+
+	render(w, "history.html", data)
+}
+
+// ChangeLogData is the data rendered by changes.html
+type ChangeLogData struct {
+	Entries []models.AuditEntry
+	Actions []string
+	Actors  []string
+	// Filters as submitted
+	Type, Action, Actor, Query, Since, Until string
+	Error                                    string
+	OlderURL                                 string // Next page of older entries, "" on the last page
+}
+
+// ChangeLogHandler lists every change of devices and racks, newest first. It can be filtered by
+// object type, action, actor, object name (?q=) and date range (?since=2024-01-31&until=).
+func ChangeLogHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	data := ChangeLogData{
+		Actions: db.AuditActions,
+		Type:    q.Get("type"),
+		Action:  q.Get("action"),
+		Actor:   q.Get("actor"),
+		Query:   strings.TrimSpace(q.Get("q")),
+		Since:   q.Get("since"),
+		Until:   q.Get("until"),
+	}
+	filter := db.AuditFilter{ObjectType: data.Type, Action: data.Action, Actor: data.Actor, Query: data.Query,
+		Limit: changeLogPageSize}
+	filter.BeforeID, _ = strconv.Atoi(q.Get("before"))
+
+	var err error
+	if data.Since != "" {
+		if filter.Since, err = time.ParseInLocation(auditDateLayout, data.Since, time.Local); err != nil {
+			data.Error = fmt.Sprintf("invalid date %q", data.Since)
+		}
+	}
+	if data.Until != "" {
+		// The until date is included
+		if until, err := time.ParseInLocation(auditDateLayout, data.Until, time.Local); err != nil {
+			data.Error = fmt.Sprintf("invalid date %q", data.Until)
+		} else {
+			filter.Until = until.AddDate(0, 0, 1)
+		}
+	}
+
+	status := http.StatusOK
+	if data.Error != "" {
+		status = http.StatusBadRequest
+	} else if data.Entries, err = db.GetAuditLog(filter); err != nil {
+		log.Printf("Error fetching the change log: %v", err)
+		http.Error(w, "Could not fetch the change log", http.StatusInternalServerError)
+		return
+	}
+	if data.Actors, err = db.GetAuditActors(); err != nil {
+		log.Printf("Error fetching audit actors: %v", err)
+	}
+
+	if len(data.Entries) == changeLogPageSize {
+		older := url.Values{}
+		for key, value := range q {
+			older[key] = value
+		}
+		older.Set("before", strconv.Itoa(data.Entries[len(data.Entries)-1].ID))
+		data.OlderURL = "/changes?" + older.Encode()
+	}
+
+	w.WriteHeader(status)
+	render(w, "changes.html", data)
+}
+
+// AddressHistoryData is the data rendered by address_history.html
+type AddressHistoryData struct {
+	IP          string
+	Date        string                     // Day asked about, "" for the whole history
+	Holders     []models.AddressAssignment // Who held the address during Date
+	Assignments []models.AddressAssignment // Everyone who ever held the address, oldest first
+	Error       string
+}
+
+// AddressHistoryHandler answers who had an IP address on a day (?ip=10.0.0.5&date=2024-01-31),
+// and lists everyone who held it over time
+func AddressHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	data := AddressHistoryData{
+		IP:   strings.TrimSpace(r.URL.Query().Get("ip")),
+		Date: r.URL.Query().Get("date"),
+	}
+	if data.IP == "" {
+		render(w, "address_history.html", data)
+		return
+	}
+
+	ip, err := netutil.CanonicalAddr(data.IP)
+	if err != nil {
+		data.Error = err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+		render(w, "address_history.html", data)
+		return
+	}
+	data.IP = ip
+	var day time.Time
+	if data.Date != "" {
+		if day, err = time.ParseInLocation(auditDateLayout, data.Date, time.Local); err != nil {
+			data.Error = fmt.Sprintf("invalid date %q", data.Date)
+			w.WriteHeader(http.StatusBadRequest)
+			render(w, "address_history.html", data)
+			return
+		}
+	}
+
+	if data.Assignments, err = db.GetAddressHistory(ip); err != nil {
+		log.Printf("Error fetching the history of %s: %v", ip, err)
+		http.Error(w, "Could not fetch the address history", http.StatusInternalServerError)
+		return
+	}
+	if !day.IsZero() {
+		for _, a := range data.Assignments {
+			if a.Overlaps(day, day.AddDate(0, 0, 1)) {
+				data.Holders = append(data.Holders, a)
+			}
+		}
+	}
+
+	render(w, "address_history.html", data)
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"errors"
+	"ipam/internal/db"
+	"ipam/internal/models"
+	"ipam/internal/validate"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// CablesHandler lists all cables
+func CablesHandler(w http.ResponseWriter, r *http.Request) {
+	cables, err := db.GetAllCables()
+	if err != nil {
+		log.Printf("Error fetching cables: %v", err)
+		http.Error(w, "Could not fetch cables", http.StatusInternalServerError)
+		return
+	}
+
+	render(w, "cables.html", cables)
+}
+
+// CableFormData is the data rendered by cable_form.html
+type CableFormData struct {
+	Cable   models.Cable
+	Devices []models.Device // Devices with their interfaces, to pick the ends from
+	Types   []string
+	Errors  validate.Errors
+	Next    string // Where to go after saving, e.g. back to the device edit page
+}
+
+// Available reports whether an interface can be picked as an end of the cable: it has no cable
+// yet, or the cable is this one
+func (f CableFormData) Available(iface models.DeviceInterface) bool {
+	return iface.Peer == nil || (f.Cable.ID != 0 && iface.Peer.CableID == f.Cable.ID)
+}
+
+func renderCableForm(w http.ResponseWriter, r *http.Request, cable models.Cable, errs validate.Errors) {
+	devices, err := db.GetAllDevices()
+	if err != nil {
+		log.Printf("Error fetching devices: %v", err)
+		http.Error(w, "Error loading form", http.StatusInternalServerError)
+		return
+	}
+	// Devices without interfaces cannot be cabled
+	var cableable []models.Device
+	for _, d := range devices {
+		if len(d.Interfaces) > 0 {
+			cableable = append(cableable, d)
+		}
+	}
+
+	if len(errs) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	render(w, "cable_form.html", CableFormData{
+		Cable:   cable,
+		Devices: cableable,
+		Types:   validate.CableTypes,
+		Errors:  errs,
+		Next:    safeNext(r.FormValue("next")),
+	})
+}
+
+// safeNext keeps a redirect target only when it is a local path, defaulting to the cable list.
+// Browsers read a backslash as a slash, so "/\evil.example" would leave the site like "//evil.example".
+func safeNext(next string) string {
+	u, err := url.Parse(next)
+	if err != nil || u.Scheme != "" || u.Host != "" || !strings.HasPrefix(next, "/") ||
+		strings.HasPrefix(next, "//") || strings.Contains(next, `\`) {
+		return "/cables"
+	}
+	return next
+}
+
+// cableFromForm reads and validates the cable form
+func cableFromForm(r *http.Request) (models.Cable, validate.Errors) {
+	a, _ := strconv.Atoi(r.FormValue("a_interface_id"))
+	b, _ := strconv.Atoi(r.FormValue("b_interface_id"))
+	cable := models.Cable{
+		AInterfaceID: a,
+		BInterfaceID: b,
+		Type:         r.FormValue("type"),
+		Color:        strings.ToLower(strings.TrimSpace(r.FormValue("color"))),
+		Label:        strings.TrimSpace(r.FormValue("label")),
+	}
+	// The color picker always submits a color; "no color" is a separate checkbox
+	if r.FormValue("no_color") != "" {
+		cable.Color = ""
+	}
+
+	var lengthErr error
+	if length := strings.TrimSpace(r.FormValue("length")); length != "" {
+		cable.Length, lengthErr = strconv.ParseFloat(length, 64)
+	}
+	errs := validate.Cable(cable)
+	if lengthErr != nil {
+		errs.Add("length", "length must be a number of meters, e.g. 1.5")
+	}
+	return cable, errs
+}
+
+// saveCableError shows the cable form again when an end is already cabled or gone,
+// reporting whether it did
+func saveCableError(w http.ResponseWriter, r *http.Request, cable models.Cable, err error) bool {
+	if !errors.Is(err, db.ErrInterfaceCabled) && !errors.Is(err, db.ErrInterfaceNotFound) {
+		return false
+	}
+	renderCableForm(w, r, cable, validate.Errors{"interfaces": err.Error()})
+	return true
+}
+
+func AddCableHandler(w http.ResponseWriter, r *http.Request) {
+	a, _ := strconv.Atoi(r.URL.Query().Get("a"))
+	renderCableForm(w, r, models.Cable{AInterfaceID: a, Type: "Cat6"}, nil)
+}
+
+func CreateCableHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/add-cable", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	cable, errs := cableFromForm(r)
+	if len(errs) > 0 {
+		renderCableForm(w, r, cable, errs)
+		return
+	}
+
+	if err := db.AddCable(cable); err != nil {
+		if saveCableError(w, r, cable, err) {
+			return
+		}
+		log.Printf("Error adding cable: %v", err)
+		http.Error(w, "Error adding cable", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, safeNext(r.FormValue("next")), http.StatusSeeOther)
+}
+
+func EditCableHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid cable ID", http.StatusBadRequest)
+		return
+	}
+
+	cable, err := db.GetCable(id)
+	if err != nil {
+		http.Error(w, "Cable not found", http.StatusNotFound)
+		return
+	}
+
+	renderCableForm(w, r, cable, nil)
+}
+
+func UpdateCableHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/cables", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid cable ID", http.StatusBadRequest)
+		return
+	}
+	cable, errs := cableFromForm(r)
+	cable.ID = id
+	if len(errs) > 0 {
+		renderCableForm(w, r, cable, errs)
+		return
+	}
+
+	if err := db.UpdateCable(cable); err != nil {
+		if saveCableError(w, r, cable, err) {
+			return
+		}
+		log.Printf("Error updating cable: %v", err)
+		http.Error(w, "Error updating cable", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, safeNext(r.FormValue("next")), http.StatusSeeOther)
+}
+
+func DeleteCableHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, safeNext(r.FormValue("next")), http.StatusSeeOther)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid cable ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.DeleteCable(id); err != nil {
+		log.Printf("Error deleting cable: %v", err)
+		http.Error(w, "Error deleting cable", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, safeNext(r.FormValue("next")), http.StatusSeeOther)
+}
@@ -0,0 +1,26 @@
+package handlers
+
+import "testing"
+
+func TestSafeNext(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"/device?id=3", "/device?id=3"},
+		{"/racks#r1", "/racks#r1"},
+		{"", "/cables"},
+		{"device?id=3", "/cables"},
+		{"//evil.example", "/cables"},
+		{`/\evil.example`, "/cables"},
+		{`\\evil.example`, "/cables"},
+		{"/device\\..\\x", "/cables"},
+		{"https://evil.example/", "/cables"},
+		{"javascript:alert(1)", "/cables"},
+		{"/\t/evil.example", "/cables"},
+	}
+	for _, tt := range tests {
+		if got := safeNext(tt.in); got != tt.want {
+			t.Errorf("safeNext(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
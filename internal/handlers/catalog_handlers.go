@@ -0,0 +1,415 @@
+package handlers
+
+import (
+	"errors"
+	"ipam/internal/db"
+	"ipam/internal/models"
+	"ipam/internal/validate"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CatalogData is the data rendered by models.html
+type CatalogData struct {
+	Manufacturers []models.Manufacturer
+	Models        []models.DeviceModel
+	Devices       []models.Device
+}
+
+// ModelCount returns the number of models of a manufacturer
+func (d CatalogData) ModelCount(manufacturerID int) int {
+	count := 0
+	for _, m := range d.Models {
+		if m.ManufacturerID == manufacturerID {
+			count++
+		}
+	}
+	return count
+}
+
+// DeviceCount returns the number of devices of a model
+func (d CatalogData) DeviceCount(modelID int) int {
+	count := 0
+	for _, dev := range d.Devices {
+		if dev.ModelID == modelID {
+			count++
+		}
+	}
+	return count
+}
+
+// CatalogHandler lists manufacturers and their models
+func CatalogHandler(w http.ResponseWriter, r *http.Request) {
+	var data CatalogData
+	var err error
+	if data.Manufacturers, err = db.GetAllManufacturers(); err != nil {
+		log.Printf("Error fetching manufacturers: %v", err)
+		http.Error(w, "Could not fetch manufacturers", http.StatusInternalServerError)
+		return
+	}
+	if data.Models, err = db.GetAllDeviceModels(); err != nil {
+		log.Printf("Error fetching device models: %v", err)
+		http.Error(w, "Could not fetch device models", http.StatusInternalServerError)
+		return
+	}
+	if data.Devices, err = db.GetAllDevices(); err != nil {
+		log.Printf("Error fetching devices: %v", err)
+		http.Error(w, "Could not fetch devices", http.StatusInternalServerError)
+		return
+	}
+
+	render(w, "models.html", data)
+}
+
+// catalogStatus maps a catalog save error to its HTTP status
+func catalogStatus(err error) int {
+	if errors.Is(err, db.ErrManufacturerExists) || errors.Is(err, db.ErrDeviceModelExists) || errors.Is(err, db.ErrManufacturerInUse) {
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// Manufacturers
+
+func CreateManufacturerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/models", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	manufacturer := models.Manufacturer{Name: strings.TrimSpace(r.FormValue("name"))}
+	if manufacturer.Name == "" {
+		http.Error(w, "Manufacturer name is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.AddManufacturer(manufacturer); err != nil {
+		log.Printf("Error adding manufacturer: %v", err)
+		http.Error(w, "Error adding manufacturer: "+err.Error(), catalogStatus(err))
+		return
+	}
+
+	http.Redirect(w, r, "/models", http.StatusSeeOther)
+}
+
+func EditManufacturerHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid manufacturer ID", http.StatusBadRequest)
+		return
+	}
+
+	manufacturer, err := db.GetManufacturer(id)
+	if err != nil {
+		http.Error(w, "Manufacturer not found", http.StatusNotFound)
+		return
+	}
+
+	render(w, "manufacturer_form.html", manufacturer)
+}
+
+func UpdateManufacturerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/models", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid manufacturer ID", http.StatusBadRequest)
+		return
+	}
+	manufacturer := models.Manufacturer{ID: id, Name: strings.TrimSpace(r.FormValue("name"))}
+	if manufacturer.Name == "" {
+		http.Error(w, "Manufacturer name is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.UpdateManufacturer(manufacturer); err != nil {
+		log.Printf("Error updating manufacturer: %v", err)
+		http.Error(w, "Error updating manufacturer: "+err.Error(), catalogStatus(err))
+		return
+	}
+
+	http.Redirect(w, r, "/models", http.StatusSeeOther)
+}
+
+func DeleteManufacturerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/models", http.StatusSeeOther)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid manufacturer ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.DeleteManufacturer(id); err != nil {
+		log.Printf("Error deleting manufacturer: %v", err)
+		http.Error(w, "Error deleting manufacturer: "+err.Error(), catalogStatus(err))
+		return
+	}
+
+	http.Redirect(w, r, "/models", http.StatusSeeOther)
+}
+
+// Device models
+
+// DeviceModelFormData is the data rendered by device_model_form.html
+type DeviceModelFormData struct {
+	Model         models.DeviceModel
+	Manufacturers []models.Manufacturer
+	Errors        validate.Errors
+}
+
+func renderDeviceModelForm(w http.ResponseWriter, model models.DeviceModel, errs validate.Errors) {
+	manufacturers, err := db.GetAllManufacturers()
+	if err != nil {
+		log.Printf("Error fetching manufacturers: %v", err)
+		http.Error(w, "Error loading form", http.StatusInternalServerError)
+		return
+	}
+	if len(errs) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	render(w, "device_model_form.html", DeviceModelFormData{Model: model, Manufacturers: manufacturers, Errors: errs})
+}
+
+// deviceModelFromForm reads and validates the device model form
+func deviceModelFromForm(r *http.Request) (models.DeviceModel, validate.Errors, error) {
+	manufacturerID, _ := strconv.Atoi(r.FormValue("manufacturer_id"))
+	uHeight, _ := strconv.Atoi(strings.TrimSpace(r.FormValue("u_height")))
+	model := models.DeviceModel{
+		ManufacturerID: manufacturerID,
+		Name:           strings.TrimSpace(r.FormValue("name")),
+		PartNumber:     strings.TrimSpace(r.FormValue("part_number")),
+		UHeight:        uHeight,
+		Interfaces:     strings.Split(strings.ReplaceAll(r.FormValue("interfaces"), "\r", ""), "\n"),
+	}
+
+	manufacturers, err := db.GetAllManufacturers()
+	if err != nil {
+		return model, nil, err
+	}
+	return model, validate.DeviceModel(model, manufacturers), nil
+}
+
+func AddDeviceModelHandler(w http.ResponseWriter, r *http.Request) {
+	manufacturerID, _ := strconv.Atoi(r.URL.Query().Get("manufacturer_id"))
+	renderDeviceModelForm(w, models.DeviceModel{ManufacturerID: manufacturerID, UHeight: 1}, nil)
+}
+
+func CreateDeviceModelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/add-model", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	model, errs, err := deviceModelFromForm(r)
+	if err != nil {
+		log.Printf("Error reading device model form: %v", err)
+		http.Error(w, "Error loading form", http.StatusInternalServerError)
+		return
+	}
+	if len(errs) > 0 {
+		renderDeviceModelForm(w, model, errs)
+		return
+	}
+
+	if err := db.AddDeviceModel(model); err != nil {
+		if errors.Is(err, db.ErrDeviceModelExists) {
+			renderDeviceModelForm(w, model, validate.Errors{"name": err.Error()})
+			return
+		}
+		log.Printf("Error adding device model: %v", err)
+		http.Error(w, "Error adding device model", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/models", http.StatusSeeOther)
+}
+
+func EditDeviceModelHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid model ID", http.StatusBadRequest)
+		return
+	}
+
+	model, err := db.GetDeviceModel(id)
+	if err != nil {
+		http.Error(w, "Model not found", http.StatusNotFound)
+		return
+	}
+
+	renderDeviceModelForm(w, model, nil)
+}
+
+func UpdateDeviceModelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/models", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid model ID", http.StatusBadRequest)
+		return
+	}
+	model, errs, err := deviceModelFromForm(r)
+	if err != nil {
+		log.Printf("Error reading device model form: %v", err)
+		http.Error(w, "Error loading form", http.StatusInternalServerError)
+		return
+	}
+	model.ID = id
+	if len(errs) > 0 {
+		renderDeviceModelForm(w, model, errs)
+		return
+	}
+
+	if err := db.UpdateDeviceModel(model); err != nil {
+		if errors.Is(err, db.ErrDeviceModelExists) {
+			renderDeviceModelForm(w, model, validate.Errors{"name": err.Error()})
+			return
+		}
+		log.Printf("Error updating device model: %v", err)
+		http.Error(w, "Error updating device model", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/models", http.StatusSeeOther)
+}
+
+func DeleteDeviceModelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/models", http.StatusSeeOther)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid model ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.DeleteDeviceModel(id, requestActor(r)); err != nil {
+		log.Printf("Error deleting device model: %v", err)
+		http.Error(w, "Error deleting device model", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/models", http.StatusSeeOther)
+}
+
+// applyDeviceModel pre-fills a new device from a catalog model: its height, and one interface
+// per label of the model's interface template
+func applyDeviceModel(device *models.Device, model models.DeviceModel) {
+	device.ModelID = model.ID
+	device.UHeight = model.UHeight
+	for _, label := range model.Interfaces {
+		device.Interfaces = append(device.Interfaces, models.DeviceInterface{Label: label})
+	}
+}
+
+// ModelReportRow counts the devices of one model, or of no model
+type ModelReportRow struct {
+	Model   models.DeviceModel
+	Devices int
+	Units   int // Rack units taken by the mounted devices
+	Status  map[string]int
+}
+
+// VendorReport groups the model rows of one manufacturer
+type VendorReport struct {
+	Manufacturer string // "" for devices without a model
+	Rows         []ModelReportRow
+	Devices      int
+}
+
+// ModelReportData is the data rendered by model_report.html
+type ModelReportData struct {
+	Vendors  []VendorReport
+	Statuses []string // Device statuses, one column each
+}
+
+// modelReport counts devices per manufacturer and model. Models without devices are listed too,
+// and devices without a model are grouped last.
+func modelReport(deviceModels []models.DeviceModel, devices []models.Device) []VendorReport {
+	rows := make(map[int]*ModelReportRow)
+	for _, m := range deviceModels {
+		rows[m.ID] = &ModelReportRow{Model: m, Status: make(map[string]int)}
+	}
+	unknown := &ModelReportRow{Status: make(map[string]int)}
+	for _, d := range devices {
+		row, ok := rows[d.ModelID]
+		if !ok {
+			row = unknown
+		}
+		row.Devices++
+		row.Status[d.Status]++
+		if d.Position > 0 {
+			row.Units += d.UHeight
+		}
+	}
+
+	var vendors []VendorReport
+	byName := make(map[string]int)
+	for _, m := range deviceModels {
+		i, ok := byName[m.ManufacturerName]
+		if !ok {
+			i = len(vendors)
+			byName[m.ManufacturerName] = i
+			vendors = append(vendors, VendorReport{Manufacturer: m.ManufacturerName})
+		}
+		vendors[i].Rows = append(vendors[i].Rows, *rows[m.ID])
+		vendors[i].Devices += rows[m.ID].Devices
+	}
+	// Most used models first within each manufacturer
+	for _, v := range vendors {
+		sort.SliceStable(v.Rows, func(a, b int) bool { return v.Rows[a].Devices > v.Rows[b].Devices })
+	}
+	if unknown.Devices > 0 {
+		vendors = append(vendors, VendorReport{Rows: []ModelReportRow{*unknown}, Devices: unknown.Devices})
+	}
+	return vendors
+}
+
+// ModelReportHandler shows how many devices of each manufacturer and model there are
+func ModelReportHandler(w http.ResponseWriter, r *http.Request) {
+	deviceModels, err := db.GetAllDeviceModels()
+	if err != nil {
+		log.Printf("Error fetching device models: %v", err)
+		http.Error(w, "Could not fetch device models", http.StatusInternalServerError)
+		return
+	}
+	devices, err := db.GetAllDevices()
+	if err != nil {
+		log.Printf("Error fetching devices: %v", err)
+		http.Error(w, "Could not fetch devices", http.StatusInternalServerError)
+		return
+	}
+
+	render(w, "model_report.html", ModelReportData{Vendors: modelReport(deviceModels, devices), Statuses: validate.DeviceStatuses})
+}
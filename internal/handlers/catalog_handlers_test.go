@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"fmt"
+	"ipam/internal/db"
+	"ipam/internal/lifecycle"
+	"ipam/internal/models"
+	"ipam/internal/validate"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestApplyDeviceModel(t *testing.T) {
+	db.InitDB(filepath.Join(t.TempDir(), "ipam.db"))
+	t.Cleanup(func() { db.CloseDB() })
+
+	if err := db.AddManufacturer(models.Manufacturer{Name: "Acme"}); err != nil {
+		t.Fatalf("AddManufacturer: %v", err)
+	}
+	vendors, err := db.GetAllManufacturers()
+	if err != nil || len(vendors) != 1 {
+		t.Fatalf("GetAllManufacturers = %v, %v, want one manufacturer", vendors, err)
+	}
+
+	var ports []string
+	for i := 1; i <= 48; i++ {
+		ports = append(ports, fmt.Sprintf("ge-0/0/%d", i))
+	}
+	tests := []struct {
+		model      string
+		interfaces []string
+	}{
+		{"S1", []string{"eth0", "ipmi"}},
+		{"SW48", ports},
+		{"Blank", nil},
+	}
+	for _, tt := range tests {
+		err := db.AddDeviceModel(models.DeviceModel{ManufacturerID: vendors[0].ID, Name: tt.model, UHeight: 1, Interfaces: tt.interfaces})
+		if err != nil {
+			t.Fatalf("AddDeviceModel(%s): %v", tt.model, err)
+		}
+	}
+	catalog, err := db.GetAllDeviceModels()
+	if err != nil {
+		t.Fatalf("GetAllDeviceModels: %v", err)
+	}
+
+	for _, tt := range tests {
+		i := slices.IndexFunc(catalog, func(m models.DeviceModel) bool { return m.Name == tt.model })
+		if i < 0 {
+			t.Fatalf("model %s not in the catalog", tt.model)
+		}
+		device := models.Device{Hostname: "host-" + tt.model, Status: lifecycle.Active}
+		applyDeviceModel(&device, catalog[i])
+
+		if errs := validate.Device(device, nil); len(errs) > 0 {
+			t.Errorf("%s: validate.Device = %v, want no errors", tt.model, errs)
+			continue
+		}
+		if err := db.AddDevice(device, "alice"); err != nil {
+			t.Errorf("%s: AddDevice: %v", tt.model, err)
+			continue
+		}
+
+		devices, err := db.GetAllDevices()
+		if err != nil {
+			t.Fatalf("GetAllDevices: %v", err)
+		}
+		j := slices.IndexFunc(devices, func(d models.Device) bool { return d.Hostname == device.Hostname })
+		if j < 0 {
+			t.Errorf("%s: device %s was not saved", tt.model, device.Hostname)
+			continue
+		}
+		var labels []string
+		for _, iface := range devices[j].Interfaces {
+			labels = append(labels, iface.Label)
+			if iface.IPAddress != "" {
+				t.Errorf("%s: interface %s has address %q, want none", tt.model, iface.Label, iface.IPAddress)
+			}
+		}
+		if !slices.Equal(labels, tt.interfaces) || devices[j].ModelID != catalog[i].ID {
+			t.Errorf("%s: saved model %d with interfaces %v, want model %d with %v", tt.model, devices[j].ModelID, labels, catalog[i].ID, tt.interfaces)
+		}
+	}
+}
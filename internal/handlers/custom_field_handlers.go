@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"errors"
+	"ipam/internal/db"
+	"ipam/internal/models"
+	"ipam/internal/validate"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CustomFieldInput is a custom field rendered in an object form, with its current value
+type CustomFieldInput struct {
+	Field models.CustomField
+	Value string
+	Error string
+}
+
+// customFieldInputs pairs the custom fields of an object type with the object's values and their problems
+func customFieldInputs(fields []models.CustomField, values map[string]string, errs validate.Errors) []CustomFieldInput {
+	inputs := make([]CustomFieldInput, len(fields))
+	for i, f := range fields {
+		inputs[i] = CustomFieldInput{Field: f, Value: values[f.Name], Error: errs[f.FormName()]}
+	}
+	return inputs
+}
+
+// customFieldsFromForm reads and validates the custom fields of an object type from a form
+func customFieldsFromForm(r *http.Request, objectType string) (map[string]string, validate.Errors, error) {
+	fields, err := db.GetCustomFields(objectType)
+	if err != nil {
+		return nil, nil, err
+	}
+	submitted := make(map[string]string)
+	for _, f := range fields {
+		submitted[f.Name] = r.FormValue(f.FormName())
+	}
+	values, errs := validate.CustomFields(fields, submitted)
+	return values, errs, nil
+}
+
+// CustomFieldFormData is the data rendered by custom_field_form.html
+type CustomFieldFormData struct {
+	Field       models.CustomField
+	ObjectTypes []string
+	FieldTypes  []string
+}
+
+// OptionsText formats the options of a select field one per line, as edited in the form
+func (d CustomFieldFormData) OptionsText() string {
+	return strings.Join(d.Field.Options, "\n")
+}
+
+func renderCustomFieldForm(w http.ResponseWriter, field models.CustomField) {
+	render(w, "custom_field_form.html", CustomFieldFormData{
+		Field:       field,
+		ObjectTypes: db.CustomFieldObjectTypes,
+		FieldTypes:  db.CustomFieldTypes,
+	})
+}
+
+func AddCustomFieldHandler(w http.ResponseWriter, r *http.Request) {
+	objectType := r.URL.Query().Get("object_type")
+	if objectType == "" {
+		objectType = db.TagDevice
+	}
+	renderCustomFieldForm(w, models.CustomField{ObjectType: objectType, Type: "text"})
+}
+
+func CreateCustomFieldHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/add-custom-field", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.AddCustomField(customFieldFromForm(r)); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, db.ErrCustomFieldExists) {
+			status = http.StatusConflict
+		}
+		log.Printf("Error adding custom field: %v", err)
+		http.Error(w, "Error adding custom field: "+err.Error(), status)
+		return
+	}
+
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+func EditCustomFieldHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid custom field ID", http.StatusBadRequest)
+		return
+	}
+
+	field, err := db.GetCustomField(id)
+	if err != nil {
+		http.Error(w, "Custom field not found", http.StatusNotFound)
+		return
+	}
+
+	renderCustomFieldForm(w, field)
+}
+
+func UpdateCustomFieldHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid custom field ID", http.StatusBadRequest)
+		return
+	}
+
+	field := customFieldFromForm(r)
+	field.ID = id
+	if err := db.UpdateCustomField(field); err != nil {
+		log.Printf("Error updating custom field: %v", err)
+		http.Error(w, "Error updating custom field: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+func DeleteCustomFieldHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid custom field ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.DeleteCustomField(id, requestActor(r)); err != nil {
+		log.Printf("Error deleting custom field: %v", err)
+		http.Error(w, "Error deleting custom field", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+func customFieldFromForm(r *http.Request) models.CustomField {
+	position, _ := strconv.Atoi(r.FormValue("position"))
+	return models.CustomField{
+		ObjectType: r.FormValue("object_type"),
+		Name:       strings.TrimSpace(r.FormValue("name")),
+		Label:      strings.TrimSpace(r.FormValue("label")),
+		Type:       r.FormValue("field_type"),
+		Options:    strings.Split(r.FormValue("options"), "\n"),
+		Required:   r.FormValue("required") != "",
+		Position:   position,
+	}
+}
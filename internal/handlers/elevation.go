@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"ipam/internal/db"
+	"ipam/internal/lifecycle"
+	"ipam/internal/models"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Elevation drawing sizes, in pixels
+const (
+	elevationUnitHeight = 22
+	elevationLabelWidth = 34
+	elevationRackWidth  = 240
+	elevationPadding    = 8
+)
+
+// mountedOn returns the devices mounted on one face of a rack
+func mountedOn(devices []models.Device, rackID int, face string) []models.Device {
+	var mounted []models.Device
+	for _, d := range devices {
+		if d.RackID == rackID && d.Position > 0 && d.Face == face {
+			mounted = append(mounted, d)
+		}
+	}
+	return mounted
+}
+
+// freeUnits counts the units of a rack face not taken by any device
+func freeUnits(rack models.Rack, mounted []models.Device) int {
+	used := make(map[int]bool)
+	for _, d := range mounted {
+		for u := d.Position; u <= d.TopUnit() && u <= rack.Height; u++ {
+			used[u] = true
+		}
+	}
+	return rack.Height - len(used)
+}
+
+// rackElevation draws one face of a rack as SVG, U1 at the bottom. Devices link to their edit
+// page; free units are drawn as empty slots.
+func rackElevation(rack models.Rack, mounted []models.Device, face string) template.HTML {
+	width := elevationLabelWidth + elevationRackWidth + 2*elevationPadding
+	height := rack.Height*elevationUnitHeight + 2*elevationPadding
+	// y returns the top edge of a unit
+	y := func(u int) int { return elevationPadding + (rack.Height-u)*elevationUnitHeight }
+	x := elevationPadding + elevationLabelWidth
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="Inter, sans-serif" font-size="11" role="img" aria-label="%s">`,
+		width, height, width, height, template.HTMLEscapeString(rack.Name+" "+face))
+	fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" rx="3" fill="#0f172a" stroke="#475569"/>`,
+		x-2, elevationPadding-2, elevationRackWidth+4, rack.Height*elevationUnitHeight+4)
+
+	used := make(map[int]bool)
+	for _, d := range mounted {
+		for u := d.Position; u <= d.TopUnit(); u++ {
+			used[u] = true
+		}
+	}
+
+	for u := 1; u <= rack.Height; u++ {
+		fmt.Fprintf(&b, `<text x="%d" y="%d" fill="#64748b" text-anchor="end" dominant-baseline="middle">%d</text>`,
+			x-6, y(u)+elevationUnitHeight/2, u)
+		if !used[u] {
+			fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" fill="none" stroke="#1e293b"><title>U%d free</title></rect>`,
+				x+1, y(u)+1, elevationRackWidth-2, elevationUnitHeight-2, u)
+		}
+	}
+
+	for _, d := range mounted {
+		top := min(d.TopUnit(), rack.Height)
+		if d.Position > top {
+			continue
+		}
+		// Devices are drawn in the color of their state, like the status badges
+		color := lifecycle.Color(d.Status)
+		h := (top-d.Position+1)*elevationUnitHeight - 2
+		fmt.Fprintf(&b, `<a href="/edit?id=%d"><title>%s</title>`, d.ID,
+			template.HTMLEscapeString(fmt.Sprintf("%s (%s, %s)", d.Hostname, d.Units(), d.Status)))
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" rx="2" fill="%s" fill-opacity="0.25" stroke="%s"/>`,
+			x+1, y(top)+1, elevationRackWidth-2, h, color, color)
+		fmt.Fprintf(&b, `<text x="%d" y="%d" fill="%s" text-anchor="middle" dominant-baseline="middle" font-weight="500">%s</text></a>`,
+			x+elevationRackWidth/2, y(top)+1+h/2, color, template.HTMLEscapeString(d.Hostname))
+	}
+
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// RackData is the data rendered by rack.html
+type RackData struct {
+	Rack      models.Rack
+	Front     template.HTML
+	Rear      template.HTML
+	FrontFree int
+	RearFree  int
+	Unmounted []models.Device // Devices in the rack without a position
+}
+
+// rackAndDevices loads a rack and every device, from the ?id= of the request
+func rackAndDevices(w http.ResponseWriter, r *http.Request) (models.Rack, []models.Device, bool) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid rack ID", http.StatusBadRequest)
+		return models.Rack{}, nil, false
+	}
+	rack, err := db.GetRack(id)
+	if err != nil {
+		http.Error(w, "Rack not found", http.StatusNotFound)
+		return rack, nil, false
+	}
+	devices, err := db.GetAllDevices()
+	if err != nil {
+		log.Printf("Error fetching devices: %v", err)
+		http.Error(w, "Could not fetch devices", http.StatusInternalServerError)
+		return rack, nil, false
+	}
+	return rack, devices, true
+}
+
+// RackHandler shows the front and rear elevation of a rack
+func RackHandler(w http.ResponseWriter, r *http.Request) {
+	rack, devices, ok := rackAndDevices(w, r)
+	if !ok {
+		return
+	}
+
+	front := mountedOn(devices, rack.ID, "front")
+	rear := mountedOn(devices, rack.ID, "rear")
+	data := RackData{
+		Rack:      rack,
+		Front:     rackElevation(rack, front, "front"),
+		Rear:      rackElevation(rack, rear, "rear"),
+		FrontFree: freeUnits(rack, front),
+		RearFree:  freeUnits(rack, rear),
+	}
+	for _, d := range devices {
+		if d.RackID == rack.ID && d.Position == 0 {
+			data.Unmounted = append(data.Unmounted, d)
+		}
+	}
+
+	render(w, "rack.html", data)
+}
+
+// RackElevationHandler serves one face of a rack elevation as an SVG image.
+//
+//	GET /rack-elevation.svg?id=3&face=rear
+func RackElevationHandler(w http.ResponseWriter, r *http.Request) {
+	rack, devices, ok := rackAndDevices(w, r)
+	if !ok {
+		return
+	}
+	face := r.URL.Query().Get("face")
+	if face != "rear" {
+		face = "front"
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Write([]byte(rackElevation(rack, mountedOn(devices, rack.ID, face), face)))
+}
@@ -1,18 +1,24 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"ipam/internal/db"
+	"ipam/internal/lifecycle"
 	"ipam/internal/models"
+	"ipam/internal/netutil"
+	"ipam/internal/validate"
 	"log"
 	"net"
 	"net/http"
-	"os"
+	"net/netip"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -41,12 +47,66 @@ func render(w http.ResponseWriter, view string, data interface{}) {
 	}
 }
 
+// trustedProxies are the addresses of the authenticating reverse proxies whose user headers
+// are believed, see SetTrustedProxies
+var trustedProxies []netip.Prefix
+
+// SetTrustedProxies sets the reverse proxies trusted to name the user of a request, as a comma
+// separated list of addresses and CIDRs, e.g. "10.0.0.5, 172.16.0.0/12". Empty trusts none.
+func SetTrustedProxies(spec string) error {
+	var proxies []netip.Prefix
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			addr, err := netutil.ParseAddr(item)
+			if err != nil {
+				return err
+			}
+			proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netutil.ParsePrefix(item)
+		if err != nil {
+			return err
+		}
+		proxies = append(proxies, prefix)
+	}
+	trustedProxies = proxies
+	return nil
+}
+
+// requestActor names who made a request: the user set by an authenticating reverse proxy
+// (X-Forwarded-User or Remote-User), otherwise the client address. The headers are only
+// believed from a trusted proxy, since any client can send them.
+func requestActor(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if addr, err := netutil.ParseAddr(host); err == nil &&
+		slices.ContainsFunc(trustedProxies, func(p netip.Prefix) bool { return p.Contains(addr) }) {
+		for _, header := range []string{"X-Forwarded-User", "Remote-User"} {
+			if user := strings.TrimSpace(r.Header.Get(header)); user != "" {
+				return user
+			}
+		}
+	}
+	return host
+}
+
 type IPStatus struct {
-	IP       string
-	Octet    int
-	Status   string // "Free", "Used", "Reserved"
-	DeviceID int
-	Hostname string
+	IP     string
+	Label  string // Short host label shown in the map (e.g. last octet)
+	Status string // "Free", "Used", "Reserved", "Gateway"
+	Range  string // Type of the IP range holding the address ("DHCP", "Static", "Infrastructure"), if any
+	// Standalone address record holding the address (Status "Address")
+	AddressID     int
+	AddressStatus string
+	DeviceID      int
+	Hostname      string
 }
 
 type RackGroup struct {
@@ -59,64 +119,124 @@ type DashboardData struct {
 	UnassignedDevices []models.Device
 	Devices           []models.Device // Keep for global stats/logic if needed, or remove if unused in template (IPMap uses it)
 	Racks             []models.Rack
-	Subnet            string
+	Subnet            models.Subnet
+	SubnetAncestors   []models.Subnet // Enclosing prefixes of Subnet, outermost first
+	Subnets           []models.Subnet // Subnets of the selected VRF, for the map selector
+	VRFs              []models.VRF
+	VRF               int                       // Selected VRF ID, 0 for all VRFs
+	Query             string                    // Search text
+	Tag               string                    // Selected tag, "" for everything
+	Tags              []models.Tag              // All tags, for the tag filter
+	Site              int                       // Selected site ID, 0 for all sites
+	Sites             []models.Site             // All sites, for the site filter
+	Expiring          []ExpiringReservation     // Reservations expiring soon
+	ReservationEvents []models.ReservationEvent // Latest extensions and releases
 	IPMap             []IPStatus
+	IPMapSparse       bool // Only assigned and a few free addresses are listed (large subnets)
 	TotalIPs          int
 	UsedIPs           int
+	ReservedIPs       int
 	FreeIPs           int
+	FreeLabel         string
+	LargeSubnet       bool // Address counts are too big to be exact (e.g. IPv6 /64)
 	UsagePercent      int
 	Sort              string
 	Order             string
+	AsOf              *time.Time // Past time the inventory is shown as of, nil for now
+	AsOfParam         string     // ?as_of= as submitted, kept in links
+	HistoryStart      time.Time  // Earliest change recorded, when showing the past
 }
 
-// IP comparison helper
+// IP comparison helper. IPv4 addresses sort before IPv6; unparsable values fall back to string order.
 func compareIPs(ip1, ip2 string) bool {
-	p1 := net.ParseIP(ip1)
-	p2 := net.ParseIP(ip2)
+	p1, err1 := netutil.ParseAddr(ip1)
+	p2, err2 := netutil.ParseAddr(ip2)
 
-	if p1 == nil || p2 == nil {
+	if err1 != nil || err2 != nil {
 		return ip1 < ip2
 	}
 
-	// Convert to 4-byte representation for comparison (IPv4)
-	p14 := p1.To4()
-	p24 := p2.To4()
+	return p1.Compare(p2) < 0
+}
 
-	if p14 == nil || p24 == nil {
-		// Fallback for IPv6 or mixed
-		return bytesCompare(p1, p2) < 0
+// filterDevices narrows devices down to a VRF and a search text. With a VRF selected
+// (vrfID != 0) only interfaces in that VRF are kept and devices without one are dropped.
+// The search matches hostname, type and description, or the IP, MAC and label of a kept interface.
+func filterDevices(devices []models.Device, vrfID int, query string) []models.Device {
+	query = strings.ToLower(strings.TrimSpace(query))
+	contains := func(s string) bool {
+		return strings.Contains(strings.ToLower(s), query)
 	}
 
-	return bytesCompare(p14, p24) < 0
-}
-
-func bytesCompare(a, b []byte) int {
-	for i := 0; i < len(a) && i < len(b); i++ {
-		if a[i] < b[i] {
-			return -1
+	var filtered []models.Device
+	for _, d := range devices {
+		if vrfID != 0 {
+			var ifaces []models.DeviceInterface
+			for _, iface := range d.Interfaces {
+				if iface.VRFID == vrfID {
+					ifaces = append(ifaces, iface)
+				}
+			}
+			if len(ifaces) == 0 {
+				continue
+			}
+			d.Interfaces = ifaces
 		}
-		if a[i] > b[i] {
-			return 1
+
+		if query != "" {
+			match := contains(d.Hostname) || contains(d.DeviceType) || contains(d.Description)
+			for _, iface := range d.Interfaces {
+				if contains(iface.IPAddress) || contains(iface.MACAddress) || contains(iface.Label) {
+					match = true
+				}
+			}
+			if !match {
+				continue
+			}
 		}
+		filtered = append(filtered, d)
 	}
-	if len(a) < len(b) {
-		return -1
-	}
-	if len(a) > len(b) {
-		return 1
+	return filtered
+}
+
+// pingCommand builds a ping invocation for an IPv4 or IPv6 address.
+// IPv6 uses ping6 where it exists (macOS/BSD) and "ping -6" otherwise.
+func pingCommand(ip string, count int) *exec.Cmd {
+	countArg := strconv.Itoa(count)
+	if addr, err := netutil.ParseAddr(ip); err == nil && addr.Is6() {
+		if path, err := exec.LookPath("ping6"); err == nil {
+			return exec.Command(path, "-c", countArg, ip)
+		}
+		return exec.Command("ping", "-6", "-c", countArg, ip)
 	}
-	return 0
+	return exec.Command("ping", "-c", countArg, ip)
 }
 
 func HomeHandler(w http.ResponseWriter, r *http.Request) {
-	devices, err := db.GetAllDevices()
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	allDevices, racks, err := inventory(asOf)
 	if err != nil {
+		log.Printf("Error fetching the inventory: %v", err)
 		http.Error(w, "Could not fetch devices", http.StatusInternalServerError)
 		return
 	}
-	racks, err := db.GetAllRacks()
+	vrfID, _ := strconv.Atoi(r.URL.Query().Get("vrf"))
+	query := r.URL.Query().Get("q")
+	tag := db.NormalizeTagName(r.URL.Query().Get("tag"))
+	siteID, _ := strconv.Atoi(r.URL.Query().Get("site"))
+	devices := filterByTag(filterDevices(allDevices, vrfID, query), racks, tag)
+	devices, racks = filterBySite(devices, racks, siteID)
+	tags, err := db.GetAllTags()
+	if err != nil {
+		log.Printf("Could not fetch tags: %v", err)
+	}
+	sites, err := db.GetAllSites()
 	if err != nil {
-		log.Printf("Could not fetch racks: %v", err)
+		log.Printf("Could not fetch sites: %v", err)
 	}
 
 	// Group devices by Rack
@@ -140,6 +260,9 @@ func HomeHandler(w http.ResponseWriter, r *http.Request) {
 				Rack:    rack,
 				Devices: devs,
 			})
+		} else if tag != "" && !rack.Tags.Has(tag) {
+			// Filtering by tag: only racks with matching devices, or tagged themselves
+			continue
 		} else {
 			// Show empty racks too? User said "name of each rack followed by machines".
 			// Usually yes, show the rack even if empty.
@@ -229,74 +352,53 @@ func HomeHandler(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	// 1. Identify most common subnet (unchanged logic)
-	subnetCounts := make(map[string]int)
-	usedIPs := make(map[string]models.Device)
-	usedIPsInterface := make(map[string]models.DeviceInterface)
-
-	for _, d := range devices {
-		for _, iface := range d.Interfaces {
-			if iface.IPAddress == "" {
-				continue
-			}
-			ipRaw := strings.ReplaceAll(iface.IPAddress, " ", "")
-			parts := strings.Split(ipRaw, ".")
-			if len(parts) == 4 {
-				subnet := strings.Join(parts[:3], ".")
-				subnetCounts[subnet]++
-				usedIPs[ipRaw] = d
-				usedIPsInterface[ipRaw] = iface
+	// Build the IP map for the selected subnet, counting only addresses in its VRF
+	subnets, err := db.GetAllSubnets()
+	if err != nil {
+		log.Printf("Could not fetch subnets: %v", err)
+	}
+	vrfs, err := db.GetAllVRFs()
+	if err != nil {
+		log.Printf("Could not fetch VRFs: %v", err)
+	}
+	mapSubnets := subnets
+	if vrfID != 0 {
+		mapSubnets = nil
+		for _, s := range subnets {
+			if s.VRFID == vrfID {
+				mapSubnets = append(mapSubnets, s)
 			}
 		}
 	}
-
-	// Default subnet
-	// Default subnet
-	// Default subnet
-	targetSubnet := os.Getenv("IP_RANGE_START")
-	if targetSubnet == "" {
-		targetSubnet = "192.168.1"
+	// A tag narrows the map selector down to the tagged subnets, if there are any
+	if tagged := filterSubnetsByTag(mapSubnets, tag); len(tagged) > 0 {
+		mapSubnets = tagged
 	}
-	maxCount := 1 // Require at least 2 devices to override default
-	for subnet, count := range subnetCounts {
-		if count > maxCount {
-			maxCount = count
-			targetSubnet = subnet
-		}
+	targetSubnet := resolveSubnet(r, mapSubnets)
+	if targetSubnet.ID == 0 && vrfID != 0 {
+		targetSubnet.VRFID = vrfID
 	}
-
-	// 2. Build IP Map (1-254)
-	var ipMap []IPStatus
-	usedCount := 0
-
-	for i := 1; i <= 254; i++ {
-		ip := fmt.Sprintf("%s.%d", targetSubnet, i)
-		status := "Free"
-		deviceID := 0
-		hostname := ""
-
-		if device, exists := usedIPs[ip]; exists {
-			deviceID = device.ID
-			hostname = device.Hostname
-			status = "Used"
-
-			// Check if specifically reserved
-			if device.Status == "Reserved" {
-				status = "Reserved"
-			}
-			usedCount++
+	ranges, err := db.GetAllRanges()
+	if err != nil {
+		log.Printf("Could not fetch IP ranges: %v", err)
+	}
+	// Address records and reservation events have no history, so they are left out of the past
+	var records []models.IPAddress
+	var events []models.ReservationEvent
+	now := time.Now()
+	if asOf == nil {
+		if records, err = db.GetAllIPAddresses(); err != nil {
+			log.Printf("Could not fetch IP addresses: %v", err)
 		}
-
-		ipMap = append(ipMap, IPStatus{
-			IP:       ip,
-			Octet:    i,
-			Status:   status,
-			DeviceID: deviceID,
-			Hostname: hostname,
-		})
+		if events, err = db.GetReservationEvents(5); err != nil {
+			log.Printf("Could not fetch reservation events: %v", err)
+		}
+	} else {
+		now = *asOf
 	}
-
-	// Logic for free blocks removed
+	used := usedAddresses(allDevices, records, targetSubnet.VRFID)
+	ipMap, sparse := buildIPMap(targetSubnet, used, ranges)
+	summary := summarizeSubnet(targetSubnet, used)
 
 	data := DashboardData{
 		RackGroups:        rackGroups,
@@ -304,46 +406,236 @@ func HomeHandler(w http.ResponseWriter, r *http.Request) {
 		Devices:           devices, // Passed for completeness, ensuring IPMap works if it relies on this? No, IPMap constructed above.
 		Racks:             racks,
 		Subnet:            targetSubnet,
+		SubnetAncestors:   subnetAncestors(targetSubnet, subnets),
+		Subnets:           mapSubnets,
+		VRFs:              vrfs,
+		VRF:               vrfID,
+		Query:             query,
+		Tag:               tag,
+		Tags:              tags,
+		Site:              siteID,
+		Sites:             sites,
+		Expiring:          expiringReservations(allDevices, now),
+		ReservationEvents: events,
 		IPMap:             ipMap,
-		TotalIPs:          254,
-		UsedIPs:           usedCount,
-		FreeIPs:           254 - usedCount,
-		UsagePercent:      (usedCount * 100) / 254,
+		IPMapSparse:       sparse,
+		TotalIPs:          summary.TotalIPs,
+		UsedIPs:           summary.UsedIPs,
+		ReservedIPs:       summary.ReservedIPs,
+		FreeIPs:           summary.FreeIPs,
+		FreeLabel:         summary.FreeLabel,
+		LargeSubnet:       summary.Large,
+		UsagePercent:      summary.UsagePercent,
 		Sort:              sortBy,
 		Order:             sortOrder,
+		AsOf:              asOf,
+	}
+	if asOf != nil {
+		data.AsOfParam = r.URL.Query().Get("as_of")
+		if data.HistoryStart, err = db.HistoryStart(); err != nil {
+			log.Printf("Could not fetch the start of the history: %v", err)
+		}
 	}
 
 	render(w, "index.html", data)
 }
 
+// DeviceFormData is the data rendered by form.html
+type DeviceFormData struct {
+	Device   models.Device
+	Racks    []models.Rack
+	VLANs    []models.VLAN
+	VRFs     []models.VRF
+	Models   []models.DeviceModel
+	Tenants  []models.Tenant
+	Warnings []string // Shown above the form; saving needs "allow_pool" to be confirmed
+	// IPs and MACs already in use; saving needs "allow_duplicates" to be confirmed
+	Conflicts    []db.Conflict
+	AllowPool    bool // DHCP pool warnings were already confirmed
+	CustomFields []models.CustomField
+	Errors       validate.Errors
+	// Stored state of the device being edited, which decides the states it may move to
+	StoredStatus string
+	History      []models.StatusEvent
+	Stale        *VersionConflict // Set when someone else saved the device while it was edited
+}
+
+// newDeviceFormData loads the lookup lists needed to render the device form
+func newDeviceFormData(device models.Device) (DeviceFormData, error) {
+	data := DeviceFormData{Device: device}
+	var err error
+	if data.Racks, err = db.GetAllRacks(); err != nil {
+		log.Printf("Error fetching racks: %v", err)
+		return data, err
+	}
+	if data.VLANs, err = db.GetAllVLANs(); err != nil {
+		log.Printf("Error fetching VLANs: %v", err)
+		return data, err
+	}
+	if data.VRFs, err = db.GetAllVRFs(); err != nil {
+		log.Printf("Error fetching VRFs: %v", err)
+		return data, err
+	}
+	if data.Models, err = db.GetAllDeviceModels(); err != nil {
+		log.Printf("Error fetching device models: %v", err)
+		return data, err
+	}
+	if data.Tenants, err = db.GetAllTenants(); err != nil {
+		log.Printf("Error fetching tenants: %v", err)
+		return data, err
+	}
+	if data.CustomFields, err = db.GetCustomFields(db.TagDevice); err != nil {
+		log.Printf("Error fetching custom fields: %v", err)
+		return data, err
+	}
+	if device.ID != 0 {
+		if data.StoredStatus, err = db.GetDeviceStatus(device.ID); err != nil {
+			log.Printf("Error fetching device status: %v", err)
+			return data, err
+		}
+		if data.History, err = db.GetStatusHistory(device.ID); err != nil {
+			log.Printf("Error fetching status history: %v", err)
+			return data, err
+		}
+	}
+	return data, nil
+}
+
+// StatusOptions returns the states the device may be saved with: the initial states for a new
+// device, otherwise its stored state and the ones it may move to
+func (f DeviceFormData) StatusOptions() []string {
+	if f.Device.ID == 0 {
+		return lifecycle.Initial
+	}
+	return lifecycle.Next(f.StoredStatus)
+}
+
+// CustomFieldInputs returns the custom fields of devices with the values of the device
+func (f DeviceFormData) CustomFieldInputs() []CustomFieldInput {
+	return customFieldInputs(f.CustomFields, f.Device.CustomFields, f.Errors)
+}
+
+// InterfaceError returns the problem with a field of the i-th interface, if any
+func (f DeviceFormData) InterfaceError(i int, field string) string {
+	return f.Errors[validate.InterfaceField(i, field)]
+}
+
+// InterfaceErrors returns the problems with the i-th interface, in field order
+func (f DeviceFormData) InterfaceErrors(i int) []string {
+	var msgs []string
+	for _, field := range []string{"ip_address", "mac_address", "label", "tagged_vlans", "tags", "tenant_id"} {
+		if msg := f.InterfaceError(i, field); msg != "" {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+// TaggedVIDs formats the tagged VLANs of an interface as a comma separated VID list
+func (f DeviceFormData) TaggedVIDs(iface models.DeviceInterface) string {
+	var vids []string
+	for _, id := range iface.TaggedVLANIDs {
+		for _, v := range f.VLANs {
+			if v.ID == id {
+				vids = append(vids, strconv.Itoa(v.VID))
+			}
+		}
+	}
+	return strings.Join(vids, ", ")
+}
+
 func AddDeviceHandler(w http.ResponseWriter, r *http.Request) {
-	racks, err := db.GetAllRacks()
-	if err != nil {
-		http.Error(w, "Error fetching racks", http.StatusInternalServerError)
-		return
+	var device models.Device
+	// Pre-fill the height and interfaces of a catalog model
+	if modelID, err := strconv.Atoi(r.URL.Query().Get("model_id")); err == nil {
+		model, err := db.GetDeviceModel(modelID)
+		if err != nil {
+			http.Error(w, "Model not found", http.StatusNotFound)
+			return
+		}
+		applyDeviceModel(&device, model)
 	}
 
-	// Check for pre-fill IP
+	// Check for pre-fill IP (and the VRF of the map it was picked from)
 	ipParam := r.URL.Query().Get("ip")
-	var device models.Device
 	if ipParam != "" {
-		device.Interfaces = append(device.Interfaces, models.DeviceInterface{
-			IPAddress: ipParam,
-		})
+		vrfID, _ := strconv.Atoi(r.URL.Query().Get("vrf"))
+		if len(device.Interfaces) == 0 {
+			device.Interfaces = append(device.Interfaces, models.DeviceInterface{})
+		}
+		device.Interfaces[0].IPAddress = ipParam
+		device.Interfaces[0].VRFID = vrfID
 	}
 
-	data := struct {
-		Device models.Device
-		Racks  []models.Rack
-	}{
-		Device: device,
-		Racks:  racks,
+	data, err := newDeviceFormData(device)
+	if err != nil {
+		http.Error(w, "Error loading form", http.StatusInternalServerError)
+		return
+	}
+	if data.Warnings, err = poolWarnings(device.Interfaces, nil); err != nil {
+		log.Printf("Error checking DHCP pools: %v", err)
 	}
 	render(w, "form.html", data)
 }
 
+// RackFormData is the data rendered by rack_form.html
+type RackFormData struct {
+	Rack         models.Rack
+	Sites        []models.Site
+	Rooms        []models.Room
+	Tenants      []models.Tenant
+	CustomFields []models.CustomField
+	Errors       validate.Errors
+	Stale        *VersionConflict // Set when someone else saved the rack while it was edited
+}
+
+// CustomFieldInputs returns the custom fields of racks with the values of the rack
+func (f RackFormData) CustomFieldInputs() []CustomFieldInput {
+	return customFieldInputs(f.CustomFields, f.Rack.CustomFields, f.Errors)
+}
+
+// renderRackForm shows the rack form, with the submitted values and their problems when errs is set
+func renderRackForm(w http.ResponseWriter, rack models.Rack, errs validate.Errors) {
+	status := http.StatusOK
+	if len(errs) > 0 {
+		status = http.StatusBadRequest
+	}
+	renderRackFormData(w, status, RackFormData{Rack: rack, Errors: errs})
+}
+
+// renderRackFormData loads the lookup lists of the rack form into data and renders it with status
+func renderRackFormData(w http.ResponseWriter, status int, data RackFormData) {
+	fields, err := db.GetCustomFields(db.TagRack)
+	if err != nil {
+		log.Printf("Error fetching custom fields: %v", err)
+		http.Error(w, "Error loading form", http.StatusInternalServerError)
+		return
+	}
+	sites, err := db.GetAllSites()
+	if err != nil {
+		log.Printf("Error fetching sites: %v", err)
+		http.Error(w, "Error loading form", http.StatusInternalServerError)
+		return
+	}
+	rooms, err := db.GetAllRooms()
+	if err != nil {
+		log.Printf("Error fetching rooms: %v", err)
+		http.Error(w, "Error loading form", http.StatusInternalServerError)
+		return
+	}
+	tenants, err := db.GetAllTenants()
+	if err != nil {
+		log.Printf("Error fetching tenants: %v", err)
+		http.Error(w, "Error loading form", http.StatusInternalServerError)
+		return
+	}
+	data.Sites, data.Rooms, data.Tenants, data.CustomFields = sites, rooms, tenants, fields
+	w.WriteHeader(status)
+	render(w, "rack_form.html", data)
+}
+
 func AddRackHandler(w http.ResponseWriter, r *http.Request) {
-	render(w, "rack_form.html", nil)
+	renderRackForm(w, models.Rack{Height: 42}, nil)
 }
 
 func CreateRackHandler(w http.ResponseWriter, r *http.Request) {
@@ -356,15 +648,18 @@ func CreateRackHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	height, _ := strconv.Atoi(r.FormValue("height"))
-	rack := models.Rack{
-		Name:     r.FormValue("name"),
-		Location: r.FormValue("location"),
-		Height:   height,
-		Status:   r.FormValue("status"),
+	rack, errs, err := rackFromForm(r)
+	if err != nil {
+		log.Printf("Error reading rack form: %v", err)
+		http.Error(w, "Error adding rack", http.StatusInternalServerError)
+		return
+	}
+	if len(errs) > 0 {
+		renderRackForm(w, rack, errs)
+		return
 	}
 
-	if err := db.AddRack(rack); err != nil {
+	if err := db.AddRack(rack, requestActor(r)); err != nil {
 		log.Printf("Error adding rack: %v", err)
 		http.Error(w, "Error adding rack", http.StatusInternalServerError)
 		return
@@ -387,7 +682,7 @@ func EditRackHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	render(w, "rack_form.html", rack)
+	renderRackForm(w, rack, nil)
 }
 
 func UpdateRackHandler(w http.ResponseWriter, r *http.Request) {
@@ -401,16 +696,32 @@ func UpdateRackHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	id, _ := strconv.Atoi(r.FormValue("id"))
-	height, _ := strconv.Atoi(r.FormValue("height"))
-	rack := models.Rack{
-		ID:       id,
-		Name:     r.FormValue("name"),
-		Location: r.FormValue("location"),
-		Height:   height,
-		Status:   r.FormValue("status"),
+	rack, errs, err := rackFromForm(r)
+	if err != nil {
+		log.Printf("Error reading rack form: %v", err)
+		http.Error(w, "Error updating rack", http.StatusInternalServerError)
+		return
+	}
+	rack.ID = id
+	if len(errs) > 0 {
+		renderRackForm(w, rack, errs)
+		return
 	}
 
-	if err := db.UpdateRack(rack); err != nil {
+	if err := db.UpdateRack(rack, requestActor(r)); err != nil {
+		var placementErr *db.PlacementError
+		if errors.As(err, &placementErr) {
+			renderRackForm(w, rack, validate.Errors{"height": placementErr.Error()})
+			return
+		}
+		if errors.Is(err, db.ErrStale) {
+			renderStaleRack(w, rack)
+			return
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Rack not found", http.StatusNotFound)
+			return
+		}
 		log.Printf("Error updating rack: %v", err)
 		http.Error(w, "Error updating rack", http.StatusInternalServerError)
 		return
@@ -419,15 +730,69 @@ func UpdateRackHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
+// rackFromForm reads and validates the rack form
+func rackFromForm(r *http.Request) (models.Rack, validate.Errors, error) {
+	height, _ := strconv.Atoi(strings.TrimSpace(r.FormValue("height")))
+	siteID, _ := strconv.Atoi(r.FormValue("site_id"))
+	roomID, _ := strconv.Atoi(r.FormValue("room_id"))
+	tenantID, _ := strconv.Atoi(r.FormValue("tenant_id"))
+	version, _ := strconv.Atoi(r.FormValue("version"))
+	rack := models.Rack{
+		Name:     strings.TrimSpace(r.FormValue("name")),
+		SiteID:   siteID,
+		RoomID:   roomID,
+		Height:   height,
+		Status:   r.FormValue("status"),
+		TenantID: tenantID,
+		Version:  version,
+	}
+
+	sites, err := db.GetAllSites()
+	if err != nil {
+		return rack, nil, err
+	}
+	rooms, err := db.GetAllRooms()
+	if err != nil {
+		return rack, nil, err
+	}
+	tenants, err := db.GetAllTenants()
+	if err != nil {
+		return rack, nil, err
+	}
+	errs := validate.Rack(rack, sites, rooms)
+	if err := validate.TenantID(rack.TenantID, tenants); err != nil {
+		errs.Add("tenant_id", err.Error())
+	}
+	if rack.Tags, err = tagsFromForm(r.FormValue("tags")); err != nil {
+		errs.Add("tags", err.Error())
+	}
+
+	custom, customErrs, err := customFieldsFromForm(r, db.TagRack)
+	if err != nil {
+		return rack, nil, err
+	}
+	rack.CustomFields = custom
+	errs.Merge(customErrs)
+	return rack, errs, nil
+}
+
+// DeleteRackHandler moves the rack in the id of a POST to the trash
 func DeleteRackHandler(w http.ResponseWriter, r *http.Request) {
-	idStr := r.URL.Query().Get("id")
-	id, err := strconv.Atoi(idStr)
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
 	if err != nil {
 		http.Error(w, "Invalid rack ID", http.StatusBadRequest)
 		return
 	}
 
-	if err := db.DeleteRack(id); err != nil {
+	if err := db.DeleteRack(id, requestActor(r)); errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Rack not found", http.StatusNotFound)
+		return
+	} else if err != nil {
 		log.Printf("Error deleting rack: %v", err)
 		http.Error(w, "Error deleting rack", http.StatusInternalServerError)
 		return
@@ -436,60 +801,203 @@ func DeleteRackHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-func CreateDeviceHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Redirect(w, r, "/add", http.StatusSeeOther)
-		return
+// interfacesFromForm parses the repeated interface fields of the device form
+// (vrf_id, ip_address, mac_address, label, vlan_mode, untagged_vlan, tagged_vlans, iface_tags).
+// Rows left completely empty are skipped. Values are kept as submitted, with valid addresses in
+// canonical form, so the form can show them again; problems are returned per field.
+func interfacesFromForm(r *http.Request) ([]models.DeviceInterface, validate.Errors, error) {
+	ips := r.PostForm["ip_address"]
+	macs := r.PostForm["mac_address"]
+	labels := r.PostForm["label"]
+	modes := r.PostForm["vlan_mode"]
+	untagged := r.PostForm["untagged_vlan"]
+	tagged := r.PostForm["tagged_vlans"]
+	vrfs := r.PostForm["vrf_id"]
+	ifaceTags := r.PostForm["iface_tags"]
+	ifaceTenants := r.PostForm["iface_tenant"]
+	// IDs of the stored interfaces being edited, empty for new rows
+	ids := r.PostForm["interface_id"]
+
+	errs := validate.Errors{}
+	// Every row submits these fields, so differing counts mean a truncated or hand-made request
+	if len(macs) != len(ips) || len(labels) != len(ips) {
+		errs.Add("interfaces", "the IP, MAC and label fields do not line up; please check the interfaces")
+	}
+
+	vlans, err := db.GetAllVLANs()
+	if err != nil {
+		return nil, nil, err
 	}
 
-	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Error parsing form", http.StatusBadRequest)
-		return
+	var interfaces []models.DeviceInterface
+	for i := 0; i < len(ips); i++ {
+		iface := models.DeviceInterface{
+			IPAddress:  strings.TrimSpace(ips[i]),
+			MACAddress: strings.TrimSpace(valueAt(macs, i)),
+			Label:      strings.TrimSpace(valueAt(labels, i)),
+		}
+		if iface.IPAddress == "" && iface.MACAddress == "" && iface.Label == "" {
+			continue
+		}
+		if ip, err := netutil.CanonicalAddr(iface.IPAddress); err == nil {
+			iface.IPAddress = ip
+		}
+		iface.ID, _ = strconv.Atoi(valueAt(ids, i))
+		iface.VRFID, _ = strconv.Atoi(valueAt(vrfs, i))
+		iface.TenantID, _ = strconv.Atoi(valueAt(ifaceTenants, i))
+		if iface.Tags, err = tagsFromForm(valueAt(ifaceTags, i)); err != nil {
+			errs.Add(validate.InterfaceField(len(interfaces), "tags"), err.Error())
+		}
+
+		if mode := valueAt(modes, i); mode == "access" || mode == "tagged" {
+			iface.VLANMode = mode
+			iface.UntaggedVLANID, _ = strconv.Atoi(valueAt(untagged, i))
+			if mode == "tagged" {
+				iface.TaggedVLANIDs, err = resolveTaggedVLANs(valueAt(tagged, i), iface.UntaggedVLANID, vlans)
+				if err != nil {
+					errs.Add(validate.InterfaceField(len(interfaces), "tagged_vlans"), err.Error())
+				}
+			}
+		}
+
+		interfaces = append(interfaces, iface)
+	}
+	return interfaces, errs, nil
+}
+
+// valueAt returns values[i], or "" when the form sent fewer values
+func valueAt(values []string, i int) string {
+	if i < len(values) {
+		return values[i]
 	}
+	return ""
+}
 
+// deviceFromForm reads and validates the device form. previousExpiry is the stored reservation
+// expiry of the device being edited, if any.
+func deviceFromForm(r *http.Request, id int, previousExpiry *time.Time) (models.Device, validate.Errors, error) {
 	rackID, _ := strconv.Atoi(r.FormValue("rack_id"))
+	position, _ := strconv.Atoi(strings.TrimSpace(r.FormValue("position")))
+	uHeight, err := strconv.Atoi(strings.TrimSpace(r.FormValue("u_height")))
+	if err != nil {
+		uHeight = 1
+	}
+	modelID, _ := strconv.Atoi(r.FormValue("model_id"))
+	tenantID, _ := strconv.Atoi(r.FormValue("tenant_id"))
+	version, _ := strconv.Atoi(r.FormValue("version"))
 
 	device := models.Device{
-		Hostname:    r.FormValue("hostname"),
+		ID:          id,
+		Hostname:    strings.TrimSpace(r.FormValue("hostname")),
 		DeviceType:  r.FormValue("device_type"),
+		ModelID:     modelID,
 		RackID:      rackID,
-		Status:      r.FormValue("status"),
+		Position:    position,
+		UHeight:     uHeight,
+		Face:        r.FormValue("face"),
+		Status:      lifecycle.Normalize(r.FormValue("status")),
+		TenantID:    tenantID,
 		Description: r.FormValue("description"),
+		Version:     version,
+
+		AllowDuplicates: r.FormValue("allow_duplicates") != "",
 	}
 
-	// Parse Interfaces
-	ips := r.PostForm["ip_address"]
-	macs := r.PostForm["mac_address"]
-	labels := r.PostForm["label"]
+	interfaces, errs, err := interfacesFromForm(r)
+	if err != nil {
+		return device, nil, err
+	}
+	device.Interfaces = interfaces
+	if device.Status == lifecycle.Retired {
+		// Retired devices keep their interfaces but hold no addresses
+		for i := range device.Interfaces {
+			device.Interfaces[i].IPAddress = ""
+		}
+	}
 
-	// Ensure equal lengths or handle gracefully.
-	// We assume the frontend sends matching arrays.
-	count := len(ips)
-	if count > 0 {
-		for i := 0; i < count; i++ {
-			if ips[i] == "" {
-				continue
-			}
+	racks, err := db.GetAllRacks()
+	if err != nil {
+		return device, nil, err
+	}
+	errs.Merge(validate.Device(device, racks))
+	if device.ModelID != 0 {
+		if _, err := db.GetDeviceModel(device.ModelID); err != nil {
+			errs.Add("model_id", "the selected model does not exist")
+		}
+	}
+	tenants, err := db.GetAllTenants()
+	if err != nil {
+		return device, nil, err
+	}
+	if err := validate.TenantID(device.TenantID, tenants); err != nil {
+		errs.Add("tenant_id", err.Error())
+	}
+	for i, iface := range device.Interfaces {
+		if err := validate.TenantID(iface.TenantID, tenants); err != nil {
+			errs.Add(validate.InterfaceField(i, "tenant_id"), err.Error())
+		}
+	}
 
-			mac := ""
-			if i < len(macs) {
-				mac = macs[i]
-			}
+	if device.Tags, err = tagsFromForm(r.FormValue("tags")); err != nil {
+		errs.Add("tags", err.Error())
+	}
 
-			label := ""
-			if i < len(labels) {
-				label = labels[i]
-			}
+	custom, customErrs, err := customFieldsFromForm(r, db.TagDevice)
+	if err != nil {
+		return device, nil, err
+	}
+	device.CustomFields = custom
+	errs.Merge(customErrs)
 
-			device.Interfaces = append(device.Interfaces, models.DeviceInterface{
-				IPAddress:  strings.ReplaceAll(ips[i], " ", ""),
-				MACAddress: strings.TrimSpace(mac),
-				Label:      strings.TrimSpace(label),
-			})
-		}
+	if device.ExpiresAt, err = expiresFromForm(r, previousExpiry); err != nil {
+		errs.Add("expires_at", err.Error())
+	}
+	return device, errs, nil
+}
+
+// renderDeviceErrors shows the device form again with the submitted values and what is wrong with them
+func renderDeviceErrors(w http.ResponseWriter, r *http.Request, device models.Device, errs validate.Errors) {
+	data, err := newDeviceFormData(device)
+	if err != nil {
+		http.Error(w, "Error loading form", http.StatusInternalServerError)
+		return
+	}
+	data.Errors = errs
+	data.AllowPool = r.FormValue("allow_pool") != ""
+	w.WriteHeader(http.StatusBadRequest)
+	render(w, "form.html", data)
+}
+
+func CreateDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/add", http.StatusSeeOther)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	device, errs, err := deviceFromForm(r, 0, nil)
+	if err != nil {
+		log.Printf("Error reading device form: %v", err)
+		http.Error(w, "Error adding device", http.StatusInternalServerError)
+		return
+	}
+	if len(errs) > 0 {
+		renderDeviceErrors(w, r, device, errs)
+		return
+	}
+
+	if confirmPoolAddresses(w, r, device, nil) {
+		return
 	}
 
-	if err := db.AddDevice(device); err != nil {
+	if err := db.AddDevice(device, requestActor(r)); err != nil {
+		if renderConflicts(w, r, device, err) || renderPlacementError(w, r, device, err) {
+			return
+		}
 		log.Printf("Error adding device: %v", err)
 		http.Error(w, "Error adding device", http.StatusInternalServerError)
 		return
@@ -498,6 +1006,152 @@ func CreateDeviceHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
+// confirmPoolAddresses re-renders the device form with a warning when a newly assigned static
+// address sits inside a DHCP pool and the user has not confirmed it yet. It reports whether
+// the form was rendered, in which case the caller must stop.
+func confirmPoolAddresses(w http.ResponseWriter, r *http.Request, device models.Device, existing []models.DeviceInterface) bool {
+	if r.FormValue("allow_pool") != "" {
+		return false
+	}
+	warnings, err := poolWarnings(device.Interfaces, existing)
+	if err != nil {
+		log.Printf("Error checking DHCP pools: %v", err)
+		return false
+	}
+	if len(warnings) == 0 {
+		return false
+	}
+
+	data, err := newDeviceFormData(device)
+	if err != nil {
+		http.Error(w, "Error loading form", http.StatusInternalServerError)
+		return true
+	}
+	data.Warnings = warnings
+	render(w, "form.html", data)
+	return true
+}
+
+// renderPlacementError re-renders the device form when err is a *db.PlacementError or
+// db.ErrRackInTrash, showing it next to the rack position. It reports whether the form was rendered.
+func renderPlacementError(w http.ResponseWriter, r *http.Request, device models.Device, err error) bool {
+	if errors.Is(err, db.ErrRackInTrash) {
+		renderDeviceErrors(w, r, device, validate.Errors{"rack_id": err.Error()})
+		return true
+	}
+	var placementErr *db.PlacementError
+	if !errors.As(err, &placementErr) {
+		return false
+	}
+	renderDeviceErrors(w, r, device, validate.Errors{"position": placementErr.Error()})
+	return true
+}
+
+// renderTransitionError re-renders the device form when err is a *lifecycle.TransitionError, showing
+// it next to the status. It reports whether the form was rendered.
+func renderTransitionError(w http.ResponseWriter, r *http.Request, device models.Device, err error) bool {
+	var transitionErr *lifecycle.TransitionError
+	if !errors.As(err, &transitionErr) {
+		return false
+	}
+	renderDeviceErrors(w, r, device, validate.Errors{"status": transitionErr.Error()})
+	return true
+}
+
+// renderConflicts re-renders the device form listing the conflicts when err is a *db.ConflictError,
+// so the user can fix the addresses or save anyway. It reports whether the form was rendered.
+func renderConflicts(w http.ResponseWriter, r *http.Request, device models.Device, err error) bool {
+	var conflictErr *db.ConflictError
+	if !errors.As(err, &conflictErr) {
+		return false
+	}
+
+	data, err := newDeviceFormData(device)
+	if err != nil {
+		http.Error(w, "Error loading form", http.StatusInternalServerError)
+		return true
+	}
+	data.Conflicts = conflictErr.Conflicts
+	data.AllowPool = r.FormValue("allow_pool") != ""
+	w.WriteHeader(http.StatusConflict)
+	render(w, "form.html", data)
+	return true
+}
+
+// VersionConflict shows a user who submitted a stale device or rack both versions: what is saved
+// now and what they submitted
+type VersionConflict struct {
+	Object  string               // "device" or "rack"
+	EditURL string               // Loads the saved version, dropping the submitted one
+	Actor   string               // Who saved the object last, "" if unknown
+	At      time.Time            // When it was saved
+	Changes []models.FieldChange // Before is the saved value, After the submitted one
+}
+
+// newVersionConflict compares what is saved with what was submitted, and looks up who saved it last
+func newVersionConflict(objectType string, id int, editURL string, changes []models.FieldChange) *VersionConflict {
+	conflict := &VersionConflict{Object: objectType, EditURL: editURL, Changes: changes}
+	entries, err := db.GetAuditLog(db.AuditFilter{ObjectType: objectType, ObjectID: id, Limit: 1})
+	if err != nil {
+		log.Printf("Error fetching the last change of %s %d: %v", objectType, id, err)
+	} else if len(entries) > 0 {
+		conflict.Actor, conflict.At = entries[0].Actor, entries[0].CreatedAt
+	}
+	return conflict
+}
+
+// renderStaleDevice re-renders the device form when err is db.ErrStale, showing the saved version
+// next to the submitted one. The form then holds the saved version number, so submitting it again
+// overwrites the other changes on purpose. It reports whether the form was rendered.
+func renderStaleDevice(w http.ResponseWriter, r *http.Request, device models.Device, err error) bool {
+	if !errors.Is(err, db.ErrStale) {
+		return false
+	}
+
+	saved, err := db.GetDevice(device.ID)
+	if err != nil {
+		http.Error(w, "The device was deleted while you were editing it", http.StatusConflict)
+		return true
+	}
+	changes, err := db.DiffDevice(saved, device)
+	if err != nil {
+		log.Printf("Error comparing device versions: %v", err)
+		http.Error(w, "Error loading form", http.StatusInternalServerError)
+		return true
+	}
+
+	device.Version = saved.Version
+	data, err := newDeviceFormData(device)
+	if err != nil {
+		http.Error(w, "Error loading form", http.StatusInternalServerError)
+		return true
+	}
+	data.Stale = newVersionConflict(db.AuditDevice, device.ID, fmt.Sprintf("/edit?id=%d", device.ID), changes)
+	data.AllowPool = r.FormValue("allow_pool") != ""
+	w.WriteHeader(http.StatusConflict)
+	render(w, "form.html", data)
+	return true
+}
+
+// renderStaleRack re-renders the rack form showing the saved version next to the submitted one,
+// like renderStaleDevice
+func renderStaleRack(w http.ResponseWriter, rack models.Rack) {
+	saved, err := db.GetRack(rack.ID)
+	if err != nil {
+		http.Error(w, "The rack was deleted while you were editing it", http.StatusConflict)
+		return
+	}
+	changes, err := db.DiffRack(saved, rack)
+	if err != nil {
+		log.Printf("Error comparing rack versions: %v", err)
+		http.Error(w, "Error loading form", http.StatusInternalServerError)
+		return
+	}
+
+	rack.Version = saved.Version
+	renderRackFormData(w, http.StatusConflict, RackFormData{Rack: rack, Stale: newVersionConflict(db.AuditRack, rack.ID, fmt.Sprintf("/edit-rack?id=%d", rack.ID), changes)})
+}
+
 func EditDeviceHandler(w http.ResponseWriter, r *http.Request) {
 	idStr := r.URL.Query().Get("id")
 	id, err := strconv.Atoi(idStr)
@@ -512,14 +1166,10 @@ func EditDeviceHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	racks, _ := db.GetAllRacks()
-
-	data := struct {
-		Device models.Device
-		Racks  []models.Rack
-	}{
-		Device: device,
-		Racks:  racks,
+	data, err := newDeviceFormData(device)
+	if err != nil {
+		http.Error(w, "Error loading form", http.StatusInternalServerError)
+		return
 	}
 
 	render(w, "form.html", data)
@@ -543,48 +1193,32 @@ func UpdateDeviceHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rackID, _ := strconv.Atoi(r.FormValue("rack_id"))
-
-	device := models.Device{
-		ID:          id,
-		Hostname:    r.FormValue("hostname"),
-		DeviceType:  r.FormValue("device_type"),
-		RackID:      rackID,
-		Status:      r.FormValue("status"),
-		Description: r.FormValue("description"),
+	existing, err := db.GetDevice(id)
+	if err != nil {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return
 	}
 
-	// Parse Interfaces
-	ips := r.PostForm["ip_address"]
-	macs := r.PostForm["mac_address"]
-	labels := r.PostForm["label"]
-
-	count := len(ips)
-	if count > 0 {
-		for i := 0; i < count; i++ {
-			if ips[i] == "" {
-				continue
-			}
-
-			mac := ""
-			if i < len(macs) {
-				mac = macs[i]
-			}
-
-			label := ""
-			if i < len(labels) {
-				label = labels[i]
-			}
+	device, errs, err := deviceFromForm(r, id, existing.ExpiresAt)
+	if err != nil {
+		log.Printf("Error reading device form: %v", err)
+		http.Error(w, "Error updating device", http.StatusInternalServerError)
+		return
+	}
+	if len(errs) > 0 {
+		renderDeviceErrors(w, r, device, errs)
+		return
+	}
 
-			device.Interfaces = append(device.Interfaces, models.DeviceInterface{
-				IPAddress:  strings.ReplaceAll(ips[i], " ", ""),
-				MACAddress: strings.TrimSpace(mac),
-				Label:      strings.TrimSpace(label),
-			})
-		}
+	if confirmPoolAddresses(w, r, device, existing.Interfaces) {
+		return
 	}
 
-	if err := db.UpdateDevice(device); err != nil {
+	if err := db.UpdateDevice(device, requestActor(r)); err != nil {
+		if renderStaleDevice(w, r, device, err) || renderConflicts(w, r, device, err) || renderPlacementError(w, r, device, err) ||
+			renderTransitionError(w, r, device, err) {
+			return
+		}
 		log.Printf("Error updating device: %v", err)
 		http.Error(w, "Error updating device", http.StatusInternalServerError)
 		return
@@ -593,15 +1227,23 @@ func UpdateDeviceHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
+// DeleteDeviceHandler moves the device in the id of a POST to the trash
 func DeleteDeviceHandler(w http.ResponseWriter, r *http.Request) {
-	idStr := r.URL.Query().Get("id")
-	id, err := strconv.Atoi(idStr)
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
 	if err != nil {
 		http.Error(w, "Invalid device ID", http.StatusBadRequest)
 		return
 	}
 
-	if err := db.DeleteDevice(id); err != nil {
+	if err := db.DeleteDevice(id, requestActor(r)); errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return
+	} else if err != nil {
 		log.Printf("Error deleting device: %v", err)
 		http.Error(w, "Error deleting device", http.StatusInternalServerError)
 		return
@@ -648,9 +1290,10 @@ func PingDeviceHandler(w http.ResponseWriter, r *http.Request) {
 	if requestedIP != "" {
 		// Verify this IP belongs to the device
 		found := false
+		requested, _ := netutil.CanonicalAddr(requestedIP)
 		for _, iface := range device.Interfaces {
-			if iface.IPAddress == requestedIP {
-				targetIP = requestedIP
+			if stored, _ := netutil.CanonicalAddr(iface.IPAddress); stored != "" && stored == requested {
+				targetIP = stored
 				found = true
 				break
 			}
@@ -670,31 +1313,68 @@ func PingDeviceHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// -c 3 for 3 packets
-	cmd := exec.Command("ping", "-c", "3", targetIP)
+	cmd := pingCommand(targetIP, 3)
 	output, err := cmd.CombinedOutput()
 
 	respond(err == nil, string(output))
 }
 
-// ExportCSVHandler exports devices to CSV
+// exportDevices returns the devices to export, as of asOf (nil for now), scoped like the dashboard by
+// ?vrf=, ?q=, ?tag= and ?site=
+func exportDevices(r *http.Request, asOf *time.Time) ([]models.Device, error) {
+	devices, racks, err := inventory(asOf)
+	if err != nil {
+		return nil, err
+	}
+	vrfID, _ := strconv.Atoi(r.URL.Query().Get("vrf"))
+	siteID, _ := strconv.Atoi(r.URL.Query().Get("site"))
+	devices = filterDevices(devices, vrfID, r.URL.Query().Get("q"))
+	devices = filterByTag(devices, racks, db.NormalizeTagName(r.URL.Query().Get("tag")))
+	devices, _ = filterBySite(devices, racks, siteID)
+	return devices, nil
+}
+
+// exportFilename names an export file, with the date of the inventory when it is from the past
+func exportFilename(asOf *time.Time, ext string) string {
+	if asOf == nil {
+		return "devices." + ext
+	}
+	return "devices-" + asOf.Format("2006-01-02T1504") + "." + ext
+}
+
+// ExportCSVHandler exports devices to CSV, as of ?as_of= when given
 func ExportCSVHandler(w http.ResponseWriter, r *http.Request) {
-	devices, err := db.GetAllDevices()
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	devices, err := exportDevices(r, asOf)
 	if err != nil {
 		http.Error(w, "Could not fetch devices", http.StatusInternalServerError)
 		return
 	}
+	fields, err := db.GetCustomFields(db.TagDevice)
+	if err != nil {
+		http.Error(w, "Could not fetch custom fields", http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "text/csv")
-	w.Header().Set("Content-Disposition", "attachment; filename=devices.csv")
+	w.Header().Set("Content-Disposition", "attachment; filename="+exportFilename(asOf, "csv"))
 
 	writer := csv.NewWriter(w)
 	defer writer.Flush()
 
-	// Header
-	writer.Write([]string{"ID", "Hostname", "Type", "Rack", "Status", "IP Addresses", "MAC Addresses", "Description", "Last Updated"})
+	// Header, with a column per custom field after the fixed ones
+	header := []string{"ID", "Hostname", "Type", "Manufacturer", "Model", "Rack", "Status", "IP Addresses", "MAC Addresses", "VRFs", "Description", "Tags", "Expires", "Last Updated"}
+	for _, f := range fields {
+		header = append(header, f.Label)
+	}
+	writer.Write(header)
 
 	for _, d := range devices {
-		var ips, macs []string
+		var ips, macs, vrfs []string
 		for _, iface := range d.Interfaces {
 			ip := iface.IPAddress
 			if iface.Label != "" {
@@ -702,32 +1382,52 @@ func ExportCSVHandler(w http.ResponseWriter, r *http.Request) {
 			}
 			ips = append(ips, ip)
 			macs = append(macs, iface.MACAddress)
+			vrfs = append(vrfs, iface.VRFName)
+		}
+
+		expires := ""
+		if d.ExpiresAt != nil {
+			expires = d.ExpiresAt.Format(time.RFC3339)
 		}
 
-		writer.Write([]string{
+		record := []string{
 			strconv.Itoa(d.ID),
 			d.Hostname,
 			d.DeviceType,
+			d.ManufacturerName,
+			d.ModelName,
 			d.RackName,
 			d.Status,
 			strings.Join(ips, "; "),
 			strings.Join(macs, "; "),
+			strings.Join(vrfs, "; "),
 			d.Description,
+			d.Tags.String(),
+			expires,
 			d.UpdatedAt.Format(time.RFC3339),
-		})
+		}
+		for _, f := range fields {
+			record = append(record, d.CustomFields[f.Name])
+		}
+		writer.Write(record)
 	}
 }
 
-// ExportJSONHandler exports devices to JSON
+// ExportJSONHandler exports devices to JSON, as of ?as_of= when given
 func ExportJSONHandler(w http.ResponseWriter, r *http.Request) {
-	devices, err := db.GetAllDevices()
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	devices, err := exportDevices(r, asOf)
 	if err != nil {
 		http.Error(w, "Could not fetch devices", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Content-Disposition", "attachment; filename=devices.json")
+	w.Header().Set("Content-Disposition", "attachment; filename="+exportFilename(asOf, "json"))
 
 	if err := json.NewEncoder(w).Encode(devices); err != nil {
 		log.Printf("Error encoding devices to JSON: %v", err)
@@ -737,35 +1437,20 @@ func ExportJSONHandler(w http.ResponseWriter, r *http.Request) {
 
 // ScanSubnetHandler pings all IPs in the subnet and returns active ones
 func ScanSubnetHandler(w http.ResponseWriter, r *http.Request) {
+	subnets, err := db.GetAllSubnets()
+	if err != nil {
+		http.Error(w, "Could not fetch subnets", http.StatusInternalServerError)
+		return
+	}
 	devices, err := db.GetAllDevices()
 	if err != nil {
 		http.Error(w, "Could not fetch devices", http.StatusInternalServerError)
 		return
 	}
 
-	// Infer subnet (simplified logic from HomeHandler)
-	subnetCounts := make(map[string]int)
-	for _, d := range devices {
-		for _, iface := range d.Interfaces {
-			ipRaw := strings.ReplaceAll(iface.IPAddress, " ", "")
-			parts := strings.Split(ipRaw, ".")
-			if len(parts) == 4 {
-				subnet := strings.Join(parts[:3], ".")
-				subnetCounts[subnet]++
-			}
-		}
-	}
-	targetSubnet := os.Getenv("IP_RANGE_START")
-	if targetSubnet == "" {
-		targetSubnet = "192.168.1"
-	}
-	maxCount := 1 // Require at least 2 devices to override default
-	for subnet, count := range subnetCounts {
-		if count > maxCount {
-			maxCount = count
-			targetSubnet = subnet
-		}
-	}
+	// Scan exactly the addresses shown on the IP map (all hosts, or the sparse view)
+	subnet := resolveSubnet(r, subnets)
+	ipMap, _ := buildIPMap(subnet, usedAddresses(devices, nil, subnet.VRFID), nil)
 
 	// Concurrent Scan
 	var wg sync.WaitGroup
@@ -775,17 +1460,15 @@ func ScanSubnetHandler(w http.ResponseWriter, r *http.Request) {
 	// Semaphore to limit concurrency (max 20 pings at once)
 	sem := make(chan struct{}, 20)
 
-	for i := 1; i <= 254; i++ {
+	for _, entry := range ipMap {
 		wg.Add(1)
-		go func(octet int) {
+		go func(ip string) {
 			defer wg.Done()
 
 			// Acquire semaphore
 			sem <- struct{}{}
 			defer func() { <-sem }()
 
-			ip := fmt.Sprintf("%s.%d", targetSubnet, octet)
-
 			// Short timeout for scan: 1 count, 200-500ms timeout equivalent
 			// On macOS ping -W is in milliseconds, on Linux it might be different.
 			// Go's exec.Command context is cleaner for timeout.
@@ -796,7 +1479,7 @@ func ScanSubnetHandler(w http.ResponseWriter, r *http.Request) {
 			// On macOS 15: -W waittime (in milliseconds). standard ping is often -t for timeout?
 			// Let's rely on Go Context timeout to kill the process.
 
-			cmd := exec.Command("ping", "-c", "1", ip)
+			cmd := pingCommand(ip, 1)
 			// On macOS sending SIGKILL via context cancellation is safest to ensure speed.
 
 			// Assuming network is fast, we allow 300ms.
@@ -831,7 +1514,7 @@ func ScanSubnetHandler(w http.ResponseWriter, r *http.Request) {
 					mutex.Unlock()
 				}
 			}
-		}(i)
+		}(entry.IP)
 	}
 
 	wg.Wait()
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestActor(t *testing.T) {
+	defer SetTrustedProxies("")
+	if err := SetTrustedProxies("10.0.0.5, 172.16.0.0/12, 2001:db8::1"); err != nil {
+		t.Fatalf("SetTrustedProxies: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{name: "trusted proxy", remote: "10.0.0.5:4000", headers: map[string]string{"X-Forwarded-User": "alice"}, want: "alice"},
+		{name: "trusted proxy range", remote: "172.20.1.1:4000", headers: map[string]string{"Remote-User": "bob"}, want: "bob"},
+		{name: "trusted IPv6 proxy", remote: "[2001:db8::1]:4000", headers: map[string]string{"X-Forwarded-User": "carol"}, want: "carol"},
+		{name: "trusted proxy without user", remote: "10.0.0.5:4000", want: "10.0.0.5"},
+		{name: "untrusted client", remote: "192.168.1.20:4000", headers: map[string]string{"X-Forwarded-User": "alice"}, want: "192.168.1.20"},
+		{name: "no port", remote: "192.168.1.20", want: "192.168.1.20"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/update", nil)
+		r.RemoteAddr = tt.remote
+		for k, v := range tt.headers {
+			r.Header.Set(k, v)
+		}
+		if got := requestActor(r); got != tt.want {
+			t.Errorf("%s: requestActor = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSetTrustedProxies(t *testing.T) {
+	defer SetTrustedProxies("")
+	tests := []struct {
+		spec    string
+		want    int
+		wantErr bool
+	}{
+		{spec: "", want: 0},
+		{spec: "10.0.0.5", want: 1},
+		{spec: "10.0.0.0/8, ,::1", want: 2},
+		{spec: "proxy.local", wantErr: true},
+		{spec: "10.0.0.0/33", wantErr: true},
+	}
+	for _, tt := range tests {
+		err := SetTrustedProxies(tt.spec)
+		if (err != nil) != tt.wantErr || (err == nil && len(trustedProxies) != tt.want) {
+			t.Errorf("SetTrustedProxies(%q) = %v with %v, want %d proxies, error %v", tt.spec, err, trustedProxies, tt.want, tt.wantErr)
+		}
+	}
+}
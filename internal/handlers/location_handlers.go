@@ -0,0 +1,517 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"ipam/internal/db"
+	"ipam/internal/models"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// filterBySite keeps the devices in a rack of the site, and the racks of the site.
+// A zero site keeps everything.
+func filterBySite(devices []models.Device, racks []models.Rack, siteID int) ([]models.Device, []models.Rack) {
+	if siteID == 0 {
+		return devices, racks
+	}
+	inSite := make(map[int]bool)
+	var siteRacks []models.Rack
+	for _, rack := range racks {
+		if rack.SiteID == siteID {
+			inSite[rack.ID] = true
+			siteRacks = append(siteRacks, rack)
+		}
+	}
+
+	var filtered []models.Device
+	for _, d := range devices {
+		if inSite[d.RackID] {
+			filtered = append(filtered, d)
+		}
+	}
+	return filtered, siteRacks
+}
+
+// SitesData is the data rendered by sites.html
+type SitesData struct {
+	Regions []models.Region
+	Sites   []models.Site
+	Rooms   []models.Room
+	Racks   []models.Rack
+}
+
+// RackCount returns the number of racks in a site
+func (d SitesData) RackCount(siteID int) int {
+	count := 0
+	for _, r := range d.Racks {
+		if r.SiteID == siteID {
+			count++
+		}
+	}
+	return count
+}
+
+// RoomRackCount returns the number of racks in a room
+func (d SitesData) RoomRackCount(roomID int) int {
+	count := 0
+	for _, r := range d.Racks {
+		if r.RoomID == roomID {
+			count++
+		}
+	}
+	return count
+}
+
+// SiteCount returns the number of sites in a region
+func (d SitesData) SiteCount(regionID int) int {
+	count := 0
+	for _, s := range d.Sites {
+		if s.RegionID == regionID {
+			count++
+		}
+	}
+	return count
+}
+
+// SitesHandler lists regions, sites and rooms
+func SitesHandler(w http.ResponseWriter, r *http.Request) {
+	var data SitesData
+	var err error
+	if data.Regions, err = db.GetAllRegions(); err != nil {
+		log.Printf("Error fetching regions: %v", err)
+		http.Error(w, "Could not fetch regions", http.StatusInternalServerError)
+		return
+	}
+	if data.Sites, err = db.GetAllSites(); err != nil {
+		log.Printf("Error fetching sites: %v", err)
+		http.Error(w, "Could not fetch sites", http.StatusInternalServerError)
+		return
+	}
+	if data.Rooms, err = db.GetAllRooms(); err != nil {
+		log.Printf("Error fetching rooms: %v", err)
+		http.Error(w, "Could not fetch rooms", http.StatusInternalServerError)
+		return
+	}
+	if data.Racks, err = db.GetAllRacks(); err != nil {
+		log.Printf("Error fetching racks: %v", err)
+		http.Error(w, "Could not fetch racks", http.StatusInternalServerError)
+		return
+	}
+
+	render(w, "sites.html", data)
+}
+
+// locationStatus maps a save error to its HTTP status: duplicate names are conflicts
+func locationStatus(err error) int {
+	if errors.Is(err, db.ErrRegionExists) || errors.Is(err, db.ErrSiteExists) || errors.Is(err, db.ErrRoomExists) {
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// Regions
+
+func AddRegionHandler(w http.ResponseWriter, r *http.Request) {
+	render(w, "region_form.html", models.Region{})
+}
+
+func CreateRegionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/add-region", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	region := models.Region{
+		Name:        strings.TrimSpace(r.FormValue("name")),
+		Description: strings.TrimSpace(r.FormValue("description")),
+	}
+	if region.Name == "" {
+		http.Error(w, "Region name is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.AddRegion(region); err != nil {
+		log.Printf("Error adding region: %v", err)
+		http.Error(w, "Error adding region: "+err.Error(), locationStatus(err))
+		return
+	}
+
+	http.Redirect(w, r, "/sites", http.StatusSeeOther)
+}
+
+func EditRegionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid region ID", http.StatusBadRequest)
+		return
+	}
+
+	region, err := db.GetRegion(id)
+	if err != nil {
+		http.Error(w, "Region not found", http.StatusNotFound)
+		return
+	}
+
+	render(w, "region_form.html", region)
+}
+
+func UpdateRegionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/sites", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid region ID", http.StatusBadRequest)
+		return
+	}
+	region := models.Region{
+		ID:          id,
+		Name:        strings.TrimSpace(r.FormValue("name")),
+		Description: strings.TrimSpace(r.FormValue("description")),
+	}
+	if region.Name == "" {
+		http.Error(w, "Region name is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.UpdateRegion(region); err != nil {
+		log.Printf("Error updating region: %v", err)
+		http.Error(w, "Error updating region: "+err.Error(), locationStatus(err))
+		return
+	}
+
+	http.Redirect(w, r, "/sites", http.StatusSeeOther)
+}
+
+func DeleteRegionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/sites", http.StatusSeeOther)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid region ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.DeleteRegion(id); err != nil {
+		log.Printf("Error deleting region: %v", err)
+		http.Error(w, "Error deleting region", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/sites", http.StatusSeeOther)
+}
+
+// Sites
+
+// SiteFormData is the data rendered by site_form.html
+type SiteFormData struct {
+	Site    models.Site
+	Regions []models.Region
+}
+
+func renderSiteForm(w http.ResponseWriter, site models.Site) {
+	regions, err := db.GetAllRegions()
+	if err != nil {
+		log.Printf("Error fetching regions: %v", err)
+		http.Error(w, "Error loading form", http.StatusInternalServerError)
+		return
+	}
+	render(w, "site_form.html", SiteFormData{Site: site, Regions: regions})
+}
+
+// siteFromForm reads the site form, checking that the chosen region exists
+func siteFromForm(r *http.Request) (models.Site, error) {
+	regionID, _ := strconv.Atoi(r.FormValue("region_id"))
+	site := models.Site{
+		Name:        strings.TrimSpace(r.FormValue("name")),
+		RegionID:    regionID,
+		Description: strings.TrimSpace(r.FormValue("description")),
+	}
+	if site.Name == "" {
+		return site, errors.New("site name is required")
+	}
+	if site.RegionID != 0 {
+		if _, err := db.GetRegion(site.RegionID); err != nil {
+			return site, errors.New("the selected region does not exist")
+		}
+	}
+	return site, nil
+}
+
+func AddSiteHandler(w http.ResponseWriter, r *http.Request) {
+	regionID, _ := strconv.Atoi(r.URL.Query().Get("region_id"))
+	renderSiteForm(w, models.Site{RegionID: regionID})
+}
+
+func CreateSiteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/add-site", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	site, err := siteFromForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := db.AddSite(site); err != nil {
+		log.Printf("Error adding site: %v", err)
+		http.Error(w, "Error adding site: "+err.Error(), locationStatus(err))
+		return
+	}
+
+	http.Redirect(w, r, "/sites", http.StatusSeeOther)
+}
+
+func EditSiteHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid site ID", http.StatusBadRequest)
+		return
+	}
+
+	site, err := db.GetSite(id)
+	if err != nil {
+		http.Error(w, "Site not found", http.StatusNotFound)
+		return
+	}
+
+	renderSiteForm(w, site)
+}
+
+func UpdateSiteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/sites", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid site ID", http.StatusBadRequest)
+		return
+	}
+	site, err := siteFromForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	site.ID = id
+
+	if err := db.UpdateSite(site); err != nil {
+		log.Printf("Error updating site: %v", err)
+		http.Error(w, "Error updating site: "+err.Error(), locationStatus(err))
+		return
+	}
+
+	http.Redirect(w, r, "/sites", http.StatusSeeOther)
+}
+
+func DeleteSiteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/sites", http.StatusSeeOther)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid site ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.DeleteSite(id, requestActor(r)); err != nil {
+		log.Printf("Error deleting site: %v", err)
+		http.Error(w, "Error deleting site", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/sites", http.StatusSeeOther)
+}
+
+// MergeSiteHandler moves the racks and rooms of a site into another one and deletes it
+func MergeSiteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/sites", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	fromID, err1 := strconv.Atoi(r.FormValue("id"))
+	intoID, err2 := strconv.Atoi(r.FormValue("into_id"))
+	if err1 != nil || err2 != nil {
+		http.Error(w, "Invalid site ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.MergeSite(fromID, intoID, requestActor(r)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Site not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error merging site %d into %d: %v", fromID, intoID, err)
+		http.Error(w, "Error merging sites: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, "/sites", http.StatusSeeOther)
+}
+
+// Rooms
+
+// RoomFormData is the data rendered by room_form.html
+type RoomFormData struct {
+	Room  models.Room
+	Sites []models.Site
+}
+
+func renderRoomForm(w http.ResponseWriter, room models.Room) {
+	sites, err := db.GetAllSites()
+	if err != nil {
+		log.Printf("Error fetching sites: %v", err)
+		http.Error(w, "Error loading form", http.StatusInternalServerError)
+		return
+	}
+	render(w, "room_form.html", RoomFormData{Room: room, Sites: sites})
+}
+
+// roomFromForm reads the room form, checking that the chosen site exists
+func roomFromForm(r *http.Request) (models.Room, error) {
+	siteID, _ := strconv.Atoi(r.FormValue("site_id"))
+	room := models.Room{
+		SiteID:      siteID,
+		Name:        strings.TrimSpace(r.FormValue("name")),
+		Description: strings.TrimSpace(r.FormValue("description")),
+	}
+	if room.Name == "" {
+		return room, errors.New("room name is required")
+	}
+	if _, err := db.GetSite(room.SiteID); err != nil {
+		return room, errors.New("a room must be in an existing site")
+	}
+	return room, nil
+}
+
+func AddRoomHandler(w http.ResponseWriter, r *http.Request) {
+	siteID, _ := strconv.Atoi(r.URL.Query().Get("site_id"))
+	renderRoomForm(w, models.Room{SiteID: siteID})
+}
+
+func CreateRoomHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/add-room", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	room, err := roomFromForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := db.AddRoom(room); err != nil {
+		log.Printf("Error adding room: %v", err)
+		http.Error(w, "Error adding room: "+err.Error(), locationStatus(err))
+		return
+	}
+
+	http.Redirect(w, r, "/sites", http.StatusSeeOther)
+}
+
+func EditRoomHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	room, err := db.GetRoom(id)
+	if err != nil {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	renderRoomForm(w, room)
+}
+
+func UpdateRoomHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/sites", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+	room, err := roomFromForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	room.ID = id
+
+	if err := db.UpdateRoom(room, requestActor(r)); err != nil {
+		log.Printf("Error updating room: %v", err)
+		http.Error(w, "Error updating room: "+err.Error(), locationStatus(err))
+		return
+	}
+
+	http.Redirect(w, r, "/sites", http.StatusSeeOther)
+}
+
+func DeleteRoomHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/sites", http.StatusSeeOther)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.DeleteRoom(id, requestActor(r)); err != nil {
+		log.Printf("Error deleting room: %v", err)
+		http.Error(w, "Error deleting room", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/sites", http.StatusSeeOther)
+}
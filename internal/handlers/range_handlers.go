@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"fmt"
+	"ipam/internal/db"
+	"ipam/internal/models"
+	"ipam/internal/netutil"
+	"log"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// rangeSpan is an IP range with its bounds parsed, for address lookups
+type rangeSpan struct {
+	Range      models.IPRange
+	start, end netip.Addr
+}
+
+// parseRanges keeps the ranges of one VRF and parses their bounds
+func parseRanges(ranges []models.IPRange, vrfID int) []rangeSpan {
+	var spans []rangeSpan
+	for _, r := range ranges {
+		if r.VRFID != vrfID {
+			continue
+		}
+		start, err1 := netutil.ParseAddr(r.StartIP)
+		end, err2 := netutil.ParseAddr(r.EndIP)
+		if err1 == nil && err2 == nil {
+			spans = append(spans, rangeSpan{Range: r, start: start, end: end})
+		}
+	}
+	return spans
+}
+
+// rangeAt returns the range holding addr, if any. Ranges never overlap within a VRF.
+func rangeAt(spans []rangeSpan, addr netip.Addr) (models.IPRange, bool) {
+	for _, s := range spans {
+		if addr.Compare(s.start) >= 0 && addr.Compare(s.end) <= 0 {
+			return s.Range, true
+		}
+	}
+	return models.IPRange{}, false
+}
+
+// poolWarnings lists the interface addresses that sit inside a DHCP pool. Addresses already
+// held by the device (existing) are not reported again.
+func poolWarnings(ifaces, existing []models.DeviceInterface) ([]string, error) {
+	ranges, err := db.GetAllRanges()
+	if err != nil {
+		return nil, err
+	}
+
+	held := make(map[string]bool)
+	for _, iface := range existing {
+		held[fmt.Sprintf("%d/%s", iface.VRFID, iface.IPAddress)] = true
+	}
+
+	var warnings []string
+	for _, iface := range ifaces {
+		vrfID := iface.VRFID
+		if vrfID == 0 {
+			vrfID = db.GlobalVRFID
+		}
+		addr, err := netutil.ParseAddr(iface.IPAddress)
+		if err != nil || held[fmt.Sprintf("%d/%s", vrfID, addr)] {
+			continue
+		}
+		if r, ok := rangeAt(parseRanges(ranges, vrfID), addr); ok && r.Type == "DHCP" {
+			warnings = append(warnings, fmt.Sprintf("%s is inside the DHCP pool %s-%s of %s; a static assignment may collide with a lease.",
+				addr, r.StartIP, r.EndIP, r.SubnetCIDR))
+		}
+	}
+	return warnings, nil
+}
+
+// RangeFormData is the data rendered by range_form.html
+type RangeFormData struct {
+	Range   models.IPRange
+	Subnets []models.Subnet
+	Types   []string
+}
+
+func renderRangeForm(w http.ResponseWriter, r models.IPRange) {
+	subnets, err := db.GetAllSubnets()
+	if err != nil {
+		log.Printf("Error fetching subnets: %v", err)
+		http.Error(w, "Error loading form", http.StatusInternalServerError)
+		return
+	}
+	render(w, "range_form.html", RangeFormData{Range: r, Subnets: subnets, Types: db.RangeTypes})
+}
+
+// RangesHandler lists all IP ranges grouped by subnet
+func RangesHandler(w http.ResponseWriter, r *http.Request) {
+	subnets, err := db.GetAllSubnets()
+	if err != nil {
+		log.Printf("Error fetching subnets: %v", err)
+		http.Error(w, "Could not fetch subnets", http.StatusInternalServerError)
+		return
+	}
+	ranges, err := db.GetAllRanges()
+	if err != nil {
+		log.Printf("Error fetching IP ranges: %v", err)
+		http.Error(w, "Could not fetch IP ranges", http.StatusInternalServerError)
+		return
+	}
+
+	type subnetRanges struct {
+		Subnet models.Subnet
+		Ranges []models.IPRange
+	}
+	var groups []subnetRanges
+	for _, s := range subnets {
+		group := subnetRanges{Subnet: s}
+		for _, rng := range ranges {
+			if rng.SubnetID == s.ID {
+				group.Ranges = append(group.Ranges, rng)
+			}
+		}
+		if len(group.Ranges) > 0 {
+			groups = append(groups, group)
+		}
+	}
+
+	render(w, "ranges.html", groups)
+}
+
+func AddRangeHandler(w http.ResponseWriter, r *http.Request) {
+	subnetID, _ := strconv.Atoi(r.URL.Query().Get("subnet"))
+	renderRangeForm(w, models.IPRange{SubnetID: subnetID, Type: "DHCP"})
+}
+
+func CreateRangeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/add-range", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.AddRange(rangeFromForm(r)); err != nil {
+		log.Printf("Error adding IP range: %v", err)
+		http.Error(w, "Error adding IP range: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, "/ranges", http.StatusSeeOther)
+}
+
+func EditRangeHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid range ID", http.StatusBadRequest)
+		return
+	}
+
+	rng, err := db.GetRange(id)
+	if err != nil {
+		http.Error(w, "IP range not found", http.StatusNotFound)
+		return
+	}
+
+	renderRangeForm(w, rng)
+}
+
+func UpdateRangeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/ranges", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid range ID", http.StatusBadRequest)
+		return
+	}
+
+	rng := rangeFromForm(r)
+	rng.ID = id
+	if err := db.UpdateRange(rng); err != nil {
+		log.Printf("Error updating IP range: %v", err)
+		http.Error(w, "Error updating IP range: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, "/ranges", http.StatusSeeOther)
+}
+
+func DeleteRangeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/ranges", http.StatusSeeOther)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid range ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.DeleteRange(id); err != nil {
+		log.Printf("Error deleting IP range: %v", err)
+		http.Error(w, "Error deleting IP range", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/ranges", http.StatusSeeOther)
+}
+
+func rangeFromForm(r *http.Request) models.IPRange {
+	subnetID, _ := strconv.Atoi(r.FormValue("subnet_id"))
+	return models.IPRange{
+		SubnetID:    subnetID,
+		StartIP:     strings.TrimSpace(r.FormValue("start_ip")),
+		EndIP:       strings.TrimSpace(r.FormValue("end_ip")),
+		Type:        r.FormValue("type"),
+		Description: r.FormValue("description"),
+	}
+}
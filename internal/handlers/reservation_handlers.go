@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"ipam/internal/db"
+	"ipam/internal/lifecycle"
+	"ipam/internal/models"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// expiringSoonWindow is how far ahead the dashboard looks for reservations about to expire
+const expiringSoonWindow = 7 * 24 * time.Hour
+
+// expiryInputLayout is the format of <input type="datetime-local"> values
+const expiryInputLayout = "2006-01-02T15:04"
+
+// ExpiringReservation is a reservation listed on the dashboard because it expires soon
+type ExpiringReservation struct {
+	Device  models.Device
+	Left    string // Time left, e.g. "3h" or "2d"
+	Overdue bool   // Expired, waiting for the reaper
+}
+
+// expiringReservations returns the reservations expiring within the window, soonest first
+func expiringReservations(devices []models.Device, now time.Time) []ExpiringReservation {
+	var expiring []ExpiringReservation
+	for _, d := range devices {
+		if !lifecycle.IsReservation(d.Status) || d.ExpiresAt == nil || d.ExpiresAt.Sub(now) > expiringSoonWindow {
+			continue
+		}
+		left := d.ExpiresAt.Sub(now)
+		expiring = append(expiring, ExpiringReservation{Device: d, Left: durationLabel(left), Overdue: left <= 0})
+	}
+	sort.Slice(expiring, func(i, j int) bool {
+		return expiring[i].Device.ExpiresAt.Before(*expiring[j].Device.ExpiresAt)
+	})
+	return expiring
+}
+
+// durationLabel formats a duration coarsely, e.g. "45m", "3h" or "2d"
+func durationLabel(d time.Duration) string {
+	switch {
+	case d <= 0:
+		return "expired"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes())+1)
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	}
+	return fmt.Sprintf("%dd", int(d.Hours()/24))
+}
+
+// expiresFromForm reads the optional reservation expiry of the device form (local time).
+// An expiry in the past is refused unless it is the previous, unchanged value.
+func expiresFromForm(r *http.Request, previous *time.Time) (*time.Time, error) {
+	value := strings.TrimSpace(r.FormValue("expires_at"))
+	if value == "" || !lifecycle.IsReservation(lifecycle.Normalize(r.FormValue("status"))) {
+		return nil, nil
+	}
+	t, err := time.ParseInLocation(expiryInputLayout, value, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expiry %q", value)
+	}
+	if previous != nil && previous.Format(expiryInputLayout) == value {
+		return previous, nil
+	}
+	if !t.After(time.Now()) {
+		return nil, db.ErrExpiryInPast
+	}
+	return &t, nil
+}
+
+// extendedExpiry adds days to the current expiry, or to now if the reservation has none or is overdue
+func extendedExpiry(d models.Device, days int) time.Time {
+	from := time.Now()
+	if d.ExpiresAt != nil && d.ExpiresAt.After(from) {
+		from = *d.ExpiresAt
+	}
+	return from.AddDate(0, 0, days)
+}
+
+// ExtendReservationHandler pushes back the expiry of a reservation by a number of days
+func ExtendReservationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid device ID", http.StatusBadRequest)
+		return
+	}
+	days, err := strconv.Atoi(r.FormValue("days"))
+	if err != nil || days < 1 {
+		http.Error(w, "Invalid number of days", http.StatusBadRequest)
+		return
+	}
+
+	device, err := db.GetDevice(id)
+	if err != nil {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return
+	}
+	// The new expiry is counted from the one just read
+	if err := db.ExtendReservation(id, extendedExpiry(device, days), device.Version, requestActor(r)); err != nil {
+		log.Printf("Error extending reservation %d: %v", id, err)
+		http.Error(w, "Error extending reservation: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+type extendReservationRequest struct {
+	DeviceID  int    `json:"device_id"`
+	Days      int    `json:"days"`
+	ExpiresAt string `json:"expires_at"` // RFC 3339, used instead of days when set
+}
+
+// ExtendReservationAPIHandler moves the expiry of a reservation, either by a number of days
+// (counted from the current expiry, or from now once it has passed) or to a given time.
+// With an If-Match header, it is only extended if the device is still at that version (412 otherwise).
+//
+//	POST /api/extend-reservation  {"device_id": 3, "days": 7}
+func ExtendReservationAPIHandler(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Success   bool       `json:"success"`
+		DeviceID  int        `json:"device_id,omitempty"`
+		ExpiresAt *time.Time `json:"expires_at,omitempty"`
+		Error     string     `json:"error,omitempty"`
+	}
+
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, response{Error: "Method not allowed"})
+		return
+	}
+
+	var req extendReservationRequest
+	err := decodeRequest(r, &req, func() {
+		req.DeviceID, _ = strconv.Atoi(r.FormValue("device_id"))
+		req.Days, _ = strconv.Atoi(r.FormValue("days"))
+		req.ExpiresAt = r.FormValue("expires_at")
+	})
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, response{Error: "Invalid request body"})
+		return
+	}
+	if req.DeviceID == 0 || (req.Days < 1 && req.ExpiresAt == "") {
+		writeJSON(w, http.StatusBadRequest, response{Error: "device_id and either days or expires_at are required"})
+		return
+	}
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, response{Error: err.Error()})
+		return
+	}
+
+	device, err := db.GetDevice(req.DeviceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusNotFound, response{Error: "Device not found"})
+		return
+	} else if err != nil {
+		writeJSON(w, http.StatusInternalServerError, response{Error: "Could not fetch device"})
+		return
+	}
+
+	until := extendedExpiry(device, req.Days)
+	if req.ExpiresAt != "" {
+		if until, err = time.Parse(time.RFC3339, req.ExpiresAt); err != nil {
+			writeJSON(w, http.StatusBadRequest, response{Error: "expires_at must be an RFC 3339 timestamp"})
+			return
+		}
+	}
+
+	if err := db.ExtendReservation(req.DeviceID, until, version, requestActor(r)); err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case errors.Is(err, db.ErrNotReserved):
+			status = http.StatusConflict
+		case errors.Is(err, db.ErrStale):
+			status = http.StatusPreconditionFailed
+			setDeviceETag(w, req.DeviceID)
+		}
+		log.Printf("Error extending reservation %d: %v", req.DeviceID, err)
+		writeJSON(w, status, response{Error: err.Error()})
+		return
+	}
+
+	setDeviceETag(w, req.DeviceID)
+	writeJSON(w, http.StatusOK, response{Success: true, DeviceID: req.DeviceID, ExpiresAt: &until})
+}
@@ -0,0 +1,623 @@
+package handlers
+
+import (
+	"errors"
+	"ipam/internal/db"
+	"ipam/internal/lifecycle"
+	"ipam/internal/models"
+	"ipam/internal/netutil"
+	"log"
+	"maps"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxIPMapEntries caps how many addresses are rendered (and scanned) for a single subnet
+const maxIPMapEntries = 1024
+
+// sparseFreeEntries is how many free addresses the sparse IP map suggests
+const sparseFreeEntries = 16
+
+type SubnetSummary struct {
+	Subnet       models.Subnet
+	TotalIPs     int
+	UsedIPs      int // Addresses held by non-reserved devices
+	ReservedIPs  int // Addresses held by planned devices or reserved address records
+	FreeIPs      int
+	UsagePercent int
+	Large        bool   // Too many addresses to count exactly (e.g. IPv6 /64)
+	TotalLabel   string // TotalIPs formatted for display
+	FreeLabel    string // FreeIPs formatted for display
+}
+
+// SubnetNode is a subnet with its utilization and the subnets nested directly inside it
+type SubnetNode struct {
+	SubnetSummary
+	Children []*SubnetNode
+}
+
+// VRFTree is the subnet forest of a single VRF
+type VRFTree struct {
+	VRF   models.VRF
+	Roots []*SubnetNode
+}
+
+// defaultSubnet is used when no subnet has been defined yet.
+// It honours the legacy IP_RANGE_START setting (e.g. "192.168.1").
+func defaultSubnet() models.Subnet {
+	base := os.Getenv("IP_RANGE_START")
+	if base == "" {
+		base = "192.168.1"
+	}
+	return models.Subnet{
+		CIDR:   base + ".0/24",
+		Name:   "Default",
+		Status: "Active",
+		VRFID:  db.GlobalVRFID,
+	}
+}
+
+// resolveSubnet picks the subnet requested via ?subnet=<id>, falling back to the
+// first active subnet, the first defined subnet, then the legacy default.
+func resolveSubnet(r *http.Request, subnets []models.Subnet) models.Subnet {
+	if id, err := strconv.Atoi(r.URL.Query().Get("subnet")); err == nil {
+		for _, s := range subnets {
+			if s.ID == id {
+				return s
+			}
+		}
+	}
+	for _, s := range subnets {
+		if s.Status == "Active" {
+			return s
+		}
+	}
+	if len(subnets) > 0 {
+		return subnets[0]
+	}
+	return defaultSubnet()
+}
+
+// addressUsage records what holds each address of a VRF
+type addressUsage struct {
+	Devices map[netip.Addr]models.Device
+	Records map[netip.Addr]models.IPAddress // Standalone records whose address no interface carries
+}
+
+// taken reports whether a device or an address record holds addr
+func (u addressUsage) taken(addr netip.Addr) bool {
+	_, device := u.Devices[addr]
+	_, record := u.Records[addr]
+	return device || record
+}
+
+// usedAddresses indexes devices by the canonical form of each interface IP in a VRF,
+// and address records by their address
+func usedAddresses(devices []models.Device, records []models.IPAddress, vrfID int) addressUsage {
+	used := addressUsage{
+		Devices: make(map[netip.Addr]models.Device),
+		Records: make(map[netip.Addr]models.IPAddress),
+	}
+	for _, d := range devices {
+		for _, iface := range d.Interfaces {
+			if iface.VRFID != vrfID {
+				continue
+			}
+			addr, err := netutil.ParseAddr(iface.IPAddress)
+			if err != nil {
+				continue
+			}
+			used.Devices[addr] = d
+		}
+	}
+	for _, rec := range records {
+		if rec.VRFID != vrfID {
+			continue
+		}
+		addr, err := netutil.ParseAddr(rec.Address)
+		if err != nil {
+			continue
+		}
+		if _, carried := used.Devices[addr]; !carried {
+			used.Records[addr] = rec
+		}
+	}
+	return used
+}
+
+// countUsed returns the number of distinct used and reserved addresses inside a prefix
+func countUsed(prefix netip.Prefix, used addressUsage) (inUse, reserved int) {
+	for addr, device := range used.Devices {
+		if !prefix.Contains(addr) {
+			continue
+		}
+		if lifecycle.IsReservation(device.Status) {
+			reserved++
+		} else {
+			inUse++
+		}
+	}
+	for addr, rec := range used.Records {
+		if !prefix.Contains(addr) {
+			continue
+		}
+		if rec.Status == "Reserved" {
+			reserved++
+		} else {
+			inUse++
+		}
+	}
+	return inUse, reserved
+}
+
+// buildIPMap builds the IP map for a subnet. Subnets with up to maxIPMapEntries hosts are
+// rendered in full; larger ones (e.g. IPv6 /64s) get a sparse view listing the assigned
+// addresses, the gateway and the first few free addresses, in address order.
+// Addresses inside one of the IP ranges are tagged with the range type.
+func buildIPMap(subnet models.Subnet, used addressUsage, ranges []models.IPRange) (ipMap []IPStatus, sparse bool) {
+	prefix, err := netutil.ParsePrefix(subnet.CIDR)
+	if err != nil {
+		return nil, false
+	}
+	gateway, _ := netip.ParseAddr(subnet.Gateway)
+	spans := parseRanges(ranges, subnet.VRFID)
+
+	entry := func(addr netip.Addr) IPStatus {
+		status := IPStatus{
+			IP:     addr.String(),
+			Label:  netutil.HostLabel(prefix, addr),
+			Status: "Free",
+		}
+		if r, ok := rangeAt(spans, addr); ok {
+			status.Range = r.Type
+		}
+		if device, exists := used.Devices[addr]; exists {
+			status.DeviceID = device.ID
+			status.Hostname = device.Hostname
+			status.Status = "Used"
+
+			// Check if specifically reserved
+			if lifecycle.IsReservation(device.Status) {
+				status.Status = "Reserved"
+			}
+		} else if rec, exists := used.Records[addr]; exists {
+			status.Status = "Address"
+			status.AddressID = rec.ID
+			status.AddressStatus = rec.Status
+			status.Hostname = rec.DNSName
+			if status.Hostname == "" {
+				status.Hostname = rec.Purpose
+			}
+		} else if addr == gateway {
+			status.Status = "Gateway"
+			status.Hostname = "Gateway"
+		}
+		return status
+	}
+
+	first, last := netutil.HostBounds(prefix)
+	inRange := func(addr netip.Addr) bool {
+		return addr.Compare(first) >= 0 && addr.Compare(last) <= 0
+	}
+
+	if !netutil.IsLarge(prefix) && netutil.HostCount(prefix) <= maxIPMapEntries {
+		for addr := first; addr.IsValid() && inRange(addr); addr = addr.Next() {
+			ipMap = append(ipMap, entry(addr))
+		}
+		return ipMap, false
+	}
+
+	var addrs []netip.Addr
+	for addr := range used.Devices {
+		if inRange(addr) {
+			addrs = append(addrs, addr)
+		}
+	}
+	for addr := range used.Records {
+		if inRange(addr) {
+			addrs = append(addrs, addr)
+		}
+	}
+	if gateway.IsValid() && inRange(gateway) && !used.taken(gateway) {
+		addrs = append(addrs, gateway)
+	}
+	free := 0
+	for addr := first; addr.IsValid() && inRange(addr) && free < sparseFreeEntries; addr = addr.Next() {
+		if used.taken(addr) || addr == gateway {
+			continue
+		}
+		addrs = append(addrs, addr)
+		free++
+	}
+
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i].Compare(addrs[j]) < 0 })
+	if len(addrs) > maxIPMapEntries {
+		addrs = addrs[:maxIPMapEntries]
+	}
+	for _, addr := range addrs {
+		ipMap = append(ipMap, entry(addr))
+	}
+	return ipMap, true
+}
+
+func summarizeSubnet(subnet models.Subnet, used addressUsage) SubnetSummary {
+	summary := SubnetSummary{Subnet: subnet}
+	prefix, err := netutil.ParsePrefix(subnet.CIDR)
+	if err != nil {
+		return summary
+	}
+	summary.TotalIPs = netutil.HostCount(prefix)
+	summary.UsedIPs, summary.ReservedIPs = countUsed(prefix, used)
+	summary.FreeIPs = max(summary.TotalIPs-summary.UsedIPs-summary.ReservedIPs, 0)
+	if summary.TotalIPs > 0 {
+		summary.UsagePercent = int(int64(summary.UsedIPs+summary.ReservedIPs) * 100 / int64(summary.TotalIPs))
+	}
+	summary.Large = netutil.IsLarge(prefix)
+	summary.TotalLabel = netutil.SizeLabel(prefix, summary.TotalIPs)
+	summary.FreeLabel = netutil.SizeLabel(prefix, summary.FreeIPs)
+	return summary
+}
+
+// buildSubnetTree arranges subnets (already sorted, with ParentID computed by the db layer)
+// into a forest. Counts on each node cover the whole prefix, so they include its children.
+func buildSubnetTree(subnets []models.Subnet, devices []models.Device, records []models.IPAddress) []*SubnetNode {
+	usedByVRF := make(map[int]addressUsage)
+	nodes := make(map[int]*SubnetNode)
+	var roots []*SubnetNode
+	for _, s := range subnets {
+		used, ok := usedByVRF[s.VRFID]
+		if !ok {
+			used = usedAddresses(devices, records, s.VRFID)
+			usedByVRF[s.VRFID] = used
+		}
+		node := &SubnetNode{SubnetSummary: summarizeSubnet(s, used)}
+		nodes[s.ID] = node
+		if parent, ok := nodes[s.ParentID]; ok {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+	return roots
+}
+
+// subnetAncestors returns the chain of parents of a subnet, outermost first
+func subnetAncestors(subnet models.Subnet, subnets []models.Subnet) []models.Subnet {
+	byID := make(map[int]models.Subnet)
+	for _, s := range subnets {
+		byID[s.ID] = s
+	}
+
+	var chain []models.Subnet
+	for parentID := subnet.ParentID; parentID != 0; {
+		parent, ok := byID[parentID]
+		if !ok {
+			break
+		}
+		chain = append([]models.Subnet{parent}, chain...)
+		parentID = parent.ParentID
+	}
+	return chain
+}
+
+// SubnetsHandler lists all defined subnets with their utilization, one tree per VRF
+func SubnetsHandler(w http.ResponseWriter, r *http.Request) {
+	subnets, err := db.GetAllSubnets()
+	if err != nil {
+		log.Printf("Error fetching subnets: %v", err)
+		http.Error(w, "Could not fetch subnets", http.StatusInternalServerError)
+		return
+	}
+	devices, err := db.GetAllDevices()
+	if err != nil {
+		http.Error(w, "Could not fetch devices", http.StatusInternalServerError)
+		return
+	}
+	vrfs, err := db.GetAllVRFs()
+	if err != nil {
+		http.Error(w, "Could not fetch VRFs", http.StatusInternalServerError)
+		return
+	}
+	records, err := db.GetAllIPAddresses()
+	if err != nil {
+		http.Error(w, "Could not fetch IP addresses", http.StatusInternalServerError)
+		return
+	}
+
+	var trees []VRFTree
+	for _, vrf := range vrfs {
+		var members []models.Subnet
+		for _, s := range subnets {
+			if s.VRFID == vrf.ID {
+				members = append(members, s)
+			}
+		}
+		if len(members) > 0 {
+			trees = append(trees, VRFTree{VRF: vrf, Roots: buildSubnetTree(members, devices, records)})
+		}
+	}
+
+	render(w, "subnets.html", trees)
+}
+
+// SubnetFormData is the data rendered by subnet_form.html
+type SubnetFormData struct {
+	Subnet       models.Subnet
+	VLANs        []models.VLAN
+	VRFs         []models.VRF
+	CustomFields []models.CustomField
+}
+
+// CustomFieldInputs returns the custom fields of subnets with the values of the subnet
+func (f SubnetFormData) CustomFieldInputs() []CustomFieldInput {
+	return customFieldInputs(f.CustomFields, f.Subnet.CustomFields, nil)
+}
+
+func renderSubnetForm(w http.ResponseWriter, subnet models.Subnet) {
+	data := SubnetFormData{Subnet: subnet}
+	var err error
+	if data.VLANs, err = db.GetAllVLANs(); err != nil {
+		log.Printf("Error fetching VLANs: %v", err)
+		http.Error(w, "Error loading form", http.StatusInternalServerError)
+		return
+	}
+	if data.VRFs, err = db.GetAllVRFs(); err != nil {
+		log.Printf("Error fetching VRFs: %v", err)
+		http.Error(w, "Error loading form", http.StatusInternalServerError)
+		return
+	}
+	if data.CustomFields, err = db.GetCustomFields(db.TagSubnet); err != nil {
+		log.Printf("Error fetching custom fields: %v", err)
+		http.Error(w, "Error loading form", http.StatusInternalServerError)
+		return
+	}
+	render(w, "subnet_form.html", data)
+}
+
+func AddSubnetHandler(w http.ResponseWriter, r *http.Request) {
+	vrfID, _ := strconv.Atoi(r.URL.Query().Get("vrf"))
+	renderSubnetForm(w, models.Subnet{CIDR: r.URL.Query().Get("cidr"), VRFID: vrfID})
+}
+
+func CreateSubnetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/add-subnet", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	subnet, err := subnetFromForm(r)
+	if err != nil {
+		http.Error(w, "Error adding subnet: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := db.AddSubnet(subnet); err != nil {
+		log.Printf("Error adding subnet: %v", err)
+		http.Error(w, "Error adding subnet: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, "/subnets", http.StatusSeeOther)
+}
+
+func EditSubnetHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid subnet ID", http.StatusBadRequest)
+		return
+	}
+
+	subnet, err := db.GetSubnet(id)
+	if err != nil {
+		http.Error(w, "Subnet not found", http.StatusNotFound)
+		return
+	}
+
+	renderSubnetForm(w, subnet)
+}
+
+func UpdateSubnetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/subnets", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid subnet ID", http.StatusBadRequest)
+		return
+	}
+
+	subnet, err := subnetFromForm(r)
+	if err != nil {
+		http.Error(w, "Error updating subnet: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	subnet.ID = id
+	if err := db.UpdateSubnet(subnet); err != nil {
+		log.Printf("Error updating subnet: %v", err)
+		http.Error(w, "Error updating subnet: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, "/subnets", http.StatusSeeOther)
+}
+
+func DeleteSubnetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/subnets", http.StatusSeeOther)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid subnet ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.DeleteSubnet(id); err != nil {
+		log.Printf("Error deleting subnet: %v", err)
+		http.Error(w, "Error deleting subnet", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/subnets", http.StatusSeeOther)
+}
+
+func subnetFromForm(r *http.Request) (models.Subnet, error) {
+	vlanID, _ := strconv.Atoi(r.FormValue("vlan_id"))
+	vrfID, _ := strconv.Atoi(r.FormValue("vrf_id"))
+	subnet := models.Subnet{
+		VLANID:      vlanID,
+		VRFID:       vrfID,
+		CIDR:        strings.TrimSpace(r.FormValue("cidr")),
+		Name:        strings.TrimSpace(r.FormValue("name")),
+		Description: r.FormValue("description"),
+		Gateway:     strings.TrimSpace(r.FormValue("gateway")),
+		Status:      r.FormValue("status"),
+	}
+
+	var err error
+	if subnet.Tags, err = tagsFromForm(r.FormValue("tags")); err != nil {
+		return subnet, err
+	}
+	custom, errs, err := customFieldsFromForm(r, db.TagSubnet)
+	if err != nil {
+		return subnet, err
+	}
+	if len(errs) > 0 {
+		return subnet, errors.New(strings.Join(slices.Sorted(maps.Values(errs)), "; "))
+	}
+	subnet.CustomFields = custom
+	return subnet, nil
+}
+
+// maxCarveCandidates caps how many free blocks a dry run lists
+const maxCarveCandidates = 32
+
+type PrefixSegment struct {
+	CIDR    string
+	Name    string // Name of the child subnet occupying the block, if any
+	Used    bool
+	Percent float64 // Share of the parent prefix, for the fragmentation bar
+}
+
+type CarveData struct {
+	Parent     models.Subnet
+	Bits       int
+	Candidates []string
+	Segments   []PrefixSegment
+	FreeBlocks int
+	Error      string
+}
+
+// prefixSegments describes how the children of parent (in the same VRF) fragment its address space
+func prefixSegments(parent models.Subnet, subnets []models.Subnet) []PrefixSegment {
+	prefix, err := netutil.ParsePrefix(parent.CIDR)
+	if err != nil {
+		return nil
+	}
+
+	names := make(map[netip.Prefix]string)
+	var children []netip.Prefix
+	for _, s := range subnets {
+		p, err := netip.ParsePrefix(s.CIDR)
+		if err != nil || s.VRFID != parent.VRFID || p.Bits() <= prefix.Bits() || !prefix.Contains(p.Addr()) {
+			continue
+		}
+		children = append(children, p)
+		names[p] = s.Name
+	}
+
+	var segments []PrefixSegment
+	for _, seg := range netutil.Segments(prefix, children) {
+		segments = append(segments, PrefixSegment{
+			CIDR:    seg.Prefix.String(),
+			Name:    names[seg.Prefix],
+			Used:    seg.Used,
+			Percent: netutil.Fraction(prefix, seg.Prefix) * 100,
+		})
+	}
+	return segments
+}
+
+// CarveSubnetHandler shows the fragmentation of a subnet and carves child prefixes out of it.
+// GET previews the free blocks of ?bits=<length>; POST records the first one as a new subnet.
+func CarveSubnetHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid subnet ID", http.StatusBadRequest)
+		return
+	}
+	parent, err := db.GetSubnet(id)
+	if err != nil {
+		http.Error(w, "Subnet not found", http.StatusNotFound)
+		return
+	}
+	bits, _ := strconv.Atoi(r.FormValue("bits"))
+
+	if r.Method == http.MethodPost {
+		_, err := db.CarveSubnet(parent.CIDR, bits, models.Subnet{
+			VRFID:       parent.VRFID,
+			Name:        strings.TrimSpace(r.FormValue("name")),
+			Description: r.FormValue("description"),
+		})
+		if err == nil {
+			http.Redirect(w, r, "/carve-subnet?id="+strconv.Itoa(parent.ID)+"&bits="+strconv.Itoa(bits), http.StatusSeeOther)
+			return
+		}
+		log.Printf("Error carving subnet out of %s: %v", parent.CIDR, err)
+		renderCarvePage(w, parent, bits, err.Error())
+		return
+	}
+
+	renderCarvePage(w, parent, bits, "")
+}
+
+func renderCarvePage(w http.ResponseWriter, parent models.Subnet, bits int, errMsg string) {
+	subnets, err := db.GetAllSubnets()
+	if err != nil {
+		http.Error(w, "Could not fetch subnets", http.StatusInternalServerError)
+		return
+	}
+
+	data := CarveData{
+		Parent:   parent,
+		Bits:     bits,
+		Segments: prefixSegments(parent, subnets),
+		Error:    errMsg,
+	}
+	for _, seg := range data.Segments {
+		if !seg.Used {
+			data.FreeBlocks++
+		}
+	}
+	if bits > 0 {
+		candidates, err := db.AvailableChildPrefixes(parent.VRFID, parent.CIDR, bits, maxCarveCandidates)
+		if err != nil {
+			data.Error = err.Error()
+		}
+		for _, c := range candidates {
+			data.Candidates = append(data.Candidates, c.String())
+		}
+	}
+
+	render(w, "carve_subnet.html", data)
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"ipam/internal/lifecycle"
+	"ipam/internal/models"
+	"testing"
+)
+
+func TestBuildSubnetTree(t *testing.T) {
+	// 10.0.0.0/24 holds 10.0.0.0/26; the lab VRF has its own 10.0.0.0/24
+	subnets := []models.Subnet{
+		{ID: 1, CIDR: "10.0.0.0/24", VRFID: 1},
+		{ID: 2, CIDR: "10.0.0.0/26", VRFID: 1, ParentID: 1},
+		{ID: 3, CIDR: "10.0.0.0/24", VRFID: 2},
+	}
+	devices := []models.Device{
+		{ID: 1, Hostname: "web01", Status: lifecycle.Active, Interfaces: []models.DeviceInterface{
+			{IPAddress: "10.0.0.10", VRFID: 1}, {IPAddress: "10.0.0.100", VRFID: 1}}},
+		{ID: 2, Hostname: "web02", Status: lifecycle.Planned, Interfaces: []models.DeviceInterface{
+			{IPAddress: "10.0.0.11", VRFID: 1}}},
+		{ID: 3, Hostname: "lab01", Status: lifecycle.Active, Interfaces: []models.DeviceInterface{
+			{IPAddress: "10.0.0.10", VRFID: 2}}},
+	}
+	records := []models.IPAddress{
+		{Address: "10.0.0.200", VRFID: 1, Status: "Reserved"},
+		{Address: "10.0.0.100", VRFID: 1, Status: "Active"}, // Carried by web01, so not counted twice
+	}
+
+	roots := buildSubnetTree(subnets, devices, records)
+	if len(roots) != 2 || len(roots[0].Children) != 1 || len(roots[1].Children) != 0 {
+		t.Fatalf("tree = %+v, want two roots, the first with one child", roots)
+	}
+
+	tests := []struct {
+		name                 string
+		node                 *SubnetNode
+		used, reserved, free int
+		total                int
+	}{
+		{name: "parent counts its child", node: roots[0], used: 2, reserved: 2, free: 250, total: 254},
+		{name: "child", node: roots[0].Children[0], used: 1, reserved: 1, free: 60, total: 62},
+		{name: "other VRF", node: roots[1], used: 1, free: 253, total: 254},
+	}
+	for _, tt := range tests {
+		n := tt.node
+		if n.UsedIPs != tt.used || n.ReservedIPs != tt.reserved || n.FreeIPs != tt.free || n.TotalIPs != tt.total {
+			t.Errorf("%s: used %d, reserved %d, free %d of %d, want %d, %d, %d of %d", tt.name,
+				n.UsedIPs, n.ReservedIPs, n.FreeIPs, n.TotalIPs, tt.used, tt.reserved, tt.free, tt.total)
+		}
+	}
+}
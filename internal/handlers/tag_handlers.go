@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"errors"
+	"ipam/internal/db"
+	"ipam/internal/models"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// tagsFromForm parses a comma separated tag list, e.g. "k8s, Customer X", into normalized tags
+func tagsFromForm(value string) (models.Tags, error) {
+	var tags models.Tags
+	for _, name := range strings.Split(value, ",") {
+		name = db.NormalizeTagName(name)
+		if name == "" || tags.Has(name) {
+			continue
+		}
+		if err := db.CheckTagName(name); err != nil {
+			return tags, err
+		}
+		tags = append(tags, models.Tag{Name: name})
+	}
+	return tags, nil
+}
+
+// filterByTag keeps the devices carrying the tag themselves, on one of their interfaces,
+// or through the rack they are in. An empty tag keeps every device.
+func filterByTag(devices []models.Device, racks []models.Rack, tag string) []models.Device {
+	if tag == "" {
+		return devices
+	}
+	taggedRacks := make(map[int]bool)
+	for _, rack := range racks {
+		if rack.Tags.Has(tag) {
+			taggedRacks[rack.ID] = true
+		}
+	}
+
+	var filtered []models.Device
+	for _, d := range devices {
+		match := d.Tags.Has(tag) || taggedRacks[d.RackID]
+		for _, iface := range d.Interfaces {
+			if iface.Tags.Has(tag) {
+				match = true
+			}
+		}
+		if match {
+			filtered = append(filtered, d)
+		}
+	}
+	return filtered
+}
+
+// filterSubnetsByTag keeps the subnets carrying the tag. An empty tag keeps every subnet.
+func filterSubnetsByTag(subnets []models.Subnet, tag string) []models.Subnet {
+	if tag == "" {
+		return subnets
+	}
+	var filtered []models.Subnet
+	for _, s := range subnets {
+		if s.Tags.Has(tag) {
+			filtered = append(filtered, s)
+		}
+	}
+	return filtered
+}
+
+// TagsHandler lists all tags with the number of objects carrying them
+func TagsHandler(w http.ResponseWriter, r *http.Request) {
+	tags, err := db.GetAllTags()
+	if err != nil {
+		log.Printf("Error fetching tags: %v", err)
+		http.Error(w, "Could not fetch tags", http.StatusInternalServerError)
+		return
+	}
+
+	render(w, "tags.html", tags)
+}
+
+// UpdateTagHandler renames and recolors a tag
+func UpdateTagHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/tags", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid tag ID", http.StatusBadRequest)
+		return
+	}
+
+	tag := models.Tag{ID: id, Name: r.FormValue("name"), Color: r.FormValue("color")}
+	if err := db.UpdateTag(tag, requestActor(r)); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, db.ErrTagExists) {
+			status = http.StatusConflict
+		}
+		log.Printf("Error updating tag %d: %v", id, err)
+		http.Error(w, "Error updating tag: "+err.Error(), status)
+		return
+	}
+
+	http.Redirect(w, r, "/tags", http.StatusSeeOther)
+}
+
+// DeleteTagHandler deletes a tag, removing it from everything carrying it
+func DeleteTagHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/tags", http.StatusSeeOther)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid tag ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.DeleteTag(id, requestActor(r)); err != nil {
+		log.Printf("Error deleting tag: %v", err)
+		http.Error(w, "Error deleting tag", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/tags", http.StatusSeeOther)
+}
+
+// DevicesAPIHandler lists devices with their interfaces and tags, filtered like the dashboard
+// by ?vrf=, ?q= and ?tag=, and as they were at ?as_of= when given.
+//
+//	GET /api/devices?tag=k8s
+//	GET /api/devices?as_of=2024-01-31T15:04
+func DevicesAPIHandler(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Success bool            `json:"success"`
+		Devices []models.Device `json:"devices"`
+		Error   string          `json:"error,omitempty"`
+	}
+
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, response{Error: "Method not allowed"})
+		return
+	}
+
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, response{Error: err.Error()})
+		return
+	}
+	devices, err := exportDevices(r, asOf)
+	if err != nil {
+		log.Printf("Error fetching devices: %v", err)
+		writeJSON(w, http.StatusInternalServerError, response{Error: "Could not fetch devices"})
+		return
+	}
+	if devices == nil {
+		devices = []models.Device{}
+	}
+
+	writeJSON(w, http.StatusOK, response{Success: true, Devices: devices})
+}
+
+// SubnetsAPIHandler lists subnets, optionally narrowed down by ?vrf= and ?tag=.
+//
+//	GET /api/subnets?tag=customer-x
+func SubnetsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Success bool            `json:"success"`
+		Subnets []models.Subnet `json:"subnets"`
+		Error   string          `json:"error,omitempty"`
+	}
+
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, response{Error: "Method not allowed"})
+		return
+	}
+
+	subnets, err := db.GetAllSubnets()
+	if err != nil {
+		log.Printf("Error fetching subnets: %v", err)
+		writeJSON(w, http.StatusInternalServerError, response{Error: "Could not fetch subnets"})
+		return
+	}
+
+	vrfID, _ := strconv.Atoi(r.URL.Query().Get("vrf"))
+	filtered := []models.Subnet{}
+	for _, s := range filterSubnetsByTag(subnets, db.NormalizeTagName(r.URL.Query().Get("tag"))) {
+		if vrfID == 0 || s.VRFID == vrfID {
+			filtered = append(filtered, s)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, response{Success: true, Subnets: filtered})
+}
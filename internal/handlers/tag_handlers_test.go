@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"ipam/internal/models"
+	"slices"
+	"testing"
+)
+
+func TestTagsFromForm(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{name: "empty", value: "", want: ""},
+		{name: "normalized", value: "k8s, Customer  X", want: "k8s, customer-x"},
+		{name: "duplicates dropped", value: "prod, PROD,,prod ", want: "prod"},
+		{name: "allowed punctuation", value: "env:prod, team/net, v1.2_a", want: "env:prod, team/net, v1.2_a"},
+		{name: "leading punctuation", value: "-prod", wantErr: true},
+		{name: "other characters", value: "prod!", wantErr: true},
+		{name: "too long", value: "abcdefghijklmnopqrstuvwxyzabcdefghijklmnopqrstuvwxyz", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := tagsFromForm(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: got %q, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || got.String() != tt.want {
+			t.Errorf("%s: got %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestFilterByTag(t *testing.T) {
+	prod := models.Tags{{Name: "prod"}}
+	racks := []models.Rack{{ID: 1, Name: "R1", Tags: prod}, {ID: 2, Name: "R2"}}
+	devices := []models.Device{
+		{ID: 1, Hostname: "tagged", RackID: 2, Tags: prod},
+		{ID: 2, Hostname: "in-tagged-rack", RackID: 1},
+		{ID: 3, Hostname: "tagged-interface", Interfaces: []models.DeviceInterface{{Label: "eth0"}, {Label: "eth1", Tags: prod}}},
+		{ID: 4, Hostname: "untagged", RackID: 2, Tags: models.Tags{{Name: "dev"}}},
+	}
+
+	tests := []struct {
+		tag  string
+		want []string
+	}{
+		{tag: "", want: []string{"tagged", "in-tagged-rack", "tagged-interface", "untagged"}},
+		{tag: "prod", want: []string{"tagged", "in-tagged-rack", "tagged-interface"}},
+		{tag: "dev", want: []string{"untagged"}},
+		{tag: "missing"},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, d := range filterByTag(devices, racks, tt.tag) {
+			got = append(got, d.Hostname)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("filterByTag(%q) = %v, want %v", tt.tag, got, tt.want)
+		}
+	}
+}
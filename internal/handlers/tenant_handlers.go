@@ -0,0 +1,473 @@
+package handlers
+
+import (
+	"errors"
+	"ipam/internal/db"
+	"ipam/internal/models"
+	"ipam/internal/netutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// interfaceTenant returns the tenant owning an interface: its own, or else the one of its device
+func interfaceTenant(d models.Device, iface models.DeviceInterface) int {
+	if iface.TenantID != 0 {
+		return iface.TenantID
+	}
+	return d.TenantID
+}
+
+// TenantsData is the data rendered by tenants.html
+type TenantsData struct {
+	Groups  []models.TenantGroup
+	Tenants []models.Tenant
+	Devices []models.Device
+	Racks   []models.Rack
+}
+
+// TenantCount returns the number of tenants in a group
+func (d TenantsData) TenantCount(groupID int) int {
+	count := 0
+	for _, t := range d.Tenants {
+		if t.GroupID == groupID {
+			count++
+		}
+	}
+	return count
+}
+
+// DeviceCount returns the number of devices of a tenant
+func (d TenantsData) DeviceCount(tenantID int) int {
+	count := 0
+	for _, dev := range d.Devices {
+		if dev.TenantID == tenantID {
+			count++
+		}
+	}
+	return count
+}
+
+// RackCount returns the number of racks of a tenant
+func (d TenantsData) RackCount(tenantID int) int {
+	count := 0
+	for _, r := range d.Racks {
+		if r.TenantID == tenantID {
+			count++
+		}
+	}
+	return count
+}
+
+// AddressCount returns the number of interface addresses a tenant owns, directly or through its devices
+func (d TenantsData) AddressCount(tenantID int) int {
+	count := 0
+	for _, dev := range d.Devices {
+		for _, iface := range dev.Interfaces {
+			if interfaceTenant(dev, iface) == tenantID {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// TenantsHandler lists tenant groups and tenants
+func TenantsHandler(w http.ResponseWriter, r *http.Request) {
+	var data TenantsData
+	var err error
+	if data.Groups, err = db.GetAllTenantGroups(); err != nil {
+		log.Printf("Error fetching tenant groups: %v", err)
+		http.Error(w, "Could not fetch tenant groups", http.StatusInternalServerError)
+		return
+	}
+	if data.Tenants, err = db.GetAllTenants(); err != nil {
+		log.Printf("Error fetching tenants: %v", err)
+		http.Error(w, "Could not fetch tenants", http.StatusInternalServerError)
+		return
+	}
+	if data.Devices, err = db.GetAllDevices(); err != nil {
+		log.Printf("Error fetching devices: %v", err)
+		http.Error(w, "Could not fetch devices", http.StatusInternalServerError)
+		return
+	}
+	if data.Racks, err = db.GetAllRacks(); err != nil {
+		log.Printf("Error fetching racks: %v", err)
+		http.Error(w, "Could not fetch racks", http.StatusInternalServerError)
+		return
+	}
+
+	render(w, "tenants.html", data)
+}
+
+// TenantInterface is an interface owned by a tenant, with the device it is on
+type TenantInterface struct {
+	Device    models.Device
+	Interface models.DeviceInterface
+}
+
+// TenantSubnet is the share of a subnet taken by the addresses of a tenant
+type TenantSubnet struct {
+	SubnetSummary
+	TenantIPs     int
+	TenantPercent int // TenantIPs out of the addresses of the subnet
+}
+
+// TenantData is the data rendered by tenant.html
+type TenantData struct {
+	Tenant     models.Tenant
+	Devices    []models.Device
+	Racks      []models.Rack
+	Interfaces []TenantInterface
+	Subnets    []TenantSubnet
+	RackUnits  int // Units taken by the mounted devices of the tenant
+}
+
+// tenantSubnets counts the addresses of a tenant in each subnet of their VRF. Subnets without
+// any are left out.
+func tenantSubnets(subnets []models.Subnet, devices []models.Device, records []models.IPAddress, ifaces []TenantInterface) []TenantSubnet {
+	usedByVRF := make(map[int]addressUsage)
+	var result []TenantSubnet
+	for _, s := range subnets {
+		prefix, err := netutil.ParsePrefix(s.CIDR)
+		if err != nil {
+			continue
+		}
+		count := 0
+		seen := make(map[string]bool)
+		for _, ti := range ifaces {
+			addr, err := netutil.ParseAddr(ti.Interface.IPAddress)
+			if err != nil || ti.Interface.VRFID != s.VRFID || !prefix.Contains(addr) || seen[addr.String()] {
+				continue
+			}
+			seen[addr.String()] = true
+			count++
+		}
+		if count == 0 {
+			continue
+		}
+
+		used, ok := usedByVRF[s.VRFID]
+		if !ok {
+			used = usedAddresses(devices, records, s.VRFID)
+			usedByVRF[s.VRFID] = used
+		}
+		ts := TenantSubnet{SubnetSummary: summarizeSubnet(s, used), TenantIPs: count}
+		if ts.TotalIPs > 0 {
+			ts.TenantPercent = int(int64(count) * 100 / int64(ts.TotalIPs))
+		}
+		result = append(result, ts)
+	}
+	return result
+}
+
+// TenantHandler shows everything a tenant owns and how much address space they use
+func TenantHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid tenant ID", http.StatusBadRequest)
+		return
+	}
+	tenant, err := db.GetTenant(id)
+	if err != nil {
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return
+	}
+
+	devices, err := db.GetAllDevices()
+	if err != nil {
+		log.Printf("Error fetching devices: %v", err)
+		http.Error(w, "Could not fetch devices", http.StatusInternalServerError)
+		return
+	}
+	racks, err := db.GetAllRacks()
+	if err != nil {
+		log.Printf("Error fetching racks: %v", err)
+		http.Error(w, "Could not fetch racks", http.StatusInternalServerError)
+		return
+	}
+	subnets, err := db.GetAllSubnets()
+	if err != nil {
+		log.Printf("Error fetching subnets: %v", err)
+		http.Error(w, "Could not fetch subnets", http.StatusInternalServerError)
+		return
+	}
+	records, err := db.GetAllIPAddresses()
+	if err != nil {
+		log.Printf("Error fetching IP addresses: %v", err)
+		http.Error(w, "Could not fetch IP addresses", http.StatusInternalServerError)
+		return
+	}
+
+	data := TenantData{Tenant: tenant}
+	for _, d := range devices {
+		if d.TenantID == id {
+			data.Devices = append(data.Devices, d)
+			if d.Position > 0 {
+				data.RackUnits += d.UHeight
+			}
+		}
+		for _, iface := range d.Interfaces {
+			if interfaceTenant(d, iface) == id {
+				data.Interfaces = append(data.Interfaces, TenantInterface{Device: d, Interface: iface})
+			}
+		}
+	}
+	for _, rack := range racks {
+		if rack.TenantID == id {
+			data.Racks = append(data.Racks, rack)
+		}
+	}
+	data.Subnets = tenantSubnets(subnets, devices, records, data.Interfaces)
+
+	render(w, "tenant.html", data)
+}
+
+// tenantStatus maps a tenant save error to its HTTP status
+func tenantStatus(err error) int {
+	if errors.Is(err, db.ErrTenantExists) || errors.Is(err, db.ErrTenantGroupExists) {
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// Tenant groups
+
+func AddTenantGroupHandler(w http.ResponseWriter, r *http.Request) {
+	render(w, "tenant_group_form.html", models.TenantGroup{})
+}
+
+func CreateTenantGroupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/add-tenant-group", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	group := models.TenantGroup{
+		Name:        strings.TrimSpace(r.FormValue("name")),
+		Description: strings.TrimSpace(r.FormValue("description")),
+	}
+	if group.Name == "" {
+		http.Error(w, "Tenant group name is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.AddTenantGroup(group); err != nil {
+		log.Printf("Error adding tenant group: %v", err)
+		http.Error(w, "Error adding tenant group: "+err.Error(), tenantStatus(err))
+		return
+	}
+
+	http.Redirect(w, r, "/tenants", http.StatusSeeOther)
+}
+
+func EditTenantGroupHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid tenant group ID", http.StatusBadRequest)
+		return
+	}
+
+	group, err := db.GetTenantGroup(id)
+	if err != nil {
+		http.Error(w, "Tenant group not found", http.StatusNotFound)
+		return
+	}
+
+	render(w, "tenant_group_form.html", group)
+}
+
+func UpdateTenantGroupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/tenants", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid tenant group ID", http.StatusBadRequest)
+		return
+	}
+	group := models.TenantGroup{
+		ID:          id,
+		Name:        strings.TrimSpace(r.FormValue("name")),
+		Description: strings.TrimSpace(r.FormValue("description")),
+	}
+	if group.Name == "" {
+		http.Error(w, "Tenant group name is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.UpdateTenantGroup(group); err != nil {
+		log.Printf("Error updating tenant group: %v", err)
+		http.Error(w, "Error updating tenant group: "+err.Error(), tenantStatus(err))
+		return
+	}
+
+	http.Redirect(w, r, "/tenants", http.StatusSeeOther)
+}
+
+func DeleteTenantGroupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/tenants", http.StatusSeeOther)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid tenant group ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.DeleteTenantGroup(id); err != nil {
+		log.Printf("Error deleting tenant group: %v", err)
+		http.Error(w, "Error deleting tenant group", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/tenants", http.StatusSeeOther)
+}
+
+// Tenants
+
+// TenantFormData is the data rendered by tenant_form.html
+type TenantFormData struct {
+	Tenant models.Tenant
+	Groups []models.TenantGroup
+}
+
+func renderTenantForm(w http.ResponseWriter, tenant models.Tenant) {
+	groups, err := db.GetAllTenantGroups()
+	if err != nil {
+		log.Printf("Error fetching tenant groups: %v", err)
+		http.Error(w, "Error loading form", http.StatusInternalServerError)
+		return
+	}
+	render(w, "tenant_form.html", TenantFormData{Tenant: tenant, Groups: groups})
+}
+
+// tenantFromForm reads the tenant form, checking that the chosen group exists
+func tenantFromForm(r *http.Request) (models.Tenant, error) {
+	groupID, _ := strconv.Atoi(r.FormValue("group_id"))
+	tenant := models.Tenant{
+		Name:        strings.TrimSpace(r.FormValue("name")),
+		GroupID:     groupID,
+		Description: strings.TrimSpace(r.FormValue("description")),
+	}
+	if tenant.Name == "" {
+		return tenant, errors.New("tenant name is required")
+	}
+	if tenant.GroupID != 0 {
+		if _, err := db.GetTenantGroup(tenant.GroupID); err != nil {
+			return tenant, errors.New("the selected tenant group does not exist")
+		}
+	}
+	return tenant, nil
+}
+
+func AddTenantHandler(w http.ResponseWriter, r *http.Request) {
+	groupID, _ := strconv.Atoi(r.URL.Query().Get("group_id"))
+	renderTenantForm(w, models.Tenant{GroupID: groupID})
+}
+
+func CreateTenantHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/add-tenant", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	tenant, err := tenantFromForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := db.AddTenant(tenant); err != nil {
+		log.Printf("Error adding tenant: %v", err)
+		http.Error(w, "Error adding tenant: "+err.Error(), tenantStatus(err))
+		return
+	}
+
+	http.Redirect(w, r, "/tenants", http.StatusSeeOther)
+}
+
+func EditTenantHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid tenant ID", http.StatusBadRequest)
+		return
+	}
+
+	tenant, err := db.GetTenant(id)
+	if err != nil {
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return
+	}
+
+	renderTenantForm(w, tenant)
+}
+
+func UpdateTenantHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/tenants", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid tenant ID", http.StatusBadRequest)
+		return
+	}
+	tenant, err := tenantFromForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tenant.ID = id
+
+	if err := db.UpdateTenant(tenant); err != nil {
+		log.Printf("Error updating tenant: %v", err)
+		http.Error(w, "Error updating tenant: "+err.Error(), tenantStatus(err))
+		return
+	}
+
+	http.Redirect(w, r, "/tenant?id="+strconv.Itoa(id), http.StatusSeeOther)
+}
+
+func DeleteTenantHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/tenants", http.StatusSeeOther)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid tenant ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.DeleteTenant(id, requestActor(r)); err != nil {
+		log.Printf("Error deleting tenant: %v", err)
+		http.Error(w, "Error deleting tenant", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/tenants", http.StatusSeeOther)
+}
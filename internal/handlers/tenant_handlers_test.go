@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"ipam/internal/models"
+	"testing"
+)
+
+func TestTenantOwnership(t *testing.T) {
+	// Tenant 1 owns web01 and its eth0; eth1 of web01 was handed to tenant 2, as was mgmt of sw01
+	devices := []models.Device{
+		{ID: 1, Hostname: "web01", TenantID: 1, Interfaces: []models.DeviceInterface{
+			{IPAddress: "10.0.0.1", VRFID: 1}, {IPAddress: "10.0.0.2", VRFID: 1, TenantID: 2}}},
+		{ID: 2, Hostname: "sw01", Interfaces: []models.DeviceInterface{
+			{IPAddress: "10.0.1.1", VRFID: 1, TenantID: 2}, {IPAddress: "10.0.1.2", VRFID: 1}}},
+	}
+	data := TenantsData{Devices: devices}
+
+	tests := []struct {
+		tenant             int
+		devices, addresses int
+		wantSubnets        []string
+		wantTenantIPs      []int
+	}{
+		{tenant: 1, devices: 1, addresses: 1, wantSubnets: []string{"10.0.0.0/24"}, wantTenantIPs: []int{1}},
+		{tenant: 2, devices: 0, addresses: 2, wantSubnets: []string{"10.0.0.0/24", "10.0.1.0/24"}, wantTenantIPs: []int{1, 1}},
+		{tenant: 3},
+	}
+	subnets := []models.Subnet{
+		{ID: 1, CIDR: "10.0.0.0/24", VRFID: 1},
+		{ID: 2, CIDR: "10.0.1.0/24", VRFID: 1},
+		{ID: 3, CIDR: "10.0.1.0/24", VRFID: 2},
+	}
+	for _, tt := range tests {
+		if got := data.DeviceCount(tt.tenant); got != tt.devices {
+			t.Errorf("tenant %d: %d devices, want %d", tt.tenant, got, tt.devices)
+		}
+		if got := data.AddressCount(tt.tenant); got != tt.addresses {
+			t.Errorf("tenant %d: %d addresses, want %d", tt.tenant, got, tt.addresses)
+		}
+
+		var ifaces []TenantInterface
+		for _, d := range devices {
+			for _, iface := range d.Interfaces {
+				if interfaceTenant(d, iface) == tt.tenant {
+					ifaces = append(ifaces, TenantInterface{Device: d, Interface: iface})
+				}
+			}
+		}
+		got := tenantSubnets(subnets, devices, nil, ifaces)
+		if len(got) != len(tt.wantSubnets) {
+			t.Errorf("tenant %d: subnets %+v, want %v", tt.tenant, got, tt.wantSubnets)
+			continue
+		}
+		for i, s := range got {
+			if s.Subnet.CIDR != tt.wantSubnets[i] || s.TenantIPs != tt.wantTenantIPs[i] || s.UsedIPs != 2 {
+				t.Errorf("tenant %d: %s has %d of %d used addresses, want %s with %d of 2",
+					tt.tenant, s.Subnet.CIDR, s.TenantIPs, s.UsedIPs, tt.wantSubnets[i], tt.wantTenantIPs[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"ipam/internal/db"
+	"ipam/internal/models"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TrashData is the data rendered by trash.html
+type TrashData struct {
+	Devices       []models.Device
+	Racks         []models.Rack
+	RetentionDays int    // 0 keeps deleted objects until they are purged by hand
+	Error         string // Why the last restore or purge failed
+}
+
+// PurgeDate returns when an object deleted at t is purged, "" when the trash is never purged
+func (d TrashData) PurgeDate(t *time.Time) string {
+	if d.RetentionDays == 0 || t == nil {
+		return ""
+	}
+	return t.Local().AddDate(0, 0, d.RetentionDays).Format("Jan 2 2006")
+}
+
+// renderTrash renders the trash page, with msg explaining why the last action failed
+func renderTrash(w http.ResponseWriter, status int, msg string) {
+	data := TrashData{Error: msg}
+	var err error
+	if data.Devices, err = db.GetDeletedDevices(); err != nil {
+		log.Printf("Error fetching deleted devices: %v", err)
+		http.Error(w, "Could not fetch the trash", http.StatusInternalServerError)
+		return
+	}
+	if data.Racks, err = db.GetDeletedRacks(); err != nil {
+		log.Printf("Error fetching deleted racks: %v", err)
+		http.Error(w, "Could not fetch the trash", http.StatusInternalServerError)
+		return
+	}
+	if data.RetentionDays, err = db.TrashRetention(); err != nil {
+		log.Printf("Error fetching the trash retention: %v", err)
+	}
+
+	w.WriteHeader(status)
+	render(w, "trash.html", data)
+}
+
+// TrashHandler lists the deleted devices and racks
+func TrashHandler(w http.ResponseWriter, r *http.Request) {
+	renderTrash(w, http.StatusOK, "")
+}
+
+// trashAction runs a restore or purge on the object in the ?id= of a POST, then goes back to
+// the trash. Conflicts that prevent a restore are shown on the trash page.
+func trashAction(w http.ResponseWriter, r *http.Request, object string, action func(id int, actor string) error) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/trash", http.StatusSeeOther)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid "+object+" ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := action(id, requestActor(r)); err != nil {
+		var conflictErr *db.ConflictError
+		var placementErr *db.PlacementError
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			http.Error(w, strings.ToUpper(object[:1])+object[1:]+" not found", http.StatusNotFound)
+		case errors.Is(err, db.ErrNotInTrash):
+			renderTrash(w, http.StatusConflict, err.Error())
+		case errors.As(err, &conflictErr), errors.As(err, &placementErr):
+			renderTrash(w, http.StatusConflict, "The "+object+" cannot be restored: "+err.Error())
+		default:
+			log.Printf("Error in trash action on %s %d: %v", object, id, err)
+			http.Error(w, "Error updating the trash", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	http.Redirect(w, r, "/trash", http.StatusSeeOther)
+}
+
+// RestoreDeviceHandler takes a device out of the trash
+func RestoreDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	trashAction(w, r, "device", db.RestoreDevice)
+}
+
+// PurgeDeviceHandler deletes a device in the trash for good
+func PurgeDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	trashAction(w, r, "device", db.PurgeDevice)
+}
+
+// RestoreRackHandler takes a rack out of the trash
+func RestoreRackHandler(w http.ResponseWriter, r *http.Request) {
+	trashAction(w, r, "rack", db.RestoreRack)
+}
+
+// PurgeRackHandler deletes a rack in the trash for good
+func PurgeRackHandler(w http.ResponseWriter, r *http.Request) {
+	trashAction(w, r, "rack", db.PurgeRack)
+}
+
+// UpdateTrashRetentionHandler sets how many days deleted objects are kept
+func UpdateTrashRetentionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/trash", http.StatusSeeOther)
+		return
+	}
+
+	days, err := strconv.Atoi(strings.TrimSpace(r.FormValue("retention_days")))
+	if err != nil || days < 0 {
+		renderTrash(w, http.StatusBadRequest, "Retention must be a number of days, 0 or more")
+		return
+	}
+
+	if err := db.SetTrashRetention(days); err != nil {
+		log.Printf("Error saving the trash retention: %v", err)
+		http.Error(w, "Error saving the retention", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/trash", http.StatusSeeOther)
+}
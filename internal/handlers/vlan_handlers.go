@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"fmt"
+	"ipam/internal/db"
+	"ipam/internal/models"
+	"ipam/internal/netutil"
+	"log"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type VLANSummary struct {
+	VLAN       models.VLAN
+	Subnets    int
+	Interfaces int
+}
+
+type VLANGroup struct {
+	Name  string
+	VLANs []VLANSummary
+}
+
+// VLANMember is a device interface that sits on a VLAN
+type VLANMember struct {
+	Device     models.Device
+	Interface  models.DeviceInterface
+	Membership string // "Access", "Native", "Tagged" or "Via subnet"
+}
+
+type VLANDetailData struct {
+	VLAN    models.VLAN
+	Subnets []models.Subnet
+	Members []VLANMember
+}
+
+// vlanMembership reports how an interface belongs to a VLAN, or "" if it does not
+func vlanMembership(iface models.DeviceInterface, vlanID int) string {
+	switch {
+	case iface.UntaggedVLANID == vlanID && iface.VLANMode == "tagged":
+		return "Native"
+	case iface.UntaggedVLANID == vlanID:
+		return "Access"
+	case iface.VLANMode == "tagged" && slices.Contains(iface.TaggedVLANIDs, vlanID):
+		return "Tagged"
+	}
+	return ""
+}
+
+// vlanMembers lists the interfaces explicitly on a VLAN, plus those whose address falls
+// inside one of the prefixes carried by the VLAN (in the prefix's VRF)
+func vlanMembers(vlanID int, devices []models.Device, subnets []models.Subnet) []VLANMember {
+	prefixes := make(map[netip.Prefix]int) // prefix -> VRF ID
+	for _, s := range subnets {
+		if p, err := netutil.ParsePrefix(s.CIDR); err == nil {
+			prefixes[p] = s.VRFID
+		}
+	}
+
+	var members []VLANMember
+	for _, d := range devices {
+		for _, iface := range d.Interfaces {
+			membership := vlanMembership(iface, vlanID)
+			if membership == "" {
+				if addr, err := netutil.ParseAddr(iface.IPAddress); err == nil {
+					for p, vrfID := range prefixes {
+						if vrfID == iface.VRFID && p.Contains(addr) {
+							membership = "Via subnet"
+							break
+						}
+					}
+				}
+			}
+			if membership != "" {
+				members = append(members, VLANMember{Device: d, Interface: iface, Membership: membership})
+			}
+		}
+	}
+	return members
+}
+
+// VLANsHandler lists all VLANs grouped by their group/site
+func VLANsHandler(w http.ResponseWriter, r *http.Request) {
+	vlans, err := db.GetAllVLANs()
+	if err != nil {
+		log.Printf("Error fetching VLANs: %v", err)
+		http.Error(w, "Could not fetch VLANs", http.StatusInternalServerError)
+		return
+	}
+	subnets, err := db.GetAllSubnets()
+	if err != nil {
+		http.Error(w, "Could not fetch subnets", http.StatusInternalServerError)
+		return
+	}
+	devices, err := db.GetAllDevices()
+	if err != nil {
+		http.Error(w, "Could not fetch devices", http.StatusInternalServerError)
+		return
+	}
+
+	var groups []VLANGroup
+	for _, v := range vlans {
+		summary := VLANSummary{VLAN: v}
+		for _, s := range subnets {
+			if s.VLANID == v.ID {
+				summary.Subnets++
+			}
+		}
+		for _, d := range devices {
+			for _, iface := range d.Interfaces {
+				if vlanMembership(iface, v.ID) != "" {
+					summary.Interfaces++
+				}
+			}
+		}
+
+		// vlans are ordered by group, so a new group starts whenever the name changes
+		if len(groups) == 0 || groups[len(groups)-1].Name != v.Group {
+			groups = append(groups, VLANGroup{Name: v.Group})
+		}
+		groups[len(groups)-1].VLANs = append(groups[len(groups)-1].VLANs, summary)
+	}
+
+	render(w, "vlans.html", groups)
+}
+
+// VLANHandler shows the networks and devices on a single VLAN
+func VLANHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid VLAN ID", http.StatusBadRequest)
+		return
+	}
+	vlan, err := db.GetVLAN(id)
+	if err != nil {
+		http.Error(w, "VLAN not found", http.StatusNotFound)
+		return
+	}
+	subnets, err := db.GetAllSubnets()
+	if err != nil {
+		http.Error(w, "Could not fetch subnets", http.StatusInternalServerError)
+		return
+	}
+	devices, err := db.GetAllDevices()
+	if err != nil {
+		http.Error(w, "Could not fetch devices", http.StatusInternalServerError)
+		return
+	}
+
+	data := VLANDetailData{VLAN: vlan}
+	for _, s := range subnets {
+		if s.VLANID == vlan.ID {
+			data.Subnets = append(data.Subnets, s)
+		}
+	}
+	data.Members = vlanMembers(vlan.ID, devices, data.Subnets)
+
+	render(w, "vlan.html", data)
+}
+
+func AddVLANHandler(w http.ResponseWriter, r *http.Request) {
+	render(w, "vlan_form.html", models.VLAN{Group: r.URL.Query().Get("group")})
+}
+
+func CreateVLANHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/add-vlan", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.AddVLAN(vlanFromForm(r)); err != nil {
+		log.Printf("Error adding VLAN: %v", err)
+		http.Error(w, "Error adding VLAN: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, "/vlans", http.StatusSeeOther)
+}
+
+func EditVLANHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid VLAN ID", http.StatusBadRequest)
+		return
+	}
+
+	vlan, err := db.GetVLAN(id)
+	if err != nil {
+		http.Error(w, "VLAN not found", http.StatusNotFound)
+		return
+	}
+
+	render(w, "vlan_form.html", vlan)
+}
+
+func UpdateVLANHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/vlans", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid VLAN ID", http.StatusBadRequest)
+		return
+	}
+
+	vlan := vlanFromForm(r)
+	vlan.ID = id
+	if err := db.UpdateVLAN(vlan); err != nil {
+		log.Printf("Error updating VLAN: %v", err)
+		http.Error(w, "Error updating VLAN: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, "/vlans", http.StatusSeeOther)
+}
+
+func DeleteVLANHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/vlans", http.StatusSeeOther)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid VLAN ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.DeleteVLAN(id, requestActor(r)); err != nil {
+		log.Printf("Error deleting VLAN: %v", err)
+		http.Error(w, "Error deleting VLAN", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/vlans", http.StatusSeeOther)
+}
+
+func vlanFromForm(r *http.Request) models.VLAN {
+	vid, _ := strconv.Atoi(strings.TrimSpace(r.FormValue("vid")))
+	return models.VLAN{
+		VID:         vid,
+		Name:        strings.TrimSpace(r.FormValue("name")),
+		Group:       strings.TrimSpace(r.FormValue("group")),
+		Description: r.FormValue("description"),
+	}
+}
+
+// resolveTaggedVLANs turns a comma separated list of VIDs (e.g. "10, 20") into VLAN IDs.
+// When a VID exists in several groups, the one in the same group as the native VLAN wins.
+func resolveTaggedVLANs(list string, nativeVLANID int, vlans []models.VLAN) ([]int, error) {
+	nativeGroup := ""
+	for _, v := range vlans {
+		if v.ID == nativeVLANID {
+			nativeGroup = v.Group
+		}
+	}
+
+	var ids []int
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		vid, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid VLAN ID %q", field)
+		}
+
+		var matches []models.VLAN
+		for _, v := range vlans {
+			if v.VID == vid {
+				matches = append(matches, v)
+			}
+		}
+		if len(matches) > 1 {
+			matches = slices.DeleteFunc(matches, func(v models.VLAN) bool { return v.Group != nativeGroup })
+		}
+		switch len(matches) {
+		case 0:
+			return nil, fmt.Errorf("VLAN %d is not defined", vid)
+		case 1:
+			if !slices.Contains(ids, matches[0].ID) {
+				ids = append(ids, matches[0].ID)
+			}
+		default:
+			return nil, fmt.Errorf("VLAN %d exists in several groups; set a native VLAN to pick one", vid)
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"ipam/internal/db"
+	"ipam/internal/models"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type VRFSummary struct {
+	VRF        models.VRF
+	Subnets    int
+	Interfaces int
+	Global     bool // The default VRF, which cannot be deleted
+}
+
+// VRFsHandler lists all VRFs with the number of subnets and addresses they hold
+func VRFsHandler(w http.ResponseWriter, r *http.Request) {
+	vrfs, err := db.GetAllVRFs()
+	if err != nil {
+		log.Printf("Error fetching VRFs: %v", err)
+		http.Error(w, "Could not fetch VRFs", http.StatusInternalServerError)
+		return
+	}
+	subnets, err := db.GetAllSubnets()
+	if err != nil {
+		http.Error(w, "Could not fetch subnets", http.StatusInternalServerError)
+		return
+	}
+	devices, err := db.GetAllDevices()
+	if err != nil {
+		http.Error(w, "Could not fetch devices", http.StatusInternalServerError)
+		return
+	}
+
+	var summaries []VRFSummary
+	for _, v := range vrfs {
+		summary := VRFSummary{VRF: v, Global: v.ID == db.GlobalVRFID}
+		for _, s := range subnets {
+			if s.VRFID == v.ID {
+				summary.Subnets++
+			}
+		}
+		for _, d := range devices {
+			for _, iface := range d.Interfaces {
+				if iface.VRFID == v.ID {
+					summary.Interfaces++
+				}
+			}
+		}
+		summaries = append(summaries, summary)
+	}
+
+	render(w, "vrfs.html", summaries)
+}
+
+func AddVRFHandler(w http.ResponseWriter, r *http.Request) {
+	render(w, "vrf_form.html", models.VRF{})
+}
+
+func CreateVRFHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/add-vrf", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.AddVRF(vrfFromForm(r)); err != nil {
+		log.Printf("Error adding VRF: %v", err)
+		http.Error(w, "Error adding VRF: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, "/vrfs", http.StatusSeeOther)
+}
+
+func EditVRFHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid VRF ID", http.StatusBadRequest)
+		return
+	}
+
+	vrf, err := db.GetVRF(id)
+	if err != nil {
+		http.Error(w, "VRF not found", http.StatusNotFound)
+		return
+	}
+
+	render(w, "vrf_form.html", vrf)
+}
+
+func UpdateVRFHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/vrfs", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid VRF ID", http.StatusBadRequest)
+		return
+	}
+
+	vrf := vrfFromForm(r)
+	vrf.ID = id
+	if err := db.UpdateVRF(vrf); err != nil {
+		log.Printf("Error updating VRF: %v", err)
+		http.Error(w, "Error updating VRF: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, "/vrfs", http.StatusSeeOther)
+}
+
+func DeleteVRFHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/vrfs", http.StatusSeeOther)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid VRF ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.DeleteVRF(id); err != nil {
+		log.Printf("Error deleting VRF: %v", err)
+		http.Error(w, "Error deleting VRF: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, "/vrfs", http.StatusSeeOther)
+}
+
+func vrfFromForm(r *http.Request) models.VRF {
+	return models.VRF{
+		Name:        strings.TrimSpace(r.FormValue("name")),
+		RD:          strings.TrimSpace(r.FormValue("rd")),
+		Description: r.FormValue("description"),
+	}
+}
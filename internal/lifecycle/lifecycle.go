@@ -0,0 +1,148 @@
+// Package lifecycle defines the states a device goes through, from planned to retired, and
+// which changes between them are allowed.
+package lifecycle
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Device states, in lifecycle order
+const (
+	Planned         = "Planned"
+	Staged          = "Staged"
+	Active          = "Active"
+	Offline         = "Offline"
+	Decommissioning = "Decommissioning"
+	Retired         = "Retired"
+)
+
+// States lists every state in lifecycle order
+var States = []string{Planned, Staged, Active, Offline, Decommissioning, Retired}
+
+// Initial are the states a new device may start in
+var Initial = []string{Planned, Staged, Active, Offline}
+
+// transitions maps a state to the states a device may move to from it
+var transitions = map[string][]string{
+	Planned:         {Staged, Active, Retired},
+	Staged:          {Planned, Active, Retired},
+	Active:          {Offline, Decommissioning},
+	Offline:         {Active, Decommissioning},
+	Decommissioning: {Active, Retired},
+	Retired:         {Planned},
+}
+
+// CanTransition reports whether a device may move from one state to another.
+// Staying in the same state is always allowed, and a device stored with a status that is not
+// a state (e.g. set by hand in the database) may move to any state.
+func CanTransition(from, to string) bool {
+	if !slices.Contains(States, from) {
+		return slices.Contains(States, to)
+	}
+	return from == to || slices.Contains(transitions[from], to)
+}
+
+// Next returns the states a device in the given state may be saved with: the state itself,
+// then the ones it may move to, in lifecycle order
+func Next(from string) []string {
+	var next []string
+	if slices.Contains(States, from) {
+		next = append(next, from)
+	}
+	for _, s := range States {
+		if s != from && CanTransition(from, s) {
+			next = append(next, s)
+		}
+	}
+	return next
+}
+
+// IsReservation reports whether a device in the state only holds its addresses for later use.
+// Such devices show as reserved in the IP map and may be given an expiry.
+func IsReservation(state string) bool {
+	return state == Planned
+}
+
+// legacy maps the free-form statuses used before the lifecycle to their state
+var legacy = map[string]string{
+	"online":   Active,
+	"reserved": Planned,
+}
+
+// Normalize returns the state a status names, ignoring case. Statuses from before the
+// lifecycle ("Online", "Reserved") are mapped to their state; unknown ones are returned as is.
+func Normalize(status string) string {
+	status = strings.TrimSpace(status)
+	if s, ok := legacy[strings.ToLower(status)]; ok {
+		return s
+	}
+	for _, s := range States {
+		if strings.EqualFold(s, status) {
+			return s
+		}
+	}
+	return status
+}
+
+// TransitionError is returned when a device is moved to a state it may not reach from its current one
+type TransitionError struct {
+	From, To string
+}
+
+func (e *TransitionError) Error() string {
+	next := Next(e.From)[1:]
+	if len(next) == 0 {
+		return fmt.Sprintf("%s devices cannot change state", strings.ToLower(e.From))
+	}
+	return fmt.Sprintf("%s devices cannot become %s; they can become %s", strings.ToLower(e.From), strings.ToLower(e.To),
+		strings.ToLower(strings.Join(next, ", ")))
+}
+
+// DefaultColors are the badge colors of the states until they are changed in the settings
+var DefaultColors = map[string]string{
+	Planned:         "#a78bfa",
+	Staged:          "#38bdf8",
+	Active:          "#4ade80",
+	Offline:         "#f87171",
+	Decommissioning: "#fb923c",
+	Retired:         "#94a3b8",
+}
+
+var colorPattern = regexp.MustCompile(`^#[0-9a-f]{6}$`)
+
+// ValidColor reports whether a color is a lowercase hex color, e.g. "#4ade80"
+func ValidColor(color string) bool {
+	return colorPattern.MatchString(color)
+}
+
+var (
+	colorsMu sync.RWMutex
+	colors   = map[string]string{}
+)
+
+// SetColors replaces the configured state colors. States left out use their default color.
+func SetColors(c map[string]string) {
+	colorsMu.Lock()
+	defer colorsMu.Unlock()
+	colors = make(map[string]string, len(c))
+	for state, color := range c {
+		colors[state] = color
+	}
+}
+
+// Color returns the badge color of a state
+func Color(state string) string {
+	colorsMu.RLock()
+	defer colorsMu.RUnlock()
+	if c, ok := colors[state]; ok {
+		return c
+	}
+	if c, ok := DefaultColors[state]; ok {
+		return c
+	}
+	return DefaultColors[Retired]
+}
@@ -0,0 +1,124 @@
+package lifecycle
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{Planned, Staged, true},
+		{Planned, Active, true},
+		{Planned, Retired, true},
+		{Planned, Offline, false},
+		{Staged, Planned, true},
+		{Active, Offline, true},
+		{Active, Decommissioning, true},
+		{Active, Retired, false},
+		{Active, Planned, false},
+		{Offline, Active, true},
+		{Decommissioning, Retired, true},
+		{Decommissioning, Active, true},
+		{Retired, Planned, true},
+		{Retired, Active, false},
+		{Active, Active, true},
+		{Retired, Retired, true},
+		{"Broken", Retired, true},
+		{"Broken", "Gone", false},
+		{Active, "Gone", false},
+	}
+	for _, tt := range tests {
+		if got := CanTransition(tt.from, tt.to); got != tt.want {
+			t.Errorf("CanTransition(%q, %q) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		from string
+		want []string
+	}{
+		{Planned, []string{Planned, Staged, Active, Retired}},
+		{Active, []string{Active, Offline, Decommissioning}},
+		{Retired, []string{Retired, Planned}},
+		{"Broken", States},
+	}
+	for _, tt := range tests {
+		if got := Next(tt.from); !slices.Equal(got, tt.want) {
+			t.Errorf("Next(%q) = %v, want %v", tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Active", Active},
+		{" active ", Active},
+		{"RETIRED", Retired},
+		{"Online", Active},
+		{"reserved", Planned},
+		{"Broken", "Broken"},
+	}
+	for _, tt := range tests {
+		if got := Normalize(tt.in); got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestTransitionError(t *testing.T) {
+	tests := []struct {
+		err  TransitionError
+		want string
+	}{
+		{TransitionError{Active, Retired}, "active devices cannot become retired; they can become offline, decommissioning"},
+		{TransitionError{Retired, Active}, "retired devices cannot become active; they can become planned"},
+	}
+	for _, tt := range tests {
+		if got := tt.err.Error(); got != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestColor(t *testing.T) {
+	defer SetColors(nil)
+
+	SetColors(map[string]string{Active: "#000000"})
+	tests := []struct {
+		state, want string
+	}{
+		{Active, "#000000"},
+		{Planned, DefaultColors[Planned]},
+		{"Broken", DefaultColors[Retired]},
+	}
+	for _, tt := range tests {
+		if got := Color(tt.state); got != tt.want {
+			t.Errorf("Color(%q) = %q, want %q", tt.state, got, tt.want)
+		}
+	}
+}
+
+func TestValidColor(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"#4ade80", true},
+		{"#4ADE80", false},
+		{"4ade80", false},
+		{"#4ade8", false},
+		{"red", false},
+	}
+	for _, tt := range tests {
+		if got := ValidColor(tt.in); got != tt.want {
+			t.Errorf("ValidColor(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
@@ -1,35 +1,398 @@
 package models
 
-import "time"
+import (
+	"fmt"
+	"ipam/internal/lifecycle"
+	"strings"
+	"time"
+)
 
 // DeviceInterface represents a network interface for a device
 type DeviceInterface struct {
-	ID         int    `json:"id"`
-	DeviceID   int    `json:"device_id"`
-	IPAddress  string `json:"ip_address"`
-	MACAddress string `json:"mac_address"`
-	Label      string `json:"label"` // e.g. "LAN", "WAN", "Management"
+	ID             int    `json:"id"`
+	DeviceID       int    `json:"device_id"`
+	IPAddress      string `json:"ip_address"`
+	MACAddress     string `json:"mac_address"`
+	Label          string `json:"label"`            // e.g. "LAN", "WAN", "Management"
+	VLANMode       string `json:"vlan_mode"`        // "", "access" or "tagged"
+	UntaggedVLANID int    `json:"untagged_vlan_id"` // Access VLAN, or native VLAN in tagged mode
+	TaggedVLANIDs  []int  `json:"tagged_vlan_ids"`  // Only used in tagged mode
+	VRFID          int    `json:"vrf_id"`           // Routing domain the address belongs to
+	VRFName        string `json:"vrf_name"`         // Display purpose (from JOIN)
+	TenantID       int    `json:"tenant_id"`        // 0 when the interface belongs to the tenant of its device
+	TenantName     string `json:"tenant_name"`      // Display purpose (from JOIN)
+	Tags           Tags   `json:"tags"`
+	// The cable plugged into the interface and what is on its other end, nil if not cabled
+	Peer *CablePeer `json:"peer,omitempty"`
+}
+
+// Cable connects two device interfaces, e.g. a server NIC to a switch port
+type Cable struct {
+	ID           int       `json:"id"`
+	AInterfaceID int       `json:"a_interface_id"`
+	BInterfaceID int       `json:"b_interface_id"`
+	Type         string    `json:"type"`   // e.g. "Cat6", "DAC", "Fiber SMF"
+	Color        string    `json:"color"`  // e.g. "#0ea5e9", "" if not recorded
+	Length       float64   `json:"length"` // in meters, 0 if not recorded
+	Label        string    `json:"label"`
+	A            CableEnd  `json:"a"` // Display purpose (from JOIN)
+	B            CableEnd  `json:"b"` // Display purpose (from JOIN)
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// CableEnd describes the interface at one end of a cable
+type CableEnd struct {
+	DeviceID  int    `json:"device_id"`
+	Hostname  string `json:"hostname"`
+	Label     string `json:"label"`
+	IPAddress string `json:"ip_address"`
+}
+
+// String names the interface, e.g. "sw1 eth0 (10.0.0.2)"
+func (e CableEnd) String() string {
+	name := e.Hostname
+	if e.Label != "" {
+		name += " " + e.Label
+	}
+	return name + " (" + e.IPAddress + ")"
+}
+
+// CablePeer is the cable plugged into an interface, seen from that interface
+type CablePeer struct {
+	CableID     int      `json:"cable_id"`
+	InterfaceID int      `json:"interface_id"` // Interface on the other end
+	End         CableEnd `json:"end"`
+	Type        string   `json:"type"`
+	Color       string   `json:"color"`
+	Label       string   `json:"label"`
 }
 
 // Rack represents a physical equipment rack
 type Rack struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	SiteID     int    `json:"site_id"`     // 0 if the rack is not placed in a site
+	SiteName   string `json:"site_name"`   // Display purpose (from JOIN)
+	RegionName string `json:"region_name"` // Display purpose (from JOIN)
+	RoomID     int    `json:"room_id"`     // 0 if not in a specific room of the site
+	RoomName   string `json:"room_name"`   // Display purpose (from JOIN)
+	Height     int    `json:"height"`      // in U
+	Status     string `json:"status"`      // "Online", "Offline"
+	TenantID   int    `json:"tenant_id"`   // 0 if the rack has no tenant
+	TenantName string `json:"tenant_name"` // Display purpose (from JOIN)
+	Tags       Tags   `json:"tags"`
+	// Values of the custom fields defined for racks, keyed by field name
+	CustomFields map[string]string `json:"custom_fields"`
+	CreatedAt    time.Time         `json:"created_at"`
+	Version      int               `json:"version"`              // Bumped by every change, to detect concurrent edits
+	DeletedAt    *time.Time        `json:"deleted_at,omitempty"` // When the rack was moved to the trash, nil if it is not there
+	DeletedBy    string            `json:"deleted_by,omitempty"`
+}
+
+// Device represents a network device in the IPAM system
+type Device struct {
+	ID               int               `json:"id"`
+	Hostname         string            `json:"hostname"`
+	DeviceType       string            `json:"device_type"`
+	ModelID          int               `json:"model_id"`          // Catalog model, 0 if not recorded
+	ModelName        string            `json:"model_name"`        // Display purpose (from JOIN)
+	ManufacturerName string            `json:"manufacturer_name"` // Display purpose (from JOIN)
+	RackID           int               `json:"rack_id"`           // Foreign Key
+	RackName         string            `json:"rack_name"`         // Display purpose (from JOIN)
+	Position         int               `json:"position"`          // Lowest rack unit the device occupies, 0 when not mounted
+	UHeight          int               `json:"u_height"`          // Number of rack units the device takes up
+	Face             string            `json:"face"`              // Side of the rack it is mounted on, "front" or "rear"; "" when not mounted
+	Status           string            `json:"status"`            // Lifecycle state, e.g. "Planned", "Active", "Retired"
+	TenantID         int               `json:"tenant_id"`         // 0 if the device has no tenant
+	TenantName       string            `json:"tenant_name"`       // Display purpose (from JOIN)
+	Description      string            `json:"description"`
+	ExpiresAt        *time.Time        `json:"expires_at,omitempty"` // When a reservation is released, nil if it never is
+	UpdatedAt        time.Time         `json:"updated_at"`
+	Version          int               `json:"version"`              // Bumped by every change, to detect concurrent edits
+	DeletedAt        *time.Time        `json:"deleted_at,omitempty"` // When the device was moved to the trash, nil if it is not there
+	DeletedBy        string            `json:"deleted_by,omitempty"`
+	Interfaces       []DeviceInterface `json:"interfaces"` // One-to-many relationship
+	Tags             Tags              `json:"tags"`
+	// Values of the custom fields defined for devices, keyed by field name
+	CustomFields map[string]string `json:"custom_fields"`
+
+	// AllowDuplicates saves the device even when one of its IPs or MACs is already in use
+	AllowDuplicates bool `json:"-"`
+}
+
+// StatusEvent records a device moving from one lifecycle state to another
+type StatusEvent struct {
+	ID                int       `json:"id"`
+	DeviceID          int       `json:"device_id"`
+	From              string    `json:"from"` // "" when the device was created
+	To                string    `json:"to"`
+	ReleasedAddresses string    `json:"released_addresses"` // Addresses freed when the device was retired, comma separated
+	ChangedAt         time.Time `json:"changed_at"`
+}
+
+// AuditEntry records one change of a device or rack in the append-only audit log
+type AuditEntry struct {
+	ID         int           `json:"id"`
+	ObjectType string        `json:"object_type"` // "device" or "rack"
+	ObjectID   int           `json:"object_id"`
+	ObjectName string        `json:"object_name"` // Hostname or rack name at the time of the change
+	Action     string        `json:"action"`      // "baseline", "create", "update", "delete", "restore" or "purge"
+	Actor      string        `json:"actor"`
+	Changes    []FieldChange `json:"changes"`
+	CreatedAt  time.Time     `json:"created_at"`
+}
+
+// FieldChange is the value of one field before and after a change, "" when it was or became unset
+type FieldChange struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// AddressAssignment is a period during which a device held an IP address, rebuilt from the audit log
+type AddressAssignment struct {
+	IPAddress string     `json:"ip_address"`
+	DeviceID  int        `json:"device_id"`
+	Hostname  string     `json:"hostname"`
+	Label     string     `json:"label"`    // Interface holding the address
+	VRFName   string     `json:"vrf_name"` // Routing domain of the address
+	From      time.Time  `json:"from"`
+	Until     *time.Time `json:"until,omitempty"` // nil while the device still holds the address
+}
+
+// Overlaps reports whether the device held the address at some point between start and end
+func (a AddressAssignment) Overlaps(start, end time.Time) bool {
+	return a.From.Before(end) && (a.Until == nil || a.Until.After(start))
+}
+
+// TenantGroup groups tenants, e.g. "Internal" and "Customers"
+type TenantGroup struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Tenant is a team or customer owning devices, racks and interfaces
+type Tenant struct {
+	ID          int       `json:"id"`
+	GroupID     int       `json:"group_id"`   // 0 if the tenant is not in a group
+	GroupName   string    `json:"group_name"` // Display purpose (from JOIN)
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Manufacturer is a hardware vendor, e.g. "Ubiquiti"
+type Manufacturer struct {
 	ID        int       `json:"id"`
 	Name      string    `json:"name"`
-	Location  string    `json:"location"`
-	Height    int       `json:"height"` // in U
-	Status    string    `json:"status"` // "Online", "Offline"
 	CreatedAt time.Time `json:"created_at"`
 }
 
-// Device represents a network device in the IPAM system
-type Device struct {
-	ID          int               `json:"id"`
-	Hostname    string            `json:"hostname"`
-	DeviceType  string            `json:"device_type"`
-	RackID      int               `json:"rack_id"`   // Foreign Key
-	RackName    string            `json:"rack_name"` // Display purpose (from JOIN)
-	Status      string            `json:"status"`    // e.g., "Online", "Offline", "Reserved"
-	Description string            `json:"description"`
-	UpdatedAt   time.Time         `json:"updated_at"`
-	Interfaces  []DeviceInterface `json:"interfaces"` // One-to-many relationship
+// DeviceModel is a hardware model in the catalog. Devices created from it start with its height
+// and one interface per label of its interface template.
+type DeviceModel struct {
+	ID               int       `json:"id"`
+	ManufacturerID   int       `json:"manufacturer_id"`
+	ManufacturerName string    `json:"manufacturer_name"` // Display purpose (from JOIN)
+	Name             string    `json:"name"`
+	PartNumber       string    `json:"part_number"`
+	UHeight          int       `json:"u_height"`
+	Interfaces       []string  `json:"interfaces"` // Labels of the interfaces new devices get, e.g. "eth0", "ipmi"
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// InterfaceTemplate returns the interface labels of the model one per line
+func (m DeviceModel) InterfaceTemplate() string {
+	return strings.Join(m.Interfaces, "\n")
+}
+
+// StatusColor returns the badge color of the lifecycle state of the device
+func (d Device) StatusColor() string {
+	return lifecycle.Color(d.Status)
+}
+
+// TopUnit returns the highest rack unit the device occupies, 0 when it is not mounted
+func (d Device) TopUnit() int {
+	if d.Position == 0 {
+		return 0
+	}
+	return d.Position + max(d.UHeight, 1) - 1
+}
+
+// Units describes where the device is mounted, e.g. "U12–U13 front", or "" when it is not mounted
+func (d Device) Units() string {
+	switch {
+	case d.Position == 0:
+		return ""
+	case d.TopUnit() == d.Position:
+		return fmt.Sprintf("U%d %s", d.Position, d.Face)
+	default:
+		return fmt.Sprintf("U%d–U%d %s", d.Position, d.TopUnit(), d.Face)
+	}
+}
+
+// Subnet represents an explicitly defined IP prefix (e.g. 192.168.1.0/24)
+type Subnet struct {
+	ID          int    `json:"id"`
+	CIDR        string `json:"cidr"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Gateway     string `json:"gateway"`
+	Status      string `json:"status"`    // "Active", "Reserved", "Deprecated"
+	VLANID      int    `json:"vlan_id"`   // VLAN carrying this prefix, 0 if none
+	VLANVID     int    `json:"vlan_vid"`  // Display purpose (from JOIN)
+	VLANName    string `json:"vlan_name"` // Display purpose (from JOIN)
+	VRFID       int    `json:"vrf_id"`    // Routing domain the prefix belongs to
+	VRFName     string `json:"vrf_name"`  // Display purpose (from JOIN)
+	ParentID    int    `json:"parent_id"` // Computed from containment, not stored
+	Tags        Tags   `json:"tags"`
+	// Values of the custom fields defined for subnets, keyed by field name
+	CustomFields map[string]string `json:"custom_fields"`
+	CreatedAt    time.Time         `json:"created_at"`
+}
+
+// VLAN represents an 802.1Q VLAN. VIDs are unique within a group (e.g. a site).
+type VLAN struct {
+	ID          int       `json:"id"`
+	VID         int       `json:"vid"` // 1-4094
+	Name        string    `json:"name"`
+	Group       string    `json:"group"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// VRF is an isolated routing domain. Addresses and prefixes only have to be unique
+// Region groups sites, e.g. a country or a metro area
+type Region struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Site is a building or datacenter holding racks, optionally in a region
+type Site struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	RegionID    int       `json:"region_id"`   // 0 if not in a region
+	RegionName  string    `json:"region_name"` // Display purpose (from JOIN)
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Room is a room or cage inside a site
+type Room struct {
+	ID          int       `json:"id"`
+	SiteID      int       `json:"site_id"`
+	SiteName    string    `json:"site_name"` // Display purpose (from JOIN)
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// within their VRF, so several labs can reuse e.g. 192.168.1.0/24.
+type VRF struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	RD          string    `json:"rd"` // Route distinguisher, e.g. "65000:1" (optional)
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// IPRange is a run of addresses inside a subnet set aside for a purpose,
+// e.g. a DHCP pool or a block reserved for infrastructure
+type IPRange struct {
+	ID          int       `json:"id"`
+	SubnetID    int       `json:"subnet_id"`
+	SubnetCIDR  string    `json:"subnet_cidr"` // Display purpose (from JOIN)
+	VRFID       int       `json:"vrf_id"`      // VRF of the subnet (from JOIN)
+	StartIP     string    `json:"start_ip"`
+	EndIP       string    `json:"end_ip"`
+	Type        string    `json:"type"` // "DHCP", "Static", "Infrastructure"
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// IPAddress is an address record that exists on its own, e.g. held for a future VM,
+// a vendor or a VIP. It can later be attached to a device interface.
+type IPAddress struct {
+	ID             int       `json:"id"`
+	VRFID          int       `json:"vrf_id"`
+	VRFName        string    `json:"vrf_name"` // Display purpose (from JOIN)
+	Address        string    `json:"address"`
+	Status         string    `json:"status"` // "Reserved", "Active", "Deprecated"
+	DNSName        string    `json:"dns_name"`
+	Owner          string    `json:"owner"`
+	Purpose        string    `json:"purpose"`
+	Description    string    `json:"description"`
+	InterfaceID    int       `json:"interface_id"`    // Attached interface, 0 if standalone
+	DeviceID       int       `json:"device_id"`       // Display purpose (from JOIN)
+	Hostname       string    `json:"hostname"`        // Display purpose (from JOIN)
+	InterfaceLabel string    `json:"interface_label"` // Display purpose (from JOIN)
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// ReservationEvent records something that happened to a time-limited reservation:
+// it was extended by a user, or released by the reaper once it expired
+type ReservationEvent struct {
+	ID        int       `json:"id"`
+	DeviceID  int       `json:"device_id"`
+	Hostname  string    `json:"hostname"`
+	Addresses string    `json:"addresses"` // Addresses held at the time, comma separated
+	Action    string    `json:"action"`    // "Extended", "Released"
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Tag is a free-form label (e.g. "k8s", "backup-target") attached to devices, racks,
+// interfaces and subnets to group them across racks and networks
+type Tag struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Color     string    `json:"color"`           // e.g. "#0ea5e9"
+	Count     int       `json:"count,omitempty"` // Number of tagged objects (tag listing only)
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CustomField is an admin-defined extra field of devices, racks or subnets. Values are stored
+// as text in a canonical form: integers in decimal, booleans as "true"/"false", dates as YYYY-MM-DD.
+type CustomField struct {
+	ID         int       `json:"id"`
+	ObjectType string    `json:"object_type"`       // "device", "rack" or "subnet"
+	Name       string    `json:"name"`              // Key used in exports and the API, e.g. "asset_tag"
+	Label      string    `json:"label"`             // Shown in the forms, e.g. "Asset Tag"
+	Type       string    `json:"type"`              // "text", "integer", "boolean", "date", "select" or "url"
+	Options    []string  `json:"options,omitempty"` // Choices of a select field
+	Required   bool      `json:"required"`
+	Position   int       `json:"position"` // Fields are shown in ascending position
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// FormName is the name of the field's input in the object forms
+func (f CustomField) FormName() string {
+	return "cf_" + f.Name
+}
+
+// Tags is the list of tags of an object. It prints as comma separated names, as edited in the forms.
+type Tags []Tag
+
+func (t Tags) String() string {
+	names := make([]string, len(t))
+	for i, tag := range t {
+		names[i] = tag.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// Has reports whether the list contains the tag with the given name
+func (t Tags) Has(name string) bool {
+	for _, tag := range t {
+		if tag.Name == name {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,205 @@
+package netutil
+
+import (
+	"fmt"
+	"math"
+	"net/netip"
+	"strings"
+)
+
+// ParsePrefix parses a CIDR string and returns it in canonical (masked) form
+// e.g. "192.168.1.17/24" becomes 192.168.1.0/24
+func ParsePrefix(s string) (netip.Prefix, error) {
+	p, err := netip.ParsePrefix(strings.TrimSpace(s))
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid CIDR %q", s)
+	}
+	return p.Masked(), nil
+}
+
+// ParseAddr parses an IPv4 or IPv6 address, ignoring surrounding and embedded spaces.
+// IPv4-mapped IPv6 addresses are returned as plain IPv4.
+func ParseAddr(s string) (netip.Addr, error) {
+	addr, err := netip.ParseAddr(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("invalid IP address %q", s)
+	}
+	return addr.Unmap(), nil
+}
+
+// CanonicalAddr returns the canonical text form of an address
+// e.g. "2001:DB8:0:0::1" becomes "2001:db8::1"
+func CanonicalAddr(s string) (string, error) {
+	addr, err := ParseAddr(s)
+	if err != nil {
+		return "", err
+	}
+	return addr.String(), nil
+}
+
+// HostBounds returns the first and last usable host addresses of a prefix.
+// For IPv4 prefixes shorter than /31 the network and broadcast addresses are excluded;
+// for IPv6 prefixes shorter than /127 the Subnet-Router anycast (network) address is excluded.
+func HostBounds(p netip.Prefix) (first, last netip.Addr) {
+	p = p.Masked()
+	first = p.Addr()
+	last = LastAddr(p)
+	if p.Addr().Is4() && p.Bits() < 31 {
+		first = first.Next()
+		last = last.Prev()
+	} else if p.Addr().Is6() && p.Bits() < 127 {
+		first = first.Next()
+	}
+	return first, last
+}
+
+// LastAddr returns the highest address contained in the prefix (the broadcast address for IPv4)
+func LastAddr(p netip.Prefix) netip.Addr {
+	p = p.Masked()
+	b := p.Addr().AsSlice()
+	hostBits := len(b)*8 - p.Bits()
+	for i := len(b) - 1; i >= 0 && hostBits > 0; i-- {
+		if hostBits >= 8 {
+			b[i] = 0xff
+			hostBits -= 8
+		} else {
+			b[i] |= byte(1<<hostBits) - 1
+			hostBits = 0
+		}
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+// HostCount returns the number of usable host addresses in a prefix.
+// Very large prefixes saturate at the maximum int value.
+func HostCount(p netip.Prefix) int {
+	hostBits := p.Addr().BitLen() - p.Bits()
+	if hostBits >= 62 {
+		return int(^uint(0) >> 1)
+	}
+	count := 1 << hostBits
+	if p.Addr().Is4() && p.Bits() < 31 {
+		count -= 2
+	} else if p.Addr().Is6() && p.Bits() < 127 {
+		count--
+	}
+	return count
+}
+
+// IsLarge reports whether a prefix holds too many addresses to count exactly with HostCount
+func IsLarge(p netip.Prefix) bool {
+	return p.Addr().BitLen()-p.Bits() >= 62
+}
+
+// SizeLabel formats an address count for display, using powers of two for large prefixes
+// e.g. "254" for a /24, "2^64" for an IPv6 /64
+func SizeLabel(p netip.Prefix, count int) string {
+	if IsLarge(p) {
+		return fmt.Sprintf("2^%d", p.Addr().BitLen()-p.Bits())
+	}
+	return fmt.Sprintf("%d", count)
+}
+
+// HostLabel returns a short label for an address inside a prefix, used on the IP map.
+// For a /24 or smaller this is the last octet; larger IPv4 prefixes show the last two octets.
+func HostLabel(p netip.Prefix, addr netip.Addr) string {
+	if addr.Is4() {
+		b := addr.As4()
+		if p.Bits() >= 24 {
+			return fmt.Sprintf("%d", b[3])
+		}
+		return fmt.Sprintf("%d.%d", b[2], b[3])
+	}
+	// IPv6: show the last two hextets, e.g. "::1" or "::a:1"
+	b := addr.As16()
+	high := uint16(b[12])<<8 | uint16(b[13])
+	low := uint16(b[14])<<8 | uint16(b[15])
+	if high == 0 {
+		return fmt.Sprintf("::%x", low)
+	}
+	return fmt.Sprintf("::%x:%x", high, low)
+}
+
+// ComparePrefixes orders prefixes by address first, then by prefix length (shorter first)
+func ComparePrefixes(a, b netip.Prefix) int {
+	if c := a.Addr().Compare(b.Addr()); c != 0 {
+		return c
+	}
+	return a.Bits() - b.Bits()
+}
+
+// FreeChildPrefixes returns up to limit aligned prefixes of the given length inside parent
+// that do not overlap any of the used prefixes, lowest address first.
+func FreeChildPrefixes(parent netip.Prefix, bits int, used []netip.Prefix, limit int) []netip.Prefix {
+	parent = parent.Masked()
+	if bits < parent.Bits() || bits > parent.Addr().BitLen() {
+		return nil
+	}
+
+	var free []netip.Prefix
+	candidate := netip.PrefixFrom(parent.Addr(), bits)
+	for candidate.IsValid() && parent.Contains(candidate.Addr()) && len(free) < limit {
+		var blocker netip.Prefix
+		for _, u := range used {
+			if u.Overlaps(candidate) {
+				blocker = u
+				break
+			}
+		}
+
+		end := LastAddr(candidate)
+		if blocker.IsValid() {
+			// Skip past whichever ends later: the candidate or the prefix blocking it
+			if last := LastAddr(blocker); last.Compare(end) > 0 {
+				end = last
+			}
+		} else {
+			free = append(free, candidate)
+		}
+
+		next := end.Next()
+		if !next.IsValid() {
+			break
+		}
+		candidate = netip.PrefixFrom(next, bits).Masked()
+		if candidate.Addr().Compare(next) < 0 {
+			// next was not aligned to the requested length; move to the following block
+			candidate = netip.PrefixFrom(LastAddr(candidate).Next(), bits)
+		}
+	}
+	return free
+}
+
+// Segment is a block of a parent prefix that is either covered by a child prefix or free
+type Segment struct {
+	Prefix netip.Prefix
+	Used   bool
+}
+
+// Segments splits parent into the largest aligned blocks that are either fully used
+// by one of the used prefixes or entirely free, in address order.
+func Segments(parent netip.Prefix, used []netip.Prefix) []Segment {
+	parent = parent.Masked()
+	var overlapping []netip.Prefix
+	for _, u := range used {
+		if u.Overlaps(parent) {
+			if u.Bits() <= parent.Bits() {
+				return []Segment{{Prefix: parent, Used: true}}
+			}
+			overlapping = append(overlapping, u)
+		}
+	}
+	if len(overlapping) == 0 {
+		return []Segment{{Prefix: parent}}
+	}
+
+	lower := netip.PrefixFrom(parent.Addr(), parent.Bits()+1)
+	upper := netip.PrefixFrom(LastAddr(lower).Next(), parent.Bits()+1)
+	return append(Segments(lower, overlapping), Segments(upper, overlapping)...)
+}
+
+// Fraction returns the share of parent covered by child, between 0 and 1
+func Fraction(parent, child netip.Prefix) float64 {
+	return math.Ldexp(1, parent.Bits()-child.Bits())
+}
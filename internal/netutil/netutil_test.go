@@ -0,0 +1,139 @@
+package netutil
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestCanonicalAddr(t *testing.T) {
+	tests := []struct {
+		in, want string
+		wantErr  bool
+	}{
+		{in: "10.0.0.1", want: "10.0.0.1"},
+		{in: " 10.0.0.1 ", want: "10.0.0.1"},
+		{in: "2001:DB8:0:0::1", want: "2001:db8::1"},
+		{in: "::ffff:192.168.1.5", want: "192.168.1.5"},
+		{in: "10.0.0.256", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := CanonicalAddr(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("CanonicalAddr(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("CanonicalAddr(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestHostBounds(t *testing.T) {
+	tests := []struct {
+		prefix, first, last string
+	}{
+		{"192.168.1.0/24", "192.168.1.1", "192.168.1.254"},
+		{"192.168.1.17/24", "192.168.1.1", "192.168.1.254"},
+		{"10.0.0.0/31", "10.0.0.0", "10.0.0.1"},
+		{"10.0.0.5/32", "10.0.0.5", "10.0.0.5"},
+		{"2001:db8::/64", "2001:db8::1", "2001:db8::ffff:ffff:ffff:ffff"},
+		{"2001:db8::/127", "2001:db8::", "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		first, last := HostBounds(netip.MustParsePrefix(tt.prefix))
+		if first.String() != tt.first || last.String() != tt.last {
+			t.Errorf("HostBounds(%s) = %s, %s, want %s, %s", tt.prefix, first, last, tt.first, tt.last)
+		}
+	}
+}
+
+func TestHostCount(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   int
+	}{
+		{"192.168.1.0/24", 254},
+		{"10.0.0.0/31", 2},
+		{"10.0.0.0/32", 1},
+		{"2001:db8::/120", 255},
+		{"2001:db8::/64", int(^uint(0) >> 1)},
+	}
+	for _, tt := range tests {
+		if got := HostCount(netip.MustParsePrefix(tt.prefix)); got != tt.want {
+			t.Errorf("HostCount(%s) = %d, want %d", tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestFreeChildPrefixes(t *testing.T) {
+	tests := []struct {
+		name   string
+		parent string
+		bits   int
+		used   []string
+		limit  int
+		want   []string
+	}{
+		{
+			name:   "empty parent",
+			parent: "10.0.0.0/24", bits: 26, limit: 10,
+			want: []string{"10.0.0.0/26", "10.0.0.64/26", "10.0.0.128/26", "10.0.0.192/26"},
+		},
+		{
+			name:   "skips used blocks",
+			parent: "10.0.0.0/24", bits: 26, limit: 10,
+			used: []string{"10.0.0.0/26", "10.0.0.128/25"},
+			want: []string{"10.0.0.64/26"},
+		},
+		{
+			name:   "skips past a smaller used prefix",
+			parent: "10.0.0.0/24", bits: 25, limit: 10,
+			used: []string{"10.0.0.8/29"},
+			want: []string{"10.0.0.128/25"},
+		},
+		{
+			name:   "stops at the limit",
+			parent: "10.0.0.0/24", bits: 28, limit: 2,
+			want: []string{"10.0.0.0/28", "10.0.0.16/28"},
+		},
+		{
+			name:   "length shorter than the parent",
+			parent: "10.0.0.0/24", bits: 16, limit: 10,
+		},
+	}
+	for _, tt := range tests {
+		var used []netip.Prefix
+		for _, u := range tt.used {
+			used = append(used, netip.MustParsePrefix(u))
+		}
+		got := FreeChildPrefixes(netip.MustParsePrefix(tt.parent), tt.bits, used, tt.limit)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i].String() != tt.want[i] {
+				t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestSegments(t *testing.T) {
+	got := Segments(netip.MustParsePrefix("10.0.0.0/24"), []netip.Prefix{netip.MustParsePrefix("10.0.0.64/26")})
+	want := []Segment{
+		{Prefix: netip.MustParsePrefix("10.0.0.0/26")},
+		{Prefix: netip.MustParsePrefix("10.0.0.64/26"), Used: true},
+		{Prefix: netip.MustParsePrefix("10.0.0.128/25")},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Segments = %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("Segments[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,303 @@
+// Package validate checks devices, interfaces, racks, cables, device models and custom field values before they are saved.
+// Problems are reported per form field so the forms can show them next to the input.
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"ipam/internal/lifecycle"
+	"ipam/internal/models"
+	"ipam/internal/netutil"
+	"net"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CableTypes are the kinds of cable that can connect two interfaces
+var CableTypes = []string{"Cat5e", "Cat6", "Cat6a", "DAC", "Fiber MMF", "Fiber SMF", "Console", "Other"}
+
+var cableColorPattern = regexp.MustCompile(`^#[0-9a-f]{6}$`)
+
+// Rack height bounds, in U
+const (
+	MinRackHeight = 1
+	MaxRackHeight = 60
+)
+
+// DeviceStatuses are the statuses a device can have: the lifecycle states
+var DeviceStatuses = lifecycle.States
+
+// RackStatuses are the statuses a rack can have
+var RackStatuses = []string{"Online", "Offline", "Maintenance"}
+
+// RackFaces are the sides of a rack a device can be mounted on
+var RackFaces = []string{"front", "rear"}
+
+// Errors maps a form field name to what is wrong with its value.
+// Interface fields are named with InterfaceField.
+type Errors map[string]string
+
+// Add records a problem with a field, keeping the first one if there are several
+func (e Errors) Add(field, msg string) {
+	if _, ok := e[field]; !ok {
+		e[field] = msg
+	}
+}
+
+// Merge copies the problems of other into e
+func (e Errors) Merge(other Errors) {
+	for field, msg := range other {
+		e.Add(field, msg)
+	}
+}
+
+// InterfaceField names a field of the i-th interface of a device, e.g. "interfaces.0.ip_address"
+func InterfaceField(i int, name string) string {
+	return fmt.Sprintf("interfaces.%d.%s", i, name)
+}
+
+// Device checks a device and its interfaces. racks are the existing racks the device may be placed in.
+func Device(d models.Device, racks []models.Rack) Errors {
+	errs := Errors{}
+	if err := Hostname(d.Hostname); err != nil {
+		errs.Add("hostname", err.Error())
+	}
+	if !slices.Contains(DeviceStatuses, d.Status) {
+		errs.Add("status", fmt.Sprintf("unknown status %q", d.Status))
+	} else if d.ID == 0 && !slices.Contains(lifecycle.Initial, d.Status) {
+		errs.Add("status", fmt.Sprintf("a new device cannot start as %s", strings.ToLower(d.Status)))
+	}
+	rack := slices.IndexFunc(racks, func(r models.Rack) bool { return r.ID == d.RackID })
+	if d.RackID != 0 && rack < 0 {
+		errs.Add("rack_id", "the selected rack does not exist")
+	}
+	if d.UHeight < 1 || d.UHeight > MaxRackHeight {
+		errs.Add("u_height", fmt.Sprintf("height must be between 1 and %d U", MaxRackHeight))
+	}
+	if d.Position < 0 {
+		errs.Add("position", "position must be a positive unit number")
+	} else if d.Position > 0 {
+		if d.RackID == 0 {
+			errs.Add("position", "select a rack to mount the device in")
+		} else if rack >= 0 && d.TopUnit() > racks[rack].Height {
+			errs.Add("position", fmt.Sprintf("U%d–U%d does not fit in the %dU rack", d.Position, d.TopUnit(), racks[rack].Height))
+		}
+		if !slices.Contains(RackFaces, d.Face) {
+			errs.Add("face", fmt.Sprintf("unknown rack face %q", d.Face))
+		}
+	}
+	for i, iface := range d.Interfaces {
+		errs.Merge(Interface(i, iface))
+	}
+	return errs
+}
+
+// Interface checks the i-th interface of a device. The address is optional, e.g. for switch
+// ports, and only checked when one is given.
+func Interface(i int, iface models.DeviceInterface) Errors {
+	errs := Errors{}
+	if strings.TrimSpace(iface.IPAddress) != "" {
+		if _, err := netutil.ParseAddr(iface.IPAddress); err != nil {
+			errs.Add(InterfaceField(i, "ip_address"), err.Error())
+		}
+	}
+	if iface.MACAddress != "" {
+		if err := MAC(iface.MACAddress); err != nil {
+			errs.Add(InterfaceField(i, "mac_address"), err.Error())
+		}
+	}
+	if len(iface.Label) > 64 {
+		errs.Add(InterfaceField(i, "label"), "label must be at most 64 characters")
+	}
+	return errs
+}
+
+// Rack checks a rack. sites and rooms are the existing places the rack may be put in.
+func Rack(r models.Rack, sites []models.Site, rooms []models.Room) Errors {
+	errs := Errors{}
+	name := strings.TrimSpace(r.Name)
+	if name == "" {
+		errs.Add("name", "rack name is required")
+	} else if len(name) > 64 {
+		errs.Add("name", "rack name must be at most 64 characters")
+	}
+	if r.Height < MinRackHeight || r.Height > MaxRackHeight {
+		errs.Add("height", fmt.Sprintf("height must be between %d and %d U", MinRackHeight, MaxRackHeight))
+	}
+	if r.Status != "" && !slices.Contains(RackStatuses, r.Status) {
+		errs.Add("status", fmt.Sprintf("unknown status %q", r.Status))
+	}
+	if r.SiteID != 0 && !slices.ContainsFunc(sites, func(s models.Site) bool { return s.ID == r.SiteID }) {
+		errs.Add("site_id", "the selected site does not exist")
+	}
+	if r.RoomID != 0 {
+		i := slices.IndexFunc(rooms, func(rm models.Room) bool { return rm.ID == r.RoomID })
+		if i < 0 {
+			errs.Add("room_id", "the selected room does not exist")
+		} else if r.SiteID != 0 && rooms[i].SiteID != r.SiteID {
+			errs.Add("room_id", "the selected room is not in the selected site")
+		}
+	}
+	return errs
+}
+
+// Cable checks a cable. Its color is expected lowercase, e.g. "#0ea5e9".
+func Cable(c models.Cable) Errors {
+	errs := Errors{}
+	if c.AInterfaceID == 0 {
+		errs.Add("a_interface_id", "select the interface on the A end")
+	}
+	if c.BInterfaceID == 0 {
+		errs.Add("b_interface_id", "select the interface on the B end")
+	} else if c.BInterfaceID == c.AInterfaceID {
+		errs.Add("b_interface_id", "a cable needs two different interfaces")
+	}
+	if !slices.Contains(CableTypes, c.Type) {
+		errs.Add("type", fmt.Sprintf("unknown cable type %q", c.Type))
+	}
+	if c.Color != "" && !cableColorPattern.MatchString(c.Color) {
+		errs.Add("color", fmt.Sprintf("invalid color %q, expected e.g. #0ea5e9", c.Color))
+	}
+	if c.Length < 0 || c.Length > 10000 {
+		errs.Add("length", "length must be between 0 and 10000 m")
+	}
+	if len(c.Label) > 64 {
+		errs.Add("label", "label must be at most 64 characters")
+	}
+	return errs
+}
+
+// DeviceModel checks a catalog model. manufacturers are the existing manufacturers it may belong to.
+func DeviceModel(m models.DeviceModel, manufacturers []models.Manufacturer) Errors {
+	errs := Errors{}
+	if !slices.ContainsFunc(manufacturers, func(v models.Manufacturer) bool { return v.ID == m.ManufacturerID }) {
+		errs.Add("manufacturer_id", "select the manufacturer of the model")
+	}
+	name := strings.TrimSpace(m.Name)
+	if name == "" {
+		errs.Add("name", "model name is required")
+	} else if len(name) > 64 {
+		errs.Add("name", "model name must be at most 64 characters")
+	}
+	if len(m.PartNumber) > 64 {
+		errs.Add("part_number", "part number must be at most 64 characters")
+	}
+	if m.UHeight < 1 || m.UHeight > MaxRackHeight {
+		errs.Add("u_height", fmt.Sprintf("height must be between 1 and %d U", MaxRackHeight))
+	}
+	for _, label := range m.Interfaces {
+		if len(strings.TrimSpace(label)) > 64 {
+			errs.Add("interfaces", "interface labels must be at most 64 characters")
+		}
+	}
+	return errs
+}
+
+// MaxCustomTextLength caps the length of text custom field values
+const MaxCustomTextLength = 255
+
+// CustomFields checks the submitted values of the custom fields of an object and returns them in
+// canonical form, keyed by field name. Problems are reported under each field's FormName.
+func CustomFields(fields []models.CustomField, submitted map[string]string) (map[string]string, Errors) {
+	values := make(map[string]string)
+	errs := Errors{}
+	for _, f := range fields {
+		value, err := CustomField(f, submitted[f.Name])
+		if err != nil {
+			errs.Add(f.FormName(), err.Error())
+		}
+		values[f.Name] = value
+	}
+	return values, errs
+}
+
+// CustomField checks a value of a custom field and returns it in canonical form. When the value
+// is invalid it is returned as submitted, so the form can show it again.
+func CustomField(f models.CustomField, value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if f.Type == "boolean" {
+		// Unchecked boxes are not submitted
+		b, _ := strconv.ParseBool(value)
+		return strconv.FormatBool(b), nil
+	}
+	if value == "" {
+		if f.Required {
+			return "", fmt.Errorf("%s is required", f.Label)
+		}
+		return "", nil
+	}
+
+	switch f.Type {
+	case "text":
+		if len(value) > MaxCustomTextLength {
+			return value, fmt.Errorf("%s must be at most %d characters", f.Label, MaxCustomTextLength)
+		}
+	case "integer":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return value, fmt.Errorf("%s must be a whole number", f.Label)
+		}
+		return strconv.FormatInt(n, 10), nil
+	case "date":
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			return value, fmt.Errorf("%s must be a date like 2026-01-31", f.Label)
+		}
+	case "select":
+		if !slices.Contains(f.Options, value) {
+			return value, fmt.Errorf("%s must be one of: %s", f.Label, strings.Join(f.Options, ", "))
+		}
+	case "url":
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return value, fmt.Errorf("%s must be an http:// or https:// URL", f.Label)
+		}
+	}
+	return value, nil
+}
+
+// TenantID checks that the tenant an object is assigned to exists; 0 means no tenant
+func TenantID(id int, tenants []models.Tenant) error {
+	if id != 0 && !slices.ContainsFunc(tenants, func(t models.Tenant) bool { return t.ID == id }) {
+		return errors.New("the selected tenant does not exist")
+	}
+	return nil
+}
+
+// Hostname checks that s is a valid host name (RFC 1123): dot separated labels of 1 to 63
+// letters, digits and hyphens, not starting or ending with a hyphen, 253 characters at most
+func Hostname(s string) error {
+	if s == "" {
+		return fmt.Errorf("hostname is required")
+	}
+	if len(s) > 253 {
+		return fmt.Errorf("hostname must be at most 253 characters")
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(s, "."), ".") {
+		if label == "" || len(label) > 63 {
+			return fmt.Errorf("hostname %q: each part between dots must be 1 to 63 characters", s)
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("hostname %q: parts may not start or end with a hyphen", s)
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return fmt.Errorf("hostname %q: only letters, digits, hyphens and dots are allowed", s)
+			}
+		}
+	}
+	return nil
+}
+
+// MAC checks that s is a 48 or 64 bit MAC address, e.g. "aa:bb:cc:dd:ee:ff",
+// "AA-BB-CC-DD-EE-FF" or "aabb.ccdd.eeff"
+func MAC(s string) error {
+	hw, err := net.ParseMAC(s)
+	if err != nil || (len(hw) != 6 && len(hw) != 8) {
+		return fmt.Errorf("invalid MAC address %q", s)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package validate
+
+import (
+	"ipam/internal/lifecycle"
+	"ipam/internal/models"
+	"strings"
+	"testing"
+)
+
+func TestHostname(t *testing.T) {
+	tests := []struct {
+		in    string
+		valid bool
+	}{
+		{"web01", true},
+		{"web-01.example.com", true},
+		{"web01.example.com.", true},
+		{"1host", true},
+		{"", false},
+		{"-web", false},
+		{"web-", false},
+		{"web..example", false},
+		{"web_01", false},
+		{"web 01", false},
+		{strings.Repeat("a", 64), false},
+		{strings.Repeat("a.", 127) + "ab", false},
+	}
+	for _, tt := range tests {
+		if err := Hostname(tt.in); (err == nil) != tt.valid {
+			t.Errorf("Hostname(%q) = %v, want valid %v", tt.in, err, tt.valid)
+		}
+	}
+}
+
+func TestMAC(t *testing.T) {
+	tests := []struct {
+		in    string
+		valid bool
+	}{
+		{"aa:bb:cc:dd:ee:ff", true},
+		{"AA-BB-CC-DD-EE-FF", true},
+		{"aabb.ccdd.eeff", true},
+		{"aa:bb:cc:dd:ee:ff:00:11", true},
+		{"aa:bb:cc:dd:ee", false},
+		{"gg:bb:cc:dd:ee:ff", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if err := MAC(tt.in); (err == nil) != tt.valid {
+			t.Errorf("MAC(%q) = %v, want valid %v", tt.in, err, tt.valid)
+		}
+	}
+}
+
+func TestDevice(t *testing.T) {
+	racks := []models.Rack{{ID: 1, Height: 42}}
+	valid := models.Device{Hostname: "web01", Status: lifecycle.Active, UHeight: 1}
+	tests := []struct {
+		name   string
+		modify func(d *models.Device)
+		fields []string // Fields expected to have an error, none for a valid device
+	}{
+		{name: "valid", modify: func(d *models.Device) {}},
+		{name: "no hostname", modify: func(d *models.Device) { d.Hostname = "" }, fields: []string{"hostname"}},
+		{name: "unknown status", modify: func(d *models.Device) { d.Status = "Broken" }, fields: []string{"status"}},
+		{name: "new device retired", modify: func(d *models.Device) { d.Status = lifecycle.Retired }, fields: []string{"status"}},
+		{name: "existing device retired", modify: func(d *models.Device) { d.ID, d.Status = 5, lifecycle.Retired }},
+		{name: "unknown rack", modify: func(d *models.Device) { d.RackID = 9 }, fields: []string{"rack_id"}},
+		{name: "too tall", modify: func(d *models.Device) { d.UHeight = 61 }, fields: []string{"u_height"}},
+		{name: "mounted without rack", modify: func(d *models.Device) { d.Position, d.Face = 1, "front" }, fields: []string{"position"}},
+		{
+			name:   "sticking out of the rack",
+			modify: func(d *models.Device) { d.RackID, d.Position, d.UHeight, d.Face = 1, 41, 4, "front" },
+			fields: []string{"position"},
+		},
+		{name: "unknown face", modify: func(d *models.Device) { d.RackID, d.Position, d.Face = 1, 1, "side" }, fields: []string{"face"}},
+		{
+			name: "invalid interfaces",
+			modify: func(d *models.Device) {
+				d.Interfaces = []models.DeviceInterface{{IPAddress: "10.0.0.1"}, {IPAddress: "10.0.0.300", MACAddress: "nope"}}
+			},
+			fields: []string{InterfaceField(1, "ip_address"), InterfaceField(1, "mac_address")},
+		},
+		{
+			name:   "interface without address",
+			modify: func(d *models.Device) { d.Interfaces = []models.DeviceInterface{{Label: "eth0"}} },
+		},
+		{
+			name: "retired device interface without address",
+			modify: func(d *models.Device) {
+				d.ID, d.Status, d.Interfaces = 5, lifecycle.Retired, []models.DeviceInterface{{Label: "eth0"}}
+			},
+		},
+	}
+	for _, tt := range tests {
+		d := valid
+		tt.modify(&d)
+		errs := Device(d, racks)
+		if len(errs) != len(tt.fields) {
+			t.Errorf("%s: got errors %v, want errors on %v", tt.name, errs, tt.fields)
+			continue
+		}
+		for _, field := range tt.fields {
+			if _, ok := errs[field]; !ok {
+				t.Errorf("%s: got errors %v, want an error on %s", tt.name, errs, field)
+			}
+		}
+	}
+}
+
+func TestRack(t *testing.T) {
+	sites := []models.Site{{ID: 1}, {ID: 2}}
+	rooms := []models.Room{{ID: 10, SiteID: 1}}
+	tests := []struct {
+		name   string
+		rack   models.Rack
+		fields []string
+	}{
+		{name: "valid", rack: models.Rack{Name: "R1", Height: 42, Status: "Online", SiteID: 1, RoomID: 10}},
+		{name: "no name", rack: models.Rack{Name: " ", Height: 42}, fields: []string{"name"}},
+		{name: "too short", rack: models.Rack{Name: "R1", Height: 0}, fields: []string{"height"}},
+		{name: "too tall", rack: models.Rack{Name: "R1", Height: 61}, fields: []string{"height"}},
+		{name: "unknown status", rack: models.Rack{Name: "R1", Height: 42, Status: "Gone"}, fields: []string{"status"}},
+		{name: "unknown site", rack: models.Rack{Name: "R1", Height: 42, SiteID: 3}, fields: []string{"site_id"}},
+		{name: "unknown room", rack: models.Rack{Name: "R1", Height: 42, RoomID: 11}, fields: []string{"room_id"}},
+		{name: "room of another site", rack: models.Rack{Name: "R1", Height: 42, SiteID: 2, RoomID: 10}, fields: []string{"room_id"}},
+	}
+	for _, tt := range tests {
+		errs := Rack(tt.rack, sites, rooms)
+		if len(errs) != len(tt.fields) {
+			t.Errorf("%s: got errors %v, want errors on %v", tt.name, errs, tt.fields)
+			continue
+		}
+		for _, field := range tt.fields {
+			if _, ok := errs[field]; !ok {
+				t.Errorf("%s: got errors %v, want an error on %s", tt.name, errs, field)
+			}
+		}
+	}
+}
+
+func TestCable(t *testing.T) {
+	tests := []struct {
+		name   string
+		cable  models.Cable
+		fields []string
+	}{
+		{name: "valid", cable: models.Cable{AInterfaceID: 1, BInterfaceID: 2, Type: "Cat6", Color: "#0ea5e9", Length: 1.5, Label: "C-001"}},
+		{name: "no color or length", cable: models.Cable{AInterfaceID: 1, BInterfaceID: 2, Type: "DAC"}},
+		{name: "no ends", cable: models.Cable{Type: "Cat6"}, fields: []string{"a_interface_id", "b_interface_id"}},
+		{name: "both ends the same", cable: models.Cable{AInterfaceID: 1, BInterfaceID: 1, Type: "Cat6"}, fields: []string{"b_interface_id"}},
+		{name: "unknown type", cable: models.Cable{AInterfaceID: 1, BInterfaceID: 2, Type: "Copper"}, fields: []string{"type"}},
+		{name: "color name", cable: models.Cable{AInterfaceID: 1, BInterfaceID: 2, Type: "Cat6", Color: "blue"}, fields: []string{"color"}},
+		{name: "negative length", cable: models.Cable{AInterfaceID: 1, BInterfaceID: 2, Type: "Cat6", Length: -1}, fields: []string{"length"}},
+		{name: "long label", cable: models.Cable{AInterfaceID: 1, BInterfaceID: 2, Type: "Cat6", Label: strings.Repeat("x", 65)}, fields: []string{"label"}},
+	}
+	for _, tt := range tests {
+		errs := Cable(tt.cable)
+		if len(errs) != len(tt.fields) {
+			t.Errorf("%s: got errors %v, want errors on %v", tt.name, errs, tt.fields)
+			continue
+		}
+		for _, field := range tt.fields {
+			if _, ok := errs[field]; !ok {
+				t.Errorf("%s: got errors %v, want an error on %s", tt.name, errs, field)
+			}
+		}
+	}
+}
+
+func TestCustomField(t *testing.T) {
+	tests := []struct {
+		field   models.CustomField
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{field: models.CustomField{Type: "text"}, in: " x ", want: "x"},
+		{field: models.CustomField{Type: "text", Required: true}, in: "", wantErr: true},
+		{field: models.CustomField{Type: "integer"}, in: "007", want: "7"},
+		{field: models.CustomField{Type: "integer"}, in: "1.5", want: "1.5", wantErr: true},
+		{field: models.CustomField{Type: "boolean"}, in: "", want: "false"},
+		{field: models.CustomField{Type: "boolean"}, in: "on", want: "false"},
+		{field: models.CustomField{Type: "boolean"}, in: "true", want: "true"},
+		{field: models.CustomField{Type: "date"}, in: "2026-01-31", want: "2026-01-31"},
+		{field: models.CustomField{Type: "date"}, in: "31/01/2026", want: "31/01/2026", wantErr: true},
+		{field: models.CustomField{Type: "select", Options: []string{"a", "b"}}, in: "b", want: "b"},
+		{field: models.CustomField{Type: "select", Options: []string{"a", "b"}}, in: "c", want: "c", wantErr: true},
+		{field: models.CustomField{Type: "url"}, in: "https://example.com/x", want: "https://example.com/x"},
+		{field: models.CustomField{Type: "url"}, in: "ftp://example.com", want: "ftp://example.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := CustomField(tt.field, tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("CustomField(%s, %q) = %q, %v, want %q, error %v", tt.field.Type, tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
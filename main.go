@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -27,6 +28,25 @@ func main() {
 	db.InitDB(dbPath)
 	defer db.DB.Close()
 
+	// Release expired reservations in the background
+	reaperInterval := time.Minute
+	if v := os.Getenv("RESERVATION_REAPER_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid RESERVATION_REAPER_INTERVAL %q", v)
+		}
+		reaperInterval = d
+	}
+	db.StartReservationReaper(reaperInterval)
+
+	// Only these reverse proxies may name the user recorded in the audit log
+	if err := handlers.SetTrustedProxies(os.Getenv("TRUSTED_PROXIES")); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+
+	// Purge deleted devices and racks once they outlive the trash retention
+	db.StartTrashPurger(time.Hour)
+
 	// Serve static files
 	fs := http.FileServer(http.Dir("static"))
 	http.Handle("/static/", http.StripPrefix("/static/", fs))
@@ -38,12 +58,65 @@ func main() {
 	http.HandleFunc("/edit", handlers.EditDeviceHandler)
 	http.HandleFunc("/update", handlers.UpdateDeviceHandler)
 	http.HandleFunc("/delete", handlers.DeleteDeviceHandler)
+	http.HandleFunc("/extend-reservation", handlers.ExtendReservationHandler)
 
 	http.HandleFunc("/add-rack", handlers.AddRackHandler)
 	http.HandleFunc("/create-rack", handlers.CreateRackHandler)
 	http.HandleFunc("/edit-rack", handlers.EditRackHandler)
 	http.HandleFunc("/update-rack", handlers.UpdateRackHandler)
 	http.HandleFunc("/delete-rack", handlers.DeleteRackHandler)
+	http.HandleFunc("/rack", handlers.RackHandler)
+	http.HandleFunc("/rack-elevation.svg", handlers.RackElevationHandler)
+
+	http.HandleFunc("/subnets", handlers.SubnetsHandler)
+	http.HandleFunc("/add-subnet", handlers.AddSubnetHandler)
+	http.HandleFunc("/create-subnet", handlers.CreateSubnetHandler)
+	http.HandleFunc("/edit-subnet", handlers.EditSubnetHandler)
+	http.HandleFunc("/update-subnet", handlers.UpdateSubnetHandler)
+	http.HandleFunc("/delete-subnet", handlers.DeleteSubnetHandler)
+	http.HandleFunc("/carve-subnet", handlers.CarveSubnetHandler)
+
+	http.HandleFunc("/addresses", handlers.AddressesHandler)
+	http.HandleFunc("/add-address", handlers.AddAddressHandler)
+	http.HandleFunc("/create-address", handlers.CreateAddressHandler)
+	http.HandleFunc("/edit-address", handlers.EditAddressHandler)
+	http.HandleFunc("/update-address", handlers.UpdateAddressHandler)
+	http.HandleFunc("/delete-address", handlers.DeleteAddressHandler)
+
+	http.HandleFunc("/ranges", handlers.RangesHandler)
+	http.HandleFunc("/add-range", handlers.AddRangeHandler)
+	http.HandleFunc("/create-range", handlers.CreateRangeHandler)
+	http.HandleFunc("/edit-range", handlers.EditRangeHandler)
+	http.HandleFunc("/update-range", handlers.UpdateRangeHandler)
+	http.HandleFunc("/delete-range", handlers.DeleteRangeHandler)
+
+	http.HandleFunc("/vlans", handlers.VLANsHandler)
+	http.HandleFunc("/vlan", handlers.VLANHandler)
+	http.HandleFunc("/add-vlan", handlers.AddVLANHandler)
+	http.HandleFunc("/create-vlan", handlers.CreateVLANHandler)
+	http.HandleFunc("/edit-vlan", handlers.EditVLANHandler)
+	http.HandleFunc("/update-vlan", handlers.UpdateVLANHandler)
+	http.HandleFunc("/delete-vlan", handlers.DeleteVLANHandler)
+
+	http.HandleFunc("/tags", handlers.TagsHandler)
+	http.HandleFunc("/update-tag", handlers.UpdateTagHandler)
+	http.HandleFunc("/delete-tag", handlers.DeleteTagHandler)
+
+	http.HandleFunc("/vrfs", handlers.VRFsHandler)
+	http.HandleFunc("/add-vrf", handlers.AddVRFHandler)
+	http.HandleFunc("/create-vrf", handlers.CreateVRFHandler)
+	http.HandleFunc("/edit-vrf", handlers.EditVRFHandler)
+	http.HandleFunc("/update-vrf", handlers.UpdateVRFHandler)
+	http.HandleFunc("/delete-vrf", handlers.DeleteVRFHandler)
+
+	// JSON API
+	http.HandleFunc("/api/devices", handlers.DevicesAPIHandler)
+	http.HandleFunc("/api/device", handlers.DeviceAPIHandler)
+	http.HandleFunc("/api/rack", handlers.RackAPIHandler)
+	http.HandleFunc("/api/subnets", handlers.SubnetsAPIHandler)
+	http.HandleFunc("/api/allocate-ip", handlers.AllocateIPHandler)
+	http.HandleFunc("/api/carve-subnet", handlers.CarveSubnetAPIHandler)
+	http.HandleFunc("/api/extend-reservation", handlers.ExtendReservationAPIHandler)
 
 	http.HandleFunc("/ping", handlers.PingDeviceHandler)
 	http.HandleFunc("/export/csv", handlers.ExportCSVHandler)
@@ -51,7 +124,68 @@ func main() {
 	http.HandleFunc("/scan", handlers.ScanSubnetHandler)
 
 	// Admin / Settings
+	http.HandleFunc("/cables", handlers.CablesHandler)
+	http.HandleFunc("/add-cable", handlers.AddCableHandler)
+	http.HandleFunc("/create-cable", handlers.CreateCableHandler)
+	http.HandleFunc("/edit-cable", handlers.EditCableHandler)
+	http.HandleFunc("/update-cable", handlers.UpdateCableHandler)
+	http.HandleFunc("/delete-cable", handlers.DeleteCableHandler)
+	http.HandleFunc("/models", handlers.CatalogHandler)
+	http.HandleFunc("/create-manufacturer", handlers.CreateManufacturerHandler)
+	http.HandleFunc("/edit-manufacturer", handlers.EditManufacturerHandler)
+	http.HandleFunc("/update-manufacturer", handlers.UpdateManufacturerHandler)
+	http.HandleFunc("/delete-manufacturer", handlers.DeleteManufacturerHandler)
+	http.HandleFunc("/add-model", handlers.AddDeviceModelHandler)
+	http.HandleFunc("/create-model", handlers.CreateDeviceModelHandler)
+	http.HandleFunc("/edit-model", handlers.EditDeviceModelHandler)
+	http.HandleFunc("/update-model", handlers.UpdateDeviceModelHandler)
+	http.HandleFunc("/delete-model", handlers.DeleteDeviceModelHandler)
+	http.HandleFunc("/reports/models", handlers.ModelReportHandler)
+	http.HandleFunc("/tenants", handlers.TenantsHandler)
+	http.HandleFunc("/tenant", handlers.TenantHandler)
+	http.HandleFunc("/add-tenant-group", handlers.AddTenantGroupHandler)
+	http.HandleFunc("/create-tenant-group", handlers.CreateTenantGroupHandler)
+	http.HandleFunc("/edit-tenant-group", handlers.EditTenantGroupHandler)
+	http.HandleFunc("/update-tenant-group", handlers.UpdateTenantGroupHandler)
+	http.HandleFunc("/delete-tenant-group", handlers.DeleteTenantGroupHandler)
+	http.HandleFunc("/add-tenant", handlers.AddTenantHandler)
+	http.HandleFunc("/create-tenant", handlers.CreateTenantHandler)
+	http.HandleFunc("/edit-tenant", handlers.EditTenantHandler)
+	http.HandleFunc("/update-tenant", handlers.UpdateTenantHandler)
+	http.HandleFunc("/delete-tenant", handlers.DeleteTenantHandler)
+	http.HandleFunc("/sites", handlers.SitesHandler)
+	http.HandleFunc("/add-region", handlers.AddRegionHandler)
+	http.HandleFunc("/create-region", handlers.CreateRegionHandler)
+	http.HandleFunc("/edit-region", handlers.EditRegionHandler)
+	http.HandleFunc("/update-region", handlers.UpdateRegionHandler)
+	http.HandleFunc("/delete-region", handlers.DeleteRegionHandler)
+	http.HandleFunc("/add-site", handlers.AddSiteHandler)
+	http.HandleFunc("/create-site", handlers.CreateSiteHandler)
+	http.HandleFunc("/edit-site", handlers.EditSiteHandler)
+	http.HandleFunc("/update-site", handlers.UpdateSiteHandler)
+	http.HandleFunc("/delete-site", handlers.DeleteSiteHandler)
+	http.HandleFunc("/merge-site", handlers.MergeSiteHandler)
+	http.HandleFunc("/add-room", handlers.AddRoomHandler)
+	http.HandleFunc("/create-room", handlers.CreateRoomHandler)
+	http.HandleFunc("/edit-room", handlers.EditRoomHandler)
+	http.HandleFunc("/update-room", handlers.UpdateRoomHandler)
+	http.HandleFunc("/delete-room", handlers.DeleteRoomHandler)
+	http.HandleFunc("/trash", handlers.TrashHandler)
+	http.HandleFunc("/restore-device", handlers.RestoreDeviceHandler)
+	http.HandleFunc("/purge-device", handlers.PurgeDeviceHandler)
+	http.HandleFunc("/restore-rack", handlers.RestoreRackHandler)
+	http.HandleFunc("/purge-rack", handlers.PurgeRackHandler)
+	http.HandleFunc("/update-trash-retention", handlers.UpdateTrashRetentionHandler)
+	http.HandleFunc("/changes", handlers.ChangeLogHandler)
+	http.HandleFunc("/history", handlers.HistoryHandler)
+	http.HandleFunc("/address-history", handlers.AddressHistoryHandler)
 	http.HandleFunc("/settings", handlers.SettingsHandler)
+	http.HandleFunc("/update-status-colors", handlers.UpdateStatusColorsHandler)
+	http.HandleFunc("/add-custom-field", handlers.AddCustomFieldHandler)
+	http.HandleFunc("/create-custom-field", handlers.CreateCustomFieldHandler)
+	http.HandleFunc("/edit-custom-field", handlers.EditCustomFieldHandler)
+	http.HandleFunc("/update-custom-field", handlers.UpdateCustomFieldHandler)
+	http.HandleFunc("/delete-custom-field", handlers.DeleteCustomFieldHandler)
 	http.HandleFunc("/backup", handlers.BackupDBHandler)
 	http.HandleFunc("/restore", handlers.RestoreDBHandler)
 